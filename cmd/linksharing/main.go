@@ -18,9 +18,12 @@ import (
 	"storj.io/common/errs2"
 	"storj.io/common/fpath"
 	"storj.io/common/identity"
+	"storj.io/common/memory"
 	"storj.io/gateway-mt/pkg/authclient"
 	"storj.io/gateway-mt/pkg/httpserver"
 	"storj.io/gateway-mt/pkg/linksharing"
+	"storj.io/gateway-mt/pkg/linksharing/analytics"
+	"storj.io/gateway-mt/pkg/linksharing/objectcache"
 	"storj.io/gateway-mt/pkg/linksharing/sharing"
 	"storj.io/private/cfgstruct"
 	"storj.io/private/process"
@@ -39,10 +42,15 @@ type LinkSharing struct {
 	PublicURL              string        `user:"true" help:"comma separated list of public urls for the server" devDefault:"http://localhost:20020" releaseDefault:""`
 	GeoLocationDB          string        `user:"true" help:"maxmind database file path"`
 	TXTRecordTTL           time.Duration `user:"true" help:"max ttl (seconds) for website hosting txt record cache" devDefault:"10s" releaseDefault:"1h"`
+	TXTRecordNegativeTTL   time.Duration `user:"true" help:"max ttl for remembering hostnames without website hosting txt records; zero disables it" devDefault:"10s" releaseDefault:"1m"`
+	TXTRecordRevalidation  time.Duration `user:"true" help:"how often Access Key IDs in the website hosting txt record cache are re-validated with the auth service; zero disables re-validation" devDefault:"10s" releaseDefault:"5m"`
+	AdminToken             []string      `user:"true" help:"bearer token(s) allowed to use the admin endpoints; the admin endpoints are disabled if empty"`
 	AuthService            authclient.Config
-	DNSServer              string        `user:"true" help:"dns server address to use for TXT resolution" default:"1.1.1.1:53"`
+	DNSServer              string        `user:"true" help:"comma separated list of dns servers to use for TXT resolution, tried in order; host:port for DNS over TCP, tls://host[:port] for DNS over TLS or an https:// URL for DNS over HTTPS" default:"1.1.1.1:53"`
 	StaticSourcesPath      string        `user:"true" help:"the path to where web assets are located" default:"./pkg/linksharing/web/static"`
 	Templates              string        `user:"true" help:"the path to where renderable templates are located" default:"./pkg/linksharing/web"`
+	TemplateThemes         string        `user:"true" help:"the path to per-hostname template overrides, with a directory per hostname holding the templates to replace; empty disables them" default:""`
+	TemplatesReload        time.Duration `user:"true" help:"how often templates are checked for changes on disk and reloaded; zero disables reloading" devDefault:"1s" releaseDefault:"1m"`
 	LandingRedirectTarget  string        `user:"true" help:"the url to redirect empty requests to" default:"https://www.storj.io/"`
 	RedirectHTTPS          bool          `user:"true" help:"redirect to HTTPS" devDefault:"false" releaseDefault:"true"`
 	DialTimeout            time.Duration `help:"timeout for dials" default:"10s"`
@@ -51,6 +59,10 @@ type LinkSharing struct {
 	StandardRendersContent bool          `user:"true" help:"enable standard (non-hosting) requests to render content and not only download it" default:"false"`
 	StandardViewsHTML      bool          `user:"true" help:"serve HTML as text/html instead of text/plain for standard (non-hosting) requests" default:"false"`
 	ConnectionPool         connectionPoolConfig
+	ObjectCache            objectCacheConfig
+	Map                    mapConfig
+	Compression            compressionConfig
+	Analytics              analyticsConfig
 	CertMagic              certMagic
 	ShutdownDelay          time.Duration `user:"true" help:"time to delay server shutdown while returning 503s on the health endpoint" devDefault:"1s" releaseDefault:"45s"`
 	StartupCheck           startupCheck
@@ -63,17 +75,51 @@ type connectionPoolConfig struct {
 	IdleExpiration time.Duration `user:"true" help:"RPC connection pool idle expiration" default:"2m0s"`
 }
 
+// objectCacheConfig is a config struct for configuring the local object cache.
+type objectCacheConfig struct {
+	MaxSize       memory.Size   `user:"true" help:"total size of the local cache for hot objects; zero disables it" default:"0"`
+	MaxObjectSize memory.Size   `user:"true" help:"size of the largest object to keep in the local object cache" default:"8MiB"`
+	DefaultTTL    time.Duration `user:"true" help:"how long to cache objects without a max-age in their Cache-Control metadata" default:"1m0s"`
+	MaxTTL        time.Duration `user:"true" help:"maximum time to cache any object" default:"1h0m0s"`
+	Dir           string        `user:"true" help:"directory to keep cached objects in instead of memory"`
+}
+
+// mapConfig is a config struct for configuring the object map view.
+type mapConfig struct {
+	LocationPrecision int           `user:"true" help:"number of decimal places node coordinates on object maps are rounded to" default:"1"`
+	CacheCapacity     int           `user:"true" help:"number of objects whose piece locations are cached for object maps; zero disables caching" default:"10000"`
+	CacheExpiration   time.Duration `user:"true" help:"how long piece locations of objects are cached for object maps" default:"10m0s"`
+}
+
+// compressionConfig is a config struct for configuring on-the-fly
+// compression of text assets.
+type compressionConfig struct {
+	Enabled bool        `user:"true" help:"compress text assets with gzip or brotli if clients accept it" default:"false"`
+	MinSize memory.Size `user:"true" help:"size of the smallest object to compress" default:"1KiB"`
+}
+
+// analyticsConfig is a config struct for configuring the per-hostname
+// analytics of hosted sites.
+type analyticsConfig struct {
+	MaxDomains     int           `user:"true" help:"number of hosted site hostnames to keep analytics for; zero disables analytics" default:"0"`
+	FlushInterval  time.Duration `user:"true" help:"how often analytics reports are written to the buckets of sites with a storj-analytics TXT record; zero disables writing them" devDefault:"1m0s" releaseDefault:"1h0m0s"`
+	IdleExpiration time.Duration `user:"true" help:"how long to keep analytics of hostnames without requests" default:"24h0m0s"`
+}
+
 // certMagic is a config struct for configuring CertMagic options.
 type certMagic struct {
-	Enabled               bool   `user:"true" help:"use CertMagic to handle TLS certificates" default:"false"`
-	KeyFile               string `user:"true" help:"path to the service account key file"`
-	Email                 string `user:"true" help:"email address to use when creating an ACME account"`
-	Staging               bool   `user:"true" help:"use staging CA endpoints" devDefault:"true" releaseDefault:"false"`
-	Bucket                string `user:"true" help:"bucket to use for certificate storage with optional prefix (bucket/prefix)"`
-	TierServiceIdentity   identity.Config
-	TierCacheExpiration   time.Duration `user:"true" help:"expiration time for tier querying service cache" devDefault:"10s" releaseDefault:"5m"`
-	TierCacheCapacity     int           `user:"true" help:"tier querying service cache capacity" default:"10000"`
-	SkipPaidTierAllowlist []string      `user:"true" help:"comma separated list of domain names which bypass paid tier queries. Set to * to disable tier check entirely"`
+	Enabled                 bool   `user:"true" help:"use CertMagic to handle TLS certificates" default:"false"`
+	KeyFile                 string `user:"true" help:"path to the service account key file"`
+	Email                   string `user:"true" help:"email address to use when creating an ACME account"`
+	Staging                 bool   `user:"true" help:"use staging CA endpoints" devDefault:"true" releaseDefault:"false"`
+	Bucket                  string `user:"true" help:"bucket to use for certificate storage with optional prefix (bucket/prefix)"`
+	TierServiceIdentity     identity.Config
+	TierCacheExpiration     time.Duration `user:"true" help:"expiration time for tier querying service cache" devDefault:"10s" releaseDefault:"5m"`
+	TierCacheCapacity       int           `user:"true" help:"tier querying service cache capacity" default:"10000"`
+	SkipPaidTierAllowlist   []string      `user:"true" help:"comma separated list of domain names which bypass paid tier queries. Set to * to disable tier check entirely"`
+	PublicIPS               []string      `user:"true" help:"comma separated list of IPs the service is reachable at, which apex domains can point to with A/AAAA records. If empty, the IPs of the public URLs' hosts are used"`
+	DecisionCacheExpiration time.Duration `user:"true" help:"expiration time for the cache of on-demand certificate decisions" devDefault:"10s" releaseDefault:"5m"`
+	DecisionCacheCapacity   int           `user:"true" help:"on-demand certificate decision cache capacity" default:"10000"`
 }
 
 type startupCheck struct {
@@ -130,20 +176,23 @@ func cmdRun(cmd *cobra.Command, args []string) (err error) {
 	var tlsConfig *httpserver.TLSConfig
 	if !runCfg.InsecureDisableTLS {
 		tlsConfig = &httpserver.TLSConfig{
-			CertMagic:             runCfg.CertMagic.Enabled,
-			CertMagicKeyFile:      runCfg.CertMagic.KeyFile,
-			CertMagicEmail:        runCfg.CertMagic.Email,
-			CertMagicStaging:      runCfg.CertMagic.Staging,
-			CertMagicBucket:       runCfg.CertMagic.Bucket,
-			TierServiceIdentity:   runCfg.CertMagic.TierServiceIdentity,
-			TierCacheExpiration:   runCfg.CertMagic.TierCacheExpiration,
-			TierCacheCapacity:     runCfg.CertMagic.TierCacheCapacity,
-			SkipPaidTierAllowlist: runCfg.CertMagic.SkipPaidTierAllowlist,
-			CertFile:              runCfg.CertFile,
-			KeyFile:               runCfg.KeyFile,
-			CertMagicPublicURLs:   publicURLs,
-			ConfigDir:             confDir,
-			Ctx:                   ctx,
+			CertMagic:                        runCfg.CertMagic.Enabled,
+			CertMagicKeyFile:                 runCfg.CertMagic.KeyFile,
+			CertMagicEmail:                   runCfg.CertMagic.Email,
+			CertMagicStaging:                 runCfg.CertMagic.Staging,
+			CertMagicBucket:                  runCfg.CertMagic.Bucket,
+			TierServiceIdentity:              runCfg.CertMagic.TierServiceIdentity,
+			TierCacheExpiration:              runCfg.CertMagic.TierCacheExpiration,
+			TierCacheCapacity:                runCfg.CertMagic.TierCacheCapacity,
+			SkipPaidTierAllowlist:            runCfg.CertMagic.SkipPaidTierAllowlist,
+			CertMagicPublicIPs:               runCfg.CertMagic.PublicIPS,
+			CertMagicDecisionCacheExpiration: runCfg.CertMagic.DecisionCacheExpiration,
+			CertMagicDecisionCacheCapacity:   runCfg.CertMagic.DecisionCacheCapacity,
+			CertFile:                         runCfg.CertFile,
+			KeyFile:                          runCfg.KeyFile,
+			CertMagicPublicURLs:              publicURLs,
+			ConfigDir:                        confDir,
+			Ctx:                              ctx,
 		}
 	}
 
@@ -158,19 +207,28 @@ func cmdRun(cmd *cobra.Command, args []string) (err error) {
 			StartupCheckConfig: httpserver.StartupCheckConfig(runCfg.StartupCheck),
 		},
 		Handler: sharing.Config{
-			URLBases:               publicURLs,
-			Templates:              runCfg.Templates,
-			StaticSourcesPath:      runCfg.StaticSourcesPath,
-			RedirectHTTPS:          runCfg.RedirectHTTPS,
-			LandingRedirectTarget:  runCfg.LandingRedirectTarget,
-			TXTRecordTTL:           runCfg.TXTRecordTTL,
-			AuthServiceConfig:      runCfg.AuthService,
-			DNSServer:              runCfg.DNSServer,
-			ConnectionPool:         sharing.ConnectionPoolConfig(runCfg.ConnectionPool),
-			ClientTrustedIPsList:   runCfg.ClientTrustedIPSList,
-			UseClientIPHeaders:     runCfg.UseClientIPHeaders,
-			StandardViewsHTML:      runCfg.StandardViewsHTML,
-			StandardRendersContent: runCfg.StandardRendersContent,
+			URLBases:                      publicURLs,
+			Templates:                     runCfg.Templates,
+			TemplateThemes:                runCfg.TemplateThemes,
+			TemplatesReloadInterval:       runCfg.TemplatesReload,
+			StaticSourcesPath:             runCfg.StaticSourcesPath,
+			RedirectHTTPS:                 runCfg.RedirectHTTPS,
+			LandingRedirectTarget:         runCfg.LandingRedirectTarget,
+			TXTRecordTTL:                  runCfg.TXTRecordTTL,
+			TXTRecordNegativeTTL:          runCfg.TXTRecordNegativeTTL,
+			TXTRecordRevalidationInterval: runCfg.TXTRecordRevalidation,
+			AdminTokens:                   runCfg.AdminToken,
+			AuthServiceConfig:             runCfg.AuthService,
+			DNSServer:                     runCfg.DNSServer,
+			ConnectionPool:                sharing.ConnectionPoolConfig(runCfg.ConnectionPool),
+			ClientTrustedIPsList:          runCfg.ClientTrustedIPSList,
+			UseClientIPHeaders:            runCfg.UseClientIPHeaders,
+			StandardViewsHTML:             runCfg.StandardViewsHTML,
+			StandardRendersContent:        runCfg.StandardRendersContent,
+			ObjectCache:                   objectcache.Config(runCfg.ObjectCache),
+			Map:                           sharing.MapConfig(runCfg.Map),
+			Compression:                   sharing.CompressionConfig(runCfg.Compression),
+			Analytics:                     analytics.Config(runCfg.Analytics),
 			Uplink: &uplink.Config{
 				UserAgent:   "linksharing",
 				DialTimeout: runCfg.DialTimeout,
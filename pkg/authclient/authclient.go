@@ -4,6 +4,7 @@
 package authclient
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"net/http"
@@ -156,6 +157,57 @@ func (a *AuthClient) ResolveWithCache(ctx context.Context, accessKeyID string, c
 	return decResp, response.err
 }
 
+// Register registers accessGrant with the Auth Service, returning the access
+// key ID and secret key it can be used with. The credentials expire with the
+// access grant if it has an expiration.
+func (a *AuthClient) Register(ctx context.Context, accessGrant string, public bool) (_ RegisterResponse, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	reqURL, err := url.Parse(a.BaseURL)
+	if err != nil {
+		return RegisterResponse{}, errdata.WithStatus(AuthServiceError.Wrap(err), http.StatusInternalServerError)
+	}
+	reqURL.Path = path.Join(reqURL.Path, "/v1/access")
+
+	body, err := json.Marshal(struct {
+		AccessGrant string `json:"access_grant"`
+		Public      bool   `json:"public"`
+	}{accessGrant, public})
+	if err != nil {
+		return RegisterResponse{}, errdata.WithStatus(AuthServiceError.Wrap(err), http.StatusInternalServerError)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", reqURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return RegisterResponse{}, errdata.WithStatus(AuthServiceError.Wrap(err), http.StatusInternalServerError)
+	}
+	req.Header.Set("Authorization", "Bearer "+a.Token)
+	req.Header.Set("Content-Type", "application/json")
+	middleware.AddRequestIDToHeaders(req)
+
+	client := http.Client{
+		Timeout:   a.Timeout,
+		Transport: &http.Transport{ResponseHeaderTimeout: a.Timeout},
+	}
+	// registering isn't retried like resolving, as every attempt that reaches
+	// the Auth Service registers new credentials.
+	resp, err := client.Do(req)
+	if err != nil {
+		return RegisterResponse{}, errdata.WithStatus(AuthServiceError.Wrap(err), http.StatusInternalServerError)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return RegisterResponse{}, errdata.WithStatus(AuthServiceError.New("%s", resp.Status), resp.StatusCode)
+	}
+
+	var registerResp RegisterResponse
+	if err := json.NewDecoder(resp.Body).Decode(&registerResp); err != nil {
+		return RegisterResponse{}, errdata.WithStatus(AuthServiceError.Wrap(err), http.StatusInternalServerError)
+	}
+	return registerResp, nil
+}
+
 // GetHealthLive returns the auth service health live status.
 func (a *AuthClient) GetHealthLive(ctx context.Context) (_ bool, err error) {
 	defer mon.Task()(&ctx)(&err)
@@ -5,6 +5,7 @@ package authclient
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -126,6 +127,41 @@ func TestBadStatusPassedThrough(t *testing.T) {
 	}
 }
 
+func TestRegister(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/v1/access", r.URL.Path)
+
+		var body struct {
+			AccessGrant string `json:"access_grant"`
+			Public      bool   `json:"public"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		if body.AccessGrant != "myaccessgrant" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		require.False(t, body.Public)
+
+		_, err := w.Write([]byte(`{"access_key_id":"myaccesskey", "secret_key":"mysecretkey", "endpoint":"https://gateway.test"}`))
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	client, err := GetTestAuthClient(t, ts.URL, "token", 2*time.Second)
+	require.NoError(t, err)
+
+	resp, err := client.Register(context.Background(), "myaccessgrant", false)
+	require.NoError(t, err)
+	require.Equal(t, "myaccesskey", resp.AccessKeyID)
+	require.Equal(t, "mysecretkey", resp.SecretKey)
+	require.Equal(t, "https://gateway.test", resp.Endpoint)
+
+	_, err = client.Register(context.Background(), "invalid", false)
+	require.Error(t, err)
+	require.Equal(t, http.StatusBadRequest, errdata.GetStatus(err, http.StatusOK))
+}
+
 func GetTestAuthClient(t *testing.T, baseURL, token string, timeout time.Duration) (*AuthClient, error) {
 	return New(Config{BaseURL: baseURL, Token: token, Timeout: timeout}), nil
 }
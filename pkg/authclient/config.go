@@ -57,6 +57,14 @@ type AuthServiceResponse struct {
 	Public      bool   `json:"public"`
 }
 
+// RegisterResponse is the struct representing the auth service's response to
+// registering an access grant.
+type RegisterResponse struct {
+	AccessKeyID string `json:"access_key_id"`
+	SecretKey   string `json:"secret_key"`
+	Endpoint    string `json:"endpoint"`
+}
+
 type BucketIsUniqueResponse struct {
 	Error       string `json:"error,omitempty"`
 	IsAvailable bool   `json:"is_available,omitempty"`
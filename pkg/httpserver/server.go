@@ -129,6 +129,20 @@ type TLSConfig struct {
 	// If any one value is set to "*" then the paid tier checking is disabled entirely.
 	SkipPaidTierAllowlist []string
 
+	// CertMagicPublicIPs is a list of IPs the service is reachable at. Custom
+	// domains that can't have a CNAME record (apex domains) are allowed if
+	// all their A/AAAA records point to one of them. If it's empty, the IPs
+	// the hosts of CertMagicPublicURLs resolve to are used.
+	CertMagicPublicIPs []string
+
+	// CertMagicDecisionCacheExpiration is how long on-demand certificate
+	// decisions for custom domains are cached.
+	CertMagicDecisionCacheExpiration time.Duration
+
+	// CertMagicDecisionCacheCapacity is the on-demand certificate decision
+	// cache size.
+	CertMagicDecisionCacheCapacity int
+
 	// CertMagicPublicURLs is a list of URLs to always issue certificates for.
 	//
 	// Typically, these are URLs that the service will be mainly reached
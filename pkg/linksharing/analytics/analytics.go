@@ -0,0 +1,269 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package analytics aggregates per-hostname traffic counters for sites
+// hosted by linksharing.
+package analytics
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+)
+
+var mon = monkit.Package()
+
+// Config configures the analytics collector.
+type Config struct {
+	// MaxDomains is the number of hostnames counters are kept for. Zero
+	// disables analytics.
+	MaxDomains int
+	// FlushInterval is how often counters are written to the buckets of
+	// sites that opted in. Zero disables flushing.
+	FlushInterval time.Duration
+	// IdleExpiration is how long counters of hostnames without requests are
+	// kept.
+	IdleExpiration time.Duration
+}
+
+// Counters are the traffic counters of a hostname.
+type Counters struct {
+	Requests    int64 `json:"requests"`
+	BytesServed int64 `json:"bytes_served"`
+	Status2xx   int64 `json:"status_2xx"`
+	Status3xx   int64 `json:"status_3xx"`
+	Status4xx   int64 `json:"status_4xx"`
+	Status5xx   int64 `json:"status_5xx"`
+	// CacheHits counts requests answered without reading the object from
+	// the network, i.e. with 304 Not Modified or from the local object
+	// cache.
+	CacheHits int64 `json:"cache_hits"`
+}
+
+func (c *Counters) add(req Request) {
+	c.Requests++
+	c.BytesServed += req.Bytes
+	switch {
+	case req.Status >= 200 && req.Status < 300:
+		c.Status2xx++
+	case req.Status >= 300 && req.Status < 400:
+		c.Status3xx++
+	case req.Status >= 400 && req.Status < 500:
+		c.Status4xx++
+	case req.Status >= 500:
+		c.Status5xx++
+	}
+	if req.CacheHit {
+		c.CacheHits++
+	}
+}
+
+func (c Counters) sub(o Counters) Counters {
+	return Counters{
+		Requests:    c.Requests - o.Requests,
+		BytesServed: c.BytesServed - o.BytesServed,
+		Status2xx:   c.Status2xx - o.Status2xx,
+		Status3xx:   c.Status3xx - o.Status3xx,
+		Status4xx:   c.Status4xx - o.Status4xx,
+		Status5xx:   c.Status5xx - o.Status5xx,
+		CacheHits:   c.CacheHits - o.CacheHits,
+	}
+}
+
+// Request describes a served request.
+type Request struct {
+	Status   int
+	Bytes    int64
+	CacheHit bool
+}
+
+// Domain holds the counters of a hostname since Since.
+type Domain struct {
+	Hostname string    `json:"hostname"`
+	Since    time.Time `json:"since"`
+	Counters
+}
+
+// Report holds the counters of a hostname between Start and End. It's the
+// format of the objects written to the buckets of sites that opted in.
+type Report struct {
+	Hostname string    `json:"hostname"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Counters
+
+	// total is the value of the cumulative counters at End.
+	total Counters
+}
+
+// Collector aggregates counters per hostname.
+//
+// A nil Collector is disabled and safe to use.
+type Collector struct {
+	config Config
+
+	mu      sync.Mutex
+	domains map[string]*domain
+}
+
+type domain struct {
+	macaroonHead []byte
+	since        time.Time
+	lastSeen     time.Time
+	total        Counters
+
+	// reported is the value of total at reportedAt, the end of the last
+	// committed report.
+	reported   Counters
+	reportedAt time.Time
+}
+
+// New creates a new Collector. It returns nil (a disabled collector) if
+// config.MaxDomains is zero.
+func New(config Config) *Collector {
+	if config.MaxDomains <= 0 {
+		return nil
+	}
+	return &Collector{
+		config:  config,
+		domains: make(map[string]*domain),
+	}
+}
+
+// Enabled reports whether the collector is enabled.
+func (c *Collector) Enabled() bool {
+	return c != nil
+}
+
+// FlushInterval returns how often reports should be written.
+func (c *Collector) FlushInterval() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return c.config.FlushInterval
+}
+
+// PruneInterval returns how often hostnames without requests should be
+// pruned.
+func (c *Collector) PruneInterval() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return c.config.IdleExpiration
+}
+
+// Record counts req for hostname, which is served with an access with the
+// given macaroon head.
+func (c *Collector) Record(hostname string, macaroonHead []byte, req Request) {
+	if c == nil {
+		return
+	}
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d, ok := c.domains[hostname]
+	if !ok {
+		if len(c.domains) >= c.config.MaxDomains {
+			mon.Event("analytics_domains_full")
+			return
+		}
+		d = &domain{since: now, reportedAt: now}
+		c.domains[hostname] = d
+	}
+	if !bytes.Equal(d.macaroonHead, macaroonHead) {
+		// the site was moved to another access, and the previous owner
+		// must not see counters that aren't theirs anymore.
+		*d = domain{since: now, reportedAt: now}
+		d.macaroonHead = append([]byte(nil), macaroonHead...)
+	}
+	d.lastSeen = now
+	d.total.add(req)
+}
+
+// Domains returns the counters of the hostnames served with an access with
+// the given macaroon head, ordered by hostname.
+func (c *Collector) Domains(macaroonHead []byte) []Domain {
+	domains := []Domain{}
+	if c == nil {
+		return domains
+	}
+
+	c.mu.Lock()
+	for hostname, d := range c.domains {
+		if bytes.Equal(d.macaroonHead, macaroonHead) {
+			domains = append(domains, Domain{Hostname: hostname, Since: d.since, Counters: d.total})
+		}
+	}
+	c.mu.Unlock()
+
+	sort.Slice(domains, func(i, j int) bool { return domains[i].Hostname < domains[j].Hostname })
+	return domains
+}
+
+// Reports returns the reports of the hostnames that received requests since
+// their last committed report, which end at now. A report is repeated (and
+// extended) by later calls until it's committed.
+func (c *Collector) Reports(now time.Time) []Report {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var reports []Report
+	for hostname, d := range c.domains {
+		if d.total == d.reported {
+			continue
+		}
+		reports = append(reports, Report{
+			Hostname: hostname,
+			Start:    d.reportedAt,
+			End:      now,
+			Counters: d.total.sub(d.reported),
+			total:    d.total,
+		})
+	}
+	return reports
+}
+
+// Commit marks report as written, so the next report of its hostname starts
+// where it ends.
+func (c *Collector) Commit(report Report) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d, ok := c.domains[report.Hostname]
+	if !ok || report.Start != d.reportedAt {
+		// the domain was pruned or reset since the report was created.
+		return
+	}
+	d.reported = report.total
+	d.reportedAt = report.End
+}
+
+// Prune forgets hostnames without requests for longer than the idle
+// expiration.
+func (c *Collector) Prune(now time.Time) {
+	if c == nil || c.config.IdleExpiration <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for hostname, d := range c.domains {
+		if now.Sub(d.lastSeen) > c.config.IdleExpiration {
+			delete(c.domains, hostname)
+		}
+	}
+}
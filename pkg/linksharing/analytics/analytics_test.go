@@ -0,0 +1,98 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package analytics
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollector(t *testing.T) {
+	var disabled *Collector
+	disabled.Record("a.test", []byte("head"), Request{Status: http.StatusOK})
+	assert.False(t, disabled.Enabled())
+	assert.Empty(t, disabled.Domains([]byte("head")))
+	assert.Empty(t, disabled.Reports(time.Now()))
+
+	c := New(Config{MaxDomains: 2})
+	require.True(t, c.Enabled())
+
+	c.Record("a.test", []byte("head"), Request{Status: http.StatusOK, Bytes: 100})
+	c.Record("a.test", []byte("head"), Request{Status: http.StatusNotModified, CacheHit: true})
+	c.Record("b.test", []byte("head"), Request{Status: http.StatusNotFound, Bytes: 10})
+	c.Record("c.test", []byte("other"), Request{Status: http.StatusOK})
+	c.Record("b.test", []byte("head"), Request{Status: http.StatusBadGateway})
+
+	domains := c.Domains([]byte("head"))
+	require.Len(t, domains, 2)
+	assert.Equal(t, "a.test", domains[0].Hostname)
+	assert.Equal(t, Counters{Requests: 2, BytesServed: 100, Status2xx: 1, Status3xx: 1, CacheHits: 1}, domains[0].Counters)
+	assert.Equal(t, "b.test", domains[1].Hostname)
+	assert.Equal(t, Counters{Requests: 2, BytesServed: 10, Status4xx: 1, Status5xx: 1}, domains[1].Counters)
+
+	// c.test didn't fit.
+	assert.Empty(t, c.Domains([]byte("other")))
+
+	// a hostname moved to another access starts over.
+	c.Record("b.test", []byte("other"), Request{Status: http.StatusOK})
+	assert.Len(t, c.Domains([]byte("head")), 1)
+	domains = c.Domains([]byte("other"))
+	require.Len(t, domains, 1)
+	assert.Equal(t, Counters{Requests: 1, Status2xx: 1}, domains[0].Counters)
+}
+
+func TestCollectorReports(t *testing.T) {
+	c := New(Config{MaxDomains: 10, IdleExpiration: time.Hour})
+
+	c.Record("a.test", []byte("head"), Request{Status: http.StatusOK, Bytes: 100})
+
+	now := time.Now()
+	reports := c.Reports(now)
+	require.Len(t, reports, 1)
+	assert.Equal(t, "a.test", reports[0].Hostname)
+	assert.Equal(t, now, reports[0].End)
+	assert.Equal(t, Counters{Requests: 1, BytesServed: 100, Status2xx: 1}, reports[0].Counters)
+
+	// uncommitted reports are extended.
+	c.Record("a.test", []byte("head"), Request{Status: http.StatusOK, Bytes: 50})
+	reports = c.Reports(now.Add(time.Minute))
+	require.Len(t, reports, 1)
+	assert.Equal(t, Counters{Requests: 2, BytesServed: 150, Status2xx: 2}, reports[0].Counters)
+
+	c.Commit(reports[0])
+	assert.Empty(t, c.Reports(now.Add(2*time.Minute)))
+
+	c.Record("a.test", []byte("head"), Request{Status: http.StatusOK, Bytes: 1})
+	next := c.Reports(now.Add(3 * time.Minute))
+	require.Len(t, next, 1)
+	assert.Equal(t, reports[0].End, next[0].Start)
+	assert.Equal(t, Counters{Requests: 1, BytesServed: 1, Status2xx: 1}, next[0].Counters)
+
+	// the cumulative counters are unaffected.
+	assert.Equal(t, int64(3), c.Domains([]byte("head"))[0].Requests)
+
+	data, err := json.Marshal(next[0])
+	require.NoError(t, err)
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &fields))
+	assert.Equal(t, "a.test", fields["hostname"])
+	assert.EqualValues(t, 1, fields["bytes_served"])
+	assert.Contains(t, fields, "start")
+	assert.Contains(t, fields, "end")
+	assert.Contains(t, fields, "cache_hits")
+
+	// pruning doesn't depend on flushing, which is disabled here.
+	assert.Zero(t, c.FlushInterval())
+	assert.Equal(t, time.Hour, c.PruneInterval())
+
+	c.Prune(time.Now())
+	assert.Len(t, c.Domains([]byte("head")), 1)
+	c.Prune(time.Now().Add(2 * time.Hour))
+	assert.Empty(t, c.Domains([]byte("head")))
+}
@@ -0,0 +1,364 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+// Package objectcache implements a bounded local cache of whole objects
+// served by linksharing.
+package objectcache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+	"golang.org/x/sync/singleflight"
+
+	"storj.io/common/memory"
+	"storj.io/common/ranger"
+)
+
+var (
+	mon = monkit.Package()
+
+	// Error is the error class for this package.
+	Error = errs.Class("objectcache")
+)
+
+// Config configures the object cache.
+type Config struct {
+	// MaxSize is the total number of bytes the cache may hold. Zero disables
+	// the cache.
+	MaxSize memory.Size
+	// MaxObjectSize is the size of the largest object that will be cached.
+	MaxObjectSize memory.Size
+	// DefaultTTL is how long objects without a max-age in their
+	// Cache-Control metadata are kept.
+	DefaultTTL time.Duration
+	// MaxTTL caps the lifetime of any cached object.
+	MaxTTL time.Duration
+	// Dir, if not empty, makes the cache keep object contents in files
+	// under this directory instead of in memory.
+	Dir string
+}
+
+// Key identifies a version of an object as seen through a particular API key.
+type Key struct {
+	MacaroonHead string
+	Bucket       string
+	Key          string
+	Version      string
+}
+
+// Cache is a size-bounded LRU cache of object contents.
+//
+// Entries are only ever looked up after the requester has successfully
+// opened the object with its own access, so the cache doesn't widen what
+// anyone can read; keying by macaroon head additionally keeps unrelated
+// projects apart.
+type Cache struct {
+	config Config
+	dir    string
+
+	group singleflight.Group
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[Key]*list.Element
+}
+
+type entry struct {
+	key        Key
+	size       int64
+	expiration time.Time
+
+	data []byte // in-memory contents
+	path string // on-disk contents
+}
+
+// New creates a new Cache. It returns nil (a disabled cache, which is safe to
+// use) if config.MaxSize is zero.
+func New(config Config) (*Cache, error) {
+	if config.MaxSize <= 0 {
+		return nil, nil
+	}
+	if config.MaxObjectSize <= 0 || config.MaxObjectSize > config.MaxSize {
+		config.MaxObjectSize = config.MaxSize
+	}
+
+	cache := &Cache{
+		config:  config,
+		lru:     list.New(),
+		entries: make(map[Key]*list.Element),
+	}
+
+	if config.Dir != "" {
+		if err := os.MkdirAll(config.Dir, 0700); err != nil {
+			return nil, Error.Wrap(err)
+		}
+		dir, err := os.MkdirTemp(config.Dir, "objectcache-")
+		if err != nil {
+			return nil, Error.Wrap(err)
+		}
+		cache.dir = dir
+	}
+
+	return cache, nil
+}
+
+// Close removes all cached contents.
+func (cache *Cache) Close() error {
+	if cache == nil {
+		return nil
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.lru.Init()
+	cache.entries = make(map[Key]*list.Element)
+	cache.size = 0
+
+	if cache.dir != "" {
+		return Error.Wrap(os.RemoveAll(cache.dir))
+	}
+	return nil
+}
+
+// Cacheable reports whether an object of the given size with the given
+// Cache-Control value may be cached and for how long.
+func (cache *Cache) Cacheable(size int64, cacheControl string) (ttl time.Duration, ok bool) {
+	if cache == nil || size < 0 || size > cache.config.MaxObjectSize.Int64() {
+		return 0, false
+	}
+	ttl, ok = parseCacheControl(cacheControl, cache.config.DefaultTTL)
+	if !ok || ttl <= 0 {
+		return 0, false
+	}
+	if cache.config.MaxTTL > 0 && ttl > cache.config.MaxTTL {
+		ttl = cache.config.MaxTTL
+	}
+	return ttl, true
+}
+
+// Ranger returns a ranger that serves the object identified by key out of the
+// cache. On a miss, the whole object is read once from source (concurrent
+// misses for the same key share the read) and stored before serving. If the
+// object can't be stored, reads fall back to source.
+func (cache *Cache) Ranger(key Key, size int64, ttl time.Duration, source ranger.Ranger) ranger.Ranger {
+	return &cachedRanger{cache: cache, key: key, size: size, ttl: ttl, source: source}
+}
+
+// Len returns the number of cached objects.
+func (cache *Cache) Len() int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.lru.Len()
+}
+
+// Size returns the number of cached bytes.
+func (cache *Cache) Size() int64 {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.size
+}
+
+func (cache *Cache) get(key Key) (*entry, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	elem, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*entry)
+	if time.Now().After(e.expiration) {
+		cache.removeElement(elem)
+		mon.Counter("objectcache_expired").Inc(1)
+		return nil, false
+	}
+	cache.lru.MoveToFront(elem)
+	return e, true
+}
+
+func (cache *Cache) fill(ctx context.Context, key Key, size int64, ttl time.Duration, source ranger.Ranger) (_ *entry, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	v, err, _ := cache.group.Do(keyHash(key), func() (interface{}, error) {
+		if e, ok := cache.get(key); ok {
+			return e, nil
+		}
+		e, err := cache.load(ctx, key, size, ttl, source)
+		if err != nil {
+			return nil, err
+		}
+		cache.add(e)
+		return e, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*entry), nil
+}
+
+func (cache *Cache) load(ctx context.Context, key Key, size int64, ttl time.Duration, source ranger.Ranger) (_ *entry, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	rc, err := source.Range(ctx, 0, size)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = errs.Combine(err, rc.Close()) }()
+
+	e := &entry{key: key, size: size, expiration: time.Now().Add(ttl)}
+	if cache.dir == "" {
+		e.data = make([]byte, size)
+		if _, err := io.ReadFull(rc, e.data); err != nil {
+			return nil, Error.Wrap(err)
+		}
+		return e, nil
+	}
+
+	e.path, err = writeFile(cache.dir, keyHash(key), rc, size)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (cache *Cache) add(e *entry) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if elem, ok := cache.entries[e.key]; ok {
+		cache.removeElement(elem)
+	}
+
+	for cache.size+e.size > cache.config.MaxSize.Int64() && cache.lru.Len() > 0 {
+		cache.removeElement(cache.lru.Back())
+		mon.Counter("objectcache_evicted").Inc(1)
+	}
+
+	cache.entries[e.key] = cache.lru.PushFront(e)
+	cache.size += e.size
+
+	mon.IntVal("objectcache_bytes").Observe(cache.size)
+	mon.IntVal("objectcache_objects").Observe(int64(cache.lru.Len()))
+}
+
+// removeElement must be called with mu held.
+func (cache *Cache) removeElement(elem *list.Element) {
+	e := cache.lru.Remove(elem).(*entry)
+	delete(cache.entries, e.key)
+	cache.size -= e.size
+	if e.path != "" {
+		// readers that already opened the file keep working.
+		_ = os.Remove(e.path)
+	}
+}
+
+func (e *entry) open(offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 || length < 0 || offset+length > e.size {
+		return nil, Error.New("range out of bounds")
+	}
+	if e.path == "" {
+		return io.NopCloser(bytes.NewReader(e.data[offset : offset+length])), nil
+	}
+	f, err := os.Open(e.path)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(f, offset, length), f}, nil
+}
+
+type cachedRanger struct {
+	cache  *Cache
+	key    Key
+	size   int64
+	ttl    time.Duration
+	source ranger.Ranger
+}
+
+// Size returns object size.
+func (r *cachedRanger) Size() int64 {
+	return r.size
+}
+
+// Range returns the requested part of the object, from the cache if
+// possible.
+func (r *cachedRanger) Range(ctx context.Context, offset, length int64) (_ io.ReadCloser, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if e, ok := r.cache.get(r.key); ok {
+		// a disk entry may be evicted between get and open, in which case
+		// we just treat this as a miss.
+		if rc, err := e.open(offset, length); err == nil {
+			mon.Counter("objectcache_hit").Inc(1)
+			mon.Counter("objectcache_hit_bytes").Inc(length)
+			if observe, ok := ctx.Value(hitObserverKey{}).(func()); ok {
+				observe()
+			}
+			return rc, nil
+		}
+	}
+	mon.Counter("objectcache_miss").Inc(1)
+
+	e, err := r.cache.fill(ctx, r.key, r.size, r.ttl, r.source)
+	if err == nil {
+		if rc, err := e.open(offset, length); err == nil {
+			return rc, nil
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	mon.Counter("objectcache_fill_failed").Inc(1)
+	return r.source.Range(ctx, offset, length)
+}
+
+type hitObserverKey struct{}
+
+// WithHitObserver returns a context that makes reads served from the cache
+// call observe.
+func WithHitObserver(ctx context.Context, observe func()) context.Context {
+	return context.WithValue(ctx, hitObserverKey{}, observe)
+}
+
+func keyHash(key Key) string {
+	h := sha256.New()
+	for _, s := range []string{key.MacaroonHead, key.Bucket, key.Key, key.Version} {
+		_, _ = h.Write([]byte(s))
+		_, _ = h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func writeFile(dir, name string, r io.Reader, size int64) (path string, err error) {
+	// every fill gets a fresh file so that replacing an entry never
+	// removes the contents of its successor.
+	f, err := os.CreateTemp(dir, name+"-*")
+	if err != nil {
+		return "", Error.Wrap(err)
+	}
+	defer func() {
+		err = errs.Combine(err, Error.Wrap(f.Close()))
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	n, err := io.CopyN(f, r, size)
+	if err != nil {
+		return "", Error.New("short read (%d of %d bytes): %w", n, size, err)
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,169 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package objectcache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/memory"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+)
+
+type countingRanger struct {
+	data  []byte
+	calls int
+}
+
+func (r *countingRanger) Size() int64 { return int64(len(r.data)) }
+
+func (r *countingRanger) Range(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	r.calls++
+	return io.NopCloser(bytes.NewReader(r.data[offset : offset+length])), nil
+}
+
+func readRange(ctx context.Context, t *testing.T, cache *Cache, key Key, source *countingRanger, offset, length int64) []byte {
+	rc, err := cache.Ranger(key, source.Size(), time.Minute, source).Range(ctx, offset, length)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, rc.Close()) }()
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return data
+}
+
+func TestCache(t *testing.T) {
+	for _, disk := range []bool{false, true} {
+		disk := disk
+		name := "memory"
+		if disk {
+			name = "disk"
+		}
+		t.Run(name, func(t *testing.T) {
+			ctx := testcontext.New(t)
+
+			config := Config{MaxSize: 3 * memory.KiB, MaxObjectSize: memory.KiB, DefaultTTL: time.Minute}
+			if disk {
+				config.Dir = ctx.Dir("cache")
+			}
+			cache, err := New(config)
+			require.NoError(t, err)
+			defer ctx.Check(cache.Close)
+
+			sources := make([]*countingRanger, 4)
+			keys := make([]Key, 4)
+			for i := range sources {
+				sources[i] = &countingRanger{data: testrand.BytesInt(memory.KiB.Int())}
+				keys[i] = Key{MacaroonHead: "head", Bucket: "bucket", Key: string(rune('a' + i)), Version: "v1"}
+			}
+
+			// miss, then ranges are served from the cache.
+			require.Equal(t, sources[0].data[10:20], readRange(ctx, t, cache, keys[0], sources[0], 10, 10))
+			require.Equal(t, sources[0].data, readRange(ctx, t, cache, keys[0], sources[0], 0, sources[0].Size()))
+			require.Equal(t, 1, sources[0].calls)
+
+			// a different version is a different entry.
+			other := keys[0]
+			other.Version = "v2"
+			readRange(ctx, t, cache, other, sources[0], 0, 1)
+			require.Equal(t, 2, sources[0].calls)
+			require.Equal(t, 2, cache.Len())
+
+			readRange(ctx, t, cache, keys[1], sources[1], 0, 1)
+			require.Equal(t, 3*memory.KiB.Int64(), cache.Size())
+
+			// touch keys[0] so that "other" is the least recently used.
+			readRange(ctx, t, cache, keys[0], sources[0], 0, 1)
+			readRange(ctx, t, cache, keys[2], sources[2], 0, 1)
+			require.Equal(t, 3, cache.Len())
+			require.Equal(t, 3*memory.KiB.Int64(), cache.Size())
+
+			readRange(ctx, t, cache, keys[0], sources[0], 0, 1)
+			require.Equal(t, 2, sources[0].calls)
+			readRange(ctx, t, cache, other, sources[0], 0, 1)
+			require.Equal(t, 3, sources[0].calls)
+
+			if disk {
+				entries, err := os.ReadDir(cache.dir)
+				require.NoError(t, err)
+				require.Len(t, entries, cache.Len())
+			}
+		})
+	}
+}
+
+func TestCacheExpiration(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	cache, err := New(Config{MaxSize: memory.KiB})
+	require.NoError(t, err)
+
+	source := &countingRanger{data: testrand.BytesInt(100)}
+	key := Key{Key: "a"}
+
+	rc, err := cache.Ranger(key, source.Size(), time.Nanosecond, source).Range(ctx, 0, 1)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	time.Sleep(time.Millisecond)
+
+	rc, err = cache.Ranger(key, source.Size(), time.Nanosecond, source).Range(ctx, 0, 1)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	require.Equal(t, 2, source.calls)
+}
+
+func TestCacheHitObserver(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	cache, err := New(Config{MaxSize: memory.KiB})
+	require.NoError(t, err)
+
+	var hits int
+	observed := WithHitObserver(ctx, func() { hits++ })
+
+	source := &countingRanger{data: testrand.BytesInt(100)}
+	readRange(observed, t, cache, Key{Key: "a"}, source, 0, 1)
+	require.Zero(t, hits)
+	readRange(observed, t, cache, Key{Key: "a"}, source, 0, 1)
+	require.Equal(t, 1, hits)
+	readRange(ctx, t, cache, Key{Key: "a"}, source, 0, 1)
+	require.Equal(t, 1, hits)
+}
+
+func TestCacheable(t *testing.T) {
+	var disabled *Cache
+	_, ok := disabled.Cacheable(1, "")
+	assert.False(t, ok)
+
+	cache, err := New(Config{MaxSize: memory.MiB, MaxObjectSize: memory.KiB, DefaultTTL: time.Minute, MaxTTL: time.Hour})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		size         int64
+		cacheControl string
+		ttl          time.Duration
+		ok           bool
+	}{
+		{size: 10, cacheControl: "", ttl: time.Minute, ok: true},
+		{size: memory.KiB.Int64() + 1, cacheControl: "", ok: false},
+		{size: 10, cacheControl: "max-age=30", ttl: 30 * time.Second, ok: true},
+		{size: 10, cacheControl: "public, max-age=30, s-maxage=60", ttl: time.Minute, ok: true},
+		{size: 10, cacheControl: "max-age=86400", ttl: time.Hour, ok: true},
+		{size: 10, cacheControl: "max-age=0, must-revalidate", ok: false},
+		{size: 10, cacheControl: "no-cache", ok: false},
+		{size: 10, cacheControl: "No-Store", ok: false},
+		{size: 10, cacheControl: "private, max-age=600", ok: false},
+	} {
+		ttl, ok := cache.Cacheable(tc.size, tc.cacheControl)
+		assert.Equal(t, tc.ok, ok, tc.cacheControl)
+		assert.Equal(t, tc.ttl, ttl, tc.cacheControl)
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package objectcache
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseCacheControl decides, from an object's Cache-Control metadata, whether
+// it may be kept in a shared cache and for how long. s-maxage takes precedence
+// over max-age; without either, defaultTTL is used. no-store, no-cache and
+// private forbid caching since we don't revalidate entries.
+func parseCacheControl(value string, defaultTTL time.Duration) (ttl time.Duration, ok bool) {
+	ttl = defaultTTL
+	var maxAge, sMaxAge = -1, -1
+
+	for _, directive := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		arg = strings.Trim(strings.TrimSpace(arg), `"`)
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "no-store", "no-cache", "private":
+			return 0, false
+		case "max-age":
+			if v, err := strconv.Atoi(arg); err == nil && v >= 0 {
+				maxAge = v
+			}
+		case "s-maxage":
+			if v, err := strconv.Atoi(arg); err == nil && v >= 0 {
+				sMaxAge = v
+			}
+		}
+	}
+
+	switch {
+	case sMaxAge >= 0:
+		ttl = time.Duration(sMaxAge) * time.Second
+	case maxAge >= 0:
+		ttl = time.Duration(maxAge) * time.Second
+	}
+
+	return ttl, ttl > 0
+}
@@ -9,7 +9,6 @@ import (
 
 	"github.com/spacemonkeygo/monkit/v3"
 
-	"storj.io/common/ranger"
 	"storj.io/common/ranger/httpranger"
 	"storj.io/uplink"
 )
@@ -27,8 +26,10 @@ type ObjectRanger struct {
 	bucket string
 }
 
-// New creates a new object ranger.
-func New(p *uplink.Project, o *uplink.Object, d *uplink.Download, r httpranger.HTTPRange, bucket string) ranger.Ranger {
+// New creates a new object ranger. d, if not nil, is an already opened
+// download covering r; it's handed out by Range when the requested range
+// matches and closed by Close otherwise.
+func New(p *uplink.Project, o *uplink.Object, d *uplink.Download, r httpranger.HTTPRange, bucket string) *ObjectRanger {
 	return &ObjectRanger{
 		p:      p,
 		o:      o,
@@ -47,7 +48,21 @@ func (ranger *ObjectRanger) Size() int64 {
 func (ranger *ObjectRanger) Range(ctx context.Context, offset, length int64) (_ io.ReadCloser, err error) {
 	defer mon.Task()(&ctx)(&err)
 	if ranger.d != nil && ranger.r.Start == offset && ranger.r.Length == length {
-		return ranger.d, nil
+		d := ranger.d
+		ranger.d = nil
+		return d, nil
 	}
 	return ranger.p.DownloadObject(ctx, ranger.bucket, ranger.o.Key, &uplink.DownloadOptions{Offset: offset, Length: length})
 }
+
+// Close closes the download passed to New if it was never handed out by
+// Range, e.g. because the request turned out to be conditional or the
+// requested range didn't match the predicted one.
+func (ranger *ObjectRanger) Close() error {
+	if ranger.d == nil {
+		return nil
+	}
+	d := ranger.d
+	ranger.d = nil
+	return d.Close()
+}
@@ -5,18 +5,24 @@ package linksharing
 
 import (
 	"context"
+	"net"
+	"net/http"
 	"net/url"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
 	"github.com/oschwald/maxminddb-golang"
 	"github.com/spacemonkeygo/monkit/v3"
-	"github.com/spacemonkeygo/monkit/v3/http"
+	mhttp "github.com/spacemonkeygo/monkit/v3/http"
 	"github.com/zeebo/errs"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 
+	"storj.io/common/lrucache"
 	"storj.io/gateway-mt/pkg/authclient"
+	"storj.io/gateway-mt/pkg/errdata"
 	"storj.io/gateway-mt/pkg/httpserver"
 	"storj.io/gateway-mt/pkg/linksharing/objectmap"
 	"storj.io/gateway-mt/pkg/linksharing/sharing"
@@ -25,6 +31,15 @@ import (
 
 var mon = monkit.Package()
 
+// transientRefusalExpiration is how long on-demand certificate refusals that
+// may not hold for long, like DNS or tier service failures, are cached for.
+const transientRefusalExpiration = 30 * time.Second
+
+// errCertificateRefused is the class of the refusals to obtain a certificate
+// that hold until the domain's configuration changes. Other refusals are
+// cached for transientRefusalExpiration only.
+var errCertificateRefused = errs.Class("certificate refused")
+
 // Config contains configurable values for sno registration Peer.
 type Config struct {
 	Server  httpserver.Config
@@ -46,6 +61,7 @@ type Peer struct {
 	Log        *zap.Logger
 	Mapper     *objectmap.IPDB
 	Server     *httpserver.Server
+	Handler    *sharing.Handler
 	TXTRecords *sharing.TXTRecords
 
 	shutdownDelay time.Duration
@@ -60,7 +76,7 @@ func New(log *zap.Logger, config Config) (_ *Peer, err error) {
 		return nil, err
 	}
 	authClient := authclient.New(config.Handler.AuthServiceConfig)
-	txtRecords := sharing.NewTXTRecords(config.Handler.TXTRecordTTL, dnsClient, authClient)
+	txtRecords := sharing.NewTXTRecords(config.Handler.TXTRecordTTL, config.Handler.TXTRecordNegativeTTL, config.Handler.TXTRecordRevalidationInterval, dnsClient, authClient)
 	if err != nil {
 		return nil, err
 	}
@@ -84,12 +100,14 @@ func New(log *zap.Logger, config Config) (_ *Peer, err error) {
 		return nil, errs.New("unable to create handler: %w", err)
 	}
 
-	handleWithTracing := http.TraceHandler(handle, mon)
+	peer.Handler = handle
+
+	handleWithTracing := mhttp.TraceHandler(handle, mon)
 	instrumentedHandle := middleware.Metrics("linksharing", handleWithTracing)
 
 	var decisionFunc httpserver.CertMagicOnDemandDecisionFunc
 	if config.Server.TLSConfig != nil && config.Server.TLSConfig.CertMagic {
-		decisionFunc, err = customDomainsOverTLSDecisionFunc(config.Server.TLSConfig, txtRecords, dnsClient)
+		decisionFunc, err = customDomainsOverTLSDecisionFunc(log.Named("certmagic"), config.Server.TLSConfig, txtRecords, dnsClient)
 		if err != nil {
 			return nil, errs.New("unable to get decision func for Custom Domains@TLS feature: %w", err)
 		}
@@ -103,7 +121,15 @@ func New(log *zap.Logger, config Config) (_ *Peer, err error) {
 	return peer, nil
 }
 
-func customDomainsOverTLSDecisionFunc(tlsConfig *httpserver.TLSConfig, txtRecords *sharing.TXTRecords, dnsClient *sharing.DNSClient) (httpserver.CertMagicOnDemandDecisionFunc, error) {
+// certificateDecision is a cached on-demand certificate decision.
+type certificateDecision struct {
+	// refusal is why a certificate can't be obtained, if it can't.
+	refusal error
+	// retry is when a transient refusal stops applying.
+	retry time.Time
+}
+
+func customDomainsOverTLSDecisionFunc(log *zap.Logger, tlsConfig *httpserver.TLSConfig, txtRecords *sharing.TXTRecords, dnsClient *sharing.DNSClient) (httpserver.CertMagicOnDemandDecisionFunc, error) {
 	bases := make([]*url.URL, 0, len(tlsConfig.CertMagicPublicURLs))
 	for _, base := range tlsConfig.CertMagicPublicURLs {
 		parsed, err := url.Parse(base)
@@ -113,30 +139,36 @@ func customDomainsOverTLSDecisionFunc(tlsConfig *httpserver.TLSConfig, txtRecord
 		bases = append(bases, parsed)
 	}
 
+	publicIPs := make([]net.IP, 0, len(tlsConfig.CertMagicPublicIPs))
+	for _, s := range tlsConfig.CertMagicPublicIPs {
+		ip := net.ParseIP(strings.TrimSpace(s))
+		if ip == nil {
+			return nil, errs.New("invalid public IP %q", s)
+		}
+		publicIPs = append(publicIPs, ip)
+	}
+
 	tqs, err := sharing.NewTierQueryingService(tlsConfig.TierServiceIdentity, tlsConfig.TierCacheExpiration, tlsConfig.TierCacheCapacity)
 	if err != nil {
 		return nil, errs.New("unable to create tier querying service: %w", err)
 	}
 
-	return func(name string) error {
-		// allow configured urls
-		for _, url := range bases {
-			if name == url.Host {
-				return nil
-			}
-		}
+	decide := func(name string) error {
 		// validate dns txt records for everyone else
 		result, err := txtRecords.FetchAccessForHostNoAccessGrant(tlsConfig.Ctx, name, "")
 		if err != nil {
+			if status := errdata.GetStatus(err, http.StatusInternalServerError); status >= 400 && status < 500 {
+				return errCertificateRefused.Wrap(err)
+			}
 			return err
 		}
 		if !result.TLS {
-			return errs.New("tls not enabled")
+			return errCertificateRefused.New("tls not enabled")
 		}
 		// validate requester is a paying customer
 		for _, allowed := range tlsConfig.SkipPaidTierAllowlist {
 			if allowed == "*" || name == allowed {
-				// skip paid tier query
+				// skip paid tier query and the domain target check
 				return nil
 			}
 		}
@@ -145,16 +177,54 @@ func customDomainsOverTLSDecisionFunc(tlsConfig *httpserver.TLSConfig, txtRecord
 			return err
 		}
 		if !paidTier {
-			return errs.New("not paid tier")
+			return errCertificateRefused.New("not paid tier")
 		}
 
-		// check the CNAME for the domain is pointing to here so challenges from the CA don't fail.
-		if err := validateCNAME(tlsConfig.Ctx, dnsClient, name, bases); err != nil {
-			return err
+		// check the domain is pointing to here so challenges from the CA don't fail.
+		return validateDomainTarget(tlsConfig.Ctx, dnsClient, name, bases, publicIPs)
+	}
+
+	// decisions are cached, including negative ones, as the func is called
+	// for every TLS handshake with a name we don't have a certificate for.
+	// Transient refusals are only used until their retry time.
+	decisions := lrucache.NewOf[certificateDecision](lrucache.Options{
+		Expiration: tlsConfig.CertMagicDecisionCacheExpiration,
+		Capacity:   tlsConfig.CertMagicDecisionCacheCapacity,
+	})
+
+	return func(name string) error {
+		// allow configured urls
+		for _, url := range bases {
+			if name == url.Host {
+				return nil
+			}
 		}
 
-		// request cert
-		return nil
+		decide := func() (certificateDecision, error) {
+			refusal := decide(name)
+			if refusal != nil {
+				log.Info("refusing to obtain certificate", zap.String("name", name), zap.Error(refusal))
+			} else {
+				log.Info("allowing to obtain certificate", zap.String("name", name))
+			}
+			decision := certificateDecision{refusal: refusal}
+			if refusal != nil && !errCertificateRefused.Has(refusal) {
+				decision.retry = time.Now().Add(transientRefusalExpiration)
+			}
+			return decision, nil
+		}
+
+		decision, err := decisions.Get(tlsConfig.Ctx, name, decide)
+		if err != nil {
+			return err
+		}
+		if !decision.retry.IsZero() && time.Now().After(decision.retry) {
+			decisions.Delete(tlsConfig.Ctx, name)
+			if decision, err = decisions.Get(tlsConfig.Ctx, name, decide); err != nil {
+				return err
+			}
+		}
+		return decision.refusal
 	}, nil
 }
 
@@ -162,7 +232,17 @@ func customDomainsOverTLSDecisionFunc(tlsConfig *httpserver.TLSConfig, txtRecord
 func (peer *Peer) Run(ctx context.Context) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	return peer.Server.Run(ctx)
+	group, groupCtx := errgroup.WithContext(ctx)
+
+	group.Go(func() error {
+		return peer.Server.Run(groupCtx)
+	})
+
+	group.Go(func() error {
+		return peer.Handler.Run(groupCtx)
+	})
+
+	return group.Wait()
 }
 
 // Close shuts down the server and all underlying resources.
@@ -179,6 +259,10 @@ func (peer *Peer) Close() error {
 		errlist.Add(peer.Server.Shutdown())
 	}
 
+	if peer.Handler != nil {
+		errlist.Add(peer.Handler.Close())
+	}
+
 	if peer.Mapper != nil {
 		errlist.Add(peer.Mapper.Close())
 	}
@@ -186,6 +270,41 @@ func (peer *Peer) Close() error {
 	return errlist.Err()
 }
 
+// validateDomainTarget checks name points to the service, either with a CNAME
+// record to one of the public URL bases or, as apex domains can't have CNAME
+// records, with A/AAAA records to the public IPs. If publicIPs is empty, the
+// IPs the bases resolve to are used.
+func validateDomainTarget(ctx context.Context, dnsClient *sharing.DNSClient, name string, bases []*url.URL, publicIPs []net.IP) error {
+	cnameErr := validateCNAME(ctx, dnsClient, name, bases)
+	if cnameErr == nil {
+		return nil
+	}
+
+	if len(publicIPs) == 0 {
+		for _, base := range bases {
+			if strings.Contains(base.Hostname(), "*") {
+				continue
+			}
+			ips, err := lookupIPs(ctx, dnsClient, base.Hostname())
+			if err != nil {
+				return errs.New("unable to resolve public host %q: %w", base.Hostname(), err)
+			}
+			publicIPs = append(publicIPs, ips...)
+		}
+	}
+
+	addressErr := validateAddresses(ctx, dnsClient, name, publicIPs)
+	if addressErr == nil {
+		return nil
+	}
+
+	// lookup failures may not last, unlike records pointing elsewhere.
+	if errCertificateRefused.Has(cnameErr) && errCertificateRefused.Has(addressErr) {
+		return errCertificateRefused.New("domain %q doesn't point to the service: %v; %v", name, cnameErr, addressErr)
+	}
+	return errs.New("domain %q doesn't point to the service: %v; %v", name, cnameErr, addressErr)
+}
+
 // validateCNAME checks name has a CNAME record with a value of one of the public URL bases.
 // todo(sean): DNS lookup may be better put into TXTRecords but refactored to handle DNS records
 // in a generic way, then we won't need to be using DNSClient here directly.
@@ -210,5 +329,52 @@ func validateCNAME(ctx context.Context, dnsClient *sharing.DNSClient, name strin
 		}
 	}
 
-	return errs.New("domain %q does not contain a CNAME with any public host", name)
+	return errCertificateRefused.New("domain %q does not contain a CNAME with any public host", name)
+}
+
+// validateAddresses checks name has at least one A/AAAA record and that all
+// of them point to one of publicIPs. A single record pointing elsewhere could
+// make the CA's challenge fail.
+func validateAddresses(ctx context.Context, dnsClient *sharing.DNSClient, name string, publicIPs []net.IP) error {
+	ips, err := lookupIPs(ctx, dnsClient, name)
+	if err != nil {
+		return err
+	}
+	if len(ips) == 0 {
+		return errCertificateRefused.New("domain %q has no A/AAAA records", name)
+	}
+
+	for _, ip := range ips {
+		public := false
+		for _, publicIP := range publicIPs {
+			if ip.Equal(publicIP) {
+				public = true
+				break
+			}
+		}
+		if !public {
+			return errCertificateRefused.New("domain %q has an A/AAAA record pointing to %s, which isn't a public IP", name, ip)
+		}
+	}
+
+	return nil
+}
+
+// lookupIPs returns the IPs of the A and AAAA records of name.
+func lookupIPs(ctx context.Context, dnsClient *sharing.DNSClient, name string) (ips []net.IP, err error) {
+	for _, recordType := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		msg, err := dnsClient.Lookup(ctx, name, recordType)
+		if err != nil {
+			return nil, err
+		}
+		for _, answer := range msg.Answer {
+			switch rec := answer.(type) {
+			case *dns.A:
+				ips = append(ips, rec.A)
+			case *dns.AAAA:
+				ips = append(ips, rec.AAAA)
+			}
+		}
+	}
+	return ips, nil
 }
@@ -0,0 +1,87 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package linksharing
+
+import (
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/gateway-mt/pkg/linksharing/sharing"
+)
+
+func TestValidateDomainTarget(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	zone := map[uint16]map[string][]string{
+		dns.TypeCNAME: {
+			"www.example.test.": {"www.example.test. 60 IN CNAME link.test."},
+			"bad.example.test.": {"bad.example.test. 60 IN CNAME elsewhere.test."},
+		},
+		dns.TypeA: {
+			"link.test.":          {"link.test. 60 IN A 192.0.2.1"},
+			"example.test.":       {"example.test. 60 IN A 192.0.2.1"},
+			"mixed.example.test.": {"mixed.example.test. 60 IN A 192.0.2.1", "mixed.example.test. 60 IN A 198.51.100.1"},
+		},
+		dns.TypeAAAA: {
+			"link.test.":    {"link.test. 60 IN AAAA 2001:db8::1"},
+			"example.test.": {"example.test. 60 IN AAAA 2001:db8::1"},
+		},
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &dns.Server{Listener: listener, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		for _, record := range zone[r.Question[0].Qtype][r.Question[0].Name] {
+			rr, err := dns.NewRR(record)
+			if err == nil {
+				m.Answer = append(m.Answer, rr)
+			}
+		}
+		_ = w.WriteMsg(m)
+	})}
+	ctx.Go(server.ActivateAndServe)
+	defer ctx.Check(server.Shutdown)
+
+	dnsClient, err := sharing.NewDNSClient(listener.Addr().String())
+	require.NoError(t, err)
+
+	bases := []*url.URL{{Scheme: "https", Host: "link.test"}}
+
+	for _, tc := range []struct {
+		name      string
+		publicIPs []net.IP
+		ok        bool
+	}{
+		{name: "www.example.test", ok: true},
+		{name: "bad.example.test"},
+		{name: "example.test", ok: true},
+		{name: "example.test", publicIPs: []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")}, ok: true},
+		{name: "example.test", publicIPs: []net.IP{net.ParseIP("192.0.2.1")}},
+		{name: "mixed.example.test"},
+		{name: "missing.example.test"},
+	} {
+		err := validateDomainTarget(ctx, dnsClient, tc.name, bases, tc.publicIPs)
+		if tc.ok {
+			assert.NoError(t, err, tc.name)
+		} else {
+			assert.True(t, errCertificateRefused.Has(err), tc.name)
+		}
+	}
+
+	// lookup failures don't refuse the domain for good.
+	unreachable, err := sharing.NewDNSClient("127.0.0.1:1")
+	require.NoError(t, err)
+	err = validateDomainTarget(ctx, unreachable, "www.example.test", bases, []net.IP{net.ParseIP("192.0.2.1")})
+	require.Error(t, err)
+	assert.False(t, errCertificateRefused.Has(err))
+}
@@ -17,6 +17,12 @@ import (
 	"storj.io/uplink"
 )
 
+// signedAccessValidityTolerance is how much a signed request's signature time
+// can be skewed.
+//
+// TODO(artur): make signedAccessValidityTolerance a configuration attribute.
+const signedAccessValidityTolerance = 15 * time.Minute
+
 // parseAccess guesses whether access is an access grant or Access Key ID. If
 // latter, it contacts authservice to resolve it. If the resolved access grant
 // isn't public, it will assume r is AWS Signature Version 4-signed if it
@@ -33,6 +39,35 @@ func parseAccess(
 ) (_ *uplink.Access, err error) {
 	defer mon.Task()(&ctx)(&err)
 
+	resolved, err := resolveAccess(ctx, access, cfg, clientIP)
+	if err != nil {
+		return nil, err
+	}
+	if err = resolved.verify(r, signedAccessValidityTolerance); err != nil {
+		return nil, err
+	}
+	return resolved.access, nil
+}
+
+// resolvedAccess is an access grant resolved from an access grant or an Access
+// Key ID whose signing requirements haven't been checked yet. It's safe to
+// share between requests, but every request must be checked with verify
+// before access is used on its behalf.
+type resolvedAccess struct {
+	access *uplink.Access
+	// serialized is the access grant access was parsed from.
+	serialized string
+	// secretKey is set if access was resolved from a non-public Access Key
+	// ID, in which case requests must be signed with it.
+	secretKey string
+}
+
+// resolveAccess guesses whether access is an access grant or Access Key ID. If
+// latter, it contacts authservice to resolve it. clientIP is the IP of the
+// client that originated the request and cannot be empty.
+func resolveAccess(ctx context.Context, access string, cfg *authclient.AuthClient, clientIP string) (_ resolvedAccess, err error) {
+	defer mon.Task()(&ctx)(&err)
+
 	wrappedParse := func(access string) (*uplink.Access, error) {
 		parsed, err := uplink.ParseAccess(access)
 		if err != nil {
@@ -42,24 +77,42 @@ func parseAccess(
 	}
 
 	if isProductionAccessGrant(access) {
-		return wrappedParse(access)
+		parsed, err := wrappedParse(access)
+		return resolvedAccess{access: parsed, serialized: access}, err
 	}
 
 	// otherwise, assume an access key.
 	authResp, err := cfg.ResolveWithCache(ctx, access, clientIP)
 	if err != nil {
-		return nil, err
+		return resolvedAccess{}, err
 	}
-	if !authResp.Public { // If credentials aren't public, assume signed request.
-		if err = signed.VerifySigningInfo(r, authResp.SecretKey, time.Now(), signedAccessValidityTolerance); err != nil {
-			if errs.Is(err, signed.ErrMissingAuthorizationHeader) {
-				return nil, errdata.WithStatus(errs.New("non-public Access Key ID"), http.StatusForbidden)
-			}
-			return nil, errdata.WithStatus(err, http.StatusForbidden)
-		}
+
+	parsed, err := wrappedParse(authResp.AccessGrant)
+	if err != nil {
+		return resolvedAccess{}, err
 	}
 
-	return wrappedParse(authResp.AccessGrant)
+	resolved := resolvedAccess{access: parsed, serialized: authResp.AccessGrant}
+	if !authResp.Public {
+		resolved.secretKey = authResp.SecretKey
+	}
+	return resolved, nil
+}
+
+// verify checks that r may use the resolved access. If the credentials aren't
+// public, r must be AWS Signature Version 4-signed with a valid signature.
+// signedAccessValidityTolerance is how much r's signature time can be skewed.
+func (resolved resolvedAccess) verify(r *http.Request, signedAccessValidityTolerance time.Duration) error {
+	if resolved.secretKey == "" {
+		return nil
+	}
+	if err := signed.VerifySigningInfo(r, resolved.secretKey, time.Now(), signedAccessValidityTolerance); err != nil {
+		if errs.Is(err, signed.ErrMissingAuthorizationHeader) {
+			return errdata.WithStatus(errs.New("non-public Access Key ID"), http.StatusForbidden)
+		}
+		return errdata.WithStatus(err, http.StatusForbidden)
+	}
+	return nil
 }
 
 func isProductionAccessGrant(s string) bool {
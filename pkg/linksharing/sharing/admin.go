@@ -0,0 +1,204 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"storj.io/gateway-mt/pkg/linksharing/analytics"
+	"storj.io/gateway-mt/pkg/linksharing/sharing/internal/signed"
+	"storj.io/gateway-mt/pkg/trustedip"
+	privateAccess "storj.io/uplink/private/access"
+)
+
+// adminPathPrefix is the path prefix of the admin endpoints. They are served
+// on our own domains only, and, except for analytics, only if admin tokens
+// are configured:
+//
+//	POST /admin/txt-records/purge?host=<hostname>
+//	POST /admin/txt-records/purge?macaroon_head=<hex>
+//	POST /admin/txt-records/refresh?host=<hostname>
+//	POST /admin/txt-records/refresh?macaroon_head=<hex>
+//	GET  /admin/analytics?macaroon_head=<hex>
+//
+// purge removes hostnames from the TXT record cache, while refresh queries
+// their TXT records again. host can be given several times; macaroon_head
+// selects all cached hostnames using an access with that macaroon head.
+// analytics returns the counters of the hosted sites served with an access
+// with that macaroon head. Besides admin tokens, it accepts requests signed
+// with the credentials of an access with that macaroon head, so customers can
+// read their own counters.
+const adminPathPrefix = "/admin/"
+
+// adminAnalyticsPath is the path of the analytics endpoint.
+const adminAnalyticsPath = adminPathPrefix + "analytics"
+
+// adminTXTRecordsResponse is the response of the TXT record cache admin
+// endpoints.
+type adminTXTRecordsResponse struct {
+	// Hostnames are the hostnames that were purged or refreshed.
+	Hostnames []string `json:"hostnames"`
+	// Failed maps hostnames that couldn't be refreshed to the error. They
+	// are removed from the cache.
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+// adminAnalyticsResponse is the response of the analytics admin endpoint.
+type adminAnalyticsResponse struct {
+	Domains []analytics.Domain `json:"domains"`
+}
+
+func (handler *Handler) serveAdmin(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if r.URL.Path == adminAnalyticsPath {
+		// analytics authorize customers themselves.
+		return handler.serveAdminAnalytics(ctx, w, r)
+	}
+
+	if !handler.adminAuthorized(r) {
+		mon.Event("admin_unauthorized")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, adminPathPrefix) {
+	case "txt-records/purge":
+		return handler.serveAdminTXTRecords(ctx, w, r, false)
+	case "txt-records/refresh":
+		return handler.serveAdminTXTRecords(ctx, w, r, true)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+		return nil
+	}
+}
+
+func (handler *Handler) serveAdminTXTRecords(ctx context.Context, w http.ResponseWriter, r *http.Request, refresh bool) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if !adminAllowMethod(w, r, http.MethodPost) {
+		return nil
+	}
+
+	query := r.URL.Query()
+	hostnames := query["host"]
+	if head := query.Get("macaroon_head"); head != "" {
+		decoded, err := hex.DecodeString(head)
+		if err != nil {
+			http.Error(w, "invalid macaroon_head", http.StatusBadRequest)
+			return nil
+		}
+		hostnames = append(hostnames, handler.txtRecords.HostnamesForMacaroonHead(decoded)...)
+	}
+	if len(query["host"]) == 0 && !query.Has("macaroon_head") {
+		http.Error(w, "host or macaroon_head is required", http.StatusBadRequest)
+		return nil
+	}
+
+	response := adminTXTRecordsResponse{Hostnames: []string{}}
+	for _, hostname := range hostnames {
+		if !refresh {
+			if handler.txtRecords.Purge(ctx, hostname) {
+				response.Hostnames = append(response.Hostnames, hostname)
+			}
+			continue
+		}
+
+		refreshed, err := handler.txtRecords.Refresh(ctx, hostname)
+		if err != nil {
+			if response.Failed == nil {
+				response.Failed = make(map[string]string)
+			}
+			response.Failed[hostname] = err.Error()
+			continue
+		}
+		if refreshed {
+			response.Hostnames = append(response.Hostnames, hostname)
+		}
+	}
+
+	handler.log.Info("admin: txt record cache updated",
+		zap.Bool("refresh", refresh),
+		zap.Strings("hostnames", response.Hostnames),
+		zap.Int("failed", len(response.Failed)))
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(response)
+}
+
+func (handler *Handler) serveAdminAnalytics(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if !adminAllowMethod(w, r, http.MethodGet) {
+		return nil
+	}
+	if !handler.analytics.Enabled() {
+		http.Error(w, "analytics are disabled", http.StatusNotFound)
+		return nil
+	}
+
+	head, err := hex.DecodeString(r.URL.Query().Get("macaroon_head"))
+	if err != nil || len(head) == 0 {
+		http.Error(w, "invalid macaroon_head", http.StatusBadRequest)
+		return nil
+	}
+
+	if !handler.adminAuthorized(r) && !handler.macaroonHeadAuthorized(ctx, r, head) {
+		mon.Event("admin_analytics_unauthorized")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(adminAnalyticsResponse{Domains: handler.analytics.Domains(head)})
+}
+
+// adminAllowMethod writes a 405 response and returns false if r doesn't use
+// method.
+func adminAllowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+// adminAuthorized reports whether r carries one of the admin tokens.
+func (handler *Handler) adminAuthorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	for _, token := range handler.adminTokens {
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// macaroonHeadAuthorized reports whether r is signed with the credentials of
+// an access whose macaroon head is head. Public credentials and access grants
+// don't prove anything, so they aren't accepted.
+func (handler *Handler) macaroonHeadAuthorized(ctx context.Context, r *http.Request, head []byte) bool {
+	accessKeyID, err := signed.AccessKeyID(r)
+	if err != nil {
+		return false
+	}
+
+	resolved, err := resolveAccess(ctx, accessKeyID, handler.authClient, trustedip.GetClientIP(handler.trustedClientIPsList, r))
+	if err != nil || resolved.secretKey == "" {
+		return false
+	}
+	if err := resolved.verify(r, signedAccessValidityTolerance); err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(privateAccess.APIKey(resolved.access).Head(), head) == 1
+}
@@ -0,0 +1,235 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/gateway-mt/pkg/auth/authdb"
+	"storj.io/gateway-mt/pkg/linksharing/analytics"
+	"storj.io/gateway-mt/pkg/linksharing/objectmap"
+	"storj.io/uplink"
+	privateAccess "storj.io/uplink/private/access"
+)
+
+func TestServeAdmin(t *testing.T) {
+	records, _ := newTestTXTRecords(t, 0)
+
+	handler, err := NewHandler(zaptest.NewLogger(t), &objectmap.IPDB{}, records, nil, nil, Config{
+		URLBases:    []string{"http://test.test"},
+		Templates:   "../../../pkg/linksharing/web/",
+		AdminTokens: []string{"", "admin-token"},
+	})
+	require.NoError(t, err)
+
+	access, err := uplink.ParseAccess(testAccessGrant)
+	require.NoError(t, err)
+	head := hex.EncodeToString(privateAccess.APIKey(access).Head())
+
+	do := func(method, target, token string) (*httptest.ResponseRecorder, adminTXTRecordsResponse) {
+		r := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		var response adminTXTRecordsResponse
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		}
+		return w, response
+	}
+
+	storeTestTXTRecord(t, records, "a.test", "")
+	storeTestTXTRecord(t, records, "b.test", "")
+	storeTestTXTRecord(t, records, "c.test", "")
+
+	w, _ := do(http.MethodPost, "http://test.test/admin/txt-records/purge?host=a.test", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w, _ = do(http.MethodPost, "http://test.test/admin/txt-records/purge?host=a.test", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w, _ = do(http.MethodGet, "http://test.test/admin/txt-records/purge?host=a.test", "admin-token")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	w, _ = do(http.MethodPost, "http://test.test/admin/txt-records/purge", "admin-token")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = do(http.MethodPost, "http://test.test/admin/txt-records/purge?macaroon_head=zz", "admin-token")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = do(http.MethodPost, "http://test.test/admin/unknown", "admin-token")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w, response := do(http.MethodPost, "http://test.test/admin/txt-records/purge?host=a.test&host=unknown.test", "admin-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"a.test"}, response.Hostnames)
+	assert.ElementsMatch(t, []string{"b.test", "c.test"}, records.HostnamesForMacaroonHead(privateAccess.APIKey(access).Head()))
+
+	w, response = do(http.MethodPost, "http://test.test/admin/txt-records/purge?macaroon_head="+head, "admin-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.ElementsMatch(t, []string{"b.test", "c.test"}, response.Hostnames)
+	assert.Empty(t, records.HostnamesForMacaroonHead(privateAccess.APIKey(access).Head()))
+
+	// hosted sites don't expose the admin endpoints.
+	storeTestTXTRecord(t, records, "a.test", "")
+	w, _ = do(http.MethodPost, "http://a.test/admin/txt-records/purge?host=a.test", "admin-token")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	_, ok := records.cache.Load("a.test")
+	assert.True(t, ok)
+}
+
+func TestServeAdminAnalytics(t *testing.T) {
+	records, _ := newTestTXTRecords(t, 0)
+
+	handler, err := NewHandler(zaptest.NewLogger(t), &objectmap.IPDB{}, records, nil, nil, Config{
+		URLBases:    []string{"http://test.test"},
+		Templates:   "../../../pkg/linksharing/web/",
+		AdminTokens: []string{"admin-token"},
+		Analytics:   analytics.Config{MaxDomains: 10},
+	})
+	require.NoError(t, err)
+
+	access, err := uplink.ParseAccess(testAccessGrant)
+	require.NoError(t, err)
+	head := hex.EncodeToString(privateAccess.APIKey(access).Head())
+
+	// the site requires basic auth, so requests are answered without
+	// contacting the satellite.
+	record, err := records.newTXTRecord("a.test", Result{
+		Root:      "bucket",
+		BasicAuth: map[string][]byte{"user": []byte("hash")},
+	}, resolvedAccess{access: access, serialized: testAccessGrant}, "", true, time.Hour)
+	require.NoError(t, err)
+	records.cache.Store("a.test", record)
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://a.test/index.html", nil))
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+	// requests that aren't for hosted sites aren't counted.
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://test.test/", nil))
+
+	do := func(method, target, token string) (*httptest.ResponseRecorder, adminAnalyticsResponse) {
+		r := httptest.NewRequest(method, target, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		var response adminAnalyticsResponse
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		}
+		return w, response
+	}
+
+	w, _ := do(http.MethodGet, "http://test.test/admin/analytics?macaroon_head="+head, "wrong")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w, _ = do(http.MethodPost, "http://test.test/admin/analytics?macaroon_head="+head, "admin-token")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	w, _ = do(http.MethodGet, "http://test.test/admin/analytics", "admin-token")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w, response := do(http.MethodGet, "http://test.test/admin/analytics?macaroon_head="+head, "admin-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, response.Domains, 1)
+	assert.Equal(t, "a.test", response.Domains[0].Hostname)
+	assert.EqualValues(t, 2, response.Domains[0].Requests)
+	assert.EqualValues(t, 2, response.Domains[0].Status4xx)
+	assert.Positive(t, response.Domains[0].BytesServed)
+
+	w, response = do(http.MethodGet, "http://test.test/admin/analytics?macaroon_head=00", "admin-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, response.Domains)
+}
+
+func TestServeAdminAnalyticsSigned(t *testing.T) {
+	records, auth := newTestTXTRecords(t, 0)
+	auth.secretKey = "secret"
+
+	handler, err := NewHandler(zaptest.NewLogger(t), &objectmap.IPDB{}, records, records.auth, nil, Config{
+		URLBases:  []string{"http://test.test"},
+		Templates: "../../../pkg/linksharing/web/",
+		Analytics: analytics.Config{MaxDomains: 10},
+	})
+	require.NoError(t, err)
+
+	access, err := uplink.ParseAccess(testAccessGrant)
+	require.NoError(t, err)
+	head := hex.EncodeToString(privateAccess.APIKey(access).Head())
+
+	key, err := authdb.NewEncryptionKey()
+	require.NoError(t, err)
+	accessKeyID := key.ToBase32()
+
+	do := func(target, secretKey string) int {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if secretKey != "" {
+			signTestRequest(r, accessKeyID, secretKey, time.Now())
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// customers can read the counters of their own macaroon head only, even
+	// without admin tokens configured.
+	assert.Equal(t, http.StatusOK, do("http://test.test/admin/analytics?macaroon_head="+head, "secret"))
+	assert.Equal(t, http.StatusUnauthorized, do("http://test.test/admin/analytics?macaroon_head=00", "secret"))
+	assert.Equal(t, http.StatusUnauthorized, do("http://test.test/admin/analytics?macaroon_head="+head, "wrong"))
+	assert.Equal(t, http.StatusUnauthorized, do("http://test.test/admin/analytics?macaroon_head="+head, ""))
+
+	// the other admin endpoints stay disabled.
+	assert.NotEqual(t, http.StatusOK, do("http://test.test/admin/txt-records/purge?macaroon_head="+head, "secret"))
+
+	// public credentials don't prove ownership of the access.
+	auth.secretKey = ""
+	records.auth.Cache.Delete(context.Background(), accessKeyID)
+	assert.Equal(t, http.StatusUnauthorized, do("http://test.test/admin/analytics?macaroon_head="+head, "secret"))
+}
+
+// signTestRequest signs r with AWS Signature Version 4 for the linksharing
+// service.
+func signTestRequest(r *http.Request, accessKeyID, secretKey string, now time.Time) {
+	hmacSHA256 := func(key []byte, data string) []byte {
+		hash := hmac.New(sha256.New, key)
+		hash.Write([]byte(data))
+		return hash.Sum(nil)
+	}
+
+	date := now.UTC().Format("20060102T150405Z")
+	scope := date[:8] + "/eu1/linksharing/aws4_request"
+	r.Header.Set("X-Amz-Date", date)
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.ReplaceAll(r.URL.Query().Encode(), "+", "%20"),
+		"host:" + r.Host + "\nx-amz-date:" + date + "\n",
+		"host;x-amz-date",
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+	}, "\n")
+	canonicalRequestSum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", date, scope, hex.EncodeToString(canonicalRequestSum[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date[:8])
+	for _, part := range []string{"eu1", "linksharing", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	r.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=host;x-amz-date, Signature=%s",
+		accessKeyID, scope, hex.EncodeToString(hmacSHA256(key, stringToSign))))
+}
@@ -0,0 +1,202 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	"storj.io/common/errs2"
+	"storj.io/common/sync2"
+	"storj.io/gateway-mt/pkg/linksharing/analytics"
+	"storj.io/gateway-mt/pkg/linksharing/objectcache"
+	privateAccess "storj.io/uplink/private/access"
+)
+
+// analyticsReportTimeFormat is the format of the times in the keys of the
+// analytics reports written to buckets.
+const analyticsReportTimeFormat = "20060102T150405Z"
+
+// siteRequest is what's known about the hosted site a request is for. It's
+// filled in while the request is served and counted once it's done.
+type siteRequest struct {
+	hostname     string
+	macaroonHead []byte
+	cacheHit     bool
+}
+
+type siteRequestKey struct{}
+
+func withSiteRequest(ctx context.Context, site *siteRequest) context.Context {
+	ctx = context.WithValue(ctx, siteRequestKey{}, site)
+	return objectcache.WithHitObserver(ctx, func() { site.cacheHit = true })
+}
+
+// setSiteRequest records that the request of ctx is served for hostname with
+// result.Access.
+func setSiteRequest(ctx context.Context, hostname string, result Result) {
+	site, ok := ctx.Value(siteRequestKey{}).(*siteRequest)
+	if !ok || result.Access == nil {
+		return
+	}
+	site.hostname = hostname
+	site.macaroonHead = privateAccess.APIKey(result.Access).Head()
+}
+
+// analyticsWriter keeps track of the status and size of a response.
+type analyticsWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *analyticsWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *analyticsWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher.
+func (w *analyticsWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// trackSiteRequest wraps w and r so that the request is counted for the
+// hosted site it's for, if any, once done is called.
+func (handler *Handler) trackSiteRequest(w http.ResponseWriter, r *http.Request) (_ http.ResponseWriter, _ *http.Request, done func()) {
+	site := &siteRequest{}
+	aw := &analyticsWriter{ResponseWriter: w}
+
+	return aw, r.WithContext(withSiteRequest(r.Context(), site)), func() {
+		if site.hostname == "" {
+			return
+		}
+		status := aw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		handler.analytics.Record(site.hostname, site.macaroonHead, analytics.Request{
+			Status:   status,
+			Bytes:    aw.bytes,
+			CacheHit: site.cacheHit || status == http.StatusNotModified,
+		})
+	}
+}
+
+// runAnalytics writes analytics reports to the buckets of hosted sites that
+// opted in every analytics flush interval and prunes idle hostnames, until ctx
+// is canceled. Pruning runs on its own, so it also happens if flushing is
+// disabled.
+func (handler *Handler) runAnalytics(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	group, ctx := errgroup.WithContext(ctx)
+	if interval := handler.analytics.FlushInterval(); interval > 0 {
+		group.Go(func() error {
+			return runCycle(ctx, interval, func(ctx context.Context) {
+				handler.flushAnalytics(ctx, time.Now())
+			})
+		})
+	}
+	if interval := handler.analytics.PruneInterval(); interval > 0 {
+		group.Go(func() error {
+			return runCycle(ctx, interval, func(ctx context.Context) {
+				handler.analytics.Prune(time.Now())
+			})
+		})
+	}
+	return group.Wait()
+}
+
+// runCycle calls fn every interval, starting after the first interval, until
+// ctx is canceled.
+func runCycle(ctx context.Context, interval time.Duration, fn func(ctx context.Context)) error {
+	cycle := sync2.NewCycle(interval)
+	cycle.SetDelayStart()
+	defer cycle.Close()
+
+	return errs2.IgnoreCanceled(cycle.Run(ctx, func(ctx context.Context) error {
+		fn(ctx)
+		return nil
+	}))
+}
+
+// flushAnalytics writes the pending analytics reports. Reports of sites that
+// haven't opted in with a storj-analytics TXT record are discarded, while
+// reports that fail to be written are retried on the next flush.
+func (handler *Handler) flushAnalytics(ctx context.Context, now time.Time) {
+	defer mon.Task()(&ctx)(nil)
+
+	for _, report := range handler.analytics.Reports(now) {
+		// the access is used on behalf of the site owner, who opted in, and
+		// not of a client, so it doesn't need to be verified.
+		result, err := handler.txtRecords.FetchAccessForHost(ctx, report.Hostname, "")
+		if err != nil {
+			mon.Event("analytics_flush_failed")
+			handler.log.Debug("unable to fetch access for analytics", zap.String("hostname", report.Hostname), zap.Error(err))
+			continue
+		}
+		if result.Analytics == "" {
+			handler.analytics.Commit(report)
+			continue
+		}
+
+		if err := handler.uploadAnalyticsReport(ctx, result, report); err != nil {
+			mon.Event("analytics_flush_failed")
+			handler.log.Warn("unable to upload analytics report", zap.String("hostname", report.Hostname), zap.Error(err))
+			continue
+		}
+		mon.Event("analytics_flushed")
+		handler.analytics.Commit(report)
+	}
+}
+
+// uploadAnalyticsReport writes report to the bucket and prefix given by
+// result.Analytics, as <prefix>/<hostname>/<end>.json.
+func (handler *Handler) uploadAnalyticsReport(ctx context.Context, result Result, report analytics.Report) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	bucket, prefix, _ := strings.Cut(result.Analytics, "/")
+	key := path.Join(prefix, report.Hostname, report.End.UTC().Format(analyticsReportTimeFormat)+".json")
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	project, err := handler.uplink.OpenProject(ctx, result.Access)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errs.Combine(err, project.Close()) }()
+
+	upload, err := project.UploadObject(ctx, bucket, key, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := upload.Write(data); err != nil {
+		return errs.Combine(err, upload.Abort())
+	}
+	return upload.Commit()
+}
@@ -0,0 +1,271 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"storj.io/common/memory"
+	"storj.io/common/ranger"
+	"storj.io/common/ranger/httpranger"
+)
+
+const (
+	// maxByteRanges is the number of parts a multipart/byteranges response
+	// may have. Requests for more are answered with the whole object, as
+	// every part needs its own download.
+	maxByteRanges = 32
+
+	// byteRangesDownloadGap is the largest gap between consecutive parts
+	// that are read with a single download, skipping the bytes in between,
+	// instead of opening another one.
+	byteRangesDownloadGap = 256 * memory.KiB
+)
+
+// byteRangePart is a part of a multipart/byteranges response.
+type byteRangePart struct {
+	httpranger.HTTPRange
+	// order is the position of the first range of the Range header the part
+	// covers.
+	order int
+}
+
+func (part byteRangePart) end() int64 {
+	return part.Start + part.Length
+}
+
+// multipleRanges reports whether the Range header value s asks for more than
+// one range.
+func multipleRanges(s string) bool {
+	return strings.Contains(s, ",")
+}
+
+// byteRanges returns the parts a multipart/byteranges response to r should
+// have. ok is false if r isn't a multi-range request that can be answered
+// with one, in which case it's left to httpranger.ServeContent (e.g. for
+// errors and single ranges). etag and modtime are used to evaluate If-Range.
+func byteRanges(r *http.Request, etag string, modtime time.Time, size int64) (parts []byteRangePart, ok bool) {
+	header := r.Header.Get("Range")
+	if !multipleRanges(header) || !ifRangeMatches(r.Header.Get("If-Range"), etag, modtime) {
+		return nil, false
+	}
+
+	ranges, err := httpranger.ParseRange(header, size)
+	if err != nil || len(ranges) < 2 {
+		return nil, false
+	}
+
+	var total int64
+	for _, ra := range ranges {
+		total += ra.Length
+	}
+	if total > size {
+		// like httpranger.ServeContent, we don't serve more than the object
+		// (e.g. the same range many times).
+		return nil, false
+	}
+
+	return coalesceByteRanges(ranges), true
+}
+
+// coalesceByteRanges merges overlapping and adjacent ranges (as RFC 7233
+// section 4.1 allows) into parts, which are ordered like the ranges they
+// cover first appear.
+func coalesceByteRanges(ranges []httpranger.HTTPRange) []byteRangePart {
+	parts := make([]byteRangePart, 0, len(ranges))
+	for i, ra := range ranges {
+		parts = append(parts, byteRangePart{HTTPRange: ra, order: i})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Start < parts[j].Start })
+
+	merged := parts[:1]
+	for _, part := range parts[1:] {
+		last := &merged[len(merged)-1]
+		if part.Start > last.end() {
+			merged = append(merged, part)
+			continue
+		}
+		if part.end() > last.end() {
+			last.Length = part.end() - last.Start
+		}
+		if part.order < last.order {
+			last.order = part.order
+		}
+	}
+
+	sort.Slice(merged, func(i, j int) bool { return merged[i].order < merged[j].order })
+	return merged
+}
+
+// ifRangeMatches reports whether the If-Range header value ifRange allows a
+// partial response for the representation with etag and modtime (RFC 7233
+// section 3.2). An empty ifRange always does.
+func ifRangeMatches(ifRange, etag string, modtime time.Time) bool {
+	switch {
+	case ifRange == "":
+		return true
+	case strings.HasPrefix(ifRange, `"`), strings.HasPrefix(ifRange, "W/"):
+		return ifRange == etag && !strings.HasPrefix(etag, "W/")
+	}
+	t, err := http.ParseTime(ifRange)
+	return err == nil && !isZeroTime(modtime) && t.Unix() == modtime.Unix()
+}
+
+// serveByteRanges writes a 206 response with parts of content. A single part
+// is sent as is; several are sent as multipart/byteranges. Consecutive parts
+// with small gaps in between are read with one download.
+func (handler *Handler) serveByteRanges(ctx context.Context, w http.ResponseWriter, r *http.Request, content ranger.Ranger, parts []byteRangePart) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if len(parts) > maxByteRanges {
+		mon.Event("byteranges_too_many")
+		full := r.Clone(ctx)
+		full.Header.Del("Range")
+		return httpranger.ServeContent(ctx, w, full, "", time.Time{}, content)
+	}
+
+	size := content.Size()
+	h := w.Header()
+	h.Set("Accept-Ranges", "bytes")
+
+	if len(parts) == 1 {
+		h.Set("Content-Range", contentRange(parts[0].HTTPRange, size))
+		h.Set("Content-Length", strconv.FormatInt(parts[0].Length, 10))
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusPartialContent)
+			return nil
+		}
+		rc, err := content.Range(ctx, parts[0].Start, parts[0].Length)
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusPartialContent)
+		_, err = io.Copy(w, rc)
+		handler.finishByteRanges(err, rc.Close())
+		return nil
+	}
+
+	contentType := h.Get("Content-Type")
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	h.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	h.Set("Content-Length", strconv.FormatInt(byteRangesSize(parts, boundary, contentType, size), 10))
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusPartialContent)
+		return nil
+	}
+
+	// the first download is opened before writing the header, so that e.g.
+	// permission errors still get a proper response.
+	var rc io.ReadCloser
+	var pos int64
+	open := func(i int) error {
+		end := parts[i].end()
+		for j := i + 1; j < len(parts) && parts[j].Start >= parts[j-1].end() && parts[j].Start-parts[j-1].end() <= byteRangesDownloadGap.Int64(); j++ {
+			end = parts[j].end()
+		}
+		var err error
+		rc, err = content.Range(ctx, parts[i].Start, end-parts[i].Start)
+		pos = parts[i].Start
+		return err
+	}
+	if err := open(0); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusPartialContent)
+
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		handler.finishByteRanges(err, rc.Close())
+		return nil
+	}
+
+	for i, part := range parts {
+		if i > 0 && (part.Start < pos || part.Start-pos > byteRangesDownloadGap.Int64()) {
+			if err := rc.Close(); err != nil {
+				handler.finishByteRanges(err, nil)
+				return nil
+			}
+			if err := open(i); err != nil {
+				handler.finishByteRanges(err, nil)
+				return nil
+			}
+		}
+
+		pw, err := mw.CreatePart(byteRangePartHeader(part.HTTPRange, contentType, size))
+		if err == nil && part.Start > pos {
+			_, err = io.CopyN(io.Discard, rc, part.Start-pos)
+		}
+		if err == nil {
+			_, err = io.CopyN(pw, rc, part.Length)
+		}
+		if err != nil {
+			handler.finishByteRanges(err, rc.Close())
+			return nil
+		}
+		pos = part.end()
+	}
+
+	handler.finishByteRanges(mw.Close(), rc.Close())
+	return nil
+}
+
+// finishByteRanges logs errors that happen after the response header was
+// written, when it's too late to report them to the client.
+func (handler *Handler) finishByteRanges(copyErr, closeErr error) {
+	if copyErr != nil {
+		handler.log.Debug("unable to write byte ranges", zap.Error(copyErr))
+	}
+	if closeErr != nil {
+		handler.log.Warn("unable to close download", zap.Error(closeErr))
+	}
+}
+
+// byteRangesSize returns the size of the multipart/byteranges body with parts.
+func byteRangesSize(parts []byteRangePart, boundary, contentType string, size int64) int64 {
+	var counter countingWriter
+	mw := multipart.NewWriter(&counter)
+	_ = mw.SetBoundary(boundary)
+
+	var total int64
+	for _, part := range parts {
+		_, _ = mw.CreatePart(byteRangePartHeader(part.HTTPRange, contentType, size))
+		total += part.Length
+	}
+	_ = mw.Close()
+
+	return total + int64(counter)
+}
+
+func byteRangePartHeader(ra httpranger.HTTPRange, contentType string, size int64) textproto.MIMEHeader {
+	header := textproto.MIMEHeader{"Content-Range": {contentRange(ra, size)}}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	return header
+}
+
+func contentRange(ra httpranger.HTTPRange, size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", ra.Start, ra.Start+ra.Length-1, size)
+}
+
+// countingWriter counts how many bytes have been written to it.
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
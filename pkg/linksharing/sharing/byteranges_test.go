@@ -0,0 +1,152 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/common/ranger/httpranger"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+)
+
+// recordingRanger serves data and records the ranges it's asked for.
+type recordingRanger struct {
+	data   []byte
+	ranges []httpranger.HTTPRange
+}
+
+func (r *recordingRanger) Size() int64 { return int64(len(r.data)) }
+
+func (r *recordingRanger) Range(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+	r.ranges = append(r.ranges, httpranger.HTTPRange{Start: offset, Length: length})
+	return io.NopCloser(bytes.NewReader(r.data[offset : offset+length])), nil
+}
+
+func TestCoalesceByteRanges(t *testing.T) {
+	parts := coalesceByteRanges([]httpranger.HTTPRange{
+		{Start: 500, Length: 100},
+		{Start: 0, Length: 10},
+		{Start: 10, Length: 10}, // adjacent to the previous one.
+		{Start: 550, Length: 100},
+		{Start: 5, Length: 2}, // within the second one.
+	})
+	assert.Equal(t, []byteRangePart{
+		{HTTPRange: httpranger.HTTPRange{Start: 500, Length: 150}, order: 0},
+		{HTTPRange: httpranger.HTTPRange{Start: 0, Length: 20}, order: 1},
+	}, parts)
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	modtime := time.Date(2023, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	assert.True(t, ifRangeMatches("", `"etag"`, modtime))
+	assert.True(t, ifRangeMatches(`"etag"`, `"etag"`, modtime))
+	assert.False(t, ifRangeMatches(`"other"`, `"etag"`, modtime))
+	assert.False(t, ifRangeMatches(`W/"etag"`, `W/"etag"`, modtime))
+	assert.True(t, ifRangeMatches(modtime.Format(http.TimeFormat), `"etag"`, modtime))
+	assert.False(t, ifRangeMatches(modtime.Add(time.Hour).Format(http.TimeFormat), `"etag"`, modtime))
+	assert.False(t, ifRangeMatches("garbage", `"etag"`, modtime))
+}
+
+func TestServeByteRanges(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	handler := &Handler{log: zaptest.NewLogger(t)}
+	data := testrand.BytesInt(2 << 20)
+	modtime := time.Now()
+
+	serve := func(method, rangeHeader string) (*httptest.ResponseRecorder, *recordingRanger) {
+		r := httptest.NewRequest(method, "/", nil)
+		r.Header.Set("Range", rangeHeader)
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Type", "application/pdf")
+		content := &recordingRanger{data: data}
+
+		parts, ok := byteRanges(r, `"etag"`, modtime, content.Size())
+		require.True(t, ok, rangeHeader)
+		require.NoError(t, handler.serveByteRanges(ctx, w, r, content, parts))
+		return w, content
+	}
+
+	// the first two parts are close enough to share a download.
+	w, content := serve(http.MethodGet, "bytes=0-99,1000-1099,1500000-1500099")
+	require.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, []httpranger.HTTPRange{{Start: 0, Length: 1100}, {Start: 1500000, Length: 100}}, content.ranges)
+	assert.Equal(t, strconv.Itoa(w.Body.Len()), w.Header().Get("Content-Length"))
+
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+
+	reader := multipart.NewReader(w.Body, params["boundary"])
+	for _, expected := range []httpranger.HTTPRange{{Start: 0, Length: 100}, {Start: 1000, Length: 100}, {Start: 1500000, Length: 100}} {
+		part, err := reader.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "application/pdf", part.Header.Get("Content-Type"))
+		assert.Equal(t, contentRange(expected, int64(len(data))), part.Header.Get("Content-Range"))
+		body, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, data[expected.Start:expected.Start+expected.Length], body)
+	}
+	_, err = reader.NextPart()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// adjacent ranges become a single part.
+	w, content = serve(http.MethodGet, "bytes=10-19,0-9")
+	require.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "bytes 0-19/"+strconv.Itoa(len(data)), w.Header().Get("Content-Range"))
+	assert.Equal(t, data[:20], w.Body.Bytes())
+	assert.Len(t, content.ranges, 1)
+
+	// HEAD requests don't download anything.
+	w, content = serve(http.MethodHead, "bytes=0-9,100-109")
+	require.Equal(t, http.StatusPartialContent, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Content-Length"))
+	assert.Zero(t, w.Body.Len())
+	assert.Empty(t, content.ranges)
+
+	// too many parts are answered with the whole object.
+	header := "bytes=0-0"
+	for i := 1; i <= maxByteRanges; i++ {
+		header += "," + strconv.Itoa(i*10) + "-" + strconv.Itoa(i*10)
+	}
+	w, _ = serve(http.MethodGet, header)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, data, w.Body.Bytes())
+}
+
+func TestByteRangesNotApplicable(t *testing.T) {
+	modtime := time.Now()
+
+	for _, tc := range []struct {
+		rangeHeader, ifRange string
+	}{
+		{rangeHeader: ""},
+		{rangeHeader: "bytes=0-9"},
+		{rangeHeader: "bytes=0-9,20-29", ifRange: `"other"`},
+		{rangeHeader: "bytes=0-9,x-y"},
+		{rangeHeader: "bytes=0-99,0-99"},
+		{rangeHeader: "bytes=0-9,200-"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Range", tc.rangeHeader)
+		r.Header.Set("If-Range", tc.ifRange)
+		_, ok := byteRanges(r, `"etag"`, modtime, 100)
+		assert.False(t, ok, tc)
+	}
+}
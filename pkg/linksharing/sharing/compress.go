@@ -0,0 +1,192 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/zeebo/errs"
+
+	"storj.io/common/memory"
+	"storj.io/common/ranger"
+)
+
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+
+	// brotliLevel is the Brotli quality used for on-the-fly compression.
+	// Higher levels compress better but are too slow to use per request.
+	brotliLevel = 5
+)
+
+// CompressionConfig configures on-the-fly compression of text assets.
+type CompressionConfig struct {
+	// Enabled enables compression.
+	Enabled bool
+	// MinSize is the size of the smallest object that is compressed. Smaller
+	// ones don't gain enough to be worth it.
+	MinSize memory.Size
+}
+
+var (
+	gzipWriters   = sync.Pool{New: func() interface{} { return gzip.NewWriter(io.Discard) }}
+	brotliWriters = sync.Pool{New: func() interface{} { return brotli.NewWriterLevel(io.Discard, brotliLevel) }}
+)
+
+// compressibleTypes are the media types, besides text/*, that are worth
+// compressing.
+var compressibleTypes = map[string]bool{
+	"application/javascript":    true,
+	"application/json":          true,
+	"application/manifest+json": true,
+	"application/wasm":          true,
+	"application/xml":           true,
+	"image/svg+xml":             true,
+	"image/x-icon":              true,
+	"font/otf":                  true,
+	"font/ttf":                  true,
+}
+
+// compressible reports whether content of contentType is worth compressing.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	default:
+		return compressibleTypes[mediaType]
+	}
+}
+
+// compressionEncoding returns the encoding an object of the given size should
+// be compressed with for r, or "" if it should be sent as is. The headers
+// already set on w (Content-Type, Content-Encoding and Cache-Control) decide
+// whether the object can be compressed at all, in which case
+// Vary: Accept-Encoding is added so that caches keep the encodings apart.
+func (handler *Handler) compressionEncoding(w http.ResponseWriter, r *http.Request, size int64) string {
+	if !handler.compression.Enabled || size < handler.compression.MinSize.Int64() {
+		return ""
+	}
+
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" || !compressible(h.Get("Content-Type")) {
+		return ""
+	}
+	for _, directive := range strings.Split(h.Get("Cache-Control"), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-transform") {
+			return ""
+		}
+	}
+
+	h.Add("Vary", "Accept-Encoding")
+
+	// ranges refer to the uncompressed content, so they're served as is.
+	if r.Header.Get("Range") != "" {
+		return ""
+	}
+	return negotiateEncoding(r.Header.Values("Accept-Encoding"))
+}
+
+// negotiateEncoding picks the preferred encoding we support among the ones
+// accepted in Accept-Encoding header values (RFC 9110 section 12.5.3),
+// preferring Brotli over gzip when the client doesn't care.
+func negotiateEncoding(acceptEncoding []string) string {
+	qualities := map[string]float64{}
+	for _, value := range acceptEncoding {
+		for _, item := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(item, ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "" {
+				continue
+			}
+			q := 1.0
+			if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.EqualFold(strings.TrimSpace(name), "q") {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil {
+					continue
+				}
+				q = parsed
+			}
+			qualities[coding] = q
+		}
+	}
+
+	quality := func(coding string) float64 {
+		if q, ok := qualities[coding]; ok {
+			return q
+		}
+		if coding == encodingGzip {
+			if q, ok := qualities["x-gzip"]; ok {
+				return q
+			}
+		}
+		return qualities["*"]
+	}
+
+	br, gz := quality(encodingBrotli), quality(encodingGzip)
+	switch {
+	case br > 0 && br >= gz:
+		return encodingBrotli
+	case gz > 0:
+		return encodingGzip
+	default:
+		return ""
+	}
+}
+
+// serveCompressed serves the whole of content compressed with encoding. The
+// compressed size isn't known in advance, so there's no Content-Length.
+func serveCompressed(ctx context.Context, w http.ResponseWriter, r *http.Request, content ranger.Ranger, encoding string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	h := w.Header()
+	h.Set("Content-Encoding", encoding)
+	h.Del("Content-Length")
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	rc, err := content.Range(ctx, 0, content.Size())
+	if err != nil {
+		return err
+	}
+	defer func() { err = errs.Combine(err, rc.Close()) }()
+
+	w.WriteHeader(http.StatusOK)
+
+	switch encoding {
+	case encodingBrotli:
+		bw := brotliWriters.Get().(*brotli.Writer)
+		defer brotliWriters.Put(bw)
+		bw.Reset(w)
+		if _, err := io.Copy(bw, rc); err != nil {
+			return err
+		}
+		return bw.Close()
+	default:
+		gw := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(gw)
+		gw.Reset(w)
+		if _, err := io.Copy(gw, rc); err != nil {
+			return err
+		}
+		return gw.Close()
+	}
+}
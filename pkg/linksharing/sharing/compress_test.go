@@ -0,0 +1,139 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/memory"
+	"storj.io/common/testcontext"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		accept   []string
+		encoding string
+	}{
+		{accept: nil, encoding: ""},
+		{accept: []string{"identity"}, encoding: ""},
+		{accept: []string{"gzip"}, encoding: "gzip"},
+		{accept: []string{"x-gzip"}, encoding: "gzip"},
+		{accept: []string{"gzip, deflate, br"}, encoding: "br"},
+		{accept: []string{"gzip", "br"}, encoding: "br"},
+		{accept: []string{"br;q=0.5, gzip;q=0.8"}, encoding: "gzip"},
+		{accept: []string{"br;q=0, gzip"}, encoding: "gzip"},
+		{accept: []string{"*"}, encoding: "br"},
+		{accept: []string{"*;q=0.1, br;q=0"}, encoding: "gzip"},
+		{accept: []string{"gzip;q=0"}, encoding: ""},
+		{accept: []string{"GZIP;Q=1"}, encoding: "gzip"},
+		{accept: []string{"gzip;q=invalid"}, encoding: ""},
+	} {
+		assert.Equal(t, tc.encoding, negotiateEncoding(tc.accept), "%q", tc.accept)
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	for _, contentType := range []string{"text/html; charset=utf-8", "text/css", "application/javascript", "application/json", "application/ld+json", "image/svg+xml"} {
+		assert.True(t, compressible(contentType), contentType)
+	}
+	for _, contentType := range []string{"", "image/png", "video/mp4", "application/zip", "application/octet-stream", "invalid/"} {
+		assert.False(t, compressible(contentType), contentType)
+	}
+}
+
+func TestCompressionEncoding(t *testing.T) {
+	handler := &Handler{compression: CompressionConfig{Enabled: true, MinSize: memory.KiB}}
+
+	check := func(contentType, cacheControl, rangeHeader string, size int64) (string, []string) {
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Type", contentType)
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip, br")
+		if rangeHeader != "" {
+			r.Header.Set("Range", rangeHeader)
+		}
+		return handler.compressionEncoding(w, r, size), w.Header().Values("Vary")
+	}
+
+	encoding, vary := check("text/html", "", "", 2048)
+	assert.Equal(t, "br", encoding)
+	assert.Equal(t, []string{"Accept-Encoding"}, vary)
+
+	// the response varies even if a range isn't compressed.
+	encoding, vary = check("text/html", "", "bytes=0-10", 2048)
+	assert.Empty(t, encoding)
+	assert.Equal(t, []string{"Accept-Encoding"}, vary)
+
+	for _, tc := range []struct {
+		contentType, cacheControl string
+		size                      int64
+	}{
+		{contentType: "text/html", size: 100},
+		{contentType: "image/png", size: 2048},
+		{contentType: "text/html", cacheControl: "max-age=60, no-transform", size: 2048},
+	} {
+		encoding, vary = check(tc.contentType, tc.cacheControl, "", tc.size)
+		assert.Empty(t, encoding)
+		assert.Empty(t, vary)
+	}
+
+	w := httptest.NewRecorder()
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Content-Encoding", "gzip")
+	assert.Empty(t, handler.compressionEncoding(w, httptest.NewRequest(http.MethodGet, "/", nil), 2048))
+
+	handler.compression.Enabled = false
+	encoding, _ = check("text/html", "", "", 2048)
+	assert.Empty(t, encoding)
+}
+
+func TestServeCompressed(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	content := strings.Repeat("<p>hello, world</p>\n", 100)
+
+	for _, encoding := range []string{"gzip", "br"} {
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Length", "2000")
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		ranger := SimpleRanger(io.NopCloser(strings.NewReader(content)), int64(len(content)))
+		require.NoError(t, serveCompressed(ctx, w, r, ranger, encoding))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, encoding, w.Header().Get("Content-Encoding"))
+		assert.Empty(t, w.Header().Get("Content-Length"))
+		assert.Less(t, w.Body.Len(), len(content))
+
+		var decoder io.Reader
+		if encoding == "gzip" {
+			zr, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+			require.NoError(t, err)
+			decoder = zr
+		} else {
+			decoder = brotli.NewReader(bytes.NewReader(w.Body.Bytes()))
+		}
+		decoded, err := io.ReadAll(decoder)
+		require.NoError(t, err)
+		assert.Equal(t, content, string(decoded))
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodHead, "/", nil)
+	require.NoError(t, serveCompressed(ctx, w, r, SimpleRanger(io.NopCloser(strings.NewReader(content)), int64(len(content))), "gzip"))
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Zero(t, w.Body.Len())
+}
@@ -4,10 +4,18 @@
 package sharing
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/spacemonkeygo/monkit/v3"
 	"github.com/zeebo/errs"
 )
 
@@ -15,30 +23,176 @@ var (
 	errDNS = errs.Class("dns error")
 )
 
+const (
+	// dnsOverHTTPSTimeout is how long to wait for a DNS-over-HTTPS response.
+	dnsOverHTTPSTimeout = 5 * time.Second
+	// dnsMessageMaxSize is the maximum size of a DNS message.
+	dnsMessageMaxSize = 65535
+)
+
 // DNSClient is a wrapper utility around github.com/miekg/dns to make it
 // a bit more palatable and client user friendly.
 type DNSClient struct {
-	c         *dns.Client
-	dnsServer string
+	upstreams []dnsUpstream
+}
+
+// dnsUpstream is a DNS server to send queries to.
+type dnsUpstream struct {
+	// name is the upstream as configured. It's used to tag metrics.
+	name     string
+	exchange func(ctx context.Context, m *dns.Msg) (*dns.Msg, error)
 }
 
-// NewDNSClient creates a DNS Client that uses the given
-// dnsServerAddr. Currently requires that the DNS Server speaks TCP.
+// NewDNSClient creates a DNS Client that uses the given comma-separated list
+// of DNS servers. Each query goes to the first server that answers, in order.
+// Servers can be given as:
+//
+//	1.1.1.1:53 or tcp://1.1.1.1:53            plain DNS over TCP
+//	tls://1.1.1.1 or tls://1.1.1.1:853        DNS over TLS
+//	https://cloudflare-dns.com/dns-query      DNS over HTTPS
+//
+// Plain DNS is always spoken over TCP, so responses are never truncated.
 func NewDNSClient(dnsServerAddr string) (*DNSClient, error) {
-	return &DNSClient{
-		c:         &dns.Client{Net: "tcp"},
-		dnsServer: dnsServerAddr,
-	}, nil
+	client := &DNSClient{}
+	for _, s := range strings.Split(dnsServerAddr, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		upstream, err := newDNSUpstream(s)
+		if err != nil {
+			return nil, errDNS.New("invalid server %q: %w", s, err)
+		}
+		client.upstreams = append(client.upstreams, upstream)
+	}
+	return client, nil
+}
+
+func newDNSUpstream(s string) (dnsUpstream, error) {
+	upstream := dnsUpstream{name: s}
+
+	switch {
+	case strings.HasPrefix(s, "https://"):
+		u, err := url.Parse(s)
+		if err != nil {
+			return dnsUpstream{}, err
+		}
+		client := &http.Client{Timeout: dnsOverHTTPSTimeout}
+		upstream.exchange = func(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+			return exchangeHTTPS(ctx, client, u.String(), m)
+		}
+	case strings.HasPrefix(s, "tls://"):
+		addr := withDefaultPort(strings.TrimPrefix(s, "tls://"), "853")
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dnsUpstream{}, err
+		}
+		client := &dns.Client{Net: "tcp-tls", TLSConfig: &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}}
+		upstream.exchange = func(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+			r, _, err := client.ExchangeContext(ctx, m, addr)
+			return r, err
+		}
+	default:
+		addr := withDefaultPort(strings.TrimPrefix(s, "tcp://"), "53")
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return dnsUpstream{}, err
+		}
+		client := &dns.Client{Net: "tcp"}
+		upstream.exchange = func(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+			r, _, err := client.ExchangeContext(ctx, m, addr)
+			return r, err
+		}
+	}
+
+	return upstream, nil
+}
+
+// withDefaultPort adds port to addr if it doesn't have one.
+func withDefaultPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+}
+
+// exchangeHTTPS sends m to a DNS-over-HTTPS server (RFC 8484).
+func exchangeHTTPS(ctx context.Context, client *http.Client, serverURL string, m *dns.Msg) (_ *dns.Msg, err error) {
+	// the ID should be 0 to make responses cacheable by HTTP caches.
+	query := m.Copy()
+	query.Id = 0
+
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, serverURL, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = errs.Combine(err, resp.Body.Close()) }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errs.New("unexpected status: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dnsMessageMaxSize))
+	if err != nil {
+		return nil, err
+	}
+
+	r := new(dns.Msg)
+	if err = r.Unpack(body); err != nil {
+		return nil, err
+	}
+	r.Id = m.Id
+
+	return r, nil
 }
 
-// Lookup is a helper method that never returns truncated DNS messages.
-// The current implementation does this by doing all lookups over TCP.
+// Lookup is a helper method that never returns truncated DNS messages. It
+// tries the servers in order and returns the first answer; servers that fail
+// or respond with SERVFAIL or REFUSED are skipped.
 func (cli *DNSClient) Lookup(ctx context.Context, host string, recordType uint16) (_ *dns.Msg, err error) {
 	defer mon.Task()(&ctx)(&err)
 	m := dns.Msg{}
 	m.SetQuestion(dns.Fqdn(host), recordType)
-	r, _, err := cli.c.ExchangeContext(ctx, &m, cli.dnsServer)
-	return r, errDNS.Wrap(err)
+
+	if len(cli.upstreams) == 0 {
+		return nil, errDNS.New("no servers configured")
+	}
+
+	var group errs.Group
+	for _, upstream := range cli.upstreams {
+		tag := monkit.NewSeriesTag("upstream", upstream.name)
+
+		start := time.Now()
+		r, err := upstream.exchange(ctx, &m)
+		mon.DurationVal("dns_upstream_latency", tag).Observe(time.Since(start))
+
+		if err == nil && (r.Rcode == dns.RcodeServerFailure || r.Rcode == dns.RcodeRefused) {
+			err = errs.New("%s responded with %s", upstream.name, dns.RcodeToString[r.Rcode])
+		}
+		if err != nil {
+			mon.Counter("dns_upstream_error", tag).Inc(1)
+			group.Add(err)
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+
+		return r, nil
+	}
+
+	return nil, errDNS.Wrap(group.Err())
 }
 
 // ResponseToTXTRecordSet returns a TXTRecordSet from a dns Lookup response.
@@ -57,3 +211,20 @@ func ResponseToTXTRecordSet(resp *dns.Msg) *TXTRecordSet {
 	}
 	return set
 }
+
+// negativeTTL returns how long the negative response resp (e.g. NXDOMAIN) may
+// be cached, according to its SOA record (RFC 2308).
+func negativeTTL(resp *dns.Msg) (ttl time.Duration, ok bool) {
+	for _, ns := range resp.Ns {
+		soa, isSOA := ns.(*dns.SOA)
+		if !isSOA {
+			continue
+		}
+		seconds := soa.Hdr.Ttl
+		if soa.Minttl < seconds {
+			seconds = soa.Minttl
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	return 0, false
+}
@@ -0,0 +1,145 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+)
+
+// startTestDNSServer starts a DNS server speaking TCP and returns its
+// address.
+func startTestDNSServer(ctx *testcontext.Context, t *testing.T, handler dns.HandlerFunc) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &dns.Server{Listener: listener, Handler: handler}
+	ctx.Go(server.ActivateAndServe)
+	t.Cleanup(func() { _ = server.Shutdown() })
+
+	return listener.Addr().String()
+}
+
+// answerTXT answers every query with a TXT record holding txt.
+func answerTXT(txt string) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+			Txt: []string{txt},
+		})
+		_ = w.WriteMsg(m)
+	}
+}
+
+func TestDNSClientFailover(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	var failing int32
+	failingAddr := startTestDNSServer(ctx, t, func(w dns.ResponseWriter, r *dns.Msg) {
+		atomic.AddInt32(&failing, 1)
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		_ = w.WriteMsg(m)
+	})
+	workingAddr := startTestDNSServer(ctx, t, answerTXT("storj-root:bucket"))
+
+	// nothing listens on unusedAddr.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unusedAddr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	client, err := NewDNSClient(unusedAddr + ", tcp://" + failingAddr + "," + workingAddr)
+	require.NoError(t, err)
+	require.Len(t, client.upstreams, 3)
+
+	r, err := client.Lookup(ctx, "txt-site.test", dns.TypeTXT)
+	require.NoError(t, err)
+	assert.Equal(t, "bucket", ResponseToTXTRecordSet(r).Lookup("storj-root"))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&failing))
+
+	client, err = NewDNSClient(unusedAddr + "," + failingAddr)
+	require.NoError(t, err)
+	_, err = client.Lookup(ctx, "txt-site.test", dns.TypeTXT)
+	require.Error(t, err)
+	assert.True(t, errDNS.Has(err))
+
+	client, err = NewDNSClient(" , ")
+	require.NoError(t, err)
+	_, err = client.Lookup(ctx, "txt-site.test", dns.TypeTXT)
+	require.Error(t, err)
+	_, err = NewDNSClient("https://%zz")
+	require.Error(t, err)
+}
+
+func TestDNSClientHTTPS(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/dns-message", r.Header.Get("Content-Type"))
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		query := new(dns.Msg)
+		require.NoError(t, query.Unpack(body))
+		assert.Zero(t, query.Id)
+
+		m := new(dns.Msg)
+		m.SetReply(query)
+		m.Answer = append(m.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: query.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+			Txt: []string{"storj-root:bucket"},
+		})
+		packed, err := m.Pack()
+		require.NoError(t, err)
+
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(packed)
+	}))
+	defer server.Close()
+
+	upstream, err := newDNSUpstream(server.URL + "/dns-query")
+	require.NoError(t, err)
+
+	// the test server's certificate is only trusted by its own client.
+	upstream.exchange = func(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+		return exchangeHTTPS(ctx, server.Client(), server.URL+"/dns-query", m)
+	}
+	client := &DNSClient{upstreams: []dnsUpstream{upstream}}
+
+	r, err := client.Lookup(ctx, "txt-site.test", dns.TypeTXT)
+	require.NoError(t, err)
+	assert.Equal(t, "bucket", ResponseToTXTRecordSet(r).Lookup("storj-root"))
+}
+
+func TestNegativeTTL(t *testing.T) {
+	m := new(dns.Msg)
+	_, ok := negativeTTL(m)
+	assert.False(t, ok)
+
+	m.Ns = append(m.Ns, &dns.SOA{Hdr: dns.RR_Header{Rrtype: dns.TypeSOA, Ttl: 300}, Minttl: 60})
+	ttl, ok := negativeTTL(m)
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, ttl)
+
+	m.Ns[0].(*dns.SOA).Hdr.Ttl = 30
+	ttl, ok = negativeTTL(m)
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, ttl)
+}
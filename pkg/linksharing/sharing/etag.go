@@ -0,0 +1,133 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"storj.io/uplink"
+)
+
+// objectETag returns a strong entity tag for o. Objects are immutable, so the
+// key, creation time and size together identify a particular version of an
+// object (uplink doesn't expose object versions yet). archivePath, if not
+// empty, is mixed in so that files inside an archive get their own tags.
+func objectETag(o *uplink.Object, archivePath string) string {
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(o.System.Created.UnixNano()))
+	binary.BigEndian.PutUint64(buf[8:], uint64(o.System.ContentLength))
+
+	h := sha256.New()
+	_, _ = h.Write([]byte(o.Key))
+	_, _ = h.Write(buf[:])
+	if archivePath != "" {
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(archivePath))
+	}
+
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// etagVariant derives the tag of an alternative representation (e.g. a
+// compressed one) from etag, keeping it strong.
+func etagVariant(etag, variant string) string {
+	return strings.TrimSuffix(etag, `"`) + "-" + variant + `"`
+}
+
+// hasPreconditions reports whether r carries any validator that could make
+// us respond without a body (304 Not Modified or 412 Precondition Failed).
+func hasPreconditions(r *http.Request) bool {
+	for _, name := range []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		if r.Header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPreconditions evaluates If-Match, If-Unmodified-Since, If-None-Match and
+// If-Modified-Since (in the order mandated by RFC 7232 section 6) against etag
+// and modtime. If the request can be answered without content, it writes the
+// 304 or 412 response and reports done. It must be called before any download
+// is opened, so conditional requests for unchanged objects never touch the
+// storage nodes. If-Range is left to httpranger.ServeContent, which compares it
+// against the ETag header set here.
+func checkPreconditions(w http.ResponseWriter, r *http.Request, etag string, modtime time.Time) (done bool) {
+	h := w.Header()
+	h.Set("ETag", etag)
+	if !isZeroTime(modtime) {
+		h.Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+	}
+
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !etagListMatches(im, etag, false) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return true
+		}
+	} else if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && !isZeroTime(modtime) {
+		if t, err := http.ParseTime(ius); err == nil && !modtime.Before(t.Add(time.Second)) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return true
+		}
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etagListMatches(inm, etag, true) {
+			writeNotModified(w)
+			return true
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !isZeroTime(modtime) {
+		// Last-Modified has a one second resolution, so compare with
+		// modtime < t+1s rather than modtime <= t.
+		if t, err := http.ParseTime(ims); err == nil && modtime.Before(t.Add(time.Second)) {
+			writeNotModified(w)
+			return true
+		}
+	}
+
+	return false
+}
+
+// etagListMatches reports whether the comma-separated list of entity tags in
+// header (or "*") matches etag. weak selects the weak comparison function used
+// by If-None-Match instead of the strong one used by If-Match.
+func etagListMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = textproto.TrimString(candidate)
+		switch {
+		case candidate == "*":
+			return true
+		case weak && strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/"):
+			return true
+		case !weak && candidate == etag && !strings.HasPrefix(etag, "W/"):
+			return true
+		}
+	}
+	return false
+}
+
+// writeNotModified strips representation headers and writes a 304 as
+// described in RFC 7232 section 4.1.
+func writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	delete(h, "Content-Type")
+	delete(h, "Content-Length")
+	delete(h, "Content-Disposition")
+	delete(h, "Content-Encoding")
+	if h.Get("ETag") != "" {
+		delete(h, "Last-Modified")
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// isZeroTime reports whether t is obviously unspecified.
+func isZeroTime(t time.Time) bool {
+	return t.IsZero() || t.Equal(time.Unix(0, 0))
+}
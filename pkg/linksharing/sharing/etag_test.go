@@ -0,0 +1,115 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"storj.io/common/ranger/httpranger"
+	"storj.io/common/testcontext"
+	"storj.io/gateway-mt/pkg/linksharing/objectmap"
+	"storj.io/uplink"
+)
+
+func TestObjectETag(t *testing.T) {
+	created := time.Date(2023, 1, 2, 3, 4, 5, 6, time.UTC)
+	o := &uplink.Object{Key: "index.html", System: uplink.SystemMetadata{Created: created, ContentLength: 10}}
+
+	etag := objectETag(o, "")
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+	assert.Equal(t, etag, objectETag(&uplink.Object{Key: "index.html", System: uplink.SystemMetadata{Created: created, ContentLength: 10}}, ""))
+
+	assert.NotEqual(t, etag, objectETag(&uplink.Object{Key: "index.htm", System: o.System}, ""))
+	assert.NotEqual(t, etag, objectETag(&uplink.Object{Key: o.Key, System: uplink.SystemMetadata{Created: created.Add(time.Nanosecond), ContentLength: 10}}, ""))
+	assert.NotEqual(t, etag, objectETag(&uplink.Object{Key: o.Key, System: uplink.SystemMetadata{Created: created, ContentLength: 11}}, ""))
+	assert.NotEqual(t, etag, objectETag(o, "a.txt"))
+
+	assert.Equal(t, etag[:len(etag)-1]+`-gzip"`, etagVariant(etag, "gzip"))
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	const etag = `"abc"`
+	modtime := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	before := modtime.Add(-time.Hour).Format(http.TimeFormat)
+	after := modtime.Add(time.Hour).Format(http.TimeFormat)
+
+	for _, tc := range []struct {
+		desc    string
+		headers map[string]string
+		status  int
+	}{
+		{desc: "no preconditions", status: 0},
+		{desc: "if-none-match match", headers: map[string]string{"If-None-Match": etag}, status: http.StatusNotModified},
+		{desc: "if-none-match weak match", headers: map[string]string{"If-None-Match": `"x", W/"abc"`}, status: http.StatusNotModified},
+		{desc: "if-none-match star", headers: map[string]string{"If-None-Match": "*"}, status: http.StatusNotModified},
+		{desc: "if-none-match mismatch", headers: map[string]string{"If-None-Match": `"def"`}, status: 0},
+		{desc: "if-none-match wins over if-modified-since", headers: map[string]string{"If-None-Match": `"def"`, "If-Modified-Since": after}, status: 0},
+		{desc: "if-modified-since not modified", headers: map[string]string{"If-Modified-Since": after}, status: http.StatusNotModified},
+		{desc: "if-modified-since modified", headers: map[string]string{"If-Modified-Since": before}, status: 0},
+		{desc: "if-match match", headers: map[string]string{"If-Match": etag}, status: 0},
+		{desc: "if-match weak", headers: map[string]string{"If-Match": `W/"abc"`}, status: http.StatusPreconditionFailed},
+		{desc: "if-match mismatch", headers: map[string]string{"If-Match": `"def"`}, status: http.StatusPreconditionFailed},
+		{desc: "if-unmodified-since modified", headers: map[string]string{"If-Unmodified-Since": before}, status: http.StatusPreconditionFailed},
+		{desc: "if-unmodified-since unmodified", headers: map[string]string{"If-Unmodified-Since": after}, status: 0},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://test.test/", nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			w.Header().Set("Content-Type", "text/html")
+
+			done := checkPreconditions(w, r, etag, modtime)
+			assert.Equal(t, tc.status != 0, done)
+			assert.Equal(t, etag, w.Header().Get("ETag"))
+			if tc.status != 0 {
+				assert.Equal(t, tc.status, w.Code)
+			}
+			if tc.status == http.StatusNotModified {
+				assert.Empty(t, w.Header().Get("Content-Type"))
+				assert.Empty(t, w.Header().Get("Last-Modified"))
+			}
+		})
+	}
+}
+
+func TestShowObjectNotModified(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	handler, err := NewHandler(&zap.Logger{}, &objectmap.IPDB{}, nil, nil, nil, Config{
+		URLBases:  []string{"http://test.test"},
+		Templates: "../../../pkg/linksharing/web/",
+	})
+	require.NoError(t, err)
+
+	object := &uplink.Object{
+		Key:    "test.css",
+		System: uplink.SystemMetadata{Created: time.Now(), ContentLength: 100},
+	}
+	etag := objectETag(object, "")
+
+	for _, hosting := range []bool{false, true} {
+		r, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://test.test/test.css", nil)
+		require.NoError(t, err)
+		r.Header.Set("If-None-Match", etag)
+		require.True(t, hasPreconditions(r))
+
+		// the zero project would panic on download, so this also checks that
+		// a 304 doesn't open one.
+		w := httptest.NewRecorder()
+		err = handler.showObject(ctx, w, r, &parsedRequest{hosting: hosting}, &uplink.Project{}, object, nil, httpranger.HTTPRange{})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Equal(t, etag, w.Header().Get("ETag"))
+		assert.Empty(t, w.Body.Bytes())
+	}
+}
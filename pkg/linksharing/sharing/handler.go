@@ -10,7 +10,6 @@ import (
 	"net"
 	"net/http"
 	"net/url"
-	"path/filepath"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -19,15 +18,20 @@ import (
 	"github.com/spacemonkeygo/monkit/v3"
 	"github.com/zeebo/errs"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 
+	"storj.io/common/lrucache"
 	"storj.io/common/ranger"
 	"storj.io/common/ranger/httpranger"
 	"storj.io/common/rpc/rpcpool"
 	"storj.io/gateway-mt/pkg/authclient"
 	"storj.io/gateway-mt/pkg/errdata"
+	"storj.io/gateway-mt/pkg/linksharing/analytics"
+	"storj.io/gateway-mt/pkg/linksharing/objectcache"
 	"storj.io/gateway-mt/pkg/linksharing/objectmap"
 	"storj.io/gateway-mt/pkg/trustedip"
 	"storj.io/uplink"
+	"storj.io/uplink/private/object"
 	"storj.io/uplink/private/transport"
 	"storj.io/zipper"
 )
@@ -68,6 +72,15 @@ type Config struct {
 	// Templates location with html templates.
 	Templates string
 
+	// TemplateThemes is the location of per-hostname template overrides: a
+	// directory per hostname (the host of a URL base or a hosted site) with
+	// the templates to replace for it. Empty disables themes.
+	TemplateThemes string
+
+	// TemplatesReloadInterval is how often the templates are checked for
+	// changes on disk and reloaded. Zero disables reloading.
+	TemplatesReloadInterval time.Duration
+
 	// StaticSourcesPath is the path to where the web assets are located
 	// on disk.
 	StaticSourcesPath string
@@ -75,11 +88,26 @@ type Config struct {
 	// TXTRecordTTL is the duration for which an entry in the txtRecordCache is valid.
 	TXTRecordTTL time.Duration
 
+	// TXTRecordNegativeTTL is the maximum duration for which hostnames without
+	// TXT records are remembered in the txtRecordCache. Zero disables
+	// negative caching.
+	TXTRecordNegativeTTL time.Duration
+
+	// TXTRecordRevalidationInterval is how often Access Key IDs of entries in
+	// the txtRecordCache are re-validated with the auth service. Zero
+	// disables re-validation.
+	TXTRecordRevalidationInterval time.Duration
+
+	// AdminTokens are the bearer tokens allowed to use the admin endpoints.
+	// The admin endpoints are disabled if there are none.
+	AdminTokens []string
+
 	// AuthServiceConfig contains configuration required to use the auth service to resolve
 	// access key ids into access grants.
 	AuthServiceConfig authclient.Config
 
-	// DNS Server address, for TXT record lookup
+	// DNS Server addresses (comma-separated, tried in order), for TXT record
+	// lookup. See NewDNSClient for the supported transports.
 	DNSServer string
 
 	// RedirectHTTPS enables redirection to https://.
@@ -116,6 +144,20 @@ type Config struct {
 	// StandardViewsHTML controls whether to serve HTML as text/html instead of
 	// text/plain for standard (non-hosting) requests.
 	StandardViewsHTML bool
+
+	// ObjectCache configures the local cache of hot objects. It's disabled
+	// if ObjectCache.MaxSize is zero.
+	ObjectCache objectcache.Config
+
+	// Map configures the object map view.
+	Map MapConfig
+
+	// Compression configures on-the-fly compression of text assets.
+	Compression CompressionConfig
+
+	// Analytics configures the per-hostname counters of hosted sites. They're
+	// disabled if Analytics.MaxDomains is zero.
+	Analytics analytics.Config
 }
 
 // ConnectionPoolConfig is a config struct for configuring RPC connection pool options.
@@ -125,14 +167,20 @@ type ConnectionPoolConfig struct {
 	IdleExpiration time.Duration
 }
 
+// basicAuthCacheCapacity is the number of successful basic auth verifications
+// for hosted sites that are remembered.
+const basicAuthCacheCapacity = 10000
+
 // Handler implements the link sharing HTTP handler.
 //
 // architecture: Service
 type Handler struct {
 	log                    *zap.Logger
 	urlBases               []*url.URL
-	templates              *template.Template
+	templates              *templates
 	mapper                 *objectmap.IPDB
+	mapConfig              MapConfig
+	ipSummaryCache         *lrucache.ExpiringLRUOf[*object.IPSummary]
 	txtRecords             *TXTRecords
 	authClient             *authclient.AuthClient
 	static                 http.Handler
@@ -143,6 +191,13 @@ type Handler struct {
 	standardRendersContent bool
 	standardViewsHTML      bool
 	archiveRanger          func(ctx context.Context, project *uplink.Project, bucket, key, path string, canReturnGzip bool) (_ ranger.Ranger, isGzip bool, _ error)
+	objectCache            *objectcache.Cache
+	compression            CompressionConfig
+	basicAuthCache         *lrucache.ExpiringLRUOf[bool]
+	readmeCache            *lrucache.ExpiringLRUOf[template.HTML]
+	readmeRenders          chan struct{}
+	analytics              *analytics.Collector
+	adminTokens            []string
 	inShutdown             *int32
 }
 
@@ -160,7 +215,7 @@ func NewHandler(log *zap.Logger, mapper *objectmap.IPDB, txtRecords *TXTRecords,
 		return nil, errors.New("requires at least one url base")
 	}
 
-	templates, err := template.ParseGlob(filepath.Join(config.Templates, "*.html"))
+	templates, err := newTemplates(log, config.Templates, config.TemplateThemes, config.TemplatesReloadInterval)
 	if err != nil {
 		return nil, err
 	}
@@ -201,7 +256,19 @@ func NewHandler(log *zap.Logger, mapper *objectmap.IPDB, txtRecords *TXTRecords,
 		if err != nil {
 			return nil, err
 		}
-		txtRecords = NewTXTRecords(config.TXTRecordTTL, dns, authClient)
+		txtRecords = NewTXTRecords(config.TXTRecordTTL, config.TXTRecordNegativeTTL, config.TXTRecordRevalidationInterval, dns, authClient)
+	}
+
+	var adminTokens []string
+	for _, token := range config.AdminTokens {
+		if token != "" {
+			adminTokens = append(adminTokens, token)
+		}
+	}
+
+	objectCache, err := objectcache.New(config.ObjectCache)
+	if err != nil {
+		return nil, err
 	}
 
 	return &Handler{
@@ -209,6 +276,7 @@ func NewHandler(log *zap.Logger, mapper *objectmap.IPDB, txtRecords *TXTRecords,
 		urlBases:               bases,
 		templates:              templates,
 		mapper:                 mapper,
+		mapConfig:              config.Map,
 		txtRecords:             txtRecords,
 		authClient:             authClient,
 		static:                 http.StripPrefix("/static/", http.FileServer(http.Dir(config.StaticSourcesPath))),
@@ -219,12 +287,55 @@ func NewHandler(log *zap.Logger, mapper *objectmap.IPDB, txtRecords *TXTRecords,
 		standardRendersContent: config.StandardRendersContent,
 		standardViewsHTML:      config.StandardViewsHTML,
 		archiveRanger:          defaultArchiveRanger,
-		inShutdown:             inShutdown,
+		objectCache:            objectCache,
+		compression:            config.Compression,
+		basicAuthCache: lrucache.NewOf[bool](lrucache.Options{
+			Expiration: config.TXTRecordTTL,
+			Capacity:   basicAuthCacheCapacity,
+		}),
+		readmeCache: lrucache.NewOf[template.HTML](lrucache.Options{
+			Expiration: time.Hour,
+			Capacity:   readmeCacheCapacity,
+		}),
+		readmeRenders: make(chan struct{}, maxReadmeRenders),
+		ipSummaryCache: lrucache.NewOf[*object.IPSummary](lrucache.Options{
+			Expiration: config.Map.CacheExpiration,
+			Capacity:   config.Map.CacheCapacity,
+		}),
+		analytics:   analytics.New(config.Analytics),
+		adminTokens: adminTokens,
+		inShutdown:  inShutdown,
 	}, nil
 }
 
+// Close releases resources held by the handler.
+func (handler *Handler) Close() error {
+	return handler.objectCache.Close()
+}
+
+// Run runs the background jobs of the handler, flushing analytics and
+// reloading templates, until ctx is canceled.
+func (handler *Handler) Run(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	group, ctx := errgroup.WithContext(ctx)
+	group.Go(func() error {
+		return handler.runAnalytics(ctx)
+	})
+	group.Go(func() error {
+		return handler.templates.run(ctx)
+	})
+	return group.Wait()
+}
+
 // ServeHTTP handles link sharing requests.
 func (handler *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler.analytics.Enabled() {
+		var done func()
+		w, r, done = handler.trackSiteRequest(w, r)
+		defer done()
+	}
+
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
 
@@ -317,21 +428,22 @@ func (handler *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	delete(w.Header(), "Content-Disposition")
 	w.WriteHeader(status)
 	if !skipRendering {
-		handler.renderTemplate(w, "error.html", pageData{Data: message, Title: "Error"})
-	}
-}
-
-func (handler *Handler) renderTemplate(w http.ResponseWriter, template string, data pageData) {
-	data.Base = strings.TrimSuffix(handler.urlBases[0].String(), "/")
-	err := handler.templates.ExecuteTemplate(w, template, data)
-	if err != nil {
-		handler.log.Error("error while executing template", zap.Error(err))
+		handler.renderTemplate(w, r, "error.html", pageData{Data: message, Title: "Error"})
 	}
 }
 
 func (handler *Handler) serveHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
+	if (len(handler.adminTokens) > 0 && strings.HasPrefix(r.URL.Path, adminPathPrefix)) ||
+		(handler.analytics.Enabled() && r.URL.Path == adminAnalyticsPath) {
+		// admin endpoints don't follow the rules of the public ones (e.g. they
+		// take POST requests), so they're routed first.
+		if ours, err := isDomainOurs(r.Host, handler.urlBases); err == nil && ours {
+			return handler.serveAdmin(ctx, w, r)
+		}
+	}
+
 	if r.Method == http.MethodOptions {
 		// handle CORS pre-flight requests
 		handler.cors(ctx, w, r)
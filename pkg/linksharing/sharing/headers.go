@@ -0,0 +1,174 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// headersFile is the name of the file at the root of a hosted site that holds
+// its custom response headers.
+const headersFile = "_headers"
+
+// headerRule is a block of a _headers file: a path pattern followed by
+// indented header lines. The format follows Netlify's:
+//
+//	# comment
+//	/*
+//	  X-Frame-Options: DENY
+//	  Strict-Transport-Security: max-age=31536000
+//	/api/*
+//	  Access-Control-Allow-Origin: https://app.example.test
+type headerRule struct {
+	path    pathPattern
+	headers http.Header
+}
+
+// headerRules is the parsed content of a _headers file.
+type headerRules []headerRule
+
+// deniedCustomHeaders are headers hosted sites can't set: hop-by-hop headers,
+// headers describing the framing or identity of the representation we
+// serve, and headers that could hijack the connection or the authentication
+// of the service.
+var deniedCustomHeaders = map[string]bool{
+	"Accept-Ranges":       true,
+	"Age":                 true,
+	"Alt-Svc":             true,
+	"Connection":          true,
+	"Content-Encoding":    true,
+	"Content-Length":      true,
+	"Content-Range":       true,
+	"Date":                true,
+	"Etag":                true,
+	"Keep-Alive":          true,
+	"Last-Modified":       true,
+	"Location":            true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Proxy-Connection":    true,
+	"Server":              true,
+	"Set-Cookie":          true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Www-Authenticate":    true,
+}
+
+// parseHeaders parses a _headers file. Header lines that are malformed, set a
+// denied header or don't belong to a path are skipped.
+func parseHeaders(data []byte) (rules headerRules) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		// only whole-line comments are supported as values (e.g. a CSP) may
+		// contain a hash sign.
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if line[0] != ' ' && line[0] != '\t' {
+			pattern, ok := parsePathPattern(trimmed)
+			if !ok {
+				mon.Event("hosting_header_rule_invalid")
+				// skip the headers of this block.
+				rules = append(rules, headerRule{})
+				continue
+			}
+			rules = append(rules, headerRule{path: pattern, headers: http.Header{}})
+			continue
+		}
+
+		if len(rules) == 0 || rules[len(rules)-1].headers == nil {
+			continue
+		}
+
+		name, value, ok := strings.Cut(trimmed, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || !validHeaderName(name) {
+			mon.Event("hosting_header_rule_invalid")
+			continue
+		}
+		name = textproto.CanonicalMIMEHeaderKey(name)
+		if deniedCustomHeaders[name] || strings.HasPrefix(name, "Proxy-") {
+			mon.Event("hosting_header_rule_denied")
+			continue
+		}
+		rules[len(rules)-1].headers.Add(name, value)
+	}
+
+	// drop invalid blocks.
+	valid := rules[:0]
+	for _, rule := range rules {
+		if rule.headers != nil {
+			valid = append(valid, rule)
+		}
+	}
+	return valid
+}
+
+// apply sets the headers of all rules matching urlPath. Headers set by
+// several matching rules get all values, in the order of the rules. Custom
+// headers replace any default the handler has already set (e.g. CORS).
+func (rules headerRules) apply(h http.Header, urlPath string) {
+	segments := splitRulePath(urlPath)
+	replaced := make(map[string]bool)
+	for _, rule := range rules {
+		if _, ok := rule.path.match(segments); !ok {
+			continue
+		}
+		for name, values := range rule.headers {
+			if !replaced[name] {
+				h.Del(name)
+				replaced[name] = true
+			}
+			for _, value := range values {
+				h.Add(name, value)
+			}
+		}
+	}
+}
+
+// validHeaderName reports whether name is a valid HTTP field name token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// headerRules returns the parsed _headers file of the hosted site, if any.
+// Failing to load the rules doesn't fail the request; the site is served as
+// if it had none.
+func (handler *Handler) headerRules(ctx context.Context, site *hostedSite) headerRules {
+	v, err := handler.txtRecords.SiteFile(ctx, site.host, headersFile, func(ctx context.Context) (interface{}, error) {
+		data, err := handler.downloadSiteFile(ctx, site, headersFile)
+		if err != nil {
+			return nil, err
+		}
+		return parseHeaders(data), nil
+	})
+	if err != nil {
+		handler.log.Debug("unable to load header rules", zap.String("host", site.host), zap.Error(err))
+		return nil
+	}
+	return v.(headerRules)
+}
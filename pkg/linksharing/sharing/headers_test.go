@@ -0,0 +1,77 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHeaders(t *testing.T) {
+	rules := parseHeaders([]byte(`
+# security headers for everything
+/*
+  X-Frame-Options: DENY
+  content-security-policy: default-src 'self'; script-src 'sha256-abc#def'
+  Transfer-Encoding: chunked
+  Set-Cookie: session=1
+  Proxy-Foo: bar
+  not a header
+
+/api/*
+  Access-Control-Allow-Origin: https://app.example.test
+  Link: </style.css>; rel=preload
+  Link: </app.js>; rel=preload
+
+  X-Orphan: ignored because the block above continues
+
+invalid-path
+  X-Ignored: yes
+`))
+
+	require.Len(t, rules, 2)
+	assert.Equal(t, pathPattern{"*"}, rules[0].path)
+	assert.Equal(t, http.Header{
+		"X-Frame-Options":         {"DENY"},
+		"Content-Security-Policy": {"default-src 'self'; script-src 'sha256-abc#def'"},
+	}, rules[0].headers)
+	assert.Equal(t, pathPattern{"api", "*"}, rules[1].path)
+	assert.Equal(t, http.Header{
+		"Access-Control-Allow-Origin": {"https://app.example.test"},
+		"Link":                        {"</style.css>; rel=preload", "</app.js>; rel=preload"},
+		"X-Orphan":                    {"ignored because the block above continues"},
+	}, rules[1].headers)
+}
+
+func TestHeaderRulesApply(t *testing.T) {
+	rules := parseHeaders([]byte(`
+/*
+  X-Frame-Options: DENY
+  Vary: Origin
+/api/:version/*
+  Access-Control-Allow-Origin: https://app.example.test
+  Vary: Cookie
+`))
+
+	h := http.Header{}
+	h.Set("Access-Control-Allow-Origin", "*")
+	rules.apply(h, "/index.html")
+	assert.Equal(t, "DENY", h.Get("X-Frame-Options"))
+	assert.Equal(t, []string{"Origin"}, h.Values("Vary"))
+	assert.Equal(t, "*", h.Get("Access-Control-Allow-Origin"))
+
+	h = http.Header{}
+	h.Set("Access-Control-Allow-Origin", "*")
+	rules.apply(h, "/api/v1/users")
+	assert.Equal(t, "DENY", h.Get("X-Frame-Options"))
+	assert.Equal(t, []string{"Origin", "Cookie"}, h.Values("Vary"))
+	assert.Equal(t, []string{"https://app.example.test"}, h.Values("Access-Control-Allow-Origin"))
+
+	h = http.Header{}
+	headerRules(nil).apply(h, "/")
+	assert.Empty(t, h)
+}
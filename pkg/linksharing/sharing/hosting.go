@@ -9,10 +9,13 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"github.com/spacemonkeygo/monkit/v3"
 	"go.uber.org/zap"
 
+	"storj.io/common/memory"
 	"storj.io/gateway-mt/pkg/errdata"
 	"storj.io/gateway-mt/pkg/trustedip"
 	"storj.io/uplink"
@@ -32,12 +35,21 @@ func (handler *Handler) handleHostingService(ctx context.Context, w http.Respons
 		}
 	}
 
-	result, err := handler.txtRecords.FetchAccessForHost(ctx, host, trustedip.GetClientIP(handler.trustedClientIPsList, r))
+	clientIP := trustedip.GetClientIP(handler.trustedClientIPsList, r)
+
+	result, err := handler.txtRecords.FetchAccessForHost(ctx, host, clientIP)
 	if err != nil {
 		return errdata.WithAction(err, "fetch access")
 	}
+	setSiteRequest(ctx, host, result)
 
-	bucket, key := determineBucketAndObjectKey(result.Root, r.URL.Path)
+	if err := result.VerifyRequest(r); err != nil {
+		return errdata.WithAction(err, "verify request")
+	}
+
+	if err := handler.authorizeHosting(ctx, w, r, host, result, clientIP); err != nil {
+		return errdata.WithAction(err, "authorize")
+	}
 
 	project, err := handler.uplink.OpenProject(ctx, result.Access)
 	if err != nil {
@@ -49,56 +61,178 @@ func (handler *Handler) handleHostingService(ctx context.Context, w http.Respons
 		}
 	}()
 
-	visibleKey := strings.TrimPrefix(r.URL.Path, "/")
+	site := &hostedSite{host: host, result: result, project: project}
+
+	// custom headers apply to everything served for the site, including
+	// redirects and 404 pages.
+	handler.headerRules(ctx, site).apply(w.Header(), r.URL.Path)
+
+	// the theme is loaded up front, so that it's there for any page we
+	// render for the site, including errors.
+	handler.siteTheme(ctx, site)
+
+	rules := handler.redirectRules(ctx, site)
+	rule, target, matched := rules.match(r.URL.Path)
+
+	if matched && rule.force {
+		err = handler.applyRedirectRule(ctx, w, r, site, rule, target)
+	} else {
+		err = handler.presentHosted(ctx, w, r, site, r.URL.Path)
+		if matched && errors.Is(err, uplink.ErrObjectNotFound) {
+			err = handler.applyRedirectRule(ctx, w, r, site, rule, target)
+		}
+	}
+
+	// if the error is anything other than ObjectNotFound, return to normal
+	// error handling. this includes the err == nil case
+	if !errors.Is(err, uplink.ErrObjectNotFound) {
+		return err
+	}
+
+	// in ObjectNotFound, let the user provide a custom 404 page
+
+	// if this returns uplink.ErrObjectNotFound, then, that's still the right
+	// error, and we should return it and return our normal 404 page, so this
+	// is fine to just pass through.
+	return handler.serveHostedWithStatus(ctx, w, site, "/404.html", http.StatusNotFound)
+}
+
+// hostedSite groups what's needed to serve a request for a hosted site.
+type hostedSite struct {
+	host    string
+	result  Result
+	project *uplink.Project
+}
+
+// presentHosted serves urlPath of the hosted site.
+func (handler *Handler) presentHosted(ctx context.Context, w http.ResponseWriter, r *http.Request, site *hostedSite, urlPath string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	bucket, key := determineBucketAndObjectKey(site.result.Root, urlPath)
+
+	visibleKey := strings.TrimPrefix(urlPath, "/")
 	if visibleKey == "" {
 		// special case: if someone is looking for http://sub.domain.tld/,
 		// explicitly assume they shared a prefix and are looking for index.html
 		key += "index.html"
 	}
 
-	err = handler.presentWithProject(ctx, w, r, &parsedRequest{
-		access:          result.Access,
+	return handler.presentWithProject(ctx, w, r, &parsedRequest{
+		access:          site.result.Access,
 		bucket:          bucket,
 		realKey:         key,
 		visibleKey:      visibleKey,
-		title:           host,
-		root:            breadcrumb{Prefix: host, URL: "/"},
+		title:           site.host,
+		root:            breadcrumb{Prefix: site.host, URL: "/"},
 		wrapDefault:     false,
 		downloadDefault: false,
 		hosting:         true,
-		hostingTLS:      result.TLS,
-	}, project)
-
-	// if the error is anything other than ObjectNotFound, return to normal
-	// error handling. this includes the err == nil case
-	if !errors.Is(err, uplink.ErrObjectNotFound) {
-		return err
-	}
+		hostingTLS:      site.result.TLS,
+	}, site.project)
+}
 
-	// in ObjectNotFound, let the user provide a custom 404 page
+// serveHostedWithStatus serves the object at urlPath of the hosted site with
+// the given status code.
+func (handler *Handler) serveHostedWithStatus(ctx context.Context, w http.ResponseWriter, site *hostedSite, urlPath string, status int) (err error) {
+	defer mon.Task()(&ctx)(&err)
 
-	bucket, key = determineBucketAndObjectKey(result.Root, "/404.html")
-	download, err := project.DownloadObject(ctx, bucket, key, nil)
+	bucket, key := determineBucketAndObjectKey(site.result.Root, urlPath)
+	download, err := site.project.DownloadObject(ctx, bucket, key, nil)
 	if err != nil {
-		// if this returns uplink.ErrObjectNotFound, then, that's still
-		// the right error, and we should return it and return our normal
-		// 404 page, so this is fine to just pass through.
-		return errdata.WithAction(err, "download 404")
+		return errdata.WithAction(err, "download "+strconv.Itoa(status))
 	}
 	defer func() {
 		if err := download.Close(); err != nil {
-			handler.log.With(zap.Error(err)).Warn("unable to close 404 download")
+			handler.log.With(zap.Error(err)).Warn("unable to close download", zap.Int("status", status))
 		}
 	}()
 
-	w.WriteHeader(http.StatusNotFound)
+	if contentType := contentType(key, download.Info().Custom, true); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(status)
 	_, err = io.Copy(w, download)
 	if err != nil {
-		return errdata.WithAction(err, "serve 404")
+		return errdata.WithAction(err, "serve "+strconv.Itoa(status))
 	}
 	return nil
 }
 
+// applyRedirectRule redirects the client to target or, for rewrite rules,
+// serves target in place of the requested path.
+func (handler *Handler) applyRedirectRule(ctx context.Context, w http.ResponseWriter, r *http.Request, site *hostedSite, rule *redirectRule, target string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	mon.Event("hosting_redirect_rule_applied", monkit.NewSeriesTag("status", strconv.Itoa(rule.status)))
+
+	if !rule.isRewrite() {
+		if r.URL.RawQuery != "" && !strings.Contains(target, "?") {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, rule.status)
+		return nil
+	}
+
+	// rewrites only ever point at the site itself, and any query string is
+	// irrelevant for picking the object.
+	targetPath, _, _ := strings.Cut(target, "?")
+	if rule.status == http.StatusNotFound {
+		return handler.serveHostedWithStatus(ctx, w, site, targetPath, http.StatusNotFound)
+	}
+	return handler.presentHosted(ctx, w, r, site, targetPath)
+}
+
+// redirectRules returns the parsed _redirects file of the hosted site, if
+// any. Failing to load the rules doesn't fail the request; the site is served
+// as if it had none.
+func (handler *Handler) redirectRules(ctx context.Context, site *hostedSite) redirectRules {
+	v, err := handler.txtRecords.SiteFile(ctx, site.host, redirectsFile, func(ctx context.Context) (interface{}, error) {
+		data, err := handler.downloadSiteFile(ctx, site, redirectsFile)
+		if err != nil {
+			return nil, err
+		}
+		return parseRedirects(data), nil
+	})
+	if err != nil {
+		handler.log.Debug("unable to load redirect rules", zap.String("host", site.host), zap.Error(err))
+		return nil
+	}
+	return v.(redirectRules)
+}
+
+// maxSiteFileSize is the size limit for configuration files such as
+// _redirects at the root of hosted sites.
+const maxSiteFileSize = 64 * memory.KiB
+
+// downloadSiteFile downloads the configuration file called name from the
+// root of the hosted site. A missing file isn't an error; it's returned empty.
+func (handler *Handler) downloadSiteFile(ctx context.Context, site *hostedSite, name string) (_ []byte, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	bucket, key := determineBucketAndObjectKey(site.result.Root, "/"+name)
+	download, err := site.project.DownloadObject(ctx, bucket, key, nil)
+	if err != nil {
+		if errors.Is(err, uplink.ErrObjectNotFound) {
+			return nil, nil
+		}
+		return nil, errdata.WithAction(err, "download "+name)
+	}
+	defer func() {
+		if err := download.Close(); err != nil {
+			handler.log.With(zap.Error(err)).Warn("unable to close download", zap.String("name", name))
+		}
+	}()
+
+	if download.Info().System.ContentLength > maxSiteFileSize.Int64() {
+		// treat it as missing so that the (cached) result doesn't make us
+		// try again on every request.
+		handler.log.Debug("site file too large", zap.String("host", site.host), zap.String("name", name))
+		return nil, nil
+	}
+
+	return io.ReadAll(io.LimitReader(download, maxSiteFileSize.Int64()))
+}
+
 // determineBucketAndObjectKey is a helper function to parse storj_root and the url into the bucket and object key.
 // For example, we have http://mydomain.com/prefix2/index.html with storj_root:bucket1/prefix1/
 // The root path will be [bucket1, prefix1/]. Our bucket is named bucket1.
@@ -5,9 +5,14 @@ package sharing
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/gateway-mt/pkg/errdata"
 )
 
 func TestDetermineBucketAndObjectKey(t *testing.T) {
@@ -78,3 +83,18 @@ func TestDetermineBucketAndObjectKey(t *testing.T) {
 		assert.Equal(t, actualKey, test.key, fmt.Sprintf("%d: %s", idx, test.name))
 	}
 }
+
+func TestResultVerifyRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://site.test/index.html", nil)
+
+	assert.NoError(t, Result{}.VerifyRequest(r))
+
+	err := Result{secretKey: "secret"}.VerifyRequest(r)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, errdata.GetStatus(err, 0))
+
+	r.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=invalid")
+	err = Result{secretKey: "secret"}.VerifyRequest(r)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, errdata.GetStatus(err, 0))
+}
@@ -0,0 +1,142 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+	"golang.org/x/crypto/bcrypt"
+
+	"storj.io/gateway-mt/pkg/errdata"
+)
+
+// parseBasicAuthRecords parses storj-basic-auth TXT record values of the form
+// user:bcrypt-hash, e.g. (generated with `htpasswd -nbB alice password`):
+//
+//	storj-basic-auth:alice:$2y$05$2bbxy6Vbg0ZHJ/2Shhhnv.tCFEgH8ZC3qmpWpf1PrgfD4Ld0Wf5Xa
+//
+// Several records can be used to allow several users.
+func parseBasicAuthRecords(values []string) (map[string][]byte, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	users := make(map[string][]byte, len(values))
+	for _, value := range values {
+		user, hash, ok := strings.Cut(value, ":")
+		if !ok || user == "" {
+			return nil, errs.New("invalid storj-basic-auth record: missing user")
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, errs.New("invalid storj-basic-auth record for %q: %w", user, err)
+		}
+		users[user] = []byte(hash)
+	}
+	return users, nil
+}
+
+// parseAllowCIDRRecords parses storj-allow-cidr TXT record values, which are
+// comma-separated lists of networks in CIDR notation or single IPs, e.g.:
+//
+//	storj-allow-cidr:203.0.113.0/24,2001:db8::/32,198.51.100.7
+//
+// Several records are combined.
+func parseAllowCIDRRecords(values []string) (networks []*net.IPNet, err error) {
+	for _, value := range values {
+		for _, s := range strings.Split(value, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			if !strings.Contains(s, "/") {
+				ip := net.ParseIP(s)
+				if ip == nil {
+					return nil, errs.New("invalid storj-allow-cidr record: %q", s)
+				}
+				bits := 8 * net.IPv6len
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 8*net.IPv4len
+				}
+				networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+			_, network, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, errs.New("invalid storj-allow-cidr record: %w", err)
+			}
+			networks = append(networks, network)
+		}
+	}
+	return networks, nil
+}
+
+// restricted reports whether the site has IP or basic auth restrictions.
+func (result Result) restricted() bool {
+	return len(result.AllowedNetworks) > 0 || len(result.BasicAuth) > 0
+}
+
+// authorizeHosting enforces the optional IP and basic auth restrictions of a
+// hosted site. clientIP is the IP of the client that originated the request.
+func (handler *Handler) authorizeHosting(ctx context.Context, w http.ResponseWriter, r *http.Request, host string, result Result, clientIP string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if len(result.AllowedNetworks) > 0 {
+		ip := net.ParseIP(clientIP)
+		allowed := false
+		for _, network := range result.AllowedNetworks {
+			if ip != nil && network.Contains(ip) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			mon.Event("hosting_client_ip_denied")
+			return errdata.WithStatus(errs.New("client IP %q is not allowed", clientIP), http.StatusForbidden)
+		}
+	}
+
+	if len(result.BasicAuth) > 0 {
+		user, password, ok := r.BasicAuth()
+		if !ok || !handler.checkBasicAuth(ctx, host, result.BasicAuth, user, password) {
+			mon.Event("hosting_basic_auth_denied", monkit.NewSeriesTag("provided", strconv.FormatBool(ok)))
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+strings.ReplaceAll(host, `"`, "")+`", charset="UTF-8"`)
+			return errdata.WithStatus(errs.New("basic auth required"), http.StatusUnauthorized)
+		}
+	}
+
+	return nil
+}
+
+// checkBasicAuth verifies user and password against the bcrypt hashes in
+// users. Successful verifications are remembered for a while because bcrypt is
+// deliberately slow and browsers send credentials with every request.
+func (handler *Handler) checkBasicAuth(ctx context.Context, host string, users map[string][]byte, user, password string) bool {
+	hash, ok := users[user]
+	if !ok {
+		return false
+	}
+
+	h := sha256.New()
+	for _, s := range []string{host, user, password, string(hash)} {
+		_, _ = h.Write([]byte(s))
+		_, _ = h.Write([]byte{0})
+	}
+	key := hex.EncodeToString(h.Sum(nil))
+
+	verified, err := handler.basicAuthCache.Get(ctx, key, func() (bool, error) {
+		if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+			// errors aren't cached, so failed attempts are always checked.
+			return false, err
+		}
+		return true, nil
+	})
+	return err == nil && verified
+}
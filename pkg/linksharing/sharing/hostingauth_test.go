@@ -0,0 +1,167 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	"golang.org/x/crypto/bcrypt"
+
+	"storj.io/common/testcontext"
+	"storj.io/gateway-mt/pkg/errdata"
+	"storj.io/gateway-mt/pkg/linksharing/objectmap"
+	"storj.io/uplink"
+	privateAccess "storj.io/uplink/private/access"
+)
+
+func TestParseAllowCIDRRecords(t *testing.T) {
+	networks, err := parseAllowCIDRRecords([]string{"10.0.0.0/8, 2001:db8::/32", "192.0.2.1,2001:db8:1::1"})
+	require.NoError(t, err)
+
+	var got []string
+	for _, network := range networks {
+		got = append(got, network.String())
+	}
+	assert.Equal(t, []string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.1/32", "2001:db8:1::1/128"}, got)
+
+	networks, err = parseAllowCIDRRecords(nil)
+	require.NoError(t, err)
+	assert.Empty(t, networks)
+
+	_, err = parseAllowCIDRRecords([]string{"10.0.0.0/8,not-an-ip"})
+	require.Error(t, err)
+	_, err = parseAllowCIDRRecords([]string{"10.0.0.0/33"})
+	require.Error(t, err)
+}
+
+func TestParseBasicAuthRecords(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	users, err := parseBasicAuthRecords([]string{"alice:" + string(hash), "bob:" + string(hash)})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"alice": hash, "bob": hash}, users)
+
+	users, err = parseBasicAuthRecords(nil)
+	require.NoError(t, err)
+	assert.Nil(t, users)
+
+	_, err = parseBasicAuthRecords([]string{string(hash)})
+	require.Error(t, err)
+	_, err = parseBasicAuthRecords([]string{"alice:plaintext"})
+	require.Error(t, err)
+}
+
+func TestAuthorizeHosting(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	handler, err := NewHandler(&zap.Logger{}, &objectmap.IPDB{}, nil, nil, nil, Config{
+		URLBases:  []string{"http://test.test"},
+		Templates: "../../../pkg/linksharing/web/",
+	})
+	require.NoError(t, err)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	networks, err := parseAllowCIDRRecords([]string{"192.0.2.0/24"})
+	require.NoError(t, err)
+
+	result := Result{
+		BasicAuth:       map[string][]byte{"alice": hash},
+		AllowedNetworks: networks,
+	}
+
+	for _, tc := range []struct {
+		desc           string
+		clientIP       string
+		user, password string
+		status         int
+	}{
+		{desc: "allowed", clientIP: "192.0.2.10", user: "alice", password: "secret"},
+		{desc: "allowed again (cached)", clientIP: "192.0.2.10", user: "alice", password: "secret"},
+		{desc: "ip not allowed", clientIP: "198.51.100.1", user: "alice", password: "secret", status: http.StatusForbidden},
+		{desc: "invalid ip", clientIP: "", user: "alice", password: "secret", status: http.StatusForbidden},
+		{desc: "no credentials", clientIP: "192.0.2.10", status: http.StatusUnauthorized},
+		{desc: "wrong password", clientIP: "192.0.2.10", user: "alice", password: "wrong", status: http.StatusUnauthorized},
+		{desc: "unknown user", clientIP: "192.0.2.10", user: "bob", password: "secret", status: http.StatusUnauthorized},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://site.test/", nil)
+			if tc.user != "" {
+				r.SetBasicAuth(tc.user, tc.password)
+			}
+			w := httptest.NewRecorder()
+
+			err := handler.authorizeHosting(ctx, w, r, "site.test", result, tc.clientIP)
+			if tc.status == 0 {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Equal(t, tc.status, errdata.GetStatus(err, 0))
+			if tc.status == http.StatusUnauthorized {
+				assert.Equal(t, `Basic realm="site.test", charset="UTF-8"`, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+
+	require.NoError(t, handler.authorizeHosting(ctx, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), "site.test", Result{}, ""))
+}
+
+func TestRestrictedSiteAccess(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	records, _ := newTestTXTRecords(t, 0)
+
+	handler, err := NewHandler(zaptest.NewLogger(t), &objectmap.IPDB{}, records, nil, nil, Config{
+		URLBases:  []string{"http://test.test"},
+		Templates: "../../../pkg/linksharing/web/",
+	})
+	require.NoError(t, err)
+
+	access, err := uplink.ParseAccess(testAccessGrant)
+	require.NoError(t, err)
+	head := privateAccess.APIKey(access).Head()
+
+	networks, err := parseAllowCIDRRecords([]string{"192.0.2.0/24"})
+	require.NoError(t, err)
+
+	store := func(hostname string, result Result) {
+		record, err := records.newTXTRecord(hostname, result, resolvedAccess{
+			access:     access,
+			serialized: testAccessGrant,
+		}, "", true, time.Hour)
+		require.NoError(t, err)
+		records.store(hostname, record)
+	}
+
+	store("public.test", Result{Root: "bucket"})
+	assert.False(t, records.IsRestrictedMacaroonHead(head))
+
+	store("a.test", Result{Root: "bucket", AllowedNetworks: networks})
+	store("b.test", Result{Root: "bucket", AllowedNetworks: networks})
+	assert.True(t, records.IsRestrictedMacaroonHead(head))
+	assert.False(t, records.IsRestrictedMacaroonHead([]byte("other")))
+
+	// the site's files aren't served without its restrictions.
+	r := httptest.NewRequest(http.MethodGet, "http://test.test/raw/"+testAccessGrant+"/bucket/index.html", nil)
+	err = handler.handleStandard(ctx, httptest.NewRecorder(), r)
+	require.ErrorContains(t, err, "restricted hosted site")
+	assert.Equal(t, http.StatusForbidden, errdata.GetStatus(err, 0))
+
+	// purged sites might still be restricted.
+	assert.True(t, records.Purge(ctx, "a.test"))
+	store("b.test", Result{Root: "bucket"})
+	assert.True(t, records.IsRestrictedMacaroonHead(head))
+
+	store("a.test", Result{Root: "bucket"})
+	assert.False(t, records.IsRestrictedMacaroonHead(head))
+}
@@ -29,6 +29,7 @@ import (
 	"crypto/subtle"
 	"encoding/hex"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
@@ -42,6 +43,11 @@ const (
 	yyyymmddTimeLayout  = "20060102"
 	v4SigningAlgorithm  = "AWS4-HMAC-SHA256"
 	emptyBodySHA256Hash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	unsignedPayload     = "UNSIGNED-PAYLOAD"
+
+	// maxPresignedExpires is the maximum validity of presigned URLs (7 days),
+	// the same as S3's.
+	maxPresignedExpires = 7 * 24 * time.Hour
 )
 
 // ErrMissingAuthorizationHeader indicates that the Authorization header for a
@@ -66,6 +72,9 @@ func VerifySigningInfo(r *http.Request, secretAccessKey string, currentTime time
 
 	authorizationValue := r.Header.Get("Authorization")
 	if authorizationValue == "" {
+		if r.URL.Query().Has("X-Amz-Signature") {
+			return errVerification.Wrap(verifyPresigned(r, secretAccessKey, currentTime, validityTolerance))
+		}
 		return errVerification.Wrap(ErrMissingAuthorizationHeader)
 	}
 
@@ -90,7 +99,7 @@ func VerifySigningInfo(r *http.Request, secretAccessKey string, currentTime time
 	}
 
 	// Step 1: https://docs.aws.amazon.com/general/latest/gr/sigv4-create-canonical-request.html
-	canonicalRequest := canonicalizeRequest(r, headers)
+	canonicalRequest := canonicalizeRequest(r, r.URL.Query(), headers, emptyBodySHA256Hash)
 
 	// Step 2: https://docs.aws.amazon.com/general/latest/gr/sigv4-create-string-to-sign.html
 	stringToSign := createStringToSign(canonicalRequest, info.credential.buildScope(), t)
@@ -112,6 +121,105 @@ func VerifySigningInfo(r *http.Request, secretAccessKey string, currentTime time
 	return nil
 }
 
+// AccessKeyID returns the Access Key ID r is signed with, either in the
+// Authorization header or in the query string of a presigned URL. It doesn't
+// verify the signature.
+func AccessKeyID(r *http.Request) (string, error) {
+	if r == nil {
+		return "", ErrMissingAuthorizationHeader
+	}
+
+	authorizationValue := r.Header.Get("Authorization")
+	if authorizationValue == "" {
+		query := r.URL.Query()
+		if !query.Has("X-Amz-Signature") {
+			return "", ErrMissingAuthorizationHeader
+		}
+		credential, err := parseCredential("Credential=" + query.Get("X-Amz-Credential"))
+		if err != nil {
+			return "", err
+		}
+		return credential.accessKeyID, nil
+	}
+
+	info, err := parseSigningInfo(authorizationValue)
+	if err != nil {
+		return "", err
+	}
+	return info.credential.accessKeyID, nil
+}
+
+// verifyPresigned verifies r's signature passed in the query string (presigned
+// URL) instead of the Authorization header. Presigned URLs are valid from their
+// signature time (tolerating validityTolerance of clock skew) until it's
+// X-Amz-Expires seconds old.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-query-string-auth.html
+func verifyPresigned(r *http.Request, secretAccessKey string, currentTime time.Time, validityTolerance time.Duration) error {
+	errPresigned := errs.Class("presigned")
+
+	query := r.URL.Query()
+
+	if query.Get("X-Amz-Algorithm") != v4SigningAlgorithm {
+		return errPresigned.New("invalid algorithm")
+	}
+
+	credential, err := parseCredential("Credential=" + query.Get("X-Amz-Credential"))
+	if err != nil {
+		return errPresigned.Wrap(err)
+	}
+
+	signedHeaders, err := parseSignedHeaders("SignedHeaders=" + query.Get("X-Amz-SignedHeaders"))
+	if err != nil {
+		return errPresigned.Wrap(err)
+	}
+
+	signature, err := parseSignature("Signature=" + query.Get("X-Amz-Signature"))
+	if err != nil {
+		return errPresigned.Wrap(err)
+	}
+
+	t, err := time.Parse(iso8601TimeLayout, query.Get("X-Amz-Date"))
+	if err != nil {
+		return errPresigned.Wrap(err)
+	}
+
+	expiresSeconds, err := strconv.ParseInt(query.Get("X-Amz-Expires"), 10, 64)
+	if err != nil {
+		return errPresigned.New("invalid expires: %w", err)
+	}
+	expires := time.Duration(expiresSeconds) * time.Second
+	if expires <= 0 || expires > maxPresignedExpires {
+		return errPresigned.New("expires out of range")
+	}
+
+	headers, err := extractHeaders(signedHeaders, r)
+	if err != nil {
+		return errPresigned.Wrap(err)
+	}
+
+	// The signature itself is the only query parameter not being signed.
+	query.Del("X-Amz-Signature")
+
+	canonicalRequest := canonicalizeRequest(r, query, headers, unsignedPayload)
+	stringToSign := createStringToSign(canonicalRequest, credential.buildScope(), t)
+
+	reg, svc, req := credential.scope.region, credential.scope.service, credential.scope.request
+
+	if subtle.ConstantTimeCompare([]byte(signature), []byte(calculateSignature(secretAccessKey, reg, svc, req, stringToSign, t))) != 1 {
+		return errPresigned.New("signature mismatch")
+	}
+
+	if currentTime.Add(validityTolerance).Before(t) {
+		return errPresigned.New("signature time too skewed")
+	}
+	if currentTime.After(t.Add(expires)) {
+		return errPresigned.New("expired")
+	}
+
+	return nil
+}
+
 // extractHeaders extracts signedHeaders from r.
 //
 // TODO(artur): better doc.
@@ -166,16 +274,17 @@ func extractHeaders(signedHeaders []string, r *http.Request) (http.Header, error
 // never received many complaints about this behavior (I only found one issue
 // that might be related: https://github.com/aws/aws-sdk-go/issues/3592).
 //
-// It assumes an empty request body as we only allow HEAD and GET requests given
-// the service's nature.
-func canonicalizeRequest(r *http.Request, headers http.Header) string {
+// The request body isn't read as we only allow HEAD and GET requests given the
+// service's nature; payloadHash is the hash of an empty body for signed
+// requests and UNSIGNED-PAYLOAD for presigned URLs.
+func canonicalizeRequest(r *http.Request, query url.Values, headers http.Header, payloadHash string) string {
 	return strings.Join([]string{
 		r.Method,
 		r.URL.EscapedPath(),
-		strings.ReplaceAll(r.URL.Query().Encode(), "+", "%20"),
+		strings.ReplaceAll(query.Encode(), "+", "%20"),
 		canonicalizeHeaders(headers),
 		createSignedHeaders(headers),
-		emptyBodySHA256Hash,
+		payloadHash,
 	}, "\n")
 }
 
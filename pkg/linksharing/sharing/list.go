@@ -28,15 +28,18 @@ type listObject struct {
 	URL    template.URL
 	Size   string
 	Prefix bool
+
+	size int64
 }
 
-func (handler *Handler) servePrefix(ctx context.Context, w http.ResponseWriter, project *uplink.Project, pr *parsedRequest, archivePath string) (err error) {
+func (handler *Handler) servePrefix(ctx context.Context, w http.ResponseWriter, r *http.Request, project *uplink.Project, pr *parsedRequest, archivePath string) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
 	var input struct {
 		Title       string
 		Breadcrumbs []breadcrumb
 		Objects     []listObject
+		Readme      *readme
 	}
 	input.Title = pr.title
 	input.Breadcrumbs = append(input.Breadcrumbs, pr.root)
@@ -65,7 +68,11 @@ func (handler *Handler) servePrefix(ctx context.Context, w http.ResponseWriter,
 		return errdata.WithAction(uplink.ErrObjectNotFound, "serve prefix - empty")
 	}
 
-	handler.renderTemplate(w, "prefix-listing.html", pageData{
+	if len(archivePath) == 0 {
+		input.Readme = handler.readme(ctx, project, pr, input.Objects)
+	}
+
+	handler.renderTemplate(w, r, "prefix-listing.html", pageData{
 		Data:             input,
 		Title:            pr.title,
 		ShowViewContents: len(archivePath) > 0,
@@ -94,6 +101,7 @@ func listObjectsPrefix(ctx context.Context, project *uplink.Project, pr *parsedR
 			URL:    template.URL("./" + keyURL + "?wrap=1"),
 			Size:   memory.Size(item.System.ContentLength).Base10String(),
 			Prefix: item.IsPrefix,
+			size:   item.System.ContentLength,
 		})
 	}
 	return objects, errdata.WithAction(projectObjects.Err(), "list objects")
@@ -119,6 +127,7 @@ func listObjectsArchive(ctx context.Context, project *uplink.Project, pr *parsed
 			URL:    template.URL("./" + keyURL + "&wrap=1"),
 			Size:   memory.Size(f.Size).Base10String(),
 			Prefix: false,
+			size:   f.Size,
 		})
 	}
 	return objects, nil
@@ -6,10 +6,17 @@ package sharing
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/zeebo/errs"
 	"go.uber.org/zap"
 
 	"storj.io/common/memory"
@@ -17,15 +24,36 @@ import (
 	"storj.io/dotworld/reference"
 	"storj.io/gateway-mt/pkg/errdata"
 	"storj.io/uplink"
+	privateAccess "storj.io/uplink/private/access"
 	"storj.io/uplink/private/object"
 )
 
+// MapConfig configures the object map view.
+type MapConfig struct {
+	// LocationPrecision is the number of decimal places node coordinates are
+	// rounded to, so that the nodes can't be pinpointed.
+	LocationPrecision int
+	// CacheCapacity is the number of objects whose piece IPs are cached.
+	// Zero disables caching.
+	CacheCapacity int
+	// CacheExpiration is how long the piece IPs of an object are cached.
+	CacheExpiration time.Duration
+}
+
 type location struct {
 	Latitude  float64
 	Longitude float64
 }
 
-func (handler *Handler) getLocations(ctx context.Context, access *uplink.Access, bucket, key string) (locs []location, pieceCount int64, err error) {
+// coarsenCoordinate rounds a coordinate to precision decimal places.
+func coarsenCoordinate(v float64, precision int) float64 {
+	scale := math.Pow10(precision)
+	return math.Round(v*scale) / scale
+}
+
+// getLocations returns the coarsened locations of the nodes storing pieces of
+// o, which has already been retrieved with access.
+func (handler *Handler) getLocations(ctx context.Context, access *uplink.Access, bucket string, o *uplink.Object) (locs []location, pieceCount int64, err error) {
 	defer mon.Task()(&ctx)(&err)
 
 	// we explicitly don't want locations to be nil, so it doesn't render as
@@ -36,7 +64,14 @@ func (handler *Handler) getLocations(ctx context.Context, access *uplink.Access,
 		return locations, 0, nil
 	}
 
-	ipSummary, err := object.GetObjectIPSummary(ctx, *handler.uplink, access, bucket, key)
+	// the object was retrieved with access, so the cached summary of that
+	// version of it can be shared by all accesses to the project.
+	cacheKey := strings.Join([]string{
+		string(privateAccess.APIKey(access).Head()), bucket, o.Key, objectETag(o, ""),
+	}, "\x00")
+	ipSummary, err := handler.ipSummaryCache.Get(ctx, cacheKey, func() (*object.IPSummary, error) {
+		return object.GetObjectIPSummary(ctx, *handler.uplink, access, bucket, o.Key)
+	})
 	if err != nil {
 		return nil, 0, errdata.WithAction(err, "get locations")
 	}
@@ -49,17 +84,125 @@ func (handler *Handler) getLocations(ctx context.Context, access *uplink.Access,
 		}
 
 		locations = append(locations, location{
-			Latitude:  info.Location.Latitude,
-			Longitude: info.Location.Longitude,
+			Latitude:  coarsenCoordinate(info.Location.Latitude, handler.mapConfig.LocationPrecision),
+			Longitude: coarsenCoordinate(info.Location.Longitude, handler.mapConfig.LocationPrecision),
 		})
 	}
 
 	return locations, ipSummary.PieceCount, nil
 }
 
+// mapLocation is a location in the JSON map output with the number of nodes
+// in it.
+type mapLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Nodes     int     `json:"nodes"`
+}
+
+// groupLocations groups equal locations, ordered by latitude and longitude.
+func groupLocations(locations []location) []mapLocation {
+	counts := map[location]int{}
+	for _, loc := range locations {
+		counts[loc]++
+	}
+
+	grouped := make([]mapLocation, 0, len(counts))
+	for loc, nodes := range counts {
+		grouped = append(grouped, mapLocation{Latitude: loc.Latitude, Longitude: loc.Longitude, Nodes: nodes})
+	}
+	sort.Slice(grouped, func(i, j int) bool {
+		if grouped[i].Latitude != grouped[j].Latitude {
+			return grouped[i].Latitude < grouped[j].Latitude
+		}
+		return grouped[i].Longitude < grouped[j].Longitude
+	})
+	return grouped
+}
+
+// mapJSON is the JSON map output.
+type mapJSON struct {
+	Pieces    int64         `json:"pieces"`
+	Size      int64         `json:"size"`
+	Locations []mapLocation `json:"locations"`
+}
+
+// geoJSONFeatureCollection is the GeoJSON (RFC 7946) map output. Pieces and
+// Size are foreign members.
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+	Pieces   int64            `json:"pieces"`
+	Size     int64            `json:"size"`
+}
+
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONPoint      `json:"geometry"`
+	Properties geoJSONProperties `json:"properties"`
+}
+
+type geoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // longitude, latitude
+}
+
+type geoJSONProperties struct {
+	Nodes int `json:"nodes"`
+}
+
+// serveMap serves the map of the node locations in the format asked for with
+// the format query parameter: svg (the default), json or geojson.
 func (handler *Handler) serveMap(ctx context.Context, w http.ResponseWriter, locations []location, pieces int64, o *uplink.Object, q url.Values) (err error) {
 	defer mon.Task()(&ctx)(&err)
 
+	switch format := q.Get("format"); format {
+	case "", "svg":
+		return handler.serveMapSVG(ctx, w, locations, pieces, o, q)
+	case "json":
+		return serveMapData(w, "application/json", mapJSON{
+			Pieces:    pieces,
+			Size:      o.System.ContentLength,
+			Locations: groupLocations(locations),
+		})
+	case "geojson":
+		collection := geoJSONFeatureCollection{
+			Type:     "FeatureCollection",
+			Features: make([]geoJSONFeature, 0),
+			Pieces:   pieces,
+			Size:     o.System.ContentLength,
+		}
+		for _, loc := range groupLocations(locations) {
+			collection.Features = append(collection.Features, geoJSONFeature{
+				Type: "Feature",
+				Geometry: geoJSONPoint{
+					Type:        "Point",
+					Coordinates: [2]float64{loc.Longitude, loc.Latitude},
+				},
+				Properties: geoJSONProperties{Nodes: loc.Nodes},
+			})
+		}
+		return serveMapData(w, "application/geo+json", collection)
+	default:
+		return errdata.WithStatus(errs.New("unsupported map format %q", format), http.StatusBadRequest)
+	}
+}
+
+func serveMapData(w http.ResponseWriter, contentType string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errdata.WithAction(err, "json encode")
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	_, err = w.Write(data)
+	return err
+}
+
+func (handler *Handler) serveMapSVG(ctx context.Context, w http.ResponseWriter, locations []location, pieces int64, o *uplink.Object, q url.Values) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
 	m := reference.WorldMap()
 
 	for i, loc := range locations {
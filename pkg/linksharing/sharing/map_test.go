@@ -0,0 +1,77 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/gateway-mt/pkg/errdata"
+	"storj.io/uplink"
+)
+
+func TestCoarsenCoordinate(t *testing.T) {
+	assert.Equal(t, 47.4, coarsenCoordinate(47.3769, 1))
+	assert.Equal(t, 47.38, coarsenCoordinate(47.3769, 2))
+	assert.Equal(t, -122.0, coarsenCoordinate(-122.4194, 0))
+	assert.Equal(t, 50.0, coarsenCoordinate(47.3769, -1))
+}
+
+func TestServeMapData(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	handler := &Handler{}
+	object := &uplink.Object{System: uplink.SystemMetadata{ContentLength: 1234}}
+	locations := []location{
+		{Latitude: 47.4, Longitude: 8.5},
+		{Latitude: -33.9, Longitude: 151.2},
+		{Latitude: 47.4, Longitude: 8.5},
+	}
+
+	serve := func(format string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		require.NoError(t, handler.serveMap(ctx, w, locations, 29, object, url.Values{"format": {format}}))
+		return w
+	}
+
+	w := serve("json")
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"pieces": 29,
+		"size": 1234,
+		"locations": [
+			{"latitude": -33.9, "longitude": 151.2, "nodes": 1},
+			{"latitude": 47.4, "longitude": 8.5, "nodes": 2}
+		]
+	}`, w.Body.String())
+
+	w = serve("geojson")
+	assert.Equal(t, "application/geo+json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"type": "FeatureCollection",
+		"pieces": 29,
+		"size": 1234,
+		"features": [
+			{"type": "Feature", "geometry": {"type": "Point", "coordinates": [151.2, -33.9]}, "properties": {"nodes": 1}},
+			{"type": "Feature", "geometry": {"type": "Point", "coordinates": [8.5, 47.4]}, "properties": {"nodes": 2}}
+		]
+	}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	require.NoError(t, handler.serveMap(ctx, w, nil, 0, object, url.Values{"format": {"geojson"}}))
+	assert.JSONEq(t, `{"type": "FeatureCollection", "features": [], "pieces": 0, "size": 1234}`, w.Body.String())
+
+	w = serve("svg")
+	assert.Equal(t, "image/svg+xml", w.Header().Get("Content-Type"))
+
+	err := handler.serveMap(ctx, httptest.NewRecorder(), locations, 29, object, url.Values{"format": {"kml"}})
+	require.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, errdata.GetStatus(err, 0))
+}
@@ -0,0 +1,154 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"bytes"
+	"html/template"
+	"net/url"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// maxMarkdownNesting is how many block quote and list markers a line may
+// start with. goldmark takes time quadratic in it.
+const maxMarkdownNesting = 16
+
+// markdown renders GitHub Flavored Markdown. Raw HTML is left out (goldmark
+// only renders it with html.WithUnsafe), and markdownLinks removes links and
+// images that aren't relative or don't use a safe scheme, so the result can
+// be embedded in our pages as is.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithParserOptions(
+		parser.WithASTTransformers(util.Prioritized(markdownLinks{}, 1000)),
+	),
+)
+
+// renderMarkdown renders src to HTML that's safe to embed in our pages.
+func renderMarkdown(src string) template.HTML {
+	var b bytes.Buffer
+	if err := markdown.Convert([]byte(src), &b); err != nil {
+		return template.HTML("<pre>" + template.HTMLEscapeString(src) + "</pre>") //nolint:gosec // the content is escaped.
+	}
+	return template.HTML(b.String()) //nolint:gosec // raw HTML and unsafe links are left out.
+}
+
+// markdownNesting returns the largest number of block quote and list markers
+// a line of src starts with.
+func markdownNesting(src string) (nesting int) {
+	for _, line := range strings.Split(src, "\n") {
+		n := 0
+		for {
+			line = strings.TrimLeft(line, " \t")
+			if line == "" {
+				break
+			}
+			if line[0] == '>' {
+				line = line[1:]
+			} else if marker := markdownListMarker(line); marker > 0 {
+				line = line[marker:]
+			} else {
+				break
+			}
+			n++
+		}
+		if n > nesting {
+			nesting = n
+		}
+	}
+	return nesting
+}
+
+// markdownListMarker returns the length of the list marker line starts with,
+// including the space after it, or zero if there's none.
+func markdownListMarker(line string) int {
+	i := 0
+	for i < len(line) && i < 9 && line[i] >= '0' && line[i] <= '9' {
+		i++
+	}
+	switch {
+	case i == 0 && (line[0] == '-' || line[0] == '*' || line[0] == '+'):
+		i = 1
+	case i > 0 && i < len(line) && (line[i] == '.' || line[i] == ')'):
+		i++
+	default:
+		return 0
+	}
+	if i < len(line) && line[i] != ' ' && line[i] != '\t' {
+		return 0
+	}
+	return i
+}
+
+// markdownLinks replaces links and images with unsafe destinations by their
+// text, and marks the remaining links as nofollow, since READMEs are written
+// by anyone.
+type markdownLinks struct{}
+
+// Transform implements parser.ASTTransformer.
+func (markdownLinks) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+
+	var unsafe []ast.Node
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Link:
+			if !safeMarkdownURL(n.Destination, true) {
+				unsafe = append(unsafe, n)
+				return ast.WalkSkipChildren, nil
+			}
+			n.SetAttributeString("rel", []byte("nofollow noopener"))
+		case *ast.AutoLink:
+			if n.AutoLinkType == ast.AutoLinkURL && !safeMarkdownURL(n.URL(source), false) {
+				unsafe = append(unsafe, n)
+				return ast.WalkSkipChildren, nil
+			}
+			n.SetAttributeString("rel", []byte("nofollow noopener"))
+		case *ast.Image:
+			if !safeMarkdownURL(n.Destination, false) {
+				unsafe = append(unsafe, n)
+				return ast.WalkSkipChildren, nil
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, n := range unsafe {
+		parent := n.Parent()
+		if link, ok := n.(*ast.AutoLink); ok {
+			parent.InsertBefore(parent, n, ast.NewString(link.Label(source)))
+		}
+		for child := n.FirstChild(); child != nil; child = n.FirstChild() {
+			parent.InsertBefore(parent, n, child)
+		}
+		parent.RemoveChild(parent, n)
+	}
+}
+
+// safeMarkdownURL reports whether the link destination dest is relative or
+// uses http or https (or mailto, if allowed).
+func safeMarkdownURL(dest []byte, allowMailto bool) bool {
+	u, err := url.Parse(strings.TrimSpace(string(dest)))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https":
+		return true
+	case "mailto":
+		return allowMailto
+	default:
+		return false
+	}
+}
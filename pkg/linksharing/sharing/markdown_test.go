@@ -0,0 +1,162 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"html/template"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/common/testcontext"
+	"storj.io/gateway-mt/pkg/linksharing/objectmap"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	for _, tc := range []struct {
+		name, src, html string
+	}{
+		{
+			name: "headings",
+			src:  "# Title #\n\n## Sub *title*\n\nSetext\n======\n\n#hashtag",
+			html: "<h1>Title</h1>\n<h2>Sub <em>title</em></h2>\n<h1>Setext</h1>\n<p>#hashtag</p>\n",
+		},
+		{
+			name: "paragraphs",
+			src:  "one\ntwo  \nthree\\\nfour\n\n---\nfive",
+			html: "<p>one\ntwo<br>\nthree<br>\nfour</p>\n<hr>\n<p>five</p>\n",
+		},
+		{
+			name: "emphasis",
+			src:  "**bold** and *em* and __b__ _e_ snake_case_name *** 2 * 3 * 4 **unclosed",
+			html: "<p><strong>bold</strong> and <em>em</em> and <strong>b</strong> <em>e</em> snake_case_name *** 2 * 3 * 4 **unclosed</p>\n",
+		},
+		{
+			name: "nested emphasis",
+			src:  "*a **b** c*",
+			html: "<p><em>a <strong>b</strong> c</em></p>\n",
+		},
+		{
+			name: "code",
+			src:  "use `a < b` or `` a`b ``\n\n```go extra\nif a < b {\n}\n```\n\n    indented\n    code",
+			html: "<p>use <code>a &lt; b</code> or <code>a`b</code></p>\n<pre><code class=\"language-go\">if a &lt; b {\n}\n</code></pre>\n<pre><code>indented\ncode</code></pre>\n",
+		},
+		{
+			name: "unclosed fence",
+			src:  "~~~\n*code*",
+			html: "<pre><code>*code*</code></pre>\n",
+		},
+		{
+			name: "lists",
+			src:  "- one\n- two\n  - nested\n- three\nlazy\n\n3. three\n4. four",
+			html: "<ul>\n<li>one</li>\n<li>two\n<ul>\n<li>nested</li>\n</ul>\n</li>\n<li>three\nlazy</li>\n</ul>\n<ol start=\"3\">\n<li>three</li>\n<li>four</li>\n</ol>\n",
+		},
+		{
+			name: "loose list",
+			src:  "1. one\n\n   more\n2. two",
+			html: "<ol>\n<li>\n<p>one</p>\n<p>more</p>\n</li>\n<li>\n<p>two</p>\n</li>\n</ol>\n",
+		},
+		{
+			name: "quotes",
+			src:  "> quoted\n> > nested",
+			html: "<blockquote>\n<p>quoted</p>\n<blockquote>\n<p>nested</p>\n</blockquote>\n</blockquote>\n",
+		},
+		{
+			name: "links",
+			src:  "[docs](docs/index.md) [**site**](https://example.test \"title\") <https://auto.test> <me@example.test> ![logo](logo.png)",
+			html: "<p><a href=\"docs/index.md\" rel=\"nofollow noopener\">docs</a> <a href=\"https://example.test\" title=\"title\" rel=\"nofollow noopener\"><strong>site</strong></a> <a href=\"https://auto.test\" rel=\"nofollow noopener\">https://auto.test</a> <a href=\"mailto:me@example.test\" rel=\"nofollow noopener\">me@example.test</a> <img src=\"logo.png\" alt=\"logo\"></p>\n",
+		},
+		{
+			name: "unsafe links",
+			src:  "[click](javascript:alert(1)) ![x](data:image/png;base64,AA) [ok](<a b>) [no link] \\*escaped\\*",
+			html: "<p>click x <a href=\"a%20b\" rel=\"nofollow noopener\">ok</a> [no link] *escaped*</p>\n",
+		},
+		{
+			name: "raw html",
+			src:  "<script>alert(1)</script>\n\n<img src=x onerror=alert(1)>",
+			html: "<!-- raw HTML omitted -->\n<!-- raw HTML omitted -->\n",
+		},
+		{
+			name: "inline raw html",
+			src:  "a <b onclick=\"alert(1)\">b</b> [c](<javascript:alert(1)>) <javascript:alert(1)>",
+			html: "<p>a <!-- raw HTML omitted -->b<!-- raw HTML omitted --> c javascript:alert(1)</p>\n",
+		},
+		{
+			name: "tables",
+			src:  "| a | b |\n| - | - |\n| `c` | ~~d~~ |",
+			html: "<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td><code>c</code></td>\n<td><del>d</del></td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		{
+			name: "attribute escaping",
+			src:  "[x](https://example.test/\"onmouseover=\"alert(1)) ![\"><script>](a.png)",
+			html: "<p><a href=\"https://example.test/%22onmouseover=%22alert(1)\" rel=\"nofollow noopener\">x</a> <img src=\"a.png\" alt=\"&quot;&gt;\"></p>\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, template.HTML(tc.html), renderMarkdown(tc.src))
+		})
+	}
+}
+
+func TestRenderMarkdownPathological(t *testing.T) {
+	for i, src := range []string{
+		strings.Repeat("*a ", 20000),
+		strings.Repeat("_a", 30000),
+		strings.Repeat("[", 60000),
+		strings.Repeat("`", 30000) + "x" + strings.Repeat("`a", 15000),
+		strings.Repeat("<", 60000),
+		strings.Repeat("> ", 16) + "deep",
+	} {
+		start := time.Now()
+		_ = renderReadme("README.md", src)
+		assert.Less(t, time.Since(start), 5*time.Second, i)
+	}
+
+	// input that would take too long to render is shown as text.
+	for _, src := range []string{
+		strings.Repeat("_a", 100000),
+		strings.Repeat("> ", 17) + "deep",
+		strings.Repeat("- ", 10000) + "deep",
+		"1. " + strings.Repeat("* 1) ", 10) + "\n" + strings.Repeat("+ ", 10) + "deep",
+	} {
+		assert.True(t, strings.HasPrefix(string(renderReadme("README.md", src)), "<pre>"))
+	}
+}
+
+func TestFindReadme(t *testing.T) {
+	assert.Nil(t, findReadme([]listObject{{Key: "index.html"}, {Key: "readme.md/", Prefix: true}}))
+
+	found := findReadme([]listObject{{Key: "README.txt"}, {Key: "a"}, {Key: "Readme.MD"}, {Key: "readme"}})
+	if assert.NotNil(t, found) {
+		assert.Equal(t, "Readme.MD", found.Key)
+	}
+
+	assert.Equal(t, template.HTML("<pre>a &lt;b&gt;</pre>"), renderReadme("README.txt", "a <b>"))
+	assert.Equal(t, template.HTML("<p>a <em>b</em></p>\n"), renderReadme("readme.markdown", "a *b*"))
+}
+
+func TestHandlerRenderReadme(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	handler, err := NewHandler(zaptest.NewLogger(t), &objectmap.IPDB{}, nil, nil, nil, Config{
+		URLBases:  []string{"http://test.test"},
+		Templates: "../../../pkg/linksharing/web/",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, template.HTML("<p>a <em>b</em></p>\n"), handler.renderReadme(ctx, "README.md", "a *b*"))
+
+	// while too many READMEs are rendered, new ones are shown as text, but
+	// rendered ones are still served from the cache.
+	for i := 0; i < cap(handler.readmeRenders); i++ {
+		handler.readmeRenders <- struct{}{}
+	}
+	assert.Equal(t, template.HTML("<pre>a *c*</pre>"), handler.renderReadme(ctx, "README.md", "a *c*"))
+	assert.Equal(t, template.HTML("<p>a <em>b</em></p>\n"), handler.renderReadme(ctx, "README.md", "a *b*"))
+	assert.Equal(t, template.HTML("<pre>a *b*</pre>"), handler.renderReadme(ctx, "README.txt", "a *b*"))
+}
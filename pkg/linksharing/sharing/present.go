@@ -19,8 +19,10 @@ import (
 	"go.uber.org/zap"
 
 	"storj.io/common/memory"
+	"storj.io/common/ranger"
 	"storj.io/common/ranger/httpranger"
 	"storj.io/gateway-mt/pkg/errdata"
+	"storj.io/gateway-mt/pkg/linksharing/objectcache"
 	"storj.io/gateway-mt/pkg/linksharing/objectranger"
 	"storj.io/gateway-mt/pkg/trustedip"
 	"storj.io/uplink"
@@ -115,14 +117,18 @@ func (handler *Handler) presentWithProject(ctx context.Context, w http.ResponseW
 		}
 
 		// it might be a prefix
-		return handler.servePrefix(ctx, w, project, pr, "")
+		return handler.servePrefix(ctx, w, r, project, pr, "")
 
 	case pr.realKey != "":
 		var objectErr error
 		options, rangeErr := predictRange(r.Header.Get("Range"))
 		// a rangeErr here does not always result in RangeNotSatisfiable so ignore it and
 		// allow StatObject and ServeContent to handle all the edge cases.
-		if (download || !wrap) && !mapOnly && len(archivePath) == 0 && rangeErr == nil {
+		// conditional requests are resolved against StatObject's result so
+		// that a 304 or 412 doesn't have to open (and throw away) a download.
+		// multi-range requests open a download per part (see serveByteRanges),
+		// so none is predicted for them either.
+		if (download || !wrap) && !mapOnly && len(archivePath) == 0 && rangeErr == nil && !hasPreconditions(r) && !multipleRanges(r.Header.Get("Range")) {
 			d, err := project.DownloadObject(ctx, pr.bucket, pr.realKey, options)
 			if err == nil {
 				// set the actual offset and length
@@ -174,7 +180,7 @@ func (handler *Handler) presentWithProject(ctx context.Context, w http.ResponseW
 			http.Redirect(w, r, r.URL.Path+"/", http.StatusSeeOther)
 			return nil
 		}
-		return handler.servePrefix(ctx, w, project, pr, "")
+		return handler.servePrefix(ctx, w, r, project, pr, "")
 	default:
 		return errdata.WithAction(err, "unexpected case")
 	}
@@ -216,6 +222,16 @@ func (handler *Handler) showObject(ctx context.Context, w http.ResponseWriter, r
 				return errdata.WithStatus(errs.New("Range header isn't compatible with path query"), http.StatusRequestedRangeNotSatisfiable)
 			}
 			acceptsGz := hasValue(r.Header, "Accept-Encoding", "gzip")
+			etag := objectETag(o, archivePath)
+			if acceptsGz {
+				// the file may come back compressed, which is a different
+				// representation and needs a different tag.
+				etag = etagVariant(etag, "gzip")
+			}
+			w.Header().Add("Vary", "Accept-Encoding")
+			if checkPreconditions(w, r, etag, o.System.Created) {
+				return nil
+			}
 			ranger, isGz, err := handler.archiveRanger(ctx, project, pr.bucket, o.Key, archivePath, acceptsGz)
 			if err != nil {
 				return errdata.WithStatus(err, http.StatusUnsupportedMediaType)
@@ -228,8 +244,48 @@ func (handler *Handler) showObject(ctx context.Context, w http.ResponseWriter, r
 				return errdata.WithAction(err, "serve content")
 			}
 		} else {
+			objectRanger := objectranger.New(project, o, d, httpRange, pr.bucket)
+			defer func() {
+				if err := objectRanger.Close(); err != nil {
+					handler.log.With(zap.Error(err)).Warn("unable to close unused download")
+				}
+			}()
 			handler.setHeaders(w, r, o.Custom, pr.hosting, filepath.Base(o.Key))
-			err = httpranger.ServeContent(ctx, w, r, o.Key, o.System.Created, objectranger.New(project, o, d, httpRange, pr.bucket))
+			version := objectETag(o, "")
+			etag := version
+			encoding := handler.compressionEncoding(w, r, o.System.ContentLength)
+			if encoding != "" {
+				// the compressed content is a different representation and
+				// needs a different tag.
+				etag = etagVariant(etag, encoding)
+			}
+			if checkPreconditions(w, r, etag, o.System.Created) {
+				return nil
+			}
+			var content ranger.Ranger = objectRanger
+			if ttl, ok := handler.objectCache.Cacheable(o.System.ContentLength, metadataHeaderValue(o.Custom, "Cache-Control")); ok {
+				content = handler.objectCache.Ranger(objectcache.Key{
+					MacaroonHead: string(privateAccess.APIKey(pr.access).Head()),
+					Bucket:       pr.bucket,
+					Key:          o.Key,
+					// the cache holds the object as stored, whatever the
+					// encoding of the response.
+					Version: version,
+				}, o.System.ContentLength, ttl, objectRanger)
+			}
+			if encoding != "" {
+				if err := serveCompressed(ctx, w, r, content, encoding); err != nil {
+					return errdata.WithAction(err, "serve compressed content")
+				}
+				return nil
+			}
+			if parts, ok := byteRanges(r, etag, o.System.Created, o.System.ContentLength); ok {
+				if err := handler.serveByteRanges(ctx, w, r, content, parts); err != nil {
+					return errdata.WithAction(err, "serve byte ranges")
+				}
+				return nil
+			}
+			err = httpranger.ServeContent(ctx, w, r, o.Key, o.System.Created, content)
 			if err != nil {
 				return errdata.WithAction(err, "serve content")
 			}
@@ -238,10 +294,10 @@ func (handler *Handler) showObject(ctx context.Context, w http.ResponseWriter, r
 	}
 
 	if archivePath == "/" {
-		return handler.servePrefix(ctx, w, project, pr, archivePath)
+		return handler.servePrefix(ctx, w, r, project, pr, archivePath)
 	}
 
-	locations, pieces, err := handler.getLocations(ctx, pr.access, pr.bucket, o.Key)
+	locations, pieces, err := handler.getLocations(ctx, pr.access, pr.bucket, o)
 	if err != nil {
 		return errdata.WithAction(err, "get locations")
 	}
@@ -288,7 +344,7 @@ func (handler *Handler) showObject(ctx context.Context, w http.ResponseWriter, r
 	data.Data = input
 	data.Title = input.Key
 
-	handler.renderTemplate(w, "single-object.html", data)
+	handler.renderTemplate(w, r, "single-object.html", data)
 
 	return nil
 }
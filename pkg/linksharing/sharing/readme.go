@@ -0,0 +1,140 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"html"
+	"html/template"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/common/memory"
+	"storj.io/uplink"
+)
+
+// readmeNames are the names of the files shown below prefix listings, in
+// order of preference. They're matched case-insensitively.
+var readmeNames = []string{"readme.md", "readme.markdown", "readme.txt", "readme"}
+
+const (
+	// maxReadmeSize is the size of the largest README shown below prefix
+	// listings.
+	maxReadmeSize = 256 * memory.KiB
+	// maxMarkdownReadmeSize is the size of the largest README rendered as
+	// Markdown. Rendering can take time quadratic in the size of the input,
+	// so larger ones are shown as text.
+	maxMarkdownReadmeSize = 64 * memory.KiB
+
+	// readmeCacheCapacity is the number of rendered READMEs that are kept,
+	// by content, so that each is only rendered once.
+	readmeCacheCapacity = 256
+	// maxReadmeRenders is the number of Markdown READMEs rendered at the same
+	// time. READMEs that would have to wait are shown as text.
+	maxReadmeRenders = 4
+)
+
+// readme is a README shown below a prefix listing.
+type readme struct {
+	Name    string
+	Content template.HTML
+}
+
+// findReadme returns the README among objects, if any.
+func findReadme(objects []listObject) (found *listObject) {
+	rank := len(readmeNames)
+	for i := range objects {
+		if objects[i].Prefix {
+			continue
+		}
+		for r, name := range readmeNames[:rank] {
+			if strings.EqualFold(objects[i].Key, name) {
+				found, rank = &objects[i], r
+				break
+			}
+		}
+	}
+	return found
+}
+
+// readme returns the README of the listed prefix, rendered to HTML. Failing
+// to get it doesn't fail the listing; it's just left out.
+func (handler *Handler) readme(ctx context.Context, project *uplink.Project, pr *parsedRequest, objects []listObject) (_ *readme) {
+	defer mon.Task()(&ctx)(nil)
+
+	object := findReadme(objects)
+	if object == nil || object.size > maxReadmeSize.Int64() {
+		return nil
+	}
+
+	download, err := project.DownloadObject(ctx, pr.bucket, pr.realKey+object.Key, nil)
+	if err != nil {
+		handler.log.Debug("unable to download readme", zap.String("key", pr.realKey+object.Key), zap.Error(err))
+		return nil
+	}
+	defer func() {
+		if err := download.Close(); err != nil {
+			handler.log.With(zap.Error(err)).Warn("unable to close readme download")
+		}
+	}()
+
+	data, err := io.ReadAll(io.LimitReader(download, maxReadmeSize.Int64()))
+	if err != nil {
+		handler.log.Debug("unable to download readme", zap.String("key", pr.realKey+object.Key), zap.Error(err))
+		return nil
+	}
+
+	return &readme{
+		Name:    object.Key,
+		Content: handler.renderReadme(ctx, object.Key, string(data)),
+	}
+}
+
+// renderReadme renders the README called name like renderReadme, but only
+// once for the same content and with a bound on concurrent renders.
+func (handler *Handler) renderReadme(ctx context.Context, name, content string) template.HTML {
+	sum := sha256.Sum256([]byte(content))
+	key := path.Ext(name) + ":" + hex.EncodeToString(sum[:])
+
+	rendered, err := handler.readmeCache.Get(ctx, key, func() (template.HTML, error) {
+		select {
+		case handler.readmeRenders <- struct{}{}:
+			defer func() { <-handler.readmeRenders }()
+		default:
+			// errors aren't cached, so it's rendered again next time.
+			return "", errs.New("too many readme renders")
+		}
+		return renderReadme(name, content), nil
+	})
+	if err != nil {
+		mon.Event("readme_render_busy")
+		return renderReadmeText(content)
+	}
+	return rendered
+}
+
+// renderReadme renders the README called name: Markdown to HTML and anything
+// else, including Markdown that would take too long to render, as
+// preformatted text.
+func renderReadme(name, content string) template.HTML {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown":
+		if len(content) <= maxMarkdownReadmeSize.Int() && markdownNesting(content) <= maxMarkdownNesting {
+			return renderMarkdown(strings.ToValidUTF8(content, "�"))
+		}
+	}
+	return renderReadmeText(content)
+}
+
+// renderReadmeText renders a README as preformatted text.
+func renderReadmeText(content string) template.HTML {
+	content = strings.ToValidUTF8(content, "�")
+	return template.HTML("<pre>" + html.EscapeString(content) + "</pre>") //nolint:gosec // the content is escaped.
+}
@@ -0,0 +1,183 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// redirectsFile is the name of the file at the root of a hosted site that
+// holds its redirect and rewrite rules.
+const redirectsFile = "_redirects"
+
+// redirectRule is a single rule of a _redirects file. The format follows
+// Netlify's:
+//
+//	# comment
+//	/old-page          /new-page               301
+//	/blog/:year/*      /archive/:year/:splat   302
+//	/docs/*            https://docs.example.test/:splat
+//	/app/*             /app/index.html         200
+//	/*                 /404-page.html          404
+//	/always            /elsewhere              302!
+//
+// The status defaults to 301. 200 and 404 rules are rewrites: the target is
+// served in place of the requested path without the client noticing. A rule
+// only applies if there is no object at the requested path, unless its status
+// is suffixed with an exclamation mark.
+type redirectRule struct {
+	from   pathPattern
+	to     string
+	status int
+	force  bool
+}
+
+// redirectRules is the parsed content of a _redirects file.
+type redirectRules []redirectRule
+
+// parseRedirects parses a _redirects file. Rules that can't be parsed, or that
+// use features we don't support, are skipped, just like Netlify does.
+func parseRedirects(data []byte) (rules redirectRules) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		rule, ok := parseRedirectRule(fields)
+		if !ok {
+			mon.Event("hosting_redirect_rule_invalid")
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func parseRedirectRule(fields []string) (rule redirectRule, ok bool) {
+	if len(fields) < 2 || len(fields) > 3 {
+		return redirectRule{}, false
+	}
+
+	rule.from, ok = parsePathPattern(fields[0])
+	if !ok {
+		return redirectRule{}, false
+	}
+	rule.to = fields[1]
+	rule.status = http.StatusMovedPermanently
+
+	if len(fields) == 3 {
+		status := fields[2]
+		if strings.HasSuffix(status, "!") {
+			rule.force = true
+			status = strings.TrimSuffix(status, "!")
+		}
+		code, err := strconv.Atoi(status)
+		if err != nil {
+			return redirectRule{}, false
+		}
+		rule.status = code
+	}
+
+	switch rule.status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	case http.StatusOK, http.StatusNotFound:
+		// we can only rewrite to objects of the site itself.
+		if !strings.HasPrefix(rule.to, "/") {
+			return redirectRule{}, false
+		}
+	default:
+		return redirectRule{}, false
+	}
+
+	return rule, true
+}
+
+// isRewrite reports whether the rule serves different content under the
+// requested URL instead of redirecting the client.
+func (rule *redirectRule) isRewrite() bool {
+	return rule.status == http.StatusOK || rule.status == http.StatusNotFound
+}
+
+// match returns the first rule matching urlPath and its target with
+// placeholders and splats substituted.
+func (rules redirectRules) match(urlPath string) (_ *redirectRule, target string, ok bool) {
+	segments := splitRulePath(urlPath)
+	for i := range rules {
+		if values, ok := rules[i].from.match(segments); ok {
+			return &rules[i], expandPlaceholders(rules[i].to, values), true
+		}
+	}
+	return nil, "", false
+}
+
+// pathPattern is a path with optional :placeholder segments and a trailing
+// * (splat) segment, as used in _redirects and _headers files.
+type pathPattern []string
+
+func parsePathPattern(s string) (_ pathPattern, ok bool) {
+	if !strings.HasPrefix(s, "/") {
+		return nil, false
+	}
+	pattern := pathPattern(splitRulePath(s))
+	for i, segment := range pattern {
+		if segment == "*" && i != len(pattern)-1 {
+			return nil, false
+		}
+	}
+	return pattern, true
+}
+
+// match matches the segments of a path (see splitRulePath) against the
+// pattern and returns the values of placeholders and the splat.
+func (pattern pathPattern) match(segments []string) (values map[string]string, ok bool) {
+	values = make(map[string]string)
+	for i, p := range pattern {
+		if p == "*" {
+			values["splat"] = strings.Join(segments[i:], "/")
+			return values, true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		switch {
+		case strings.HasPrefix(p, ":") && len(p) > 1:
+			values[p[1:]] = segments[i]
+		case p != segments[i]:
+			return nil, false
+		}
+	}
+	return values, len(segments) == len(pattern)
+}
+
+var placeholderRegexp = regexp.MustCompile(`:[A-Za-z_][A-Za-z0-9_]*`)
+
+func expandPlaceholders(to string, values map[string]string) string {
+	return placeholderRegexp.ReplaceAllStringFunc(to, func(placeholder string) string {
+		if value, ok := values[placeholder[1:]]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+// splitRulePath splits a URL path into segments, treating /path and /path/
+// the same way.
+func splitRulePath(urlPath string) []string {
+	urlPath = strings.Trim(urlPath, "/")
+	if urlPath == "" {
+		return nil
+	}
+	return strings.Split(urlPath, "/")
+}
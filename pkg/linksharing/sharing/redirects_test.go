@@ -0,0 +1,72 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRedirects(t *testing.T) {
+	rules := parseRedirects([]byte(`
+# a comment
+/old          /new
+/temp         /elsewhere      302   # trailing comment
+/forced       /elsewhere      307!
+/app/*        /app/index.html 200
+/missing/*    /404-page.html  404
+
+/bad
+not-a-path    /new
+/bad-status   /new            999
+/bad-rewrite  https://example.test/ 200
+/a/*/b        /new
+/too /many fields here
+`))
+
+	require.Equal(t, redirectRules{
+		{from: []string{"old"}, to: "/new", status: http.StatusMovedPermanently},
+		{from: []string{"temp"}, to: "/elsewhere", status: http.StatusFound},
+		{from: []string{"forced"}, to: "/elsewhere", status: http.StatusTemporaryRedirect, force: true},
+		{from: []string{"app", "*"}, to: "/app/index.html", status: http.StatusOK},
+		{from: []string{"missing", "*"}, to: "/404-page.html", status: http.StatusNotFound},
+	}, rules)
+}
+
+func TestRedirectRulesMatch(t *testing.T) {
+	rules := parseRedirects([]byte(`
+/news/:year/:month/:title  /blog/:year/:title?m=:month  301
+/docs/*                     https://docs.example.test/:splat 302
+/shop                       /store                      301
+/*                          /index.html                 200
+`))
+
+	for _, tc := range []struct {
+		path   string
+		target string
+		status int
+	}{
+		{path: "/news/2023/04/hello", target: "/blog/2023/hello?m=04", status: http.StatusMovedPermanently},
+		{path: "/docs/a/b/c.html", target: "https://docs.example.test/a/b/c.html", status: http.StatusFound},
+		{path: "/docs", target: "https://docs.example.test/", status: http.StatusFound},
+		{path: "/shop/", target: "/store", status: http.StatusMovedPermanently},
+		{path: "/shop/item", target: "/index.html", status: http.StatusOK},
+		{path: "/news/2023/04", target: "/index.html", status: http.StatusOK},
+		{path: "/", target: "/index.html", status: http.StatusOK},
+	} {
+		rule, target, ok := rules.match(tc.path)
+		require.True(t, ok, tc.path)
+		assert.Equal(t, tc.target, target, tc.path)
+		assert.Equal(t, tc.status, rule.status, tc.path)
+	}
+
+	_, _, ok := redirectRules(nil).match("/anything")
+	assert.False(t, ok)
+
+	_, _, ok = parseRedirects([]byte("/a/:b /c/:b")).match("/a")
+	assert.False(t, ok)
+}
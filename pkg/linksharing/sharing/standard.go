@@ -8,12 +8,12 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
 
 	"github.com/zeebo/errs"
 
 	"storj.io/gateway-mt/pkg/errdata"
 	"storj.io/gateway-mt/pkg/trustedip"
+	privateAccess "storj.io/uplink/private/access"
 )
 
 func (handler *Handler) handleStandard(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
@@ -56,12 +56,17 @@ func (handler *Handler) handleStandard(ctx context.Context, w http.ResponseWrite
 
 	clientIP := trustedip.GetClientIP(handler.trustedClientIPsList, r)
 
-	// TODO(artur): make signedAccessValidityTolerance a configuration attribute.
-	access, err := parseAccess(ctx, r, serializedAccess, 15*time.Minute, handler.authClient, clientIP)
+	access, err := parseAccess(ctx, r, serializedAccess, signedAccessValidityTolerance, handler.authClient, clientIP)
 	if err != nil {
 		return err
 	}
 
+	// the access of a site with basic auth or IP restrictions would otherwise
+	// serve the site's files without them.
+	if handler.txtRecords.IsRestrictedMacaroonHead(privateAccess.APIKey(access).Head()) {
+		return errdata.WithStatus(errs.New("access of a restricted hosted site"), http.StatusForbidden)
+	}
+
 	pr.access = access
 	pr.serializedAccess = serializedAccess
 
@@ -0,0 +1,436 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template/parse"
+	"time"
+
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/common/errs2"
+	"storj.io/common/memory"
+	"storj.io/common/sync2"
+)
+
+// siteThemeDir is the prefix at the root of hosted sites whose templates
+// replace ours for the site.
+const siteThemeDir = "_theme"
+
+// siteThemeTemplates are the templates hosted sites may replace. The page
+// templates depend on data that isn't meant to be stable, so only what
+// surrounds them can be changed.
+var siteThemeTemplates = []string{"header.html", "footer.html"}
+
+const (
+	// siteThemeMaxSize is the size limit of a site theme template.
+	siteThemeMaxSize = 16 * memory.KiB
+	// siteThemeMaxOutput and siteThemeRenderTimeout bound rendering a page
+	// with a site theme.
+	siteThemeMaxOutput     = memory.MiB
+	siteThemeRenderTimeout = 100 * time.Millisecond
+)
+
+// theme is a parsed set of templates.
+type theme struct {
+	// pristine is never executed, as html/template can't clone templates
+	// that have been, and serves as the base of the themes derived from it.
+	pristine *template.Template
+	// tmpl is a clone of pristine used for rendering.
+	tmpl *template.Template
+}
+
+func newTheme(pristine *template.Template) (theme, error) {
+	tmpl, err := pristine.Clone()
+	if err != nil {
+		return theme{}, err
+	}
+	return theme{pristine: pristine, tmpl: tmpl}, nil
+}
+
+// derive returns a theme with the templates of t replaced by the ones parsed
+// by parse. Templates parse doesn't define are kept.
+func (t theme) derive(parse func(*template.Template) error) (theme, error) {
+	clone, err := t.pristine.Clone()
+	if err != nil {
+		return theme{}, err
+	}
+	if err := parse(clone); err != nil {
+		return theme{}, err
+	}
+	return newTheme(clone)
+}
+
+// templateSet holds the default templates and the themes of the hostnames
+// that have one.
+type templateSet struct {
+	defaults theme
+	themes   map[string]theme // lowercase hostname -> theme
+}
+
+// loadTemplateSet parses the templates in dir and the themes in themesDir,
+// which holds a directory per hostname (either the host of a URL base or a
+// hosted site) with the templates to replace for it. Themes that fail to parse
+// are left out, so that their hostname gets the default templates.
+func loadTemplateSet(log *zap.Logger, dir, themesDir string) (*templateSet, error) {
+	pristine, err := template.ParseGlob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	defaults, err := newTheme(pristine)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &templateSet{defaults: defaults, themes: map[string]theme{}}
+	if themesDir == "" {
+		return set, nil
+	}
+
+	entries, err := os.ReadDir(themesDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		hostname := strings.ToLower(entry.Name())
+
+		files, err := filepath.Glob(filepath.Join(themesDir, entry.Name(), "*.html"))
+		if err != nil || len(files) == 0 {
+			continue
+		}
+
+		t, err := defaults.derive(func(tmpl *template.Template) error {
+			_, err := tmpl.ParseFiles(files...)
+			return err
+		})
+		if err != nil {
+			log.Warn("invalid template theme; using the default templates", zap.String("hostname", hostname), zap.Error(err))
+			continue
+		}
+		set.themes[hostname] = t
+	}
+
+	return set, nil
+}
+
+// theme returns the theme for hostname, or the default templates.
+func (set *templateSet) theme(hostname string) theme {
+	if t, ok := set.themes[hostname]; ok {
+		return t
+	}
+	return set.defaults
+}
+
+// siteTheme is the theme of a hosted site, loaded from its _theme prefix.
+type siteTheme struct {
+	files map[string]string // template name -> source
+
+	mu   sync.Mutex
+	set  *templateSet
+	tmpl *template.Template
+	err  error
+}
+
+// templates returns the templates of the site hosted at hostname, parsed
+// over its theme in set. They're parsed once per set.
+func (site *siteTheme) templates(set *templateSet, hostname string) (*template.Template, error) {
+	site.mu.Lock()
+	defer site.mu.Unlock()
+
+	if site.set != set {
+		var t theme
+		t, site.err = set.theme(hostname).derive(func(tmpl *template.Template) error {
+			for _, name := range siteThemeTemplates {
+				if source, ok := site.files[name]; ok {
+					if err := checkSiteThemeTemplate(name, source); err != nil {
+						return err
+					}
+					if _, err := tmpl.New(name).Parse(source); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		site.set, site.tmpl = set, t.tmpl
+	}
+
+	return site.tmpl, site.err
+}
+
+// checkSiteThemeTemplate checks that the site theme template called name can
+// only take time proportional to its size and the page data to render. Sites
+// can't define templates or call them, which could otherwise recurse, nor nest
+// range actions.
+func checkSiteThemeTemplate(name, source string) error {
+	if len(source) > siteThemeMaxSize.Int() {
+		return errs.New("%s: larger than %s", name, siteThemeMaxSize)
+	}
+
+	tree := parse.New(name)
+	tree.Mode = parse.SkipFuncCheck
+	trees := map[string]*parse.Tree{}
+	if _, err := tree.Parse(source, "", "", trees); err != nil {
+		return err
+	}
+	if len(trees) != 1 || trees[name] != tree {
+		return errs.New("%s: define and block actions aren't allowed", name)
+	}
+
+	if err := checkSiteThemeNode(tree.Root, false); err != nil {
+		return errs.New("%s: %v", name, err)
+	}
+	return nil
+}
+
+func checkSiteThemeNode(node parse.Node, inRange bool) error {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return nil
+		}
+		for _, child := range node.Nodes {
+			if err := checkSiteThemeNode(child, inRange); err != nil {
+				return err
+			}
+		}
+	case *parse.TemplateNode:
+		return errs.New("template actions aren't allowed")
+	case *parse.IfNode:
+		return checkSiteThemeBranch(&node.BranchNode, inRange)
+	case *parse.WithNode:
+		return checkSiteThemeBranch(&node.BranchNode, inRange)
+	case *parse.RangeNode:
+		if inRange {
+			return errs.New("nested range actions aren't allowed")
+		}
+		return checkSiteThemeBranch(&node.BranchNode, true)
+	}
+	return nil
+}
+
+func checkSiteThemeBranch(node *parse.BranchNode, inRange bool) error {
+	if err := checkSiteThemeNode(node.List, inRange); err != nil {
+		return err
+	}
+	return checkSiteThemeNode(node.ElseList, inRange)
+}
+
+// siteThemeWriter buffers a page rendered with a site theme. Writes fail once
+// the page grows larger than siteThemeMaxOutput or the deadline passes, which
+// stops executing the templates.
+type siteThemeWriter struct {
+	buf      *bytes.Buffer
+	deadline time.Time
+}
+
+func (w *siteThemeWriter) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > siteThemeMaxOutput.Int() {
+		return 0, errs.New("site theme output too large")
+	}
+	if time.Now().After(w.deadline) {
+		return 0, errs.New("site theme took too long to render")
+	}
+	return w.buf.Write(p)
+}
+
+// templates holds the templates in use and reloads them when the files they
+// are parsed from change.
+type templates struct {
+	log            *zap.Logger
+	dir            string
+	themesDir      string
+	reloadInterval time.Duration
+
+	mu          sync.RWMutex
+	set         *templateSet
+	fingerprint string
+}
+
+func newTemplates(log *zap.Logger, dir, themesDir string, reloadInterval time.Duration) (*templates, error) {
+	t := &templates{
+		log:            log,
+		dir:            dir,
+		themesDir:      themesDir,
+		reloadInterval: reloadInterval,
+	}
+	if _, err := t.reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// get returns the current template set.
+func (t *templates) get() *templateSet {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.set
+}
+
+// reload parses the templates again if any of the files changed since they
+// were last parsed. If that fails, the current ones are kept.
+func (t *templates) reload() (reloaded bool, err error) {
+	fingerprint, err := t.files()
+	if err != nil {
+		return false, err
+	}
+
+	t.mu.RLock()
+	unchanged := t.set != nil && fingerprint == t.fingerprint
+	t.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	set, err := loadTemplateSet(t.log, t.dir, t.themesDir)
+	if err != nil {
+		return false, err
+	}
+
+	t.mu.Lock()
+	t.set, t.fingerprint = set, fingerprint
+	t.mu.Unlock()
+
+	return true, nil
+}
+
+// files describes the names, sizes and modification times of the template
+// files, so that changes to them can be noticed.
+func (t *templates) files() (string, error) {
+	patterns := []string{filepath.Join(t.dir, "*.html")}
+	if t.themesDir != "" {
+		patterns = append(patterns, filepath.Join(t.themesDir, "*", "*.html"))
+	}
+
+	var b strings.Builder
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return "", err
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&b, "%s %d %d\n", match, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return b.String(), nil
+}
+
+// run reloads the templates every reload interval until ctx is canceled.
+func (t *templates) run(ctx context.Context) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if t.reloadInterval <= 0 {
+		return nil
+	}
+
+	cycle := sync2.NewCycle(t.reloadInterval)
+	cycle.SetDelayStart()
+	defer cycle.Close()
+
+	return errs2.IgnoreCanceled(cycle.Run(ctx, func(ctx context.Context) error {
+		reloaded, err := t.reload()
+		switch {
+		case err != nil:
+			mon.Event("templates_reload_failed")
+			t.log.Error("unable to reload templates; keeping the current ones", zap.Error(err))
+		case reloaded:
+			mon.Event("templates_reloaded")
+			t.log.Info("templates reloaded")
+		}
+		return nil
+	}))
+}
+
+// siteTheme returns the theme of the hosted site, loading it if needed.
+// Failing to load it doesn't fail the request; the site gets our templates.
+func (handler *Handler) siteTheme(ctx context.Context, site *hostedSite) *siteTheme {
+	v, err := handler.txtRecords.SiteFile(ctx, site.host, siteThemeDir, func(ctx context.Context) (interface{}, error) {
+		files := map[string]string{}
+		for _, name := range siteThemeTemplates {
+			data, err := handler.downloadSiteFile(ctx, site, siteThemeDir+"/"+name)
+			if err != nil {
+				return nil, err
+			}
+			if len(data) > 0 {
+				files[name] = string(data)
+			}
+		}
+		if len(files) == 0 {
+			return (*siteTheme)(nil), nil
+		}
+		return &siteTheme{files: files}, nil
+	})
+	if err != nil {
+		handler.log.Debug("unable to load site theme", zap.String("host", site.host), zap.Error(err))
+		return nil
+	}
+	return v.(*siteTheme)
+}
+
+// renderTemplate renders the template called name with the theme for the
+// host r is for. If the theme fails to render, the default templates are used
+// instead.
+func (handler *Handler) renderTemplate(w http.ResponseWriter, r *http.Request, name string, data pageData) {
+	data.Base = strings.TrimSuffix(handler.urlBases[0].String(), "/")
+
+	hostname := r.Host
+	if host, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = host
+	}
+	hostname = strings.ToLower(hostname)
+
+	set := handler.templates.get()
+	tmpl := set.theme(hostname).tmpl
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+
+	// the site theme is loaded while serving the request, if it's for a hosted
+	// site, so it's only looked up here.
+	if v, ok := handler.txtRecords.CachedSiteFile(hostname, siteThemeDir); ok {
+		if site, _ := v.(*siteTheme); site != nil {
+			themed, err := site.templates(set, hostname)
+			if err == nil {
+				tmpl = themed
+				out = &siteThemeWriter{buf: &buf, deadline: time.Now().Add(siteThemeRenderTimeout)}
+			} else {
+				handler.log.Debug("invalid site theme", zap.String("host", hostname), zap.Error(err))
+			}
+		}
+	}
+
+	err := tmpl.ExecuteTemplate(out, name, data)
+	if err != nil && tmpl != set.defaults.tmpl {
+		mon.Event("theme_render_failed")
+		handler.log.Debug("unable to render theme; using the default templates", zap.String("host", hostname), zap.Error(err))
+		buf.Reset()
+		err = set.defaults.tmpl.ExecuteTemplate(&buf, name, data)
+	}
+	if err != nil {
+		handler.log.Error("error while executing template", zap.Error(err))
+		return
+	}
+
+	if _, err := buf.WriteTo(w); err != nil {
+		handler.log.Debug("unable to write page", zap.Error(err))
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/common/testcontext"
+)
+
+func writeTemplates(t *testing.T, dir string, files map[string]string) {
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+}
+
+func newThemesTestHandler(t *testing.T, dir, themesDir string) *Handler {
+	writeTemplates(t, dir, map[string]string{
+		"header.html": `<header>default</header>`,
+		"footer.html": `<footer>default</footer>`,
+		"page.html":   `{{template "header.html" .}}{{.Data}}{{template "footer.html" .}}`,
+	})
+
+	templates, err := newTemplates(zaptest.NewLogger(t), dir, themesDir, 0)
+	require.NoError(t, err)
+
+	return &Handler{
+		log:        zaptest.NewLogger(t),
+		urlBases:   []*url.URL{{Scheme: "https", Host: "link.test"}},
+		templates:  templates,
+		txtRecords: NewTXTRecords(time.Hour, 0, 0, nil, nil),
+	}
+}
+
+func renderTestPage(handler *Handler, host string) string {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Host = host
+	handler.renderTemplate(w, r, "page.html", pageData{Data: "body"})
+	return w.Body.String()
+}
+
+func TestTemplateThemes(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	themesDir := ctx.Dir("themes")
+	writeTemplates(t, filepath.Join(themesDir, "partner.test"), map[string]string{
+		"header.html": `<header>partner</header>`,
+	})
+	writeTemplates(t, filepath.Join(themesDir, "Site.Test"), map[string]string{
+		"footer.html": `<footer>site</footer>`,
+	})
+	writeTemplates(t, filepath.Join(themesDir, "broken.test"), map[string]string{
+		"header.html": `{{if}}`,
+	})
+	writeTemplates(t, filepath.Join(themesDir, "failing.test"), map[string]string{
+		"header.html": `{{template "missing.html"}}`,
+	})
+
+	handler := newThemesTestHandler(t, ctx.Dir("templates"), themesDir)
+
+	assert.Equal(t, `<header>default</header>body<footer>default</footer>`, renderTestPage(handler, "link.test"))
+	assert.Equal(t, `<header>partner</header>body<footer>default</footer>`, renderTestPage(handler, "partner.test:443"))
+	assert.Equal(t, `<header>default</header>body<footer>site</footer>`, renderTestPage(handler, "SITE.test"))
+
+	// themes that don't parse or fail to render fall back to the defaults.
+	assert.Equal(t, `<header>default</header>body<footer>default</footer>`, renderTestPage(handler, "broken.test"))
+	assert.Equal(t, `<header>default</header>body<footer>default</footer>`, renderTestPage(handler, "failing.test"))
+}
+
+func TestSiteTheme(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	themesDir := ctx.Dir("themes")
+	writeTemplates(t, filepath.Join(themesDir, "site.test"), map[string]string{
+		"footer.html": `<footer>operator</footer>`,
+	})
+
+	handler := newThemesTestHandler(t, ctx.Dir("templates"), themesDir)
+	handler.txtRecords.cache.Store("site.test", &txtRecord{})
+	handler.txtRecords.cache.Store("invalid.test", &txtRecord{})
+
+	// nothing is rendered differently until the site theme is loaded.
+	assert.Equal(t, `<header>default</header>body<footer>operator</footer>`, renderTestPage(handler, "site.test"))
+
+	load := func(host string, theme *siteTheme) {
+		_, err := handler.txtRecords.SiteFile(ctx, host, siteThemeDir, func(context.Context) (interface{}, error) {
+			return theme, nil
+		})
+		require.NoError(t, err)
+	}
+	load("site.test", &siteTheme{files: map[string]string{"header.html": `<header>{{.Title}}</header>`}})
+	load("invalid.test", &siteTheme{files: map[string]string{"header.html": `{{end}}`}})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Host = "site.test"
+	handler.renderTemplate(w, r, "page.html", pageData{Data: "body", Title: "<title>"})
+	assert.Equal(t, `<header>&lt;title&gt;</header>body<footer>operator</footer>`, w.Body.String())
+
+	assert.Equal(t, `<header>default</header>body<footer>default</footer>`, renderTestPage(handler, "invalid.test"))
+
+	// themes that could take long to render fall back to our templates.
+	handler.txtRecords.cache.Store("recursive.test", &txtRecord{})
+	load("recursive.test", &siteTheme{files: map[string]string{
+		"header.html": `{{define "x"}}{{template "x" .}}{{template "x" .}}{{end}}{{template "x" .}}`,
+	}})
+	assert.Equal(t, `<header>default</header>body<footer>default</footer>`, renderTestPage(handler, "recursive.test"))
+}
+
+func TestCheckSiteThemeTemplate(t *testing.T) {
+	for _, source := range []string{
+		``,
+		`<header>{{.Title}}</header>`,
+		`{{range .Data}}{{if .}}{{with .}}{{.}}{{end}}{{end}}{{else}}none{{end}}`,
+		`{{range .Data}}{{end}}{{range .Data}}{{end}}`,
+	} {
+		assert.NoError(t, checkSiteThemeTemplate("header.html", source), source)
+	}
+
+	for _, source := range []string{
+		`{{end}}`,
+		`{{template "page.html" .}}`,
+		`{{define "header.html"}}{{.Title}}{{end}}`,
+		`{{define "x"}}{{end}}`,
+		`{{block "x" .}}{{end}}`,
+		`{{if .}}{{else}}{{template "x"}}{{end}}`,
+		`{{range .Data}}{{with .}}{{range .}}{{end}}{{end}}{{end}}`,
+		strings.Repeat("a", siteThemeMaxSize.Int()+1),
+	} {
+		assert.Error(t, checkSiteThemeTemplate("header.html", source), source)
+	}
+}
+
+func TestSiteThemeWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &siteThemeWriter{buf: &buf, deadline: time.Now().Add(time.Hour)}
+	_, err := w.Write([]byte("page"))
+	require.NoError(t, err)
+	_, err = w.Write(make([]byte, siteThemeMaxOutput.Int()))
+	require.Error(t, err)
+
+	w.deadline = time.Now().Add(-time.Second)
+	_, err = w.Write([]byte("page"))
+	require.Error(t, err)
+	assert.Equal(t, "page", buf.String())
+}
+
+func TestTemplatesReload(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	dir, themesDir := ctx.Dir("templates"), ctx.Dir("themes")
+	handler := newThemesTestHandler(t, dir, themesDir)
+
+	reloaded, err := handler.templates.reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded)
+
+	writeTemplates(t, filepath.Join(themesDir, "partner.test"), map[string]string{
+		"header.html": `<header>partner</header>`,
+	})
+	reloaded, err = handler.templates.reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, `<header>partner</header>body<footer>default</footer>`, renderTestPage(handler, "partner.test"))
+
+	// broken default templates keep the current ones in use.
+	writeTemplates(t, dir, map[string]string{"page.html": `{{range}}`})
+	_, err = handler.templates.reload()
+	require.Error(t, err)
+	assert.Equal(t, `<header>default</header>body<footer>default</footer>`, renderTestPage(handler, "link.test"))
+
+	writeTemplates(t, dir, map[string]string{"page.html": `{{.Data}} reloaded`})
+	reloaded, err = handler.templates.reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, `body reloaded`, renderTestPage(handler, "link.test"))
+}
@@ -4,56 +4,146 @@
 package sharing
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"net"
+	"net/http"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
 	"github.com/zeebo/errs"
 
+	"storj.io/common/encryption"
+	"storj.io/common/storj"
 	"storj.io/gateway-mt/pkg/authclient"
+	"storj.io/gateway-mt/pkg/errdata"
 	"storj.io/uplink"
+	privateAccess "storj.io/uplink/private/access"
+)
+
+var (
+	txtRecordSealError = errs.Class("txt record cache seal")
+	txtRecordOpenError = errs.Class("txt record cache open")
 )
 
 // TXTRecords fetches and caches linksharing DNS txt records.
 type TXTRecords struct {
-	maxTTL time.Duration
-	dns    *DNSClient
-	auth   *authclient.AuthClient
+	maxTTL               time.Duration
+	maxNegativeTTL       time.Duration
+	revalidationInterval time.Duration
+	dns                  *DNSClient
+	auth                 *authclient.AuthClient
+
+	// secret is mixed with hostnames to derive the keys cached accesses are
+	// encrypted with.
+	secret [32]byte
 
 	cache       sync.Map
 	updateLocks MutexGroup
+
+	// restricted holds the hostnames of sites with basic auth or IP
+	// restrictions by the macaroon head of their access, so that the access
+	// isn't served without them elsewhere. Hostnames are only forgotten once
+	// their records are queried again without restrictions.
+	restrictedMu sync.Mutex
+	restricted   map[string]map[string]struct{} // macaroon head -> hostnames
+	restrictedBy map[string]string              // hostname -> macaroon head
 }
 
 // Result is the result of a query on TXTRecords.
 type Result struct {
+	// Access must not be used on behalf of a request before the request is
+	// checked with VerifyRequest.
 	Access *uplink.Access
 	Root   string
 	TLS    bool
+
+	// BasicAuth maps user names to bcrypt hashes of their passwords. If it's
+	// not empty, clients must authenticate with one of these users.
+	BasicAuth map[string][]byte
+	// AllowedNetworks, if not empty, restricts access to clients with an IP
+	// in one of these networks.
+	AllowedNetworks []*net.IPNet
+	// Analytics, if not empty, is the bucket and optional prefix
+	// (bucket/prefix) analytics reports of the site are written to.
+	Analytics string
+
+	// secretKey is set if Access was resolved from a non-public Access Key
+	// ID. Requests must then be signed with it, which VerifyRequest checks.
+	secretKey string
+}
+
+// VerifyRequest checks that r may use Access. Access Key IDs that aren't
+// public require r to be AWS Signature Version 4-signed; the signature is
+// verified on every request as the cached Result is shared by all clients.
+func (result Result) VerifyRequest(r *http.Request) error {
+	return resolvedAccess{access: result.Access, secretKey: result.secretKey}.verify(r, signedAccessValidityTolerance)
 }
 
 type txtRecord struct {
-	// TODO: storing the actual access grant in the cache saves us some work and
-	// a request to the auth service, so that's nice. however, by storing the
-	// actual access grant in the cache, the dns entry will live until TTL *even
-	// though* the access key it used may have gotten revoked sooner. this is a
-	// troubling problem for access keys, and implies we should only support
-	// revoking access grants and not support revoking access keys due to this
-	// confusion.
-	//
-	// TODO: parts of this cache should be encrypted.
+	// queryResult holds everything but Access and secretKey, which are kept
+	// encrypted in access and secretKey below so that the cache doesn't hold
+	// credentials in the clear. Use TXTRecords.result to get the full Result.
 	queryResult Result
-	expiration  time.Time
+	access      sealed
+	secretKey   sealed
+
+	// accessKeyID is set if storj-access holds an Access Key ID. Unlike
+	// access grants, Access Key IDs can be revoked, so they're re-validated
+	// with the auth service every revalidationInterval.
+	accessKeyID      string
+	macaroonHead     []byte
+	allowAccessGrant bool
+	validated        int64 // unix nanoseconds; accessed atomically.
+	expiration       time.Time
+
+	// negative is set if hostname has no TXT records, in which case
+	// everything else is unset. It's returned until expiration.
+	negative error
+
+	// siteFiles holds parsed configuration files (e.g. _redirects) from the
+	// root of the hosted site. They live exactly as long as the record, so
+	// they're reloaded whenever the TXT records are refreshed.
+	siteFiles sync.Map // name -> *siteFile
 }
 
-// NewTXTRecords constructs a TXTRecords.
-func NewTXTRecords(maxTTL time.Duration, dns *DNSClient, auth *authclient.AuthClient) *TXTRecords {
-	return &TXTRecords{
-		maxTTL: maxTTL,
-		dns:    dns,
-		auth:   auth,
+// sealed is data encrypted with a hostname's key.
+type sealed struct {
+	nonce storj.Nonce
+	data  []byte
+}
+
+type siteFile struct {
+	mu     sync.Mutex
+	loaded bool
+	value  interface{}
+}
+
+// NewTXTRecords constructs a TXTRecords. Cached records holding an Access Key
+// ID are re-validated with the auth service every revalidationInterval, so
+// revoked keys stop working before maxTTL; zero disables re-validation.
+// Hostnames without TXT records are remembered for at most maxNegativeTTL;
+// zero disables negative caching.
+func NewTXTRecords(maxTTL, maxNegativeTTL, revalidationInterval time.Duration, dns *DNSClient, auth *authclient.AuthClient) *TXTRecords {
+	records := &TXTRecords{
+		maxTTL:               maxTTL,
+		maxNegativeTTL:       maxNegativeTTL,
+		revalidationInterval: revalidationInterval,
+		dns:                  dns,
+		auth:                 auth,
+		restricted:           make(map[string]map[string]struct{}),
+		restrictedBy:         make(map[string]string),
+	}
+	if _, err := rand.Read(records.secret[:]); err != nil {
+		panic(err)
 	}
+	return records
 }
 
 // FetchAccessForHost fetches
@@ -61,6 +151,9 @@ func NewTXTRecords(maxTTL time.Duration, dns *DNSClient, auth *authclient.AuthCl
 //   - access/grant
 //   - root/path
 //   - tls
+//   - basic-auth
+//   - allow-cidr
+//   - analytics
 //
 // TXT records from cache or DNS when applicable.
 //
@@ -88,11 +181,25 @@ func (records *TXTRecords) fetchAccessForHost(ctx context.Context, hostname stri
 		if err != nil {
 			return Result{}, err
 		}
-		return record.queryResult, nil
+		return records.result(hostname, record)
 	}
 
 	// there's something in the cache!
 	record := val.(*txtRecord)
+	if record.negative != nil {
+		if record.expiration.After(time.Now()) {
+			mon.Event("txt_record_negative_cache_hit")
+			return Result{}, record.negative
+		}
+		// there's nothing to serve in the meantime, so we can't update the
+		// cache in the background.
+		record, err = records.updateCache(ctx, hostname, allowAccessGrant, record.expiration, clientIP)
+		if err != nil {
+			return Result{}, err
+		}
+		return records.result(hostname, record)
+	}
+
 	if record.expiration.Before(time.Now()) {
 		// but it's expired. okay, this happens a lot and is usually going to
 		// return the same value. we're going to be optimistic and assume the
@@ -109,7 +216,73 @@ func (records *TXTRecords) fetchAccessForHost(ctx context.Context, hostname stri
 		}(ctx, hostname, record)
 	}
 
-	return record.queryResult, nil
+	if records.needsRevalidation(record) {
+		// unlike DNS changes, revoked Access Key IDs must stop working as soon
+		// as we notice, so this isn't done in the background.
+		record, err = records.revalidate(ctx, hostname, record, clientIP)
+		if err != nil {
+			return Result{}, err
+		}
+		if record == nil {
+			// purged in the meantime.
+			record, err = records.updateCache(ctx, hostname, allowAccessGrant, time.Time{}, clientIP)
+			if err != nil {
+				return Result{}, err
+			}
+		}
+	}
+
+	return records.result(hostname, record)
+}
+
+// SiteFile returns the value produced by load for the site file called name of
+// the site hosted at hostname. Successfully loaded values are cached alongside
+// hostname's TXT records; errors are not cached. If hostname has no cached
+// records, load is called every time.
+func (records *TXTRecords) SiteFile(ctx context.Context, hostname, name string, load func(context.Context) (interface{}, error)) (_ interface{}, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	val, ok := records.cache.Load(hostname)
+	if !ok {
+		return load(ctx)
+	}
+
+	v, _ := val.(*txtRecord).siteFiles.LoadOrStore(name, &siteFile{})
+	file := v.(*siteFile)
+
+	file.mu.Lock()
+	defer file.mu.Unlock()
+
+	if file.loaded {
+		return file.value, nil
+	}
+
+	value, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	file.value, file.loaded = value, true
+
+	return value, nil
+}
+
+// CachedSiteFile returns the value of the site file called name of the site
+// hosted at hostname if it has been loaded with SiteFile and is still cached.
+func (records *TXTRecords) CachedSiteFile(hostname, name string) (_ interface{}, ok bool) {
+	val, ok := records.cache.Load(hostname)
+	if !ok {
+		return nil, false
+	}
+	v, ok := val.(*txtRecord).siteFiles.Load(name)
+	if !ok {
+		return nil, false
+	}
+	file := v.(*siteFile)
+
+	file.mu.Lock()
+	defer file.mu.Unlock()
+
+	return file.value, file.loaded
 }
 
 // updateCache will attempt to fetch and update the dns record for the given
@@ -127,20 +300,288 @@ func (records *TXTRecords) updateCache(ctx context.Context, hostname string, all
 	if val, ok := records.cache.Load(hostname); ok {
 		record = val.(*txtRecord)
 		if currentExpiration.IsZero() || !record.expiration.Equal(currentExpiration) {
-			return record, nil
+			return record, record.negative
 		}
 	}
 
+	return records.replaceLocked(ctx, hostname, allowAccessGrant, clientIP)
+}
+
+// replaceLocked queries the records of hostname and replaces the cached ones,
+// or removes them if the query fails. The caller must hold hostname's lock.
+func (records *TXTRecords) replaceLocked(ctx context.Context, hostname string, allowAccessGrant bool, clientIP string) (record *txtRecord, err error) {
 	record, err = records.queryAccessFromDNS(ctx, hostname, allowAccessGrant, clientIP)
 	if err != nil {
-		records.cache.Delete(hostname)
-		return record, err
+		if record != nil && record.negative != nil {
+			records.store(hostname, record)
+		} else {
+			records.cache.Delete(hostname)
+		}
+		return nil, err
 	}
 
+	records.store(hostname, record)
+	return record, nil
+}
+
+// store caches record as hostname's records and keeps track of whether the
+// site is restricted. Hostnames whose records are removed without being
+// replaced stay restricted, as they might still be.
+func (records *TXTRecords) store(hostname string, record *txtRecord) {
 	records.cache.Store(hostname, record)
+
+	records.restrictedMu.Lock()
+	defer records.restrictedMu.Unlock()
+
+	if head, ok := records.restrictedBy[hostname]; ok {
+		delete(records.restricted[head], hostname)
+		if len(records.restricted[head]) == 0 {
+			delete(records.restricted, head)
+		}
+		delete(records.restrictedBy, hostname)
+	}
+
+	if record.negative != nil || !record.queryResult.restricted() {
+		return
+	}
+
+	head := string(record.macaroonHead)
+	if records.restricted[head] == nil {
+		records.restricted[head] = make(map[string]struct{})
+	}
+	records.restricted[head][hostname] = struct{}{}
+	records.restrictedBy[hostname] = head
+}
+
+// needsRevalidation reports whether record's Access Key ID is due to be
+// re-validated.
+func (records *TXTRecords) needsRevalidation(record *txtRecord) bool {
+	if records.revalidationInterval <= 0 || record.accessKeyID == "" {
+		return false
+	}
+	validated := time.Unix(0, atomic.LoadInt64(&record.validated))
+	return time.Since(validated) >= records.revalidationInterval
+}
+
+// revalidate checks with the auth service that the Access Key ID of record is
+// still valid. If it has been revoked, hostname's records are purged and an
+// error is returned. If the auth service can't be reached, record is assumed
+// to be still valid until the next revalidation. It returns the record to use,
+// which is nil if hostname's records were purged by someone else.
+func (records *TXTRecords) revalidate(ctx context.Context, hostname string, record *txtRecord, clientIP string) (_ *txtRecord, err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer records.updateLocks.Lock(hostname)()
+
+	// check if the call to us raced with another revalidate or updateCache.
+	val, ok := records.cache.Load(hostname)
+	if !ok {
+		return nil, nil
+	}
+	if current := val.(*txtRecord); current != record || !records.needsRevalidation(current) {
+		return current, nil
+	}
+
+	// the cached response would defeat the purpose.
+	records.forgetAccessKeyID(ctx, record.accessKeyID)
+
+	authResp, err := records.auth.ResolveWithCache(ctx, record.accessKeyID, clientIP)
+	if err != nil {
+		switch errdata.GetStatus(err, http.StatusInternalServerError) {
+		case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+			mon.Event("txt_record_access_revoked")
+			records.cache.Delete(hostname)
+			return nil, errs.New("failure with hostname %q: %w", hostname, err)
+		default:
+			mon.Event("txt_record_revalidation_failed")
+			atomic.StoreInt64(&record.validated, time.Now().UnixNano())
+			return record, nil
+		}
+	}
+
+	serializedAccess, err := records.open(hostname, record.access)
+	if err != nil {
+		return nil, err
+	}
+	secretKey, err := records.open(hostname, record.secretKey)
+	if err != nil {
+		return nil, err
+	}
+	if authResp.Public {
+		authResp.SecretKey = ""
+	}
+
+	if string(serializedAccess) == authResp.AccessGrant && string(secretKey) == authResp.SecretKey {
+		atomic.StoreInt64(&record.validated, time.Now().UnixNano())
+		return record, nil
+	}
+
+	// the Access Key ID now resolves to something else, so we have to start
+	// from scratch.
+	mon.Event("txt_record_access_changed")
+	return records.replaceLocked(ctx, hostname, record.allowAccessGrant, clientIP)
+}
+
+// forgetAccessKeyID removes accessKeyID from the auth service response cache.
+func (records *TXTRecords) forgetAccessKeyID(ctx context.Context, accessKeyID string) {
+	if accessKeyID != "" && records.auth.Cache != nil {
+		records.auth.Cache.Delete(ctx, accessKeyID)
+	}
+}
+
+// Purge removes the cached records of hostname. It reports whether there were
+// any.
+func (records *TXTRecords) Purge(ctx context.Context, hostname string) (purged bool) {
+	defer mon.Task()(&ctx)(nil)
+	defer records.updateLocks.Lock(hostname)()
+
+	val, ok := records.cache.LoadAndDelete(hostname)
+	if ok {
+		records.forgetAccessKeyID(ctx, val.(*txtRecord).accessKeyID)
+	}
+	return ok
+}
+
+// Refresh queries the records of hostname again and replaces the cached ones.
+// It reports whether there were any; hostnames that aren't cached are left
+// alone. If the query fails, hostname's records are removed.
+func (records *TXTRecords) Refresh(ctx context.Context, hostname string) (refreshed bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+	defer records.updateLocks.Lock(hostname)()
+
+	val, ok := records.cache.Load(hostname)
+	if !ok {
+		return false, nil
+	}
+	record := val.(*txtRecord)
+
+	records.forgetAccessKeyID(ctx, record.accessKeyID)
+
+	_, err = records.replaceLocked(ctx, hostname, record.allowAccessGrant, "")
+	return true, err
+}
+
+// HostnamesForMacaroonHead returns the cached hostnames whose access has the
+// given macaroon head.
+func (records *TXTRecords) HostnamesForMacaroonHead(head []byte) (hostnames []string) {
+	records.cache.Range(func(key, value interface{}) bool {
+		if bytes.Equal(value.(*txtRecord).macaroonHead, head) {
+			hostnames = append(hostnames, key.(string))
+		}
+		return true
+	})
+	return hostnames
+}
+
+// IsRestrictedMacaroonHead reports whether an access with the given macaroon
+// head is the access of a site with basic auth or IP restrictions.
+func (records *TXTRecords) IsRestrictedMacaroonHead(head []byte) bool {
+	records.restrictedMu.Lock()
+	defer records.restrictedMu.Unlock()
+
+	return len(records.restricted[string(head)]) > 0
+}
+
+// result returns the full Result of record, decrypting its access.
+func (records *TXTRecords) result(hostname string, record *txtRecord) (_ Result, err error) {
+	serializedAccess, err := records.open(hostname, record.access)
+	if err != nil {
+		return Result{}, err
+	}
+	secretKey, err := records.open(hostname, record.secretKey)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := record.queryResult
+	result.Access, err = uplink.ParseAccess(string(serializedAccess))
+	if err != nil {
+		return Result{}, txtRecordOpenError.Wrap(err)
+	}
+	result.secretKey = string(secretKey)
+
+	return result, nil
+}
+
+// key derives the key cached data of hostname is encrypted with, so entries
+// can't be decrypted as another hostname's.
+func (records *TXTRecords) key(hostname string) (*storj.Key, error) {
+	sum := sha256.Sum256(append(records.secret[:], hostname...))
+	return storj.NewKey(sum[:])
+}
+
+func (records *TXTRecords) seal(hostname string, data []byte) (sealed, error) {
+	key, err := records.key(hostname)
+	if err != nil {
+		return sealed{}, txtRecordSealError.Wrap(err)
+	}
+
+	// the same key is used every time hostname's records are refreshed, so
+	// nonces must be random.
+	var s sealed
+	if _, err = rand.Read(s.nonce[:]); err != nil {
+		return sealed{}, txtRecordSealError.Wrap(err)
+	}
+	s.data, err = encryption.Encrypt(data, storj.EncAESGCM, key, &s.nonce)
+	if err != nil {
+		return sealed{}, txtRecordSealError.Wrap(err)
+	}
+	return s, nil
+}
+
+func (records *TXTRecords) open(hostname string, s sealed) ([]byte, error) {
+	key, err := records.key(hostname)
+	if err != nil {
+		return nil, txtRecordOpenError.Wrap(err)
+	}
+	data, err := encryption.Decrypt(s.data, storj.EncAESGCM, key, &s.nonce)
+	if err != nil {
+		return nil, txtRecordOpenError.Wrap(err)
+	}
+	return data, nil
+}
+
+// newTXTRecord creates a cache entry for hostname holding result and the
+// resolved access.
+func (records *TXTRecords) newTXTRecord(hostname string, result Result, resolved resolvedAccess, accessKeyID string, allowAccessGrant bool, ttl time.Duration) (_ *txtRecord, err error) {
+	record := &txtRecord{
+		queryResult:      result,
+		accessKeyID:      accessKeyID,
+		macaroonHead:     privateAccess.APIKey(resolved.access).Head(),
+		allowAccessGrant: allowAccessGrant,
+		validated:        time.Now().UnixNano(),
+		expiration:       time.Now().Add(ttl),
+	}
+	if record.access, err = records.seal(hostname, []byte(resolved.serialized)); err != nil {
+		return nil, err
+	}
+	if record.secretKey, err = records.seal(hostname, []byte(resolved.secretKey)); err != nil {
+		return nil, err
+	}
 	return record, nil
 }
 
+// negativeRecord creates a cache entry remembering that hostname has no
+// linksharing TXT records according to r, and returns it along with the error
+// to return until it expires. The entry is nil if negative caching is
+// disabled.
+func (records *TXTRecords) negativeRecord(hostname string, r *dns.Msg, allowAccessGrant bool) (*txtRecord, error) {
+	negative := errdata.WithStatus(errs.New("no storj-access TXT record for hostname %q", hostname), http.StatusNotFound)
+
+	ttl, ok := negativeTTL(r)
+	if !ok || ttl > records.maxNegativeTTL {
+		ttl = records.maxNegativeTTL
+	}
+	if ttl <= 0 {
+		return nil, negative
+	}
+
+	return &txtRecord{
+		negative:         negative,
+		allowAccessGrant: allowAccessGrant,
+		expiration:       time.Now().Add(ttl),
+	}, negative
+}
+
 // queryAccessFromDNS does an txt record lookup for the hostname on the DNS
 // server. clientIP is the IP of the client that originated the request and it's
 // required to be sent to the Auth Service.
@@ -158,6 +599,9 @@ func (records *TXTRecords) queryAccessFromDNS(ctx context.Context, hostname stri
 		// backcompat
 		serializedAccess = set.Lookup("storj-grant")
 	}
+	if serializedAccess == "" {
+		return records.negativeRecord(hostname, r, allowAccessGrant)
+	}
 	if !allowAccessGrant && isProductionAccessGrant(serializedAccess) { // fail fast
 		return nil, errs.New("cannot use access grant with allowAccessGrant=false because of the risk of an untrusted satellite")
 	}
@@ -168,27 +612,41 @@ func (records *TXTRecords) queryAccessFromDNS(ctx context.Context, hostname stri
 	}
 	tls, _ := strconv.ParseBool(set.Lookup("storj-tls"))
 
-	// NOTE(artur): due to cache shared among all clients per hostname for
-	// hosting requests, signed requests cannot be served. One client with a
-	// valid signed request could update the cache for all other clients. One
-	// way to circumvent this would be to guess the signed request before and
-	// disable cache in that path. However, this requires major refactoring.
-	access, err := parseAccess(ctx, nil, serializedAccess, 0, records.auth, clientIP)
+	// restrictions that can't be parsed fail the lookup rather than being
+	// ignored, which would silently make the site public.
+	basicAuth, err := parseBasicAuthRecords(set.LookupAll("storj-basic-auth"))
+	if err != nil {
+		return nil, errs.New("failure with hostname %q: %w", hostname, err)
+	}
+	allowedNetworks, err := parseAllowCIDRRecords(set.LookupAll("storj-allow-cidr"))
+	if err != nil {
+		return nil, errs.New("failure with hostname %q: %w", hostname, err)
+	}
+
+	// NOTE(artur): the cache is shared among all clients per hostname for
+	// hosting requests, so only the resolved (but unverified) access is cached
+	// here. Whether a particular request may use it, e.g. because it's signed
+	// for non-public credentials, is checked with Result.VerifyRequest.
+	resolved, err := resolveAccess(ctx, serializedAccess, records.auth, clientIP)
 	if err != nil {
 		return nil, errs.New("failure with hostname %q: %w", hostname, err)
 	}
 
+	var accessKeyID string
+	if !isProductionAccessGrant(serializedAccess) {
+		accessKeyID = serializedAccess
+	}
+
 	ttl := set.TTL()
 	if ttl > records.maxTTL {
 		ttl = records.maxTTL
 	}
 
-	return &txtRecord{
-		queryResult: Result{
-			Access: access,
-			Root:   root,
-			TLS:    tls,
-		},
-		expiration: time.Now().Add(ttl),
-	}, nil
+	return records.newTXTRecord(hostname, Result{
+		Root:            root,
+		TLS:             tls,
+		BasicAuth:       basicAuth,
+		AllowedNetworks: allowedNetworks,
+		Analytics:       strings.Trim(set.Lookup("storj-analytics"), "/"),
+	}, resolved, accessKeyID, allowAccessGrant, ttl)
 }
@@ -0,0 +1,212 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package sharing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/gateway-mt/pkg/auth/authdb"
+	"storj.io/gateway-mt/pkg/authclient"
+	"storj.io/gateway-mt/pkg/errdata"
+	"storj.io/uplink"
+	privateAccess "storj.io/uplink/private/access"
+)
+
+const testAccessGrant = "13J4Upun87ATb3T5T5sDXVeQaCzWFZeF9Ly4ELfxS5hUwTL8APEkwahTEJ1wxZjyErimiDs3kgid33kDLuYPYtwaY7Toy32mCTapfrUB814X13RiA844HPWK3QLKZb9cAoVceTowmNZXWbcUMKNbkMHCURE4hn8ZrdHPE3S86yngjvDxwKmarfGx"
+
+// testAuthService is a fake auth service resolving every Access Key ID to
+// testAccessGrant, or failing with status if it's set. The credentials are
+// public unless secretKey is set.
+type testAuthService struct {
+	status    int32
+	calls     int32
+	secretKey string
+}
+
+func (auth *testAuthService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&auth.calls, 1)
+	if status := atomic.LoadInt32(&auth.status); status != 0 {
+		w.WriteHeader(int(status))
+		return
+	}
+	_ = json.NewEncoder(w).Encode(authclient.AuthServiceResponse{
+		AccessGrant: testAccessGrant,
+		SecretKey:   auth.secretKey,
+		Public:      auth.secretKey == "",
+	})
+}
+
+func newTestTXTRecords(t *testing.T, revalidationInterval time.Duration) (*TXTRecords, *testAuthService) {
+	auth := &testAuthService{}
+	server := httptest.NewServer(auth)
+	t.Cleanup(server.Close)
+
+	return NewTXTRecords(time.Hour, 0, revalidationInterval, nil, authclient.New(authclient.Config{
+		BaseURL: server.URL,
+		Token:   "token",
+		Timeout: 5 * time.Second,
+		Cache: authclient.AuthServiceCacheConfig{
+			Expiration: time.Hour,
+			Capacity:   10,
+		},
+	})), auth
+}
+
+// storeTestTXTRecord caches records of hostname as if they were queried. If
+// accessKeyID is empty, testAccessGrant is used directly.
+func storeTestTXTRecord(t *testing.T, records *TXTRecords, hostname, accessKeyID string) {
+	access, err := uplink.ParseAccess(testAccessGrant)
+	require.NoError(t, err)
+
+	record, err := records.newTXTRecord(hostname, Result{Root: "bucket"}, resolvedAccess{
+		access:     access,
+		serialized: testAccessGrant,
+	}, accessKeyID, true, time.Hour)
+	require.NoError(t, err)
+
+	records.cache.Store(hostname, record)
+}
+
+func TestTXTRecordsEncryption(t *testing.T) {
+	records, _ := newTestTXTRecords(t, 0)
+
+	storeTestTXTRecord(t, records, "site.test", "")
+
+	val, ok := records.cache.Load("site.test")
+	require.True(t, ok)
+	record := val.(*txtRecord)
+	assert.Nil(t, record.queryResult.Access)
+	assert.NotContains(t, string(record.access.data), testAccessGrant)
+
+	result, err := records.result("site.test", record)
+	require.NoError(t, err)
+	assert.Equal(t, "bucket", result.Root)
+	serialized, err := result.Access.Serialize()
+	require.NoError(t, err)
+	assert.Equal(t, testAccessGrant, serialized)
+
+	// entries are bound to their hostname.
+	_, err = records.result("other.test", record)
+	require.Error(t, err)
+}
+
+func TestTXTRecordsRevalidation(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	records, auth := newTestTXTRecords(t, time.Nanosecond)
+
+	key, err := authdb.NewEncryptionKey()
+	require.NoError(t, err)
+
+	storeTestTXTRecord(t, records, "site.test", key.ToBase32())
+
+	_, err = records.FetchAccessForHost(ctx, "site.test", "")
+	require.NoError(t, err)
+	_, err = records.FetchAccessForHost(ctx, "site.test", "")
+	require.NoError(t, err)
+	// the auth service response cache must be bypassed.
+	assert.EqualValues(t, 2, atomic.LoadInt32(&auth.calls))
+
+	// the auth service being unavailable doesn't take sites down.
+	atomic.StoreInt32(&auth.status, http.StatusServiceUnavailable)
+	_, err = records.FetchAccessForHost(ctx, "site.test", "")
+	require.NoError(t, err)
+
+	atomic.StoreInt32(&auth.status, http.StatusUnauthorized)
+	_, err = records.FetchAccessForHost(ctx, "site.test", "")
+	require.Error(t, err)
+	_, ok := records.cache.Load("site.test")
+	assert.False(t, ok)
+
+	// access grants can't be revoked this way, so they're never re-validated.
+	storeTestTXTRecord(t, records, "grant.test", "")
+	calls := atomic.LoadInt32(&auth.calls)
+	_, err = records.FetchAccessForHost(ctx, "grant.test", "")
+	require.NoError(t, err)
+	assert.Equal(t, calls, atomic.LoadInt32(&auth.calls))
+}
+
+func TestTXTRecordsPurge(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	records, _ := newTestTXTRecords(t, 0)
+
+	storeTestTXTRecord(t, records, "a.test", "")
+	storeTestTXTRecord(t, records, "b.test", "")
+
+	access, err := uplink.ParseAccess(testAccessGrant)
+	require.NoError(t, err)
+	head := privateAccess.APIKey(access).Head()
+
+	assert.ElementsMatch(t, []string{"a.test", "b.test"}, records.HostnamesForMacaroonHead(head))
+	assert.Empty(t, records.HostnamesForMacaroonHead([]byte("other")))
+
+	assert.True(t, records.Purge(ctx, "a.test"))
+	assert.False(t, records.Purge(ctx, "a.test"))
+	assert.Equal(t, []string{"b.test"}, records.HostnamesForMacaroonHead(head))
+
+	refreshed, err := records.Refresh(ctx, "a.test")
+	require.NoError(t, err)
+	assert.False(t, refreshed)
+}
+
+func TestTXTRecordsNegativeCaching(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	var queries int32
+	addr := startTestDNSServer(ctx, t, func(w dns.ResponseWriter, r *dns.Msg) {
+		atomic.AddInt32(&queries, 1)
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeNameError)
+		m.Ns = append(m.Ns, &dns.SOA{
+			Hdr:    dns.RR_Header{Name: "test.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+			Ns:     "ns.test.",
+			Mbox:   "admin.test.",
+			Minttl: 3600,
+		})
+		_ = w.WriteMsg(m)
+	})
+
+	dnsClient, err := NewDNSClient(addr)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		maxNegativeTTL time.Duration
+		queries        int32
+	}{
+		{maxNegativeTTL: time.Hour, queries: 1},
+		{maxNegativeTTL: 0, queries: 3},
+	} {
+		atomic.StoreInt32(&queries, 0)
+		records := NewTXTRecords(time.Hour, tc.maxNegativeTTL, 0, dnsClient, authclient.New(authclient.Config{}))
+
+		for i := 0; i < 3; i++ {
+			_, err := records.FetchAccessForHost(ctx, "missing.test", "")
+			require.Error(t, err)
+			assert.Equal(t, http.StatusNotFound, errdata.GetStatus(err, 0))
+		}
+		assert.Equal(t, tc.queries, atomic.LoadInt32(&queries))
+	}
+
+	// negative entries are bounded by maxNegativeTTL and re-queried once
+	// expired.
+	atomic.StoreInt32(&queries, 0)
+	records := NewTXTRecords(time.Hour, time.Nanosecond, 0, dnsClient, authclient.New(authclient.Config{}))
+	for i := 0; i < 2; i++ {
+		_, err := records.FetchAccessForHost(ctx, "missing.test", "")
+		require.Error(t, err)
+		time.Sleep(time.Millisecond)
+	}
+	assert.EqualValues(t, 2, atomic.LoadInt32(&queries))
+}
@@ -80,5 +80,11 @@ func (set *TXTRecordSet) Lookup(field string) (value string) {
 	return value
 }
 
+// LookupAll returns all values named by a given field in a TXT record set,
+// sorted. Unlike Lookup, it doesn't concatenate numbered fields.
+func (set *TXTRecordSet) LookupAll(field string) []string {
+	return set.vals[field]
+}
+
 // TTL returns the minimum TTL seen in the reecord set.
 func (set *TXTRecordSet) TTL() time.Duration { return set.minTTL }
@@ -0,0 +1,589 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/common/memory"
+	"storj.io/gateway-mt/pkg/authclient"
+	"storj.io/gateway-mt/pkg/server/middleware"
+	"storj.io/gateway-mt/pkg/trustedip"
+	"storj.io/minio/cmd"
+	xhttp "storj.io/minio/cmd/http"
+	"storj.io/minio/pkg/hash"
+)
+
+// maxAccessLogErrorBody is how much of error responses is kept to find their
+// error code.
+const maxAccessLogErrorBody = 1024
+
+// ServerAccessLogConfig configures how server access logs are delivered to the
+// target buckets of buckets with logging enabled.
+type ServerAccessLogConfig struct {
+	FlushInterval time.Duration `help:"how often buffered access log records are written to target buckets" default:"5m"`
+	MaxObjectSize memory.Size   `help:"size of the records buffered for a target bucket at which they're written without waiting for the flush interval" default:"4MiB"`
+	MaxBufferSize memory.Size   `help:"size of the records buffered for all target buckets over which records are dropped" default:"64MiB"`
+	WriteTimeout  time.Duration `help:"how long writing an access log object to a target bucket can take" default:"1m"`
+}
+
+// accessLogTarget is where, and with which credentials, records are written.
+type accessLogTarget struct {
+	owner     string
+	accessKey string
+	bucket    string
+	prefix    string
+}
+
+// AccessLogs collects S3 server access log records of requests to buckets
+// with logging enabled, and writes them in batches to their target buckets
+// with the credentials of the buckets' owner. Records are buffered up to a
+// bound, over which they're dropped rather than delaying requests.
+type AccessLogs struct {
+	log           *zap.Logger
+	config        ServerAccessLogConfig
+	bucketLogging *BucketLogging
+	layer         cmd.ObjectLayer
+	trustedIPs    trustedip.List
+	domainNames   []string
+	resolve       func(ctx context.Context, accessKey string) (authclient.AuthServiceResponse, error)
+	now           func() time.Time
+
+	// flushMu serializes flushes, so that batches of a target are written in
+	// order.
+	flushMu sync.Mutex
+
+	mu       sync.Mutex
+	buffers  map[accessLogTarget]*bytes.Buffer
+	buffered int
+	closed   bool
+
+	full      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewAccessLogs returns AccessLogs that look up the logging configuration of
+// buckets with bucketLogging and write records with layer, resolving the
+// credentials of their owners with authClient.
+func NewAccessLogs(log *zap.Logger, config ServerAccessLogConfig, bucketLogging *BucketLogging, layer cmd.ObjectLayer,
+	authClient *authclient.AuthClient, trustedIPs trustedip.List, domainNames []string) *AccessLogs {
+	return &AccessLogs{
+		log:           log,
+		config:        config,
+		bucketLogging: bucketLogging,
+		layer:         layer,
+		trustedIPs:    trustedIPs,
+		domainNames:   domainNames,
+		resolve: func(ctx context.Context, accessKey string) (authclient.AuthServiceResponse, error) {
+			return authClient.ResolveWithCache(ctx, accessKey, "")
+		},
+		now:     time.Now,
+		buffers: make(map[accessLogTarget]*bytes.Buffer),
+		full:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// Middleware records requests to buckets with logging enabled.
+func (a *AccessLogs) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket, key := requestBucketObject(r, a.domainNames)
+		if bucket == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// everything about the request is taken before handling it, as
+		// handlers rewrite it for the buckets DWS keeps objects in.
+		record := &accessLogRecord{
+			bucket:     bucket,
+			time:       a.now(),
+			remoteIP:   trustedip.GetClientIP(a.trustedIPs, r),
+			requester:  middleware.GetMacaroonHead(r),
+			operation:  accessLogOperation(r, key),
+			key:        s3utils.EncodePath(key),
+			requestURI: accessLogRequestURI(r),
+			objectSize: -1,
+			referer:    r.Referer(),
+			userAgent:  r.UserAgent(),
+			host:       r.Host,
+		}
+		record.signatureVersion, record.authType = accessLogAuth(r)
+		if r.TLS != nil {
+			record.cipherSuite = tls.CipherSuiteName(r.TLS.CipherSuite)
+			record.tlsVersion = accessLogTLSVersion(r.TLS.Version)
+		}
+		if r.Method == http.MethodPut || r.Method == http.MethodPost {
+			record.objectSize = r.ContentLength
+			if size, err := strconv.ParseInt(r.Header.Get(xhttp.AmzDecodedContentLength), 10, 64); err == nil {
+				record.objectSize = size
+			}
+		}
+
+		rw := &accessLogWriter{ResponseWriter: w, now: a.now}
+		next.ServeHTTP(rw, r)
+
+		// requests are recorded before they're rate limited, so configurations
+		// are only looked up for valid bucket names, of requests that were
+		// authenticated or allowed by a bucket policy, and at most the lookup
+		// limit of them at once.
+		if s3utils.CheckValidBucketName(bucket) != nil {
+			return
+		}
+		if credentials := middleware.GetAccess(r.Context()); (credentials == nil || credentials.Error != nil) && rw.statusCode() >= 300 {
+			return
+		}
+		config, err := a.bucketLogging.lookup(r.Context(), bucket)
+		if errs.Is(err, errLoggingLookupsExhausted) {
+			dropAccessLogRecords("lookups_exhausted", 1)
+			return
+		}
+		if err != nil {
+			mon.Event("access_log_config_failed")
+			a.log.Debug("unable to get bucket logging configuration", zap.String("bucket", bucket), zap.Error(err))
+			return
+		}
+		if config == nil {
+			return
+		}
+
+		end := a.now()
+		record.owner = config.Owner
+		record.requestID = rw.Header().Get(xhttp.AmzRequestID)
+		record.status = rw.statusCode()
+		record.errorCode = rw.errorCode()
+		record.bytesSent = rw.written
+		record.totalTime = end.Sub(record.time)
+		if !rw.firstByte.IsZero() {
+			record.turnAround = rw.firstByte.Sub(record.time)
+		}
+		if record.objectSize < 0 && record.status < 300 && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			record.objectSize = responseObjectSize(rw.Header())
+		}
+
+		a.add(accessLogTarget{
+			owner:     config.Owner,
+			accessKey: config.AccessKey,
+			bucket:    config.TargetBucket,
+			prefix:    config.TargetPrefix,
+		}, record.String())
+	})
+}
+
+// add buffers line to be written to target, dropping it if there's no room.
+func (a *AccessLogs) add(target accessLogTarget, line string) {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		dropAccessLogRecords("closed", 1)
+		return
+	}
+	if a.buffered+len(line) > a.config.MaxBufferSize.Int() {
+		a.mu.Unlock()
+		dropAccessLogRecords("buffer_full", 1)
+		return
+	}
+	buffer, ok := a.buffers[target]
+	if !ok {
+		buffer = new(bytes.Buffer)
+		a.buffers[target] = buffer
+	}
+	buffer.WriteString(line)
+	a.buffered += len(line)
+	full := buffer.Len() >= a.config.MaxObjectSize.Int()
+	a.mu.Unlock()
+
+	if full {
+		select {
+		case a.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run writes buffered records every flush interval, and as soon as enough
+// records for a target are buffered, until ctx is canceled or AccessLogs are
+// closed.
+func (a *AccessLogs) Run(ctx context.Context) error {
+	// without a flush interval, records are only written once enough of them
+	// are buffered, and on close.
+	var tick <-chan time.Time
+	if a.config.FlushInterval > 0 {
+		ticker := time.NewTicker(a.config.FlushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-a.done:
+			return nil
+		case <-tick:
+			a.flush(ctx, false)
+		case <-a.full:
+			a.flush(ctx, true)
+		}
+	}
+}
+
+// Close stops buffering records and writes the ones that are buffered.
+func (a *AccessLogs) Close() error {
+	a.mu.Lock()
+	a.closed = true
+	a.mu.Unlock()
+	a.closeOnce.Do(func() { close(a.done) })
+
+	a.flush(context.Background(), false)
+	return nil
+}
+
+// flush writes the buffered records of all targets, or only the ones with
+// enough of them if onlyFull is set.
+func (a *AccessLogs) flush(ctx context.Context, onlyFull bool) {
+	a.flushMu.Lock()
+	defer a.flushMu.Unlock()
+
+	batches := make(map[accessLogTarget]*bytes.Buffer)
+	a.mu.Lock()
+	for target, buffer := range a.buffers {
+		if onlyFull && buffer.Len() < a.config.MaxObjectSize.Int() {
+			continue
+		}
+		batches[target] = buffer
+		delete(a.buffers, target)
+	}
+	a.mu.Unlock()
+
+	for target, buffer := range batches {
+		if err := a.write(ctx, target, buffer.Bytes()); err != nil {
+			dropAccessLogRecords("write_failed", bytes.Count(buffer.Bytes(), []byte{'\n'}))
+			a.log.Warn("unable to write access logs", zap.String("bucket", target.bucket), zap.Error(err))
+		}
+
+		// the records count towards the bound until they're written, so that
+		// slow writes don't let memory grow.
+		a.mu.Lock()
+		a.buffered -= buffer.Len()
+		a.mu.Unlock()
+	}
+}
+
+// write writes data to a new object in the target bucket.
+func (a *AccessLogs) write(ctx context.Context, target accessLogTarget, data []byte) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, a.config.WriteTimeout)
+	defer cancel()
+
+	credentials, err := a.resolve(ctx, target.accessKey)
+	if err != nil {
+		return err
+	}
+	ctx = middleware.WithCredentials(ctx, &middleware.Credentials{
+		AccessKey:           target.accessKey,
+		AuthServiceResponse: credentials,
+	})
+
+	key, err := accessLogObjectKey(target.prefix, a.now())
+	if err != nil {
+		return err
+	}
+	reader, err := hash.NewReader(bytes.NewReader(data), int64(len(data)), "", "", int64(len(data)))
+	if err != nil {
+		return err
+	}
+	_, err = a.layer.PutObject(ctx, target.owner, target.bucket+Sep+key, cmd.NewPutObjReader(reader), cmd.ObjectOptions{})
+	return err
+}
+
+func dropAccessLogRecords(reason string, count int) {
+	mon.Counter("access_log_records_dropped", monkit.NewSeriesTag("reason", reason)).Inc(int64(count))
+}
+
+// accessLogObjectKey returns the key of a new log object, named the way S3
+// names them: TargetPrefixYYYY-mm-DD-HH-MM-SS-UniqueString.
+func accessLogObjectKey(prefix string, now time.Time) (string, error) {
+	var unique [8]byte
+	if _, err := rand.Read(unique[:]); err != nil {
+		return "", err
+	}
+	return prefix + now.UTC().Format("2006-01-02-15-04-05") + "-" + strings.ToUpper(hex.EncodeToString(unique[:])), nil
+}
+
+// accessLogRecord is a record in the S3 server access log format.
+//
+// See https://docs.aws.amazon.com/AmazonS3/latest/userguide/LogFormat.html.
+type accessLogRecord struct {
+	owner            string
+	bucket           string
+	time             time.Time
+	remoteIP         string
+	requester        string
+	requestID        string
+	operation        string
+	key              string
+	requestURI       string
+	status           int
+	errorCode        string
+	bytesSent        int64
+	objectSize       int64
+	totalTime        time.Duration
+	turnAround       time.Duration
+	referer          string
+	userAgent        string
+	signatureVersion string
+	cipherSuite      string
+	authType         string
+	host             string
+	tlsVersion       string
+}
+
+// String returns the record as a line of the log.
+func (r *accessLogRecord) String() string {
+	var b strings.Builder
+	field := func(s string) {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		if s == "" {
+			s = "-"
+		}
+		b.WriteString(s)
+	}
+	quoted := func(s string) {
+		if s == "" {
+			s = "-"
+		}
+		field(`"` + accessLogEscaper.Replace(s) + `"`)
+	}
+	size := func(n int64) {
+		if n <= 0 {
+			field("-")
+			return
+		}
+		field(strconv.FormatInt(n, 10))
+	}
+
+	field(r.owner)
+	field(r.bucket)
+	field(r.time.UTC().Format("[02/Jan/2006:15:04:05 -0700]"))
+	field(r.remoteIP)
+	field(r.requester)
+	field(r.requestID)
+	field(r.operation)
+	field(r.key)
+	quoted(r.requestURI)
+	field(strconv.Itoa(r.status))
+	field(r.errorCode)
+	size(r.bytesSent)
+	size(r.objectSize)
+	field(strconv.FormatInt(r.totalTime.Milliseconds(), 10))
+	if r.turnAround > 0 {
+		field(strconv.FormatInt(r.turnAround.Milliseconds(), 10))
+	} else {
+		field("-")
+	}
+	quoted(r.referer)
+	quoted(r.userAgent)
+	field("") // version ID
+	field("") // host ID
+	field(r.signatureVersion)
+	field(r.cipherSuite)
+	field(r.authType)
+	field(r.host)
+	field(r.tlsVersion)
+	field("") // access point ARN
+	field("") // ACL required
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// accessLogEscaper keeps quoted fields, which come from clients, from
+// spanning fields or lines.
+var accessLogEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+
+// accessLogSubresources are the bucket subresources named by the operations
+// of the requests for them, like REST.GET.CORS.
+var accessLogSubresources = []string{"acl", "cors", "lifecycle", "location", "logging", "notification",
+	"object-lock", "policy", "replication", "requestPayment", "tagging", "uploads", "versioning", "versions", "website"}
+
+// accessLogOperation returns the operation of r in the form S3 logs it, like
+// REST.GET.OBJECT or REST.PUT.PART.
+func accessLogOperation(r *http.Request, key string) string {
+	query := r.URL.Query()
+	method := r.Method
+	if method == http.MethodPut && r.Header.Get(xhttp.AmzCopySource) != "" {
+		method = "COPY"
+	}
+
+	var resource string
+	switch {
+	case key != "" && query.Has("uploadId") && query.Has("partNumber"):
+		resource = "PART"
+	case key != "" && query.Has("uploadId"):
+		resource = "UPLOAD"
+	case key != "" && query.Has("uploads"):
+		resource = "UPLOADS"
+	case key != "" && query.Has("tagging"):
+		resource = "OBJECT_TAGGING"
+	case key != "" && query.Has("acl"):
+		resource = "ACL"
+	case key != "":
+		resource = "OBJECT"
+	default:
+		resource = "BUCKET"
+		for _, subresource := range accessLogSubresources {
+			if query.Has(subresource) {
+				resource = strings.ToUpper(subresource)
+				break
+			}
+		}
+	}
+	return "REST." + method + "." + resource
+}
+
+// accessLogRequestURI returns the request line of r, without the credentials
+// and signatures of presigned requests.
+func accessLogRequestURI(r *http.Request) string {
+	uri := r.URL.EscapedPath()
+	if r.URL.RawQuery != "" {
+		query := r.URL.Query()
+		for _, k := range []string{xhttp.AmzAccessKeyID, xhttp.AmzSignatureV2, xhttp.AmzSignature, xhttp.AmzCredential} {
+			if query.Has(k) {
+				query.Set(k, "[...]")
+			}
+		}
+		uri += "?" + query.Encode()
+	}
+	return r.Method + " " + uri + " " + r.Proto
+}
+
+// accessLogAuth returns the signature version and authentication type of r.
+func accessLogAuth(r *http.Request) (signatureVersion, authType string) {
+	auth := r.Header.Get(xhttp.Authorization)
+	query := r.URL.Query()
+	switch {
+	case strings.HasPrefix(auth, "AWS4-HMAC-SHA256"):
+		return "SigV4", "AuthHeader"
+	case strings.HasPrefix(auth, "AWS "):
+		return "SigV2", "AuthHeader"
+	case query.Has(xhttp.AmzCredential):
+		return "SigV4", "QueryString"
+	case query.Has(xhttp.AmzAccessKeyID):
+		return "SigV2", "QueryString"
+	}
+	return "", ""
+}
+
+func accessLogTLSVersion(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLSv1"
+	case tls.VersionTLS11:
+		return "TLSv1.1"
+	case tls.VersionTLS12:
+		return "TLSv1.2"
+	case tls.VersionTLS13:
+		return "TLSv1.3"
+	}
+	return ""
+}
+
+// responseObjectSize returns the size of the object a response is for, which
+// Content-Range has for partial responses.
+func responseObjectSize(header http.Header) int64 {
+	if contentRange := header.Get(xhttp.ContentRange); contentRange != "" {
+		if i := strings.LastIndexByte(contentRange, '/'); i >= 0 {
+			if size, err := strconv.ParseInt(contentRange[i+1:], 10, 64); err == nil {
+				return size
+			}
+		}
+	}
+	if size, err := strconv.ParseInt(header.Get(xhttp.ContentLength), 10, 64); err == nil {
+		return size
+	}
+	return -1
+}
+
+// accessLogWriter records what access logs need to know about responses.
+type accessLogWriter struct {
+	http.ResponseWriter
+	now func() time.Time
+
+	status    int
+	written   int64
+	firstByte time.Time
+	errorBody []byte
+}
+
+func (w *accessLogWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+		w.firstByte = w.now()
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *accessLogWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.status >= 300 && len(w.errorBody) < maxAccessLogErrorBody {
+		n := maxAccessLogErrorBody - len(w.errorBody)
+		if n > len(p) {
+			n = len(p)
+		}
+		w.errorBody = append(w.errorBody, p[:n]...)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *accessLogWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *accessLogWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// errorCode returns the S3 error code of error responses.
+func (w *accessLogWriter) errorCode() string {
+	if w.status < 300 {
+		return ""
+	}
+	_, code, ok := strings.Cut(string(w.errorBody), "<Code>")
+	if !ok {
+		return ""
+	}
+	code, _, ok = strings.Cut(code, "</Code>")
+	if !ok {
+		return ""
+	}
+	return url.PathEscape(code)
+}
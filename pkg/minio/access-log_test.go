@@ -0,0 +1,260 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/common/memory"
+	"storj.io/common/testcontext"
+	"storj.io/gateway-mt/pkg/authclient"
+	"storj.io/gateway-mt/pkg/server/middleware"
+	"storj.io/gateway-mt/pkg/trustedip"
+	"storj.io/minio/cmd"
+	xhttp "storj.io/minio/cmd/http"
+)
+
+func TestAccessLogRecord(t *testing.T) {
+	record := accessLogRecord{
+		owner:            "user",
+		bucket:           "photos",
+		time:             time.Date(2023, 10, 5, 14, 3, 2, 0, time.FixedZone("", 3600)),
+		remoteIP:         "192.0.2.3",
+		requester:        "0123abcd",
+		requestID:        "178B3AC59B5D1C00",
+		operation:        "REST.GET.OBJECT",
+		key:              "2023/puppy%20one.jpg",
+		requestURI:       "GET /photos/2023/puppy%20one.jpg HTTP/1.1",
+		status:           http.StatusOK,
+		bytesSent:        2662992,
+		objectSize:       3462992,
+		totalTime:        70 * time.Millisecond,
+		turnAround:       10 * time.Millisecond,
+		userAgent:        `curl/8.0 "quoted"` + "\n",
+		signatureVersion: "SigV4",
+		cipherSuite:      "TLS_AES_128_GCM_SHA256",
+		authType:         "AuthHeader",
+		host:             "photos.gateway.test",
+		tlsVersion:       "TLSv1.3",
+	}
+	assert.Equal(t, `user photos [05/Oct/2023:13:03:02 +0000] 192.0.2.3 0123abcd 178B3AC59B5D1C00 REST.GET.OBJECT 2023/puppy%20one.jpg `+
+		`"GET /photos/2023/puppy%20one.jpg HTTP/1.1" 200 - 2662992 3462992 70 10 "-" "curl/8.0 \"quoted\"\n" - - `+
+		`SigV4 TLS_AES_128_GCM_SHA256 AuthHeader photos.gateway.test TLSv1.3 - -`+"\n", record.String())
+
+	record = accessLogRecord{owner: "user", bucket: "photos", status: http.StatusNotFound, errorCode: "NoSuchKey", objectSize: -1}
+	assert.Equal(t, `user photos [01/Jan/0001:00:00:00 +0000] - - - - - "-" 404 NoSuchKey - - 0 - "-" "-" - - - - - - - - -`+"\n", record.String())
+}
+
+func TestAccessLogOperation(t *testing.T) {
+	operation := func(method, target string, header http.Header) string {
+		r := httptest.NewRequest(method, target, nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		_, key := requestBucketObject(r, nil)
+		return accessLogOperation(r, key)
+	}
+
+	assert.Equal(t, "REST.GET.BUCKET", operation(http.MethodGet, "/photos?list-type=2", nil))
+	assert.Equal(t, "REST.PUT.CORS", operation(http.MethodPut, "/photos?cors", nil))
+	assert.Equal(t, "REST.GET.LOGGING", operation(http.MethodGet, "/photos?logging", nil))
+	assert.Equal(t, "REST.GET.UPLOADS", operation(http.MethodGet, "/photos?uploads", nil))
+	assert.Equal(t, "REST.GET.OBJECT", operation(http.MethodGet, "/photos/a/b", nil))
+	assert.Equal(t, "REST.HEAD.OBJECT", operation(http.MethodHead, "/photos/a", nil))
+	assert.Equal(t, "REST.COPY.OBJECT", operation(http.MethodPut, "/photos/a", http.Header{xhttp.AmzCopySource: {"/photos/b"}}))
+	assert.Equal(t, "REST.POST.UPLOADS", operation(http.MethodPost, "/photos/a?uploads", nil))
+	assert.Equal(t, "REST.PUT.PART", operation(http.MethodPut, "/photos/a?partNumber=1&uploadId=x", nil))
+	assert.Equal(t, "REST.POST.UPLOAD", operation(http.MethodPost, "/photos/a?uploadId=x", nil))
+	assert.Equal(t, "REST.PUT.OBJECT_TAGGING", operation(http.MethodPut, "/photos/a?tagging", nil))
+}
+
+func TestAccessLogRequestURI(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/photos/a%20b?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=secret&X-Amz-Signature=secret", nil)
+	assert.Equal(t, "GET /photos/a%20b?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=%5B...%5D&X-Amz-Signature=%5B...%5D HTTP/1.1", accessLogRequestURI(r))
+
+	signatureVersion, authType := accessLogAuth(r)
+	assert.Equal(t, "SigV4", signatureVersion)
+	assert.Equal(t, "QueryString", authType)
+}
+
+// testAccessLogLayer is an object layer that keeps the objects put into it,
+// with the access grants they were put with.
+type testAccessLogLayer struct {
+	cmd.ObjectLayer
+
+	mu      sync.Mutex
+	objects map[string]string
+	grants  map[string]string
+	fail    bool
+}
+
+func (l *testAccessLogLayer) PutObject(ctx context.Context, bucket, object string, data *cmd.PutObjReader, opts cmd.ObjectOptions) (cmd.ObjectInfo, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.fail {
+		return cmd.ObjectInfo{}, errors.New("put failed")
+	}
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return cmd.ObjectInfo{}, err
+	}
+	l.objects[bucket+"/"+object] = string(content)
+	l.grants[bucket+"/"+object] = middleware.GetAccess(ctx).AccessGrant
+	return cmd.ObjectInfo{Bucket: bucket, Name: object}, nil
+}
+
+func (l *testAccessLogLayer) lines() (lines []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, content := range l.objects {
+		lines = append(lines, strings.Split(strings.TrimSuffix(content, "\n"), "\n")...)
+	}
+	return lines
+}
+
+func newTestAccessLogs(t *testing.T, config ServerAccessLogConfig) (*AccessLogs, *testAccessLogLayer, http.Handler) {
+	ctx := testcontext.New(t)
+
+	node := &testBucketConfigNode{configs: map[string]string{}}
+	server := httptest.NewServer(node)
+	t.Cleanup(server.Close)
+
+	bucketLogging := NewBucketLogging(zaptest.NewLogger(t), DwsConfig{
+		DwsBackendHost:         server.URL,
+		DwsNodeToken:           "token",
+		LoggingCacheExpiration: time.Hour,
+		LoggingCacheCapacity:   10,
+		LoggingLookupLimit:     1,
+	})
+	require.NoError(t, bucketLogging.put(ctx, "photos", &bucketLoggingConfig{
+		TargetBucket: "logs",
+		TargetPrefix: "photos/",
+		AccessKey:    "owner-access-key",
+		Owner:        "user",
+	}))
+
+	layer := &testAccessLogLayer{objects: map[string]string{}, grants: map[string]string{}}
+	accessLogs := NewAccessLogs(zaptest.NewLogger(t), config, bucketLogging, layer, nil, trustedip.NewListTrustAll(), []string{"gateway.test"})
+	accessLogs.resolve = func(ctx context.Context, accessKey string) (authclient.AuthServiceResponse, error) {
+		return authclient.AuthServiceResponse{AccessGrant: "grant of " + accessKey}, nil
+	}
+
+	handler := accessLogs.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(xhttp.AmzRequestID, "REQUEST")
+		if strings.HasSuffix(r.URL.Path, "/missing") {
+			cmd.WriteErrorResponse(r.Context(), w, cmd.GetAPIError(cmd.ErrNoSuchKey), r.URL, false)
+			return
+		}
+		_, _ = io.WriteString(w, "hello")
+	}))
+	return accessLogs, layer, handler
+}
+
+func TestAccessLogs(t *testing.T) {
+	accessLogs, layer, handler := newTestAccessLogs(t, ServerAccessLogConfig{
+		FlushInterval: time.Hour,
+		MaxObjectSize: memory.MiB,
+		MaxBufferSize: memory.MiB,
+		WriteTimeout:  time.Minute,
+	})
+
+	withCredentials := middleware.WithCredentials(context.Background(), &middleware.Credentials{AccessKey: "accesskey"})
+	serveWith := func(ctx context.Context, host, target string) {
+		r := httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+		r.Host = host
+		r.TLS = &tls.ConnectionState{Version: tls.VersionTLS12, CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	serve := func(host, target string) {
+		serveWith(withCredentials, host, target)
+	}
+	serve("gateway.test", "/photos/a/b")
+	serve("photos.gateway.test", "/missing")
+	serve("gateway.test", "/other/a")
+	serve("gateway.test", "/")
+
+	// failed anonymous requests aren't recorded, as anyone can make them.
+	serveWith(context.Background(), "gateway.test", "/photos/missing")
+
+	// records are buffered until they're flushed.
+	assert.Empty(t, layer.lines())
+	require.NoError(t, accessLogs.Close())
+
+	require.Len(t, layer.objects, 1)
+	for name, content := range layer.objects {
+		assert.Regexp(t, regexp.MustCompile(`^user/logs/photos/\d{4}-\d{2}-\d{2}-\d{2}-\d{2}-\d{2}-[0-9A-F]{16}$`), name)
+		assert.Equal(t, "grant of owner-access-key", layer.grants[name])
+		assert.True(t, strings.HasSuffix(content, "\n"))
+	}
+
+	lines := layer.lines()
+	require.Len(t, lines, 2)
+	assert.Regexp(t, `^user photos \[.*\] 192\.0\.2\.1 - REQUEST REST\.GET\.OBJECT a/b "GET /photos/a/b HTTP/1\.1" 200 - 5 - \d+ \d+ "-" "-" - - - `+
+		`TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 - gateway\.test TLSv1\.2 - -$`, lines[0])
+	assert.Regexp(t, `^user photos \[.*\] 192\.0\.2\.1 - REQUEST REST\.GET\.OBJECT missing "GET /missing HTTP/1\.1" 404 NoSuchKey \d+ - `, lines[1])
+
+	// records made after closing are dropped.
+	serve("gateway.test", "/photos/a/b")
+	assert.Len(t, layer.lines(), 2)
+}
+
+func TestAccessLogsBatching(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	accessLogs, layer, handler := newTestAccessLogs(t, ServerAccessLogConfig{
+		FlushInterval: time.Hour,
+		MaxObjectSize: 1000,
+		MaxBufferSize: 2000,
+		WriteTimeout:  time.Minute,
+	})
+	serve := func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/photos/a", nil))
+	}
+
+	// records over the bound are dropped.
+	for i := 0; i < 20; i++ {
+		serve()
+	}
+	accessLogs.mu.Lock()
+	buffered := accessLogs.buffered
+	accessLogs.mu.Unlock()
+	assert.LessOrEqual(t, buffered, 2000)
+	assert.Greater(t, buffered, 1000)
+
+	// a full buffer is written without waiting for the flush interval.
+	runCtx, cancel := context.WithCancel(ctx)
+	ctx.Go(func() error { return accessLogs.Run(runCtx) })
+	require.Eventually(t, func() bool { return len(layer.lines()) > 0 }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+
+	accessLogs.mu.Lock()
+	assert.Zero(t, accessLogs.buffered)
+	accessLogs.mu.Unlock()
+
+	// records that can't be written are dropped.
+	layer.mu.Lock()
+	layer.fail = true
+	layer.mu.Unlock()
+	serve()
+	require.NoError(t, accessLogs.Close())
+	accessLogs.mu.Lock()
+	assert.Zero(t, accessLogs.buffered)
+	assert.Empty(t, accessLogs.buffers)
+	accessLogs.mu.Unlock()
+}
@@ -4,15 +4,17 @@
 package minio
 
 import (
-	"fmt"
+	"errors"
+	"io"
 	"net/http"
 	"path"
-	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 	"storj.io/gateway-mt/pkg/authclient"
 	dwsProto "storj.io/gateway-mt/pkg/minio/dws/proto"
+	"storj.io/gateway-mt/pkg/server/middleware"
 	"storj.io/gateway-mt/pkg/trustedip"
 	"storj.io/minio/cmd"
 	xhttp "storj.io/minio/cmd/http"
@@ -30,21 +32,38 @@ type objectAPIHandlersWrapper struct {
 	trustedIPs         trustedip.List
 	logger             *zap.SugaredLogger
 	nodeToken          string
+	bucketCORS         *BucketCORS
+	bucketLogging      *BucketLogging
+	bucketNotification *BucketNotification
+	bucketLifecycle    *BucketLifecycle
+	bucketPolicy       *BucketPolicy
+	multipartChecksums *multipartChecksums
 }
 
 // HeadObjectHandler stands for HeadObject
 func (h objectAPIHandlersWrapper) HeadObjectHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "HeadObject", policyGet, objectKey(r))
+	if err != nil {
+		return
+	}
 	if err := h.objectPrefixSubstitution(w, r, "HeadObject"); err != nil {
 		return
 	}
-	h.core.HeadObjectHandler(w, r)
+	h.core.HeadObjectHandler(w, withChecksumMode(r))
 }
 
 func (h objectAPIHandlersWrapper) CopyObjectPartHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "CopyObjectPart", policyPut, objectKey(r))
+	if err != nil {
+		return
+	}
+	if err := h.checkCopySourcePolicy(w, r, "CopyObjectPart"); err != nil {
+		return
+	}
 	h.core.CopyObjectPartHandler(w, r)
 }
 
@@ -52,9 +71,21 @@ func (h objectAPIHandlersWrapper) CopyObjectPartHandler(w http.ResponseWriter, r
 func (h objectAPIHandlersWrapper) PutObjectPartHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "PutObjectPart", policyPut, objectKey(r))
+	if err != nil {
+		return
+	}
+	// aws-chunked requests have to be decoded before they're authenticated.
+	w, r, err = h.withRequestChecksum(w, r, "PutObjectPart")
+	if err != nil {
+		return
+	}
 	if err := h.objectPrefixSubstitution(w, r, "PutObjectPart"); err != nil {
 		return
 	}
+	if err := h.withPartChecksum(w, r, "PutObjectPart"); err != nil {
+		return
+	}
 	h.core.PutObjectPartHandler(w, r)
 }
 
@@ -62,6 +93,10 @@ func (h objectAPIHandlersWrapper) PutObjectPartHandler(w http.ResponseWriter, r
 func (h objectAPIHandlersWrapper) ListObjectPartsHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "ListObjectParts", policyList, objectKey(r))
+	if err != nil {
+		return
+	}
 	if err := h.objectPrefixSubstitution(w, r, "ListObjectParts"); err != nil {
 		return
 	}
@@ -72,19 +107,44 @@ func (h objectAPIHandlersWrapper) ListObjectPartsHandler(w http.ResponseWriter,
 func (h objectAPIHandlersWrapper) CompleteMultipartUploadHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "CompleteMultipartUpload", policyPut, objectKey(r))
+	if err != nil {
+		return
+	}
 	if err := h.objectPrefixSubstitution(w, r, "CompleteMultipartUpload"); err != nil {
 		return
 	}
+	r, partNumbers, err := h.withCompositeChecksum(w, r, "CompleteMultipartUpload")
+	if err != nil {
+		return
+	}
 	h.core.CompleteMultipartUploadHandler(w, r)
+	h.deletePartChecksums(ctx, r, partNumbers)
 }
 
 // NewMultipartUploadHandler stands for CreateMultipartUpload
 func (h objectAPIHandlersWrapper) NewMultipartUploadHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	vars := mux.Vars(r)
+	bucket, object := vars[VarKeyBucket], vars[VarKeyObject]
+	r, err := h.withBucketPolicy(w, r, "NewMultipartUpload", policyPut, objectKey(r))
+	if err != nil {
+		return
+	}
 	if err := h.objectPrefixSubstitution(w, r, "NewMultipartUpload"); err != nil {
 		return
 	}
+	r, err = h.withLifecycleExpiration(w, r, "NewMultipartUpload", bucket, object, func() (string, error) {
+		return r.Header.Get(xhttp.AmzObjectTagging), nil
+	})
+	if err != nil {
+		return
+	}
+	w, r, err = h.withChecksumAlgorithm(w, r, "NewMultipartUpload")
+	if err != nil {
+		return
+	}
 	h.core.NewMultipartUploadHandler(w, r)
 }
 
@@ -92,57 +152,99 @@ func (h objectAPIHandlersWrapper) NewMultipartUploadHandler(w http.ResponseWrite
 func (h objectAPIHandlersWrapper) AbortMultipartUploadHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "AbortMultipartUpload", policyPut, objectKey(r))
+	if err != nil {
+		return
+	}
 	if err := h.objectPrefixSubstitution(w, r, "AbortMultipartUpload"); err != nil {
 		return
 	}
+	// the checksums of the parts are deleted with them.
+	partNumbers, err := h.uploadedParts(ctx, r)
+	if err != nil {
+		h.logger.Debugf("failed to list parts of aborted upload: %v", err)
+	}
 	h.core.AbortMultipartUploadHandler(w, r)
+	h.deletePartChecksums(ctx, r, partNumbers)
 }
 
 func (h objectAPIHandlersWrapper) GetObjectACLHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "GetObjectACL", policyGet, objectKey(r))
+	if err != nil {
+		return
+	}
 	h.core.GetObjectACLHandler(w, r)
 }
 
 func (h objectAPIHandlersWrapper) PutObjectACLHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "PutObjectACL", policyPut, objectKey(r))
+	if err != nil {
+		return
+	}
 	h.core.PutObjectACLHandler(w, r)
 }
 
 func (h objectAPIHandlersWrapper) GetObjectTaggingHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "GetObjectTagging", policyGet, objectKey(r))
+	if err != nil {
+		return
+	}
 	h.core.GetObjectTaggingHandler(w, r)
 }
 
 func (h objectAPIHandlersWrapper) PutObjectTaggingHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "PutObjectTagging", policyPut, objectKey(r))
+	if err != nil {
+		return
+	}
 	h.core.PutObjectTaggingHandler(w, r)
 }
 
 func (h objectAPIHandlersWrapper) DeleteObjectTaggingHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "DeleteObjectTagging", policyPut, objectKey(r))
+	if err != nil {
+		return
+	}
 	h.core.DeleteObjectTaggingHandler(w, r)
 }
 
 func (h objectAPIHandlersWrapper) SelectObjectContentHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "SelectObjectContent", policyGet, objectKey(r))
+	if err != nil {
+		return
+	}
 	h.core.SelectObjectContentHandler(w, r)
 }
 
 func (h objectAPIHandlersWrapper) GetObjectRetentionHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "GetObjectRetention", policyGet, objectKey(r))
+	if err != nil {
+		return
+	}
 	h.core.GetObjectRetentionHandler(w, r)
 }
 
 func (h objectAPIHandlersWrapper) GetObjectLegalHoldHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "GetObjectLegalHold", policyGet, objectKey(r))
+	if err != nil {
+		return
+	}
 	h.core.GetObjectLegalHoldHandler(w, r)
 }
 
@@ -150,19 +252,38 @@ func (h objectAPIHandlersWrapper) GetObjectLegalHoldHandler(w http.ResponseWrite
 func (h objectAPIHandlersWrapper) GetObjectHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "GetObject", policyGet, objectKey(r))
+	if err != nil {
+		return
+	}
 	if err := h.objectPrefixSubstitution(w, r, "GetObject"); err != nil {
 		return
 	}
-	h.core.GetObjectHandler(w, r)
+	h.core.GetObjectHandler(w, withChecksumMode(r))
 }
 
 // CopyObjectHandler stands for CopyObject
 func (h objectAPIHandlersWrapper) CopyObjectHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	vars := mux.Vars(r)
+	bucket, object := vars[VarKeyBucket], vars[VarKeyObject]
+	r, err := h.withBucketPolicy(w, r, "CopyObject", policyPut, objectKey(r))
+	if err != nil {
+		return
+	}
+	if err := h.checkCopySourcePolicy(w, r, "CopyObject"); err != nil {
+		return
+	}
 	if err := h.objectPrefixSubstitution(w, r, "CopyObject"); err != nil {
 		return
 	}
+	r, err = h.withLifecycleExpiration(w, r, "CopyObject", bucket, object, func() (string, error) {
+		return h.copySourceTagging(r)
+	})
+	if err != nil {
+		return
+	}
 	r.Header.Set(xhttp.AmzCopySource, path.Join(mux.Vars(r)[VarKeyBucket], r.Header.Get(xhttp.AmzCopySource)))
 
 	h.core.CopyObjectHandler(w, r)
@@ -171,12 +292,20 @@ func (h objectAPIHandlersWrapper) CopyObjectHandler(w http.ResponseWriter, r *ht
 func (h objectAPIHandlersWrapper) PutObjectRetentionHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "PutObjectRetention", policyPut, objectKey(r))
+	if err != nil {
+		return
+	}
 	h.core.PutObjectRetentionHandler(w, r)
 }
 
 func (h objectAPIHandlersWrapper) PutObjectLegalHoldHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "PutObjectLegalHold", policyPut, objectKey(r))
+	if err != nil {
+		return
+	}
 	h.core.PutObjectLegalHoldHandler(w, r)
 }
 
@@ -184,9 +313,26 @@ func (h objectAPIHandlersWrapper) PutObjectLegalHoldHandler(w http.ResponseWrite
 func (h objectAPIHandlersWrapper) PutObjectHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	vars := mux.Vars(r)
+	bucket, object := vars[VarKeyBucket], vars[VarKeyObject]
+	r, err := h.withBucketPolicy(w, r, "PutObject", policyPut, objectKey(r))
+	if err != nil {
+		return
+	}
+	// aws-chunked requests have to be decoded before they're authenticated.
+	w, r, err = h.withRequestChecksum(w, r, "PutObject")
+	if err != nil {
+		return
+	}
 	if err := h.objectPrefixSubstitution(w, r, "PutObject"); err != nil {
 		return
 	}
+	r, err = h.withLifecycleExpiration(w, r, "PutObject", bucket, object, func() (string, error) {
+		return r.Header.Get(xhttp.AmzObjectTagging), nil
+	})
+	if err != nil {
+		return
+	}
 	h.core.PutObjectHandler(w, r)
 }
 
@@ -194,6 +340,10 @@ func (h objectAPIHandlersWrapper) PutObjectHandler(w http.ResponseWriter, r *htt
 func (h objectAPIHandlersWrapper) DeleteObjectHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "DeleteObject", policyDelete, objectKey(r))
+	if err != nil {
+		return
+	}
 	if err := h.objectPrefixSubstitution(w, r, "DeleteObject"); err != nil {
 		return
 	}
@@ -206,16 +356,52 @@ func (h objectAPIHandlersWrapper) GetBucketLocationHandler(w http.ResponseWriter
 	h.core.GetBucketLocationHandler(w, r)
 }
 
+// GetBucketPolicyHandler stands for GetBucketPolicy
 func (h objectAPIHandlersWrapper) GetBucketPolicyHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
-	h.core.GetBucketPolicyHandler(w, r)
+	bucket, err := h.bucketOwnerCheck(w, r, "GetBucketPolicy")
+	if err != nil {
+		return
+	}
+
+	errCtx := cmd.NewContext(r, w, "GetBucketPolicy")
+	policy, err := h.bucketPolicy.get(ctx, bucket)
+	if err != nil {
+		h.logger.With("bucket", bucket).Errorf("failed to get bucket policy: %s", err)
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+		return
+	}
+	if policy == nil {
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrNoSuchBucketPolicy], r.URL, false)
+		return
+	}
+	w.Header().Set(xhttp.ContentType, "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(policy.Policy)
 }
 
+// GetBucketLifecycleHandler stands for GetBucketLifecycleConfiguration
 func (h objectAPIHandlersWrapper) GetBucketLifecycleHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
-	h.core.GetBucketLifecycleHandler(w, r)
+	bucket, err := h.bucketOwnerCheck(w, r, "GetBucketLifecycle")
+	if err != nil {
+		return
+	}
+
+	errCtx := cmd.NewContext(r, w, "GetBucketLifecycle")
+	config, err := h.bucketLifecycle.get(ctx, bucket)
+	if err != nil {
+		h.logger.With("bucket", bucket).Errorf("failed to get bucket lifecycle configuration: %s", err)
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+		return
+	}
+	if config == nil {
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrNoSuchLifecycle], r.URL, false)
+		return
+	}
+	cmd.WriteSuccessResponseXML(w, cmd.EncodeResponse(config))
 }
 
 func (h objectAPIHandlersWrapper) GetBucketEncryptionHandler(w http.ResponseWriter, r *http.Request) {
@@ -242,10 +428,28 @@ func (h objectAPIHandlersWrapper) GetBucketVersioningHandler(w http.ResponseWrit
 	h.core.GetBucketVersioningHandler(w, r)
 }
 
+// GetBucketNotificationHandler stands for GetBucketNotificationConfiguration
 func (h objectAPIHandlersWrapper) GetBucketNotificationHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
-	h.core.GetBucketNotificationHandler(w, r)
+	bucket, err := h.bucketOwnerCheck(w, r, "GetBucketNotification")
+	if err != nil {
+		return
+	}
+
+	config, err := h.bucketNotification.get(ctx, bucket)
+	if err != nil {
+		errCtx := cmd.NewContext(r, w, "GetBucketNotification")
+		h.logger.With("bucket", bucket).Errorf("failed to get bucket notification configuration: %s", err)
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+		return
+	}
+	if config == nil || config.Configuration == nil {
+		cmd.WriteSuccessResponseXML(w, cmd.EncodeResponse(&notificationConfiguration{XMLNS: notificationXMLNamespace}))
+		return
+	}
+	w.Header().Set(NotificationSigningSecretHeader, config.SigningSecret)
+	cmd.WriteSuccessResponseXML(w, cmd.EncodeResponse(config.Configuration))
 }
 
 func (h objectAPIHandlersWrapper) ListenNotificationHandler(w http.ResponseWriter, r *http.Request) {
@@ -266,35 +470,103 @@ func (h objectAPIHandlersWrapper) PutBucketACLHandler(w http.ResponseWriter, r *
 	h.core.PutBucketACLHandler(w, r)
 }
 
+// GetBucketCorsHandler stands for GetBucketCors
 func (h objectAPIHandlersWrapper) GetBucketCorsHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
-	var sb strings.Builder
-	sb.WriteString("<CORSConfiguration><CORSRule>")
-	for _, o := range h.corsAllowedOrigins {
-		fmt.Fprintf(&sb, "<AllowedOrigin>%s</AllowedOrigin>", o)
+	bucket, err := h.bucketOwnerCheck(w, r, "GetBucketCors")
+	if err != nil {
+		return
+	}
+
+	errCtx := cmd.NewContext(r, w, "GetBucketCors")
+	config, err := h.bucketCORS.get(ctx, bucket)
+	if err != nil {
+		h.logger.With("bucket", bucket).Errorf("failed to get bucket CORS configuration: %s", err)
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+		return
 	}
-	// CorsHandler's AllowedHeader list is duplicated here
-	allowedMethods := []string{http.MethodGet, http.MethodPut, http.MethodHead, http.MethodPost,
-		http.MethodDelete, http.MethodOptions, http.MethodPatch}
-	for _, o := range allowedMethods {
-		fmt.Fprintf(&sb, "<AllowedMethod>%s</AllowedMethod>", o)
+	if config == nil {
+		// buckets without a configuration of their own are subject to the
+		// global one.
+		config = h.globalCORSConfiguration()
+	}
+	cmd.WriteSuccessResponseXML(w, cmd.EncodeResponse(config))
+}
+
+// globalCORSConfiguration describes the CORS policy CorsHandler applies to
+// buckets without a configuration of their own.
+func (h objectAPIHandlersWrapper) globalCORSConfiguration() *corsConfiguration {
+	return &corsConfiguration{
+		XMLNS: corsXMLNamespace,
+		Rules: []corsRule{{
+			AllowedOrigins: h.corsAllowedOrigins,
+			// CorsHandler's AllowedMethods list is duplicated here
+			AllowedMethods: []string{http.MethodGet, http.MethodPut, http.MethodHead, http.MethodPost,
+				http.MethodDelete, http.MethodOptions, http.MethodPatch},
+			// CorsHandler's AllowedHeaders list is not duplicated here, because it includes "*"
+			AllowedHeaders: []string{"*"},
+			ExposeHeaders:  []string{"*"},
+		}},
 	}
-	// CorsHandler's AllowedHeader list is not implemented here, because it includes "*"
-	sb.WriteString("<AllowedHeader>*</AllowedHeader><ExposeHeader>*</ExposeHeader></CORSRule></CORSConfiguration>")
-	cmd.WriteSuccessResponseXML(w, []byte(sb.String()))
 }
 
+// PutBucketCorsHandler stands for PutBucketCors
 func (h objectAPIHandlersWrapper) PutBucketCorsHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
-	cmd.WriteErrorResponse(r.Context(), w, cmd.GetAPIError(cmd.ErrNotImplemented), r.URL, false)
+	bucket, err := h.bucketOwnerCheck(w, r, "PutBucketCors")
+	if err != nil {
+		return
+	}
+
+	errCtx := cmd.NewContext(r, w, "PutBucketCors")
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxCORSConfigurationSize+1))
+	if err != nil {
+		cmd.WriteErrorResponse(errCtx, w, cmd.ToAPIError(errCtx, err), r.URL, false)
+		return
+	}
+	if len(data) > maxCORSConfigurationSize {
+		cmd.WriteErrorResponse(errCtx, w, cmd.GetAPIError(cmd.ErrEntityTooLarge), r.URL, false)
+		return
+	}
+
+	config, err := parseCORSConfiguration(data)
+	switch {
+	case ErrCORSConfiguration.Has(err):
+		apiErr := apiErrors[ErrInvalidCORSRequest]
+		apiErr.Description = err.Error()
+		cmd.WriteErrorResponse(errCtx, w, apiErr, r.URL, false)
+		return
+	case err != nil:
+		cmd.WriteErrorResponse(errCtx, w, cmd.GetAPIError(cmd.ErrMalformedXML), r.URL, false)
+		return
+	}
+
+	if err := h.bucketCORS.put(ctx, bucket, config); err != nil {
+		h.logger.With("bucket", bucket).Errorf("failed to put bucket CORS configuration: %s", err)
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
 }
 
+// DeleteBucketCorsHandler stands for DeleteBucketCors
 func (h objectAPIHandlersWrapper) DeleteBucketCorsHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
-	cmd.WriteErrorResponse(r.Context(), w, cmd.GetAPIError(cmd.ErrNotImplemented), r.URL, false)
+	bucket, err := h.bucketOwnerCheck(w, r, "DeleteBucketCors")
+	if err != nil {
+		return
+	}
+
+	if err := h.bucketCORS.delete(ctx, bucket); err != nil {
+		errCtx := cmd.NewContext(r, w, "DeleteBucketCors")
+		h.logger.With("bucket", bucket).Errorf("failed to delete bucket CORS configuration: %s", err)
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h objectAPIHandlersWrapper) GetBucketWebsiteHandler(w http.ResponseWriter, r *http.Request) {
@@ -315,10 +587,103 @@ func (h objectAPIHandlersWrapper) GetBucketRequestPaymentHandler(w http.Response
 	h.core.GetBucketRequestPaymentHandler(w, r)
 }
 
+// GetBucketLoggingHandler stands for GetBucketLogging
 func (h objectAPIHandlersWrapper) GetBucketLoggingHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
-	h.core.GetBucketLoggingHandler(w, r)
+	bucket, err := h.bucketOwnerCheck(w, r, "GetBucketLogging")
+	if err != nil {
+		return
+	}
+
+	config, err := h.bucketLogging.get(ctx, bucket)
+	if err != nil {
+		errCtx := cmd.NewContext(r, w, "GetBucketLogging")
+		h.logger.With("bucket", bucket).Errorf("failed to get bucket logging configuration: %s", err)
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+		return
+	}
+	cmd.WriteSuccessResponseXML(w, cmd.EncodeResponse(config.status()))
+}
+
+// PutBucketLoggingHandler stands for PutBucketLogging
+func (h objectAPIHandlersWrapper) PutBucketLoggingHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	defer mon.Task()(&ctx)(nil)
+	bucket, owner, err := h.bucketOwner(w, r, "PutBucketLogging")
+	if err != nil {
+		return
+	}
+
+	errCtx := cmd.NewContext(r, w, "PutBucketLogging")
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxLoggingStatusSize+1))
+	if err != nil {
+		cmd.WriteErrorResponse(errCtx, w, cmd.ToAPIError(errCtx, err), r.URL, false)
+		return
+	}
+	if len(data) > maxLoggingStatusSize {
+		cmd.WriteErrorResponse(errCtx, w, cmd.GetAPIError(cmd.ErrEntityTooLarge), r.URL, false)
+		return
+	}
+
+	status, err := parseBucketLoggingStatus(data)
+	switch {
+	case ErrLoggingStatus.Has(err):
+		apiErr := apiErrors[ErrInvalidLogging]
+		apiErr.Description = err.Error()
+		cmd.WriteErrorResponse(errCtx, w, apiErr, r.URL, false)
+		return
+	case err != nil:
+		cmd.WriteErrorResponse(errCtx, w, cmd.GetAPIError(cmd.ErrMalformedXML), r.URL, false)
+		return
+	}
+
+	if status.LoggingEnabled == nil {
+		if err := h.bucketLogging.delete(ctx, bucket); err != nil {
+			h.logger.With("bucket", bucket).Errorf("failed to delete bucket logging configuration: %s", err)
+			cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// logs are written with the credentials of the bucket's owner, so the
+	// target has to be one of their buckets too.
+	target := status.LoggingEnabled.TargetBucket
+	if _, err := h.core.ObjectAPI().GetObjectInfo(errCtx, owner, target, cmd.ObjectOptions{}); err != nil {
+		if errors.As(err, &cmd.ObjectNotFound{}) {
+			cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInvalidLogTarget], r.URL, false)
+		} else {
+			cmd.WriteErrorResponse(errCtx, w, cmd.ToAPIError(errCtx, err), r.URL, false)
+		}
+		return
+	}
+
+	credentials := middleware.GetAccess(ctx)
+	if credentials == nil || credentials.AccessKey == "" {
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrAccessDenied], r.URL, false)
+		return
+	}
+	// logs are written long after, and temporary credentials would expire.
+	if credentials.SessionToken != "" {
+		apiErr := apiErrors[ErrAccessDenied]
+		apiErr.Description = "logging can't be enabled with temporary credentials"
+		cmd.WriteErrorResponse(errCtx, w, apiErr, r.URL, false)
+		return
+	}
+
+	if err := h.bucketLogging.put(ctx, bucket, &bucketLoggingConfig{
+		TargetBucket: target,
+		TargetPrefix: status.LoggingEnabled.TargetPrefix,
+		AccessKey:    credentials.AccessKey,
+		Owner:        owner,
+	}); err != nil {
+		h.logger.With("bucket", bucket).Errorf("failed to put bucket logging configuration: %s", err)
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
 }
 
 func (h objectAPIHandlersWrapper) GetBucketTaggingHandler(w http.ResponseWriter, r *http.Request) {
@@ -343,6 +708,10 @@ func (h objectAPIHandlersWrapper) DeleteBucketTaggingHandler(w http.ResponseWrit
 func (h objectAPIHandlersWrapper) ListMultipartUploadsHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "ListMultipartUploads", policyList, listPrefix(r))
+	if err != nil {
+		return
+	}
 	if err := h.bucketPrefixSubstitution(w, r, "ListMultipartUploads"); err != nil {
 		return
 	}
@@ -352,6 +721,10 @@ func (h objectAPIHandlersWrapper) ListMultipartUploadsHandler(w http.ResponseWri
 func (h objectAPIHandlersWrapper) ListObjectsV2MHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "ListObjectsV2M", policyList, listPrefix(r))
+	if err != nil {
+		return
+	}
 	h.core.ListObjectsV2MHandler(w, r)
 }
 
@@ -359,6 +732,10 @@ func (h objectAPIHandlersWrapper) ListObjectsV2MHandler(w http.ResponseWriter, r
 func (h objectAPIHandlersWrapper) ListObjectsV2Handler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "ListObjectsV2", policyList, listPrefix(r))
+	if err != nil {
+		return
+	}
 	if err := h.bucketPrefixSubstitution(w, r, "ListObjectsV2"); err != nil {
 		return
 	}
@@ -368,6 +745,10 @@ func (h objectAPIHandlersWrapper) ListObjectsV2Handler(w http.ResponseWriter, r
 func (h objectAPIHandlersWrapper) ListObjectVersionsHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "ListObjectVersions", policyList, listPrefix(r))
+	if err != nil {
+		return
+	}
 	h.core.ListObjectVersionsHandler(w, r)
 }
 
@@ -375,16 +756,54 @@ func (h objectAPIHandlersWrapper) ListObjectVersionsHandler(w http.ResponseWrite
 func (h objectAPIHandlersWrapper) ListObjectsV1Handler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "ListObjectsV1", policyList, listPrefix(r))
+	if err != nil {
+		return
+	}
 	if err := h.bucketPrefixSubstitution(w, r, "ListObjectsV1"); err != nil {
 		return
 	}
 	h.core.ListObjectsV1Handler(w, r)
 }
 
+// PutBucketLifecycleHandler stands for PutBucketLifecycleConfiguration
 func (h objectAPIHandlersWrapper) PutBucketLifecycleHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
-	h.core.PutBucketLifecycleHandler(w, r)
+	bucket, err := h.bucketOwnerCheck(w, r, "PutBucketLifecycle")
+	if err != nil {
+		return
+	}
+
+	errCtx := cmd.NewContext(r, w, "PutBucketLifecycle")
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxLifecycleConfigurationSize+1))
+	if err != nil {
+		cmd.WriteErrorResponse(errCtx, w, cmd.ToAPIError(errCtx, err), r.URL, false)
+		return
+	}
+	if len(data) > maxLifecycleConfigurationSize {
+		cmd.WriteErrorResponse(errCtx, w, cmd.GetAPIError(cmd.ErrEntityTooLarge), r.URL, false)
+		return
+	}
+
+	config, err := parseLifecycleConfiguration(data, time.Now())
+	switch {
+	case ErrLifecycleConfiguration.Has(err):
+		apiErr := apiErrors[ErrInvalidLifecycle]
+		apiErr.Description = err.Error()
+		cmd.WriteErrorResponse(errCtx, w, apiErr, r.URL, false)
+		return
+	case err != nil:
+		cmd.WriteErrorResponse(errCtx, w, cmd.GetAPIError(cmd.ErrMalformedXML), r.URL, false)
+		return
+	}
+
+	if err := h.bucketLifecycle.put(ctx, bucket, config); err != nil {
+		h.logger.With("bucket", bucket).Errorf("failed to put bucket lifecycle configuration: %s", err)
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
 }
 
 func (h objectAPIHandlersWrapper) PutBucketReplicationConfigHandler(w http.ResponseWriter, r *http.Request) {
@@ -399,10 +818,63 @@ func (h objectAPIHandlersWrapper) PutBucketEncryptionHandler(w http.ResponseWrit
 	h.core.PutBucketEncryptionHandler(w, r)
 }
 
+// PutBucketPolicyHandler stands for PutBucketPolicy
 func (h objectAPIHandlersWrapper) PutBucketPolicyHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
-	h.core.PutBucketPolicyHandler(w, r)
+	bucket, userID, err := h.bucketOwner(w, r, "PutBucketPolicy")
+	if err != nil {
+		return
+	}
+
+	errCtx := cmd.NewContext(r, w, "PutBucketPolicy")
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBucketPolicySize+1))
+	if err != nil {
+		cmd.WriteErrorResponse(errCtx, w, cmd.ToAPIError(errCtx, err), r.URL, false)
+		return
+	}
+	if len(data) > maxBucketPolicySize {
+		cmd.WriteErrorResponse(errCtx, w, cmd.GetAPIError(cmd.ErrPolicyTooLarge), r.URL, false)
+		return
+	}
+
+	policy := &bucketPolicy{Policy: data, Owner: userID}
+	if policy.statements, err = parseBucketPolicy(bucket, data); err != nil {
+		apiErr := apiErrors[ErrInvalidPolicy]
+		if ErrPolicy.Has(err) {
+			apiErr.Description = err.Error()
+		}
+		cmd.WriteErrorResponse(errCtx, w, apiErr, r.URL, false)
+		return
+	}
+
+	if permission, _ := policy.publicRestriction(bucket); permission.AllowDownload || permission.AllowList {
+		if err := h.registerPublicAccess(ctx, bucket, policy); err != nil {
+			if errors.Is(err, errTemporaryPublicAccess) {
+				apiErr := apiErrors[ErrAccessDenied]
+				apiErr.Description = err.Error()
+				cmd.WriteErrorResponse(errCtx, w, apiErr, r.URL, false)
+				return
+			}
+			h.logger.With("bucket", bucket).Errorf("failed to register public access of bucket policy: %s", err)
+			cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+			return
+		}
+	}
+
+	replaced, err := h.bucketPolicy.stored(ctx, bucket)
+	if err != nil {
+		h.logger.With("bucket", bucket).Errorf("failed to get replaced bucket policy: %s", err)
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+		return
+	}
+	if err := h.bucketPolicy.put(ctx, bucket, policy); err != nil {
+		h.logger.With("bucket", bucket).Errorf("failed to put bucket policy: %s", err)
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+		return
+	}
+	h.revokePublicAccess(ctx, bucket, replaced)
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h objectAPIHandlersWrapper) PutBucketObjectLockConfigHandler(w http.ResponseWriter, r *http.Request) {
@@ -423,10 +895,69 @@ func (h objectAPIHandlersWrapper) PutBucketVersioningHandler(w http.ResponseWrit
 	h.core.PutBucketVersioningHandler(w, r)
 }
 
+// PutBucketNotificationHandler stands for PutBucketNotificationConfiguration
 func (h objectAPIHandlersWrapper) PutBucketNotificationHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
-	h.core.PutBucketNotificationHandler(w, r)
+	bucket, owner, err := h.bucketOwner(w, r, "PutBucketNotification")
+	if err != nil {
+		return
+	}
+
+	errCtx := cmd.NewContext(r, w, "PutBucketNotification")
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxNotificationConfigurationSize+1))
+	if err != nil {
+		cmd.WriteErrorResponse(errCtx, w, cmd.ToAPIError(errCtx, err), r.URL, false)
+		return
+	}
+	if len(data) > maxNotificationConfigurationSize {
+		cmd.WriteErrorResponse(errCtx, w, cmd.GetAPIError(cmd.ErrEntityTooLarge), r.URL, false)
+		return
+	}
+
+	config, err := parseNotificationConfiguration(data)
+	switch {
+	case ErrNotificationConfiguration.Has(err):
+		apiErr := apiErrors[ErrInvalidNotification]
+		apiErr.Description = err.Error()
+		cmd.WriteErrorResponse(errCtx, w, apiErr, r.URL, false)
+		return
+	case err != nil:
+		cmd.WriteErrorResponse(errCtx, w, cmd.GetAPIError(cmd.ErrMalformedXML), r.URL, false)
+		return
+	}
+
+	// an empty configuration turns notifications off.
+	if len(config.Topics) == 0 {
+		if err := h.bucketNotification.delete(ctx, bucket); err != nil {
+			h.logger.With("bucket", bucket).Errorf("failed to delete bucket notification configuration: %s", err)
+			cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// deliveries are signed with a secret of the bucket, which webhooks get
+	// from the responses of the configuration API.
+	secret, err := h.bucketNotification.signingSecret(ctx, bucket)
+	if err != nil {
+		h.logger.With("bucket", bucket).Errorf("failed to get bucket notification signing secret: %s", err)
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+		return
+	}
+
+	if err := h.bucketNotification.put(ctx, bucket, &bucketNotificationConfig{
+		Configuration: config,
+		SigningSecret: secret,
+		Owner:         owner,
+	}); err != nil {
+		h.logger.With("bucket", bucket).Errorf("failed to put bucket notification configuration: %s", err)
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+		return
+	}
+	w.Header().Set(NotificationSigningSecretHeader, secret)
+	w.WriteHeader(http.StatusOK)
 }
 
 // PutBucketHandler stands for CreateBucket
@@ -508,6 +1039,10 @@ func (h objectAPIHandlersWrapper) HeadBucketHandler(w http.ResponseWriter, r *ht
 func (h objectAPIHandlersWrapper) PostPolicyBucketHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "PostPolicyBucket", policyPut, postPolicyKey(r))
+	if err != nil {
+		return
+	}
 	h.core.PostPolicyBucketHandler(w, r)
 }
 
@@ -515,16 +1050,39 @@ func (h objectAPIHandlersWrapper) PostPolicyBucketHandler(w http.ResponseWriter,
 func (h objectAPIHandlersWrapper) DeleteMultipleObjectsHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "DeleteMultipleObjects", policyDelete, deleteObjectsKeys(r))
+	if err != nil {
+		return
+	}
 	if err := h.bucketPrefixSubstitution(w, r, "DeleteMultipleObjects"); err != nil {
 		return
 	}
 	h.core.DeleteMultipleObjectsHandler(w, r)
 }
 
+// DeleteBucketPolicyHandler stands for DeleteBucketPolicy
 func (h objectAPIHandlersWrapper) DeleteBucketPolicyHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
-	h.core.DeleteBucketPolicyHandler(w, r)
+	bucket, err := h.bucketOwnerCheck(w, r, "DeleteBucketPolicy")
+	if err != nil {
+		return
+	}
+
+	errCtx := cmd.NewContext(r, w, "DeleteBucketPolicy")
+	deleted, err := h.bucketPolicy.stored(ctx, bucket)
+	if err != nil {
+		h.logger.With("bucket", bucket).Errorf("failed to get deleted bucket policy: %s", err)
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+		return
+	}
+	if err := h.bucketPolicy.delete(ctx, bucket); err != nil {
+		h.logger.With("bucket", bucket).Errorf("failed to delete bucket policy: %s", err)
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+		return
+	}
+	h.revokePublicAccess(ctx, bucket, deleted)
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h objectAPIHandlersWrapper) DeleteBucketReplicationConfigHandler(w http.ResponseWriter, r *http.Request) {
@@ -533,10 +1091,22 @@ func (h objectAPIHandlersWrapper) DeleteBucketReplicationConfigHandler(w http.Re
 	h.core.DeleteBucketReplicationConfigHandler(w, r)
 }
 
+// DeleteBucketLifecycleHandler stands for DeleteBucketLifecycle
 func (h objectAPIHandlersWrapper) DeleteBucketLifecycleHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
-	h.core.DeleteBucketLifecycleHandler(w, r)
+	bucket, err := h.bucketOwnerCheck(w, r, "DeleteBucketLifecycle")
+	if err != nil {
+		return
+	}
+
+	if err := h.bucketLifecycle.delete(ctx, bucket); err != nil {
+		errCtx := cmd.NewContext(r, w, "DeleteBucketLifecycle")
+		h.logger.With("bucket", bucket).Errorf("failed to delete bucket lifecycle configuration: %s", err)
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h objectAPIHandlersWrapper) DeleteBucketEncryptionHandler(w http.ResponseWriter, r *http.Request) {
@@ -549,7 +1119,13 @@ func (h objectAPIHandlersWrapper) DeleteBucketEncryptionHandler(w http.ResponseW
 func (h objectAPIHandlersWrapper) DeleteBucketHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
-	bucket := mux.Vars(r)[VarKeyBucket]
+	// bucket names are shared by all users, so deleting the marker object of
+	// a bucket the user doesn't have would succeed, and the name and the
+	// configurations of another user's bucket would be deleted below.
+	bucket, err := h.bucketOwnerCheck(w, r, "DeleteBucket")
+	if err != nil {
+		return
+	}
 	if err := h.bucketPrefixSubstitution(w, r, "DeleteBucket"); err != nil {
 		return
 	}
@@ -564,12 +1140,38 @@ func (h objectAPIHandlersWrapper) DeleteBucketHandler(w http.ResponseWriter, r *
 	if err != nil || (code >= 300 || code < 200) {
 		errCtx := cmd.NewContext(r, w, "DeleteBucket")
 		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+		return
+	}
+	// the bucket's configurations mustn't outlive it, or they would apply to
+	// the next bucket with its name.
+	if err := h.bucketCORS.delete(ctx, bucket); err != nil {
+		h.logger.With("bucket", bucket).Errorf("failed to delete CORS configuration of deleted bucket: %s", err)
+	}
+	if err := h.bucketLogging.delete(ctx, bucket); err != nil {
+		h.logger.With("bucket", bucket).Errorf("failed to delete logging configuration of deleted bucket: %s", err)
+	}
+	if err := h.bucketNotification.delete(ctx, bucket); err != nil {
+		h.logger.With("bucket", bucket).Errorf("failed to delete notification configuration of deleted bucket: %s", err)
+	}
+	if err := h.bucketLifecycle.delete(ctx, bucket); err != nil {
+		h.logger.With("bucket", bucket).Errorf("failed to delete lifecycle configuration of deleted bucket: %s", err)
+	}
+	if policy, err := h.bucketPolicy.stored(ctx, bucket); err != nil {
+		h.logger.With("bucket", bucket).Errorf("failed to get policy of deleted bucket: %s", err)
+	} else if err := h.bucketPolicy.delete(ctx, bucket); err != nil {
+		h.logger.With("bucket", bucket).Errorf("failed to delete policy of deleted bucket: %s", err)
+	} else {
+		h.revokePublicAccess(ctx, bucket, policy)
 	}
 }
 
 func (h objectAPIHandlersWrapper) PostRestoreObjectHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	defer mon.Task()(&ctx)(nil)
+	r, err := h.withBucketPolicy(w, r, "PostRestoreObject", policyPut, objectKey(r))
+	if err != nil {
+		return
+	}
 	h.core.PostRestoreObjectHandler(w, r)
 }
 
@@ -33,6 +33,13 @@ func RegisterAPIRouter(
 	uuidResolverAddr string,
 	dwsBackendHost string,
 	dwsNodeToken string,
+	bucketCORS *BucketCORS,
+	bucketLogging *BucketLogging,
+	bucketNotification *BucketNotification,
+	bucketLifecycle *BucketLifecycle,
+	bucketPolicy *BucketPolicy,
+	accessLogs *AccessLogs,
+	rateLimiter *middleware.RateLimiter,
 ) {
 	api := objectAPIHandlersWrapper{
 		core: cmd.ObjectAPIHandlers{
@@ -44,12 +51,18 @@ func RegisterAPIRouter(
 		httpClient: &http.Client{
 			Timeout: time.Second * 15,
 		},
-		authClient: authClient,
-		dwsClient:  dwsClient,
-		trustedIPs: trustedIPs,
-		nodeHost:   dwsBackendHost,
-		logger:     logger.Sugar(),
-		nodeToken:  dwsNodeToken,
+		authClient:         authClient,
+		dwsClient:          dwsClient,
+		trustedIPs:         trustedIPs,
+		nodeHost:           dwsBackendHost,
+		logger:             logger.Sugar(),
+		nodeToken:          dwsNodeToken,
+		bucketCORS:         bucketCORS,
+		bucketLogging:      bucketLogging,
+		bucketNotification: bucketNotification,
+		bucketLifecycle:    bucketLifecycle,
+		bucketPolicy:       bucketPolicy,
+		multipartChecksums: newMultipartChecksums(DwsConfig{DwsBackendHost: dwsBackendHost, DwsNodeToken: dwsNodeToken}),
 	}
 
 	// limit the conccurrency of uploads and downloads per macaroon head
@@ -66,6 +79,18 @@ func RegisterAPIRouter(
 
 	apiRouter := router.PathPrefix(cmd.SlashSeparator).Subrouter()
 
+	// record requests to buckets with logging enabled, including the ones
+	// the rate limits refuse
+	if accessLogs != nil {
+		apiRouter.Use(accessLogs.Middleware)
+	}
+
+	// limit the rate of requests and the bandwidth per macaroon head and per
+	// client IP
+	if rateLimiter != nil && rateLimiter.Enabled() {
+		apiRouter.Use(rateLimiter.Limit)
+	}
+
 	var routers []*mux.Router
 	for _, domainName := range domainNames {
 		routers = append(routers, apiRouter.Host("{bucket:.+}."+domainName).Subrouter())
@@ -178,13 +203,13 @@ func RegisterAPIRouter(
 		// PutBucketACL -- this is a dummy call.
 		bucket.Methods(http.MethodPut).HandlerFunc(
 			cmd.MaxClients(cmd.CollectAPIStats("putbucketacl", cmd.HTTPTraceAll(api.PutBucketACLHandler)))).Queries("acl", "")
-		// GetBucketCors - this is a dummy call.
+		// GetBucketCors
 		bucket.Methods(http.MethodGet).HandlerFunc(
 			cmd.MaxClients(cmd.CollectAPIStats("getbucketcors", cmd.HTTPTraceAll(api.GetBucketCorsHandler)))).Queries("cors", "")
-		// PutBucketCors - this is a dummy call.
+		// PutBucketCors
 		bucket.Methods(http.MethodPut).HandlerFunc(
 			cmd.MaxClients(cmd.CollectAPIStats("putbucketcors", cmd.HTTPTraceAll(api.PutBucketCorsHandler)))).Queries("cors", "")
-		// DeleteBucketCors - this is a dummy call.
+		// DeleteBucketCors
 		bucket.Methods(http.MethodDelete).HandlerFunc(
 			cmd.MaxClients(cmd.CollectAPIStats("deletebucketcors", cmd.HTTPTraceAll(api.DeleteBucketCorsHandler)))).Queries("cors", "")
 		// GetBucketWebsiteHandler - this is a dummy call.
@@ -196,10 +221,13 @@ func RegisterAPIRouter(
 		// GetBucketRequestPaymentHandler - this is a dummy call.
 		bucket.Methods(http.MethodGet).HandlerFunc(
 			cmd.MaxClients(cmd.CollectAPIStats("getbucketrequestpayment", cmd.HTTPTraceAll(api.GetBucketRequestPaymentHandler)))).Queries("requestPayment", "")
-		// GetBucketLoggingHandler - this is a dummy call.
+		// GetBucketLogging
 		bucket.Methods(http.MethodGet).HandlerFunc(
 			cmd.MaxClients(cmd.CollectAPIStats("getbucketlogging", cmd.HTTPTraceAll(api.GetBucketLoggingHandler)))).Queries("logging", "")
-		// GetBucketLifecycleHandler - this is a dummy call.
+		// PutBucketLogging
+		bucket.Methods(http.MethodPut).HandlerFunc(
+			cmd.MaxClients(cmd.CollectAPIStats("putbucketlogging", cmd.HTTPTraceAll(api.PutBucketLoggingHandler)))).Queries("logging", "")
+		// GetBucketLifecycle
 		bucket.Methods(http.MethodGet).HandlerFunc(
 			cmd.MaxClients(cmd.CollectAPIStats("getbucketlifecycle", cmd.HTTPTraceAll(api.GetBucketLifecycleHandler)))).Queries("lifecycle", "")
 		// GetBucketTaggingHandler
@@ -289,6 +317,10 @@ func RegisterAPIRouter(
 
 	// Root operation
 
+	// AssumeRole and GetSessionToken (STS)
+	apiRouter.Methods(http.MethodPost).Path(cmd.SlashSeparator).HeadersRegexp(xhttp.ContentType, "application/x-www-form-urlencoded*").HandlerFunc(
+		cmd.MaxClients(cmd.CollectAPIStats("assumerole", cmd.HTTPTraceAll(api.AssumeRoleHandler))))
+
 	// ListenNotification
 	apiRouter.Methods(http.MethodGet).Path(cmd.SlashSeparator).HandlerFunc(
 		cmd.CollectAPIStats("listennotification", cmd.HTTPTraceAll(api.ListenNotificationHandler))).Queries("events", "{events:.*}")
@@ -473,7 +505,7 @@ func RegisterAPIRouter(
 // 		// PutBucketACL -- this is a dummy call.
 // 		bucket.Methods(http.MethodPut).HandlerFunc(
 // 			maxClients(collectAPIStats("putbucketacl", httpTraceAll(api.PutBucketACLHandler)))).Queries("acl", "")
-// 		// GetBucketCors - this is a dummy call.
+// 		// GetBucketCors
 // 		bucket.Methods(http.MethodGet).HandlerFunc(
 // 			maxClients(collectAPIStats("getbucketcors", httpTraceAll(api.GetBucketCorsHandler)))).Queries("cors", "")
 // 		// GetBucketWebsiteHandler - this is a dummy call.
@@ -488,7 +520,7 @@ func RegisterAPIRouter(
 // 		// GetBucketLoggingHandler - this is a dummy call.
 // 		bucket.Methods(http.MethodGet).HandlerFunc(
 // 			maxClients(collectAPIStats("getbucketlogging", httpTraceAll(api.GetBucketLoggingHandler)))).Queries("logging", "")
-// 		// GetBucketLifecycleHandler - this is a dummy call.
+// 		// GetBucketLifecycle
 // 		bucket.Methods(http.MethodGet).HandlerFunc(
 // 			maxClients(collectAPIStats("getbucketlifecycle", httpTraceAll(api.GetBucketLifecycleHandler)))).Queries("lifecycle", "")
 // 		// GetBucketTaggingHandler
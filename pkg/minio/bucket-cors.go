@@ -0,0 +1,261 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"net/http"
+	"strings"
+
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/common/lrucache"
+	"storj.io/minio/pkg/wildcard"
+)
+
+const (
+	// maxCORSConfigurationSize is the largest CORS configuration S3 accepts.
+	maxCORSConfigurationSize = 64 << 10
+	// maxCORSRules is the largest number of rules S3 accepts in a CORS
+	// configuration.
+	maxCORSRules = 100
+	// maxCORSRuleIDLength is the longest rule ID S3 accepts.
+	maxCORSRuleIDLength = 255
+
+	corsXMLNamespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+)
+
+// corsAllowedMethods are the methods rules of a CORS configuration may allow.
+var corsAllowedMethods = []string{
+	http.MethodGet,
+	http.MethodPut,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodDelete,
+}
+
+// ErrCORSConfiguration is the error class for invalid CORS configurations.
+var ErrCORSConfiguration = errs.Class("invalid CORS configuration")
+
+// corsConfiguration is the CORS configuration of a bucket, as sent to
+// PutBucketCors.
+type corsConfiguration struct {
+	XMLName xml.Name   `xml:"CORSConfiguration"`
+	XMLNS   string     `xml:"xmlns,attr,omitempty"`
+	Rules   []corsRule `xml:"CORSRule"`
+}
+
+// corsRule is a rule of a CORS configuration.
+type corsRule struct {
+	ID             string   `xml:"ID,omitempty"`
+	AllowedOrigins []string `xml:"AllowedOrigin"`
+	AllowedMethods []string `xml:"AllowedMethod"`
+	AllowedHeaders []string `xml:"AllowedHeader,omitempty"`
+	ExposeHeaders  []string `xml:"ExposeHeader,omitempty"`
+	MaxAgeSeconds  *int     `xml:"MaxAgeSeconds,omitempty"`
+}
+
+// parseCORSConfiguration parses and validates a CORS configuration. XML that
+// can't be parsed is returned as is, while configurations S3 would refuse are
+// returned as ErrCORSConfiguration.
+func parseCORSConfiguration(data []byte) (*corsConfiguration, error) {
+	var config corsConfiguration
+	if err := xml.NewDecoder(bytes.NewReader(data)).Decode(&config); err != nil {
+		return nil, err
+	}
+	config.XMLNS = corsXMLNamespace
+
+	if len(config.Rules) == 0 {
+		return nil, ErrCORSConfiguration.New("at least one CORSRule is required")
+	}
+	if len(config.Rules) > maxCORSRules {
+		return nil, ErrCORSConfiguration.New("the number of CORS rules should not exceed allowed limit of %d rules", maxCORSRules)
+	}
+
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		if len(rule.ID) > maxCORSRuleIDLength {
+			return nil, ErrCORSConfiguration.New("the ID of a CORSRule can't be longer than %d characters", maxCORSRuleIDLength)
+		}
+		if len(rule.AllowedOrigins) == 0 || len(rule.AllowedMethods) == 0 {
+			return nil, ErrCORSConfiguration.New("a CORSRule requires at least one AllowedOrigin and AllowedMethod")
+		}
+		for _, origin := range rule.AllowedOrigins {
+			if strings.Count(origin, "*") > 1 {
+				return nil, ErrCORSConfiguration.New("AllowedOrigin %q can not have more than one wildcard", origin)
+			}
+		}
+		for _, method := range rule.AllowedMethods {
+			if !containsString(corsAllowedMethods, method) {
+				return nil, ErrCORSConfiguration.New("found unsupported HTTP method in CORS config; unsupported method is %s", method)
+			}
+		}
+		for _, header := range rule.AllowedHeaders {
+			if strings.Count(header, "*") > 1 {
+				return nil, ErrCORSConfiguration.New("AllowedHeader %q can not have more than one wildcard", header)
+			}
+		}
+		if rule.MaxAgeSeconds != nil && *rule.MaxAgeSeconds < 0 {
+			return nil, ErrCORSConfiguration.New("MaxAgeSeconds can't be negative")
+		}
+	}
+
+	return &config, nil
+}
+
+// match returns the first rule allowing a request from origin using method and
+// sending headers, or nil if there's none.
+func (config *corsConfiguration) match(origin, method string, headers []string) *corsRule {
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		if rule.allowsOrigin(origin) && containsString(rule.AllowedMethods, method) && rule.allowsHeaders(headers) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (rule *corsRule) allowsOrigin(origin string) bool {
+	for _, allowed := range rule.AllowedOrigins {
+		if wildcard.MatchSimple(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHeaders returns whether each of headers matches an AllowedHeader.
+// Header names are compared case-insensitively.
+func (rule *corsRule) allowsHeaders(headers []string) bool {
+next:
+	for _, header := range headers {
+		header = strings.ToLower(header)
+		for _, allowed := range rule.AllowedHeaders {
+			if wildcard.MatchSimple(strings.ToLower(allowed), header) {
+				continue next
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// allowsAnyOrigin returns whether the rule allows requests from any origin,
+// in which case responses don't need to name the origin they allow.
+func (rule *corsRule) allowsAnyOrigin() bool {
+	return containsString(rule.AllowedOrigins, "*")
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// BucketCORS stores the CORS configurations of buckets in the DWS node's
+// bucket registry. The registry is global, like bucket names, so
+// configurations can be found for preflight requests, which carry no
+// credentials.
+type BucketCORS struct {
+	log   *zap.Logger
+	node  nodeBucketConfig
+	cache *lrucache.ExpiringLRUOf[*corsConfiguration]
+	// lookups bounds the lookups made for requests that aren't authenticated
+	// yet.
+	lookups chan struct{}
+}
+
+// errCORSLookupsExhausted is returned by lookup when too many lookups are in
+// progress.
+var errCORSLookupsExhausted = errs.New("too many bucket CORS lookups in progress")
+
+// NewBucketCORS returns a BucketCORS that talks to the DWS node configured by
+// config.
+func NewBucketCORS(log *zap.Logger, config DwsConfig) *BucketCORS {
+	return &BucketCORS{
+		log:  log,
+		node: newNodeBucketConfig(config, "cors", "CORS"),
+		cache: lrucache.NewOf[*corsConfiguration](lrucache.Options{
+			Expiration: config.CorsCacheExpiration,
+			Capacity:   config.CorsCacheCapacity,
+		}),
+		lookups: make(chan struct{}, config.CorsLookupLimit),
+	}
+}
+
+// get returns the CORS configuration of bucket, or nil if it has none.
+// Configurations are cached, so changes made through other gateways may take
+// up to the cache expiration to be noticed.
+func (c *BucketCORS) get(ctx context.Context, bucket string) (_ *corsConfiguration, err error) {
+	defer mon.Task()(&ctx)(&err)
+	return c.cache.Get(ctx, bucket, func() (*corsConfiguration, error) {
+		return c.fetch(ctx, bucket)
+	})
+}
+
+// lookup is like get, but for requests that aren't authenticated yet. As
+// anyone can make them, for any bucket name, at most the lookup limit of them
+// ask the node at once; the others fail with errCORSLookupsExhausted.
+func (c *BucketCORS) lookup(ctx context.Context, bucket string) (_ *corsConfiguration, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if config, cached := c.cache.GetCached(ctx, bucket); cached {
+		return config, nil
+	}
+
+	select {
+	case c.lookups <- struct{}{}:
+		defer func() { <-c.lookups }()
+	default:
+		mon.Event("bucket_cors_lookups_exhausted")
+		return nil, errCORSLookupsExhausted
+	}
+
+	return c.get(ctx, bucket)
+}
+
+// fetch returns the CORS configuration of bucket from the node.
+func (c *BucketCORS) fetch(ctx context.Context, bucket string) (_ *corsConfiguration, err error) {
+	data, err := c.node.get(ctx, bucket)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	// the configuration was validated when it was set, but it's parsed the same
+	// way so that what's enforced is what would be accepted now.
+	return parseCORSConfiguration(data)
+}
+
+// put sets the CORS configuration of bucket.
+func (c *BucketCORS) put(ctx context.Context, bucket string, config *corsConfiguration) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	data, err := xml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err := c.node.put(ctx, bucket, "application/xml", data); err != nil {
+		return err
+	}
+
+	c.cache.Add(ctx, bucket, config)
+	return nil
+}
+
+// delete removes the CORS configuration of bucket.
+func (c *BucketCORS) delete(ctx context.Context, bucket string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if err := c.node.delete(ctx, bucket); err != nil {
+		return err
+	}
+
+	c.cache.Add(ctx, bucket, nil)
+	return nil
+}
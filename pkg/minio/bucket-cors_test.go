@@ -0,0 +1,391 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minio/minio-go/v7/pkg/signer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/common/testcontext"
+	"storj.io/gateway-mt/pkg/authclient"
+	"storj.io/gateway-mt/pkg/server/middleware"
+	"storj.io/gateway-mt/pkg/trustedip"
+	"storj.io/minio/cmd"
+)
+
+func TestParseCORSConfiguration(t *testing.T) {
+	config, err := parseCORSConfiguration([]byte(`<CORSConfiguration>
+		<CORSRule>
+			<ID>uploads</ID>
+			<AllowedOrigin>https://*.example.test</AllowedOrigin>
+			<AllowedMethod>PUT</AllowedMethod>
+			<AllowedMethod>POST</AllowedMethod>
+			<AllowedHeader>x-amz-*</AllowedHeader>
+			<ExposeHeader>ETag</ExposeHeader>
+			<MaxAgeSeconds>3000</MaxAgeSeconds>
+		</CORSRule>
+		<CORSRule>
+			<AllowedOrigin>*</AllowedOrigin>
+			<AllowedMethod>GET</AllowedMethod>
+		</CORSRule>
+	</CORSConfiguration>`))
+	require.NoError(t, err)
+	require.Len(t, config.Rules, 2)
+	assert.Equal(t, "uploads", config.Rules[0].ID)
+	assert.Equal(t, []string{"PUT", "POST"}, config.Rules[0].AllowedMethods)
+	require.NotNil(t, config.Rules[0].MaxAgeSeconds)
+	assert.Equal(t, 3000, *config.Rules[0].MaxAgeSeconds)
+	assert.Nil(t, config.Rules[1].MaxAgeSeconds)
+
+	_, err = parseCORSConfiguration([]byte(`<CORSConfiguration><CORSRule>`))
+	require.Error(t, err)
+	assert.False(t, ErrCORSConfiguration.Has(err))
+
+	rule := func(body string) string {
+		return "<CORSConfiguration><CORSRule>" + body + "</CORSRule></CORSConfiguration>"
+	}
+	for _, invalid := range []string{
+		`<CORSConfiguration></CORSConfiguration>`,
+		"<CORSConfiguration>" + strings.Repeat("<CORSRule><AllowedOrigin>*</AllowedOrigin><AllowedMethod>GET</AllowedMethod></CORSRule>", maxCORSRules+1) + "</CORSConfiguration>",
+		rule(`<AllowedMethod>GET</AllowedMethod>`),
+		rule(`<AllowedOrigin>*</AllowedOrigin>`),
+		rule(`<AllowedOrigin>*</AllowedOrigin><AllowedMethod>PATCH</AllowedMethod>`),
+		rule(`<AllowedOrigin>https://*.*.test</AllowedOrigin><AllowedMethod>GET</AllowedMethod>`),
+		rule(`<AllowedOrigin>*</AllowedOrigin><AllowedMethod>GET</AllowedMethod><AllowedHeader>**</AllowedHeader>`),
+		rule(`<AllowedOrigin>*</AllowedOrigin><AllowedMethod>GET</AllowedMethod><MaxAgeSeconds>-1</MaxAgeSeconds>`),
+		rule(`<ID>` + strings.Repeat("a", maxCORSRuleIDLength+1) + `</ID><AllowedOrigin>*</AllowedOrigin><AllowedMethod>GET</AllowedMethod>`),
+	} {
+		_, err := parseCORSConfiguration([]byte(invalid))
+		assert.True(t, ErrCORSConfiguration.Has(err), invalid)
+	}
+}
+
+func TestCORSConfigurationMatch(t *testing.T) {
+	config := &corsConfiguration{Rules: []corsRule{
+		{
+			ID:             "uploads",
+			AllowedOrigins: []string{"https://*.example.test"},
+			AllowedMethods: []string{"PUT"},
+			AllowedHeaders: []string{"Content-Type", "x-amz-*"},
+		},
+		{
+			ID:             "downloads",
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{"GET", "HEAD"},
+		},
+	}}
+
+	id := func(rule *corsRule) string {
+		if rule == nil {
+			return ""
+		}
+		return rule.ID
+	}
+
+	assert.Equal(t, "uploads", id(config.match("https://app.example.test", "PUT", []string{"content-type", "X-Amz-Date"})))
+	assert.Equal(t, "", id(config.match("https://app.example.test", "PUT", []string{"authorization"})))
+	assert.Equal(t, "", id(config.match("https://example.test", "PUT", nil)))
+	assert.Equal(t, "downloads", id(config.match("https://elsewhere.test", "GET", nil)))
+	assert.Equal(t, "", id(config.match("https://elsewhere.test", "GET", []string{"range"})))
+	assert.Equal(t, "", id(config.match("https://elsewhere.test", "DELETE", nil)))
+}
+
+func TestRequestBucket(t *testing.T) {
+	domains := []string{"gateway.test"}
+
+	request := func(host, path string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Host = host
+		return r
+	}
+
+	assert.Equal(t, "photos", requestBucket(request("photos.gateway.test", "/a/b"), domains))
+	assert.Equal(t, "my.photos", requestBucket(request("my.photos.gateway.test:7777", "/"), domains))
+	assert.Equal(t, "photos", requestBucket(request("gateway.test", "/photos/a/b"), domains))
+	assert.Equal(t, "photos", requestBucket(request("gateway.test", "/photos"), domains))
+	assert.Equal(t, "", requestBucket(request("gateway.test", "/"), domains))
+
+	object := func(r *http.Request) string {
+		_, object := requestBucketObject(r, domains)
+		return object
+	}
+	assert.Equal(t, "a/b", object(request("photos.gateway.test", "/a/b")))
+	assert.Equal(t, "", object(request("photos.gateway.test:7777", "/")))
+	assert.Equal(t, "a/b", object(request("gateway.test", "/photos/a/b")))
+	assert.Equal(t, "", object(request("gateway.test", "/photos")))
+}
+
+// testBucketConfigNode is an in-memory stand-in for the bucket configuration
+// endpoints of the DWS node's bucket registry, like the CORS ones.
+type testBucketConfigNode struct {
+	mu      sync.Mutex
+	configs map[string]string
+	fail    bool
+}
+
+func (node *testBucketConfigNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	if node.fail || r.Header.Get("Authorization") != "token" {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// configurations are keyed by bucket and subresource, like "photos/cors".
+	key := strings.TrimPrefix(r.URL.Path, nodeBucketPath+"/")
+	switch r.Method {
+	case http.MethodGet:
+		config, ok := node.configs[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = io.WriteString(w, config)
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		node.configs[key] = string(data)
+	case http.MethodDelete:
+		delete(node.configs, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestCorsHandler(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	node := &testBucketConfigNode{configs: map[string]string{}}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	bucketCORS := NewBucketCORS(zaptest.NewLogger(t), DwsConfig{
+		DwsBackendHost:      server.URL,
+		DwsNodeToken:        "token",
+		CorsCacheExpiration: time.Hour,
+		CorsCacheCapacity:   10,
+		CorsLookupLimit:     1,
+	})
+
+	maxAge := 600
+	require.NoError(t, bucketCORS.put(ctx, "photos", &corsConfiguration{
+		XMLNS: corsXMLNamespace,
+		Rules: []corsRule{
+			{
+				AllowedOrigins: []string{"https://*.example.test"},
+				AllowedMethods: []string{"PUT", "GET"},
+				AllowedHeaders: []string{"*"},
+				ExposeHeaders:  []string{"ETag"},
+				MaxAgeSeconds:  &maxAge,
+			},
+			{
+				AllowedOrigins: []string{"*"},
+				AllowedMethods: []string{"GET"},
+			},
+		},
+	}))
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := CorsHandler([]string{"https://global.test"}, []string{"gateway.test"}, bucketCORS, nil)(next)
+
+	serve := func(method, host, path string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Host = host
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	preflight := func(origin, method, headers string) http.Header {
+		header := http.Header{
+			"Origin":                        {origin},
+			"Access-Control-Request-Method": {method},
+		}
+		if headers != "" {
+			header.Set("Access-Control-Request-Headers", headers)
+		}
+		return header
+	}
+
+	t.Run("bucket preflight", func(t *testing.T) {
+		w := serve(http.MethodOptions, "photos.gateway.test", "/a.jpg", preflight("https://app.example.test", "PUT", "Content-Type, X-Amz-Date"))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "https://app.example.test", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "PUT, GET", w.Header().Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "Content-Type, X-Amz-Date", w.Header().Get("Access-Control-Allow-Headers"))
+		assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+
+		w = serve(http.MethodOptions, "gateway.test", "/photos/a.jpg", preflight("https://global.test", "PUT", ""))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "AccessForbidden")
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("bucket actual request", func(t *testing.T) {
+		w := serve(http.MethodGet, "gateway.test", "/photos/a.jpg", http.Header{"Origin": {"https://app.example.test"}})
+		assert.Equal(t, http.StatusTeapot, w.Code)
+		assert.Equal(t, "https://app.example.test", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "ETag", w.Header().Get("Access-Control-Expose-Headers"))
+
+		w = serve(http.MethodGet, "gateway.test", "/photos/a.jpg", http.Header{"Origin": {"https://elsewhere.test"}})
+		assert.Equal(t, http.StatusTeapot, w.Code)
+		assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+
+		w = serve(http.MethodDelete, "gateway.test", "/photos/a.jpg", http.Header{"Origin": {"https://app.example.test"}})
+		assert.Equal(t, http.StatusTeapot, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("global fallback", func(t *testing.T) {
+		w := serve(http.MethodOptions, "other.gateway.test", "/a.jpg", preflight("https://global.test", "PATCH", ""))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "https://global.test", w.Header().Get("Access-Control-Allow-Origin"))
+
+		w = serve(http.MethodGet, "other.gateway.test", "/a.jpg", http.Header{"Origin": {"https://app.example.test"}})
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+		// the global policy applies while bucket configurations can't be
+		// looked up.
+		node.mu.Lock()
+		node.fail = true
+		node.mu.Unlock()
+		w = serve(http.MethodGet, "gateway.test", "/unknown/a.jpg", http.Header{"Origin": {"https://global.test"}})
+		assert.Equal(t, "https://global.test", w.Header().Get("Access-Control-Allow-Origin"))
+		node.mu.Lock()
+		node.fail = false
+		node.mu.Unlock()
+	})
+
+	t.Run("lookup limits", func(t *testing.T) {
+		limited := NewBucketCORS(zaptest.NewLogger(t), DwsConfig{
+			DwsBackendHost:      server.URL,
+			DwsNodeToken:        "token",
+			CorsCacheExpiration: time.Hour,
+			CorsCacheCapacity:   10,
+		})
+		rateLimiter := middleware.NewRateLimiter(zaptest.NewLogger(t), middleware.RateLimitConfig{
+			IP: middleware.RateLimit{Requests: 1},
+		}, nil, nil, trustedip.NewListUntrustAll(), nil, RateLimited)
+		handler := CorsHandler([]string{"https://global.test"}, []string{"gateway.test"}, limited, rateLimiter)(next)
+
+		serve := func(ip string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodGet, "/photos/a.jpg", nil)
+			r.Host = "gateway.test"
+			r.RemoteAddr = ip + ":1234"
+			r.Header.Set("Origin", "https://app.example.test")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			return w
+		}
+
+		// configurations that aren't cached can't be looked up while all
+		// lookups are in progress, so the global policy applies.
+		w := serve("10.0.0.1")
+		assert.Equal(t, http.StatusTeapot, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+		_, err := limited.get(ctx, "photos")
+		require.NoError(t, err)
+		w = serve("10.0.0.2")
+		assert.Equal(t, http.StatusTeapot, w.Code)
+		assert.Equal(t, "https://app.example.test", w.Header().Get("Access-Control-Allow-Origin"))
+
+		// clients over their limit don't get to look configurations up.
+		w = serve("10.0.0.2")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, bucketCORS.delete(ctx, "photos"))
+		w := serve(http.MethodGet, "gateway.test", "/photos/a.jpg", http.Header{"Origin": {"https://app.example.test"}})
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+		config, err := bucketCORS.get(ctx, "photos")
+		require.NoError(t, err)
+		assert.Nil(t, config)
+	})
+}
+
+func TestDeleteBucketHandlerOwner(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	// requests are authenticated with the credentials in their context.
+	StartMinio(false)
+
+	// the node has user a's bucket and its configurations.
+	node := &testBucketConfigNode{configs: map[string]string{"photos": ""}}
+	for _, subresource := range []string{"cors", "logging", "notification", "lifecycle", "policy"} {
+		node.configs["photos/"+subresource] = "config"
+	}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	config := DwsConfig{DwsBackendHost: server.URL, DwsNodeToken: "token"}
+	log := zaptest.NewLogger(t)
+	h := objectAPIHandlersWrapper{
+		core: cmd.ObjectAPIHandlers{
+			ObjectAPI: func() cmd.ObjectLayer {
+				return &testBucketMarkerLayer{markers: map[string]bool{"a/photos": true}}
+			},
+		},
+		httpClient:         server.Client(),
+		dwsClient:          &testDWSClient{buckets: map[string]string{"akey": "a", "bkey": "b"}},
+		nodeHost:           server.URL,
+		nodeToken:          "token",
+		logger:             log.Sugar(),
+		bucketCORS:         NewBucketCORS(log, config),
+		bucketLogging:      NewBucketLogging(log, config),
+		bucketNotification: NewBucketNotification(log, config),
+		bucketLifecycle:    NewBucketLifecycle(log, config),
+		bucketPolicy:       NewBucketPolicy(log, config),
+	}
+
+	// user b deleting the bucket of user a doesn't find it.
+	r := httptest.NewRequest(http.MethodDelete, "https://gateway.test/photos", nil)
+	r.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	r = signer.SignV4(*r, "bkey", "bsecretkey", "", "us-east-1")
+	r = r.WithContext(middleware.WithCredentials(ctx, &middleware.Credentials{
+		AccessKey:           "bkey",
+		AuthServiceResponse: authclient.AuthServiceResponse{SecretKey: "bsecretkey"},
+	}))
+	r = mux.SetURLVars(r, map[string]string{VarKeyBucket: "photos"})
+	rec := httptest.NewRecorder()
+	h.DeleteBucketHandler(rec, r)
+	assert.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), "<Code>NoSuchBucket</Code>")
+
+	assert.Len(t, node.configs, 6)
+}
+
+// testBucketMarkerLayer is an object layer with the bucket marker objects
+// (see PutBucketHandler) in markers, by user and bucket.
+type testBucketMarkerLayer struct {
+	cmd.ObjectLayer
+
+	markers map[string]bool
+}
+
+func (l *testBucketMarkerLayer) GetObjectInfo(ctx context.Context, bucket, object string, opts cmd.ObjectOptions) (cmd.ObjectInfo, error) {
+	if !l.markers[bucket+"/"+object] {
+		return cmd.ObjectInfo{}, cmd.ObjectNotFound{Bucket: bucket, Object: object}
+	}
+	return cmd.ObjectInfo{Bucket: bucket, Name: object}, nil
+}
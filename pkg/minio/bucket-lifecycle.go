@@ -0,0 +1,268 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/common/lrucache"
+	"storj.io/gateway-mt/pkg/server/gw"
+	"storj.io/minio/cmd"
+	xhttp "storj.io/minio/cmd/http"
+	"storj.io/minio/pkg/bucket/lifecycle"
+)
+
+// objectTagsMetadataKey is the custom metadata key storj.io/gateway stores the
+// tags of objects under.
+const objectTagsMetadataKey = "s3:tags"
+
+// maxLifecycleConfigurationSize is the largest lifecycle configuration
+// accepted by PutBucketLifecycleConfiguration. It fits the 1000 rules S3
+// allows.
+const maxLifecycleConfigurationSize = 512 << 10
+
+// ErrLifecycleConfiguration is returned for invalid lifecycle configurations,
+// and for the ones with rules the gateway can't enforce.
+var ErrLifecycleConfiguration = errs.Class("invalid lifecycle configuration")
+
+// parseLifecycleConfiguration parses and validates an S3 lifecycle
+// configuration. Only Expiration actions with Days or Date are supported, as
+// they're applied by setting the expiration of objects when they're uploaded.
+// Other actions would need objects to be visited after they're uploaded, so
+// configurations with them are refused rather than silently ignored. For the
+// same reason, Date actions must be after now, unless it's zero, as it is for
+// stored configurations.
+func parseLifecycleConfiguration(data []byte, now time.Time) (*lifecycle.Lifecycle, error) {
+	config, err := lifecycle.ParseLifecycleConfig(bytes.NewReader(data))
+	if err != nil {
+		if errors.As(err, &lifecycle.Error{}) {
+			return nil, ErrLifecycleConfiguration.Wrap(err)
+		}
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, ErrLifecycleConfiguration.Wrap(err)
+	}
+
+	// lifecycle.Rule ignores AbortIncompleteMultipartUpload actions.
+	var unsupported struct {
+		Rules []struct {
+			AbortIncompleteMultipartUpload *struct{} `xml:"AbortIncompleteMultipartUpload"`
+		} `xml:"Rule"`
+	}
+	if err := xml.Unmarshal(data, &unsupported); err != nil {
+		return nil, err
+	}
+
+	for i, rule := range config.Rules {
+		switch {
+		case !rule.Transition.IsNull():
+			return nil, ErrLifecycleConfiguration.New("Transition actions are not supported")
+		case !rule.NoncurrentVersionExpiration.IsDaysNull() || !rule.NoncurrentVersionTransition.IsDaysNull():
+			return nil, ErrLifecycleConfiguration.New("noncurrent version actions are not supported")
+		case i < len(unsupported.Rules) && unsupported.Rules[i].AbortIncompleteMultipartUpload != nil:
+			return nil, ErrLifecycleConfiguration.New("AbortIncompleteMultipartUpload actions are not supported")
+		case rule.Expiration.IsNull():
+			return nil, ErrLifecycleConfiguration.New("only Expiration actions with Days or Date are supported")
+		case !now.IsZero() && !rule.Expiration.IsDateNull() && !rule.Expiration.Date.After(now):
+			return nil, ErrLifecycleConfiguration.New("Expiration Date must be in the future")
+		}
+	}
+
+	return config, nil
+}
+
+// lifecycleHasTagFilters returns whether rules of config filter objects by
+// their tags.
+func lifecycleHasTagFilters(config *lifecycle.Lifecycle) bool {
+	for _, rule := range config.Rules {
+		if rule.Status == lifecycle.Enabled && rule.Tags() != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// lifecycleExpiration returns when an object named key, tagged with tagging
+// (as sent in x-amz-tagging) and created at now, expires according to config,
+// or the zero time if it doesn't. Rules whose Date has passed since the
+// configuration was set are ignored: objects can't be uploaded already
+// expired, and S3 would only remove them some time after they were uploaded.
+func lifecycleExpiration(config *lifecycle.Lifecycle, key, tagging string, now time.Time) time.Time {
+	var expires time.Time
+	for _, rule := range config.FilterActionableRules(lifecycle.ObjectOpts{
+		Name:     key,
+		UserTags: lifecycleUserTags(tagging),
+		ModTime:  now,
+		IsLatest: true,
+	}) {
+		var ruleExpires time.Time
+		switch {
+		case !rule.Expiration.IsDateNull():
+			if !rule.Expiration.Date.After(now) {
+				continue
+			}
+			ruleExpires = rule.Expiration.Date.Time
+		case !rule.Expiration.IsDaysNull():
+			ruleExpires = lifecycle.ExpectedExpiryTime(now, int(rule.Expiration.Days))
+		default:
+			continue
+		}
+		if expires.IsZero() || ruleExpires.Before(expires) {
+			expires = ruleExpires
+		}
+	}
+	return expires
+}
+
+// lifecycleUserTags converts URL-encoded tags, as sent in x-amz-tagging, to
+// the unescaped key=value pairs lifecycle rules are matched against.
+func lifecycleUserTags(tagging string) string {
+	values, err := url.ParseQuery(tagging)
+	if err != nil {
+		return ""
+	}
+	var tags []string
+	for key, vs := range values {
+		for _, value := range vs {
+			tags = append(tags, key+"="+value)
+		}
+	}
+	return strings.Join(tags, "&")
+}
+
+// BucketLifecycle stores the lifecycle configurations of buckets in the DWS
+// node's bucket registry.
+type BucketLifecycle struct {
+	log   *zap.Logger
+	node  nodeBucketConfig
+	cache *lrucache.ExpiringLRUOf[*lifecycle.Lifecycle]
+}
+
+// NewBucketLifecycle returns a BucketLifecycle that talks to the DWS node
+// configured by config.
+func NewBucketLifecycle(log *zap.Logger, config DwsConfig) *BucketLifecycle {
+	return &BucketLifecycle{
+		log:  log,
+		node: newNodeBucketConfig(config, "lifecycle", "lifecycle"),
+		cache: lrucache.NewOf[*lifecycle.Lifecycle](lrucache.Options{
+			Expiration: config.LifecycleCacheExpiration,
+			Capacity:   config.LifecycleCacheCapacity,
+		}),
+	}
+}
+
+// get returns the lifecycle configuration of bucket, or nil if it has none.
+// Configurations are cached, like CORS configurations.
+func (c *BucketLifecycle) get(ctx context.Context, bucket string) (_ *lifecycle.Lifecycle, err error) {
+	defer mon.Task()(&ctx)(&err)
+	return c.cache.Get(ctx, bucket, func() (*lifecycle.Lifecycle, error) {
+		return c.fetch(ctx, bucket)
+	})
+}
+
+// fetch returns the lifecycle configuration of bucket from the node.
+func (c *BucketLifecycle) fetch(ctx context.Context, bucket string) (_ *lifecycle.Lifecycle, err error) {
+	data, err := c.node.get(ctx, bucket)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return parseLifecycleConfiguration(data, time.Time{})
+}
+
+// put sets the lifecycle configuration of bucket.
+func (c *BucketLifecycle) put(ctx context.Context, bucket string, config *lifecycle.Lifecycle) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	data, err := xml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err := c.node.put(ctx, bucket, "application/xml", data); err != nil {
+		return err
+	}
+
+	c.cache.Add(ctx, bucket, config)
+	return nil
+}
+
+// delete removes the lifecycle configuration of bucket.
+func (c *BucketLifecycle) delete(ctx context.Context, bucket string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if err := c.node.delete(ctx, bucket); err != nil {
+		return err
+	}
+
+	c.cache.Add(ctx, bucket, nil)
+	return nil
+}
+
+// withLifecycleExpiration returns r with a context in which the object it
+// creates expires according to the lifecycle configuration of bucket, if any.
+// tagging returns the tags of the object, as sent in x-amz-tagging. It's only
+// called for configurations filtering objects by their tags.
+func (h objectAPIHandlersWrapper) withLifecycleExpiration(w http.ResponseWriter, r *http.Request, fName, bucket, key string, tagging func() (string, error)) (*http.Request, error) {
+	ctx := r.Context()
+	errCtx := cmd.NewContext(r, w, fName)
+
+	config, err := h.bucketLifecycle.get(ctx, bucket)
+	if err != nil {
+		h.logger.With("bucket", bucket).Errorf("failed to get bucket lifecycle configuration: %s", err)
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+		return nil, err
+	}
+	if config == nil {
+		return r, nil
+	}
+
+	var tags string
+	if lifecycleHasTagFilters(config) {
+		if tags, err = tagging(); err != nil {
+			cmd.WriteErrorResponse(errCtx, w, cmd.ToAPIError(errCtx, err), r.URL, false)
+			return nil, err
+		}
+	}
+
+	expires := lifecycleExpiration(config, key, tags, time.Now())
+	if expires.IsZero() {
+		return r, nil
+	}
+	return r.WithContext(gw.WithObjectExpiration(ctx, expires)), nil
+}
+
+// copySourceTagging returns the tags of the source of a CopyObject request
+// whose bucket has been substituted, as sent in x-amz-tagging.
+func (h objectAPIHandlersWrapper) copySourceTagging(r *http.Request) (string, error) {
+	if strings.EqualFold(r.Header.Get(xhttp.AmzTagDirective), "REPLACE") {
+		return r.Header.Get(xhttp.AmzObjectTagging), nil
+	}
+
+	source := r.Header.Get(xhttp.AmzCopySource)
+	if i := strings.IndexByte(source, '?'); i >= 0 {
+		source = source[:i]
+	}
+	source, err := url.PathUnescape(source)
+	if err != nil {
+		return "", err
+	}
+
+	// the source is in the same user's bucket as the destination.
+	info, err := h.core.ObjectAPI().GetObjectInfo(r.Context(), mux.Vars(r)[VarKeyBucket], strings.TrimPrefix(source, Sep), cmd.ObjectOptions{})
+	if err != nil {
+		return "", err
+	}
+	return info.UserDefined[objectTagsMetadataKey], nil
+}
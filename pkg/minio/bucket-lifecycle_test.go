@@ -0,0 +1,102 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testLifecycleConfiguration = `<LifecycleConfiguration>
+	<Rule>
+		<ID>logs</ID>
+		<Status>Enabled</Status>
+		<Filter><Prefix>logs/</Prefix></Filter>
+		<Expiration><Days>30</Days></Expiration>
+	</Rule>
+	<Rule>
+		<ID>tmp</ID>
+		<Status>Enabled</Status>
+		<Filter><And><Prefix>logs/</Prefix><Tag><Key>class</Key><Value>tmp file</Value></Tag></And></Filter>
+		<Expiration><Days>1</Days></Expiration>
+	</Rule>
+	<Rule>
+		<ID>archive</ID>
+		<Status>Enabled</Status>
+		<Filter><Prefix>archive/</Prefix></Filter>
+		<Expiration><Date>2030-01-01T00:00:00Z</Date></Expiration>
+	</Rule>
+	<Rule>
+		<ID>disabled</ID>
+		<Status>Disabled</Status>
+		<Filter><Prefix></Prefix></Filter>
+		<Expiration><Days>1</Days></Expiration>
+	</Rule>
+</LifecycleConfiguration>`
+
+func TestParseLifecycleConfiguration(t *testing.T) {
+	now := time.Date(2023, 5, 21, 13, 42, 50, 0, time.UTC)
+
+	config, err := parseLifecycleConfiguration([]byte(testLifecycleConfiguration), now)
+	require.NoError(t, err)
+	require.Len(t, config.Rules, 4)
+	assert.True(t, lifecycleHasTagFilters(config))
+
+	// stored configurations are parsed again when they're fetched.
+	data, err := xml.Marshal(config)
+	require.NoError(t, err)
+	stored, err := parseLifecycleConfiguration(data, time.Time{})
+	require.NoError(t, err)
+	assert.Len(t, stored.Rules, 4)
+
+	for _, invalid := range []string{
+		`<LifecycleConfiguration></LifecycleConfiguration>`,
+		`<LifecycleConfiguration><Rule><Status>Enabled</Status><Filter></Filter>` +
+			`<Transition><Days>1</Days><StorageClass>GLACIER</StorageClass></Transition></Rule></LifecycleConfiguration>`,
+		`<LifecycleConfiguration><Rule><Status>Enabled</Status><Filter></Filter>` +
+			`<NoncurrentVersionExpiration><NoncurrentDays>1</NoncurrentDays></NoncurrentVersionExpiration></Rule></LifecycleConfiguration>`,
+		`<LifecycleConfiguration><Rule><Status>Enabled</Status><Filter></Filter><Expiration><Days>1</Days></Expiration>` +
+			`<AbortIncompleteMultipartUpload><DaysAfterInitiation>1</DaysAfterInitiation></AbortIncompleteMultipartUpload></Rule></LifecycleConfiguration>`,
+		`<LifecycleConfiguration><Rule><Status>Enabled</Status><Filter></Filter>` +
+			`<Expiration><ExpiredObjectDeleteMarker>true</ExpiredObjectDeleteMarker></Expiration></Rule></LifecycleConfiguration>`,
+		`<LifecycleConfiguration><Rule><Status>Enabled</Status><Filter></Filter>` +
+			`<Expiration><Date>2030-01-01T12:00:00Z</Date></Expiration></Rule></LifecycleConfiguration>`,
+		`<LifecycleConfiguration><Rule><Status>Enabled</Status><Filter></Filter>` +
+			`<Expiration><Date>2023-05-21T00:00:00Z</Date></Expiration></Rule></LifecycleConfiguration>`,
+	} {
+		_, err := parseLifecycleConfiguration([]byte(invalid), now)
+		assert.True(t, ErrLifecycleConfiguration.Has(err), invalid)
+	}
+
+	_, err = parseLifecycleConfiguration([]byte(`<LifecycleConfiguration>`), now)
+	require.Error(t, err)
+	assert.False(t, ErrLifecycleConfiguration.Has(err))
+
+	// configurations can't be set once their dates passed, but stored ones
+	// stay valid.
+	_, err = parseLifecycleConfiguration([]byte(testLifecycleConfiguration), time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Error(t, err)
+	_, err = parseLifecycleConfiguration([]byte(testLifecycleConfiguration), time.Time{})
+	require.NoError(t, err)
+}
+
+func TestLifecycleExpiration(t *testing.T) {
+	now := time.Date(2023, 5, 21, 13, 42, 50, 0, time.UTC)
+	config, err := parseLifecycleConfiguration([]byte(testLifecycleConfiguration), now)
+	require.NoError(t, err)
+
+	day := 24 * time.Hour
+	midnight := time.Date(2023, 5, 22, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, midnight.Add(30*day), lifecycleExpiration(config, "logs/a.log", "", now))
+	assert.Equal(t, midnight.Add(day), lifecycleExpiration(config, "logs/a.log", "class=tmp%20file&b=c", now))
+	assert.Equal(t, midnight.Add(30*day), lifecycleExpiration(config, "logs/a.log", "class=other", now))
+	assert.Equal(t, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), lifecycleExpiration(config, "archive/a", "", now))
+	assert.Zero(t, lifecycleExpiration(config, "archive/a", "", time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Zero(t, lifecycleExpiration(config, "other/a", "", now))
+}
@@ -0,0 +1,183 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/common/lrucache"
+)
+
+// loggingXMLNamespace is the namespace of S3 bucket logging documents.
+const loggingXMLNamespace = "http://doc.s3.amazonaws.com/2006-03-01"
+
+// maxLoggingStatusSize is the largest bucket logging status accepted by
+// PutBucketLogging.
+const maxLoggingStatusSize = 64 << 10
+
+// maxLoggingTargetPrefixSize is the longest target prefix accepted, so that
+// log object keys stay within the limits of object keys.
+const maxLoggingTargetPrefixSize = 512
+
+// ErrLoggingStatus is returned for invalid bucket logging statuses.
+var ErrLoggingStatus = errs.Class("invalid bucket logging status")
+
+// bucketLoggingStatus is the S3 BucketLoggingStatus document. Logging is
+// disabled when LoggingEnabled is missing.
+type bucketLoggingStatus struct {
+	XMLName        xml.Name        `xml:"BucketLoggingStatus"`
+	XMLNS          string          `xml:"xmlns,attr,omitempty"`
+	LoggingEnabled *loggingEnabled `xml:"LoggingEnabled,omitempty"`
+}
+
+type loggingEnabled struct {
+	TargetBucket string `xml:"TargetBucket"`
+	TargetPrefix string `xml:"TargetPrefix"`
+}
+
+// parseBucketLoggingStatus parses and validates an S3 BucketLoggingStatus.
+// Whether the target bucket exists is up to the caller.
+func parseBucketLoggingStatus(data []byte) (*bucketLoggingStatus, error) {
+	var status bucketLoggingStatus
+	if err := xml.Unmarshal(data, &status); err != nil {
+		return nil, err
+	}
+	if enabled := status.LoggingEnabled; enabled != nil {
+		if enabled.TargetBucket == "" {
+			return nil, ErrLoggingStatus.New("TargetBucket is required")
+		}
+		if len(enabled.TargetPrefix) > maxLoggingTargetPrefixSize {
+			return nil, ErrLoggingStatus.New("TargetPrefix is longer than %d bytes", maxLoggingTargetPrefixSize)
+		}
+	}
+	return &status, nil
+}
+
+// bucketLoggingConfig is the logging configuration of a bucket as it's stored.
+// Besides the target, it keeps what's needed to write logs on behalf of the
+// bucket's owner: the access key that enabled logging, whose access grant logs
+// are written with, and the DWS user the buckets belong to.
+type bucketLoggingConfig struct {
+	TargetBucket string `json:"targetBucket"`
+	TargetPrefix string `json:"targetPrefix"`
+	AccessKey    string `json:"accessKey"`
+	Owner        string `json:"owner"`
+}
+
+// status returns the S3 BucketLoggingStatus describing config.
+func (config *bucketLoggingConfig) status() *bucketLoggingStatus {
+	status := &bucketLoggingStatus{XMLNS: loggingXMLNamespace}
+	if config != nil {
+		status.LoggingEnabled = &loggingEnabled{
+			TargetBucket: config.TargetBucket,
+			TargetPrefix: config.TargetPrefix,
+		}
+	}
+	return status
+}
+
+// BucketLogging stores the server access logging configurations of buckets in
+// the DWS node's bucket registry, next to their CORS configurations.
+type BucketLogging struct {
+	log   *zap.Logger
+	node  nodeBucketConfig
+	cache *lrucache.ExpiringLRUOf[*bucketLoggingConfig]
+	// lookups bounds the lookups made for access logs.
+	lookups chan struct{}
+}
+
+// errLoggingLookupsExhausted is returned by lookup when too many lookups are
+// in progress.
+var errLoggingLookupsExhausted = errs.New("too many bucket logging lookups in progress")
+
+// NewBucketLogging returns a BucketLogging that talks to the DWS node
+// configured by config.
+func NewBucketLogging(log *zap.Logger, config DwsConfig) *BucketLogging {
+	return &BucketLogging{
+		log:  log,
+		node: newNodeBucketConfig(config, "logging", "logging"),
+		cache: lrucache.NewOf[*bucketLoggingConfig](lrucache.Options{
+			Expiration: config.LoggingCacheExpiration,
+			Capacity:   config.LoggingCacheCapacity,
+		}),
+		lookups: make(chan struct{}, config.LoggingLookupLimit),
+	}
+}
+
+// get returns the logging configuration of bucket, or nil if logging is
+// disabled. Configurations are cached, like CORS configurations, and so is
+// logging being disabled.
+func (c *BucketLogging) get(ctx context.Context, bucket string) (_ *bucketLoggingConfig, err error) {
+	defer mon.Task()(&ctx)(&err)
+	return c.cache.Get(ctx, bucket, func() (*bucketLoggingConfig, error) {
+		return c.fetch(ctx, bucket)
+	})
+}
+
+// lookup is like get, but for the access logs of requests. As they're
+// recorded for every request naming a bucket, at most the lookup limit of them
+// ask the node at once; the others fail with errLoggingLookupsExhausted.
+func (c *BucketLogging) lookup(ctx context.Context, bucket string) (_ *bucketLoggingConfig, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if config, cached := c.cache.GetCached(ctx, bucket); cached {
+		return config, nil
+	}
+
+	select {
+	case c.lookups <- struct{}{}:
+		defer func() { <-c.lookups }()
+	default:
+		mon.Event("bucket_logging_lookups_exhausted")
+		return nil, errLoggingLookupsExhausted
+	}
+
+	return c.get(ctx, bucket)
+}
+
+// fetch returns the logging configuration of bucket from the node.
+func (c *BucketLogging) fetch(ctx context.Context, bucket string) (_ *bucketLoggingConfig, err error) {
+	data, err := c.node.get(ctx, bucket)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var config bucketLoggingConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// put sets the logging configuration of bucket.
+func (c *BucketLogging) put(ctx context.Context, bucket string, config *bucketLoggingConfig) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err := c.node.put(ctx, bucket, "application/json", data); err != nil {
+		return err
+	}
+
+	c.cache.Add(ctx, bucket, config)
+	return nil
+}
+
+// delete disables logging for bucket.
+func (c *BucketLogging) delete(ctx context.Context, bucket string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if err := c.node.delete(ctx, bucket); err != nil {
+		return err
+	}
+
+	c.cache.Add(ctx, bucket, nil)
+	return nil
+}
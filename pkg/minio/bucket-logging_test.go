@@ -0,0 +1,162 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minio/minio-go/v7/pkg/signer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/common/testcontext"
+	"storj.io/gateway-mt/pkg/authclient"
+	"storj.io/gateway-mt/pkg/server/middleware"
+	"storj.io/minio/cmd"
+	"storj.io/minio/cmd/config"
+)
+
+func TestParseBucketLoggingStatus(t *testing.T) {
+	status, err := parseBucketLoggingStatus([]byte(`<BucketLoggingStatus xmlns="http://doc.s3.amazonaws.com/2006-03-01">
+		<LoggingEnabled>
+			<TargetBucket>logs</TargetBucket>
+			<TargetPrefix>photos/</TargetPrefix>
+		</LoggingEnabled>
+	</BucketLoggingStatus>`))
+	require.NoError(t, err)
+	require.NotNil(t, status.LoggingEnabled)
+	assert.Equal(t, "logs", status.LoggingEnabled.TargetBucket)
+	assert.Equal(t, "photos/", status.LoggingEnabled.TargetPrefix)
+
+	status, err = parseBucketLoggingStatus([]byte(`<BucketLoggingStatus xmlns="http://doc.s3.amazonaws.com/2006-03-01"/>`))
+	require.NoError(t, err)
+	assert.Nil(t, status.LoggingEnabled)
+
+	_, err = parseBucketLoggingStatus([]byte(`<BucketLoggingStatus><LoggingEnabled></LoggingEnabled></BucketLoggingStatus>`))
+	assert.True(t, ErrLoggingStatus.Has(err))
+
+	_, err = parseBucketLoggingStatus([]byte(`<BucketLoggingStatus><LoggingEnabled><TargetBucket>logs</TargetBucket><TargetPrefix>` +
+		strings.Repeat("a", maxLoggingTargetPrefixSize+1) + `</TargetPrefix></LoggingEnabled></BucketLoggingStatus>`))
+	assert.True(t, ErrLoggingStatus.Has(err))
+
+	_, err = parseBucketLoggingStatus([]byte(`<BucketLoggingStatus>`))
+	require.Error(t, err)
+	assert.False(t, ErrLoggingStatus.Has(err))
+}
+
+func TestBucketLoggingStatusXML(t *testing.T) {
+	var config *bucketLoggingConfig
+	data, err := xml.Marshal(config.status())
+	require.NoError(t, err)
+	assert.Equal(t, `<BucketLoggingStatus xmlns="http://doc.s3.amazonaws.com/2006-03-01"></BucketLoggingStatus>`, string(data))
+
+	config = &bucketLoggingConfig{TargetBucket: "logs", TargetPrefix: "photos/", AccessKey: "access", Owner: "user"}
+	data, err = xml.Marshal(config.status())
+	require.NoError(t, err)
+	assert.Equal(t, `<BucketLoggingStatus xmlns="http://doc.s3.amazonaws.com/2006-03-01"><LoggingEnabled>`+
+		`<TargetBucket>logs</TargetBucket><TargetPrefix>photos/</TargetPrefix></LoggingEnabled></BucketLoggingStatus>`, string(data))
+}
+
+func TestBucketLogging(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	node := &testBucketConfigNode{configs: map[string]string{}}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	bucketLogging := NewBucketLogging(zaptest.NewLogger(t), DwsConfig{
+		DwsBackendHost:         server.URL,
+		DwsNodeToken:           "token",
+		LoggingCacheExpiration: time.Hour,
+		LoggingCacheCapacity:   10,
+		LoggingLookupLimit:     1,
+	})
+
+	config, err := bucketLogging.get(ctx, "photos")
+	require.NoError(t, err)
+	assert.Nil(t, config)
+
+	expected := &bucketLoggingConfig{TargetBucket: "logs", TargetPrefix: "photos/", AccessKey: "access", Owner: "user"}
+	require.NoError(t, bucketLogging.put(ctx, "photos", expected))
+	assert.Contains(t, node.configs, "photos/logging")
+
+	// the configuration is read back from the node once the cache expires.
+	config, err = bucketLogging.fetch(ctx, "photos")
+	require.NoError(t, err)
+	assert.Equal(t, expected, config)
+
+	require.NoError(t, bucketLogging.delete(ctx, "photos"))
+	assert.NotContains(t, node.configs, "photos/logging")
+	config, err = bucketLogging.get(ctx, "photos")
+	require.NoError(t, err)
+	assert.Nil(t, config)
+
+	// lookups over the limit fail, unless they're cached, including the
+	// absence of a configuration.
+	bucketLogging.lookups <- struct{}{}
+	_, err = bucketLogging.lookup(ctx, "other")
+	assert.True(t, errs.Is(err, errLoggingLookupsExhausted))
+	config, err = bucketLogging.lookup(ctx, "photos")
+	require.NoError(t, err)
+	assert.Nil(t, config)
+	<-bucketLogging.lookups
+	config, err = bucketLogging.lookup(ctx, "other")
+	require.NoError(t, err)
+	assert.Nil(t, config)
+}
+
+func TestPutBucketLoggingTemporaryCredentials(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	// requests are authenticated with the credentials in their context.
+	StartMinio(false)
+	t.Setenv(config.EnvRootPassword, "sessionsecret")
+
+	node := &testBucketConfigNode{configs: map[string]string{}}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	log := zaptest.NewLogger(t)
+	h := objectAPIHandlersWrapper{
+		core: cmd.ObjectAPIHandlers{
+			ObjectAPI: func() cmd.ObjectLayer {
+				return &testBucketMarkerLayer{markers: map[string]bool{"user/photos": true, "user/logs": true}}
+			},
+		},
+		logger: log.Sugar(),
+		bucketLogging: NewBucketLogging(log, DwsConfig{
+			DwsBackendHost:         server.URL,
+			DwsNodeToken:           "token",
+			LoggingCacheExpiration: time.Hour,
+			LoggingCacheCapacity:   10,
+		}),
+	}
+
+	// logs are written after the temporary credentials would have expired.
+	token, err := newSessionToken("tempaccesskey", "user", time.Now().Add(time.Hour), "sessionsecret")
+	require.NoError(t, err)
+	body := `<BucketLoggingStatus><LoggingEnabled><TargetBucket>logs</TargetBucket><TargetPrefix>photos/</TargetPrefix></LoggingEnabled></BucketLoggingStatus>`
+	r := httptest.NewRequest(http.MethodPut, "https://gateway.test/photos?logging", strings.NewReader(body))
+	r.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	r = signer.SignV4(*r, "tempaccesskey", "tempsecretkey", token, "us-east-1")
+	r = r.WithContext(middleware.WithCredentials(ctx, &middleware.Credentials{
+		AccessKey:           "tempaccesskey",
+		AuthServiceResponse: authclient.AuthServiceResponse{SecretKey: "tempsecretkey"},
+		SessionToken:        token,
+	}))
+	r = mux.SetURLVars(r, map[string]string{VarKeyBucket: "photos"})
+	rec := httptest.NewRecorder()
+	h.PutBucketLoggingHandler(rec, r)
+	assert.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), "temporary credentials")
+	assert.Empty(t, node.configs)
+}
@@ -0,0 +1,264 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"net/url"
+	"strings"
+
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/common/lrucache"
+	"storj.io/gateway-mt/pkg/server/gw"
+)
+
+// notificationXMLNamespace is the namespace of S3 notification configurations.
+const notificationXMLNamespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// maxNotificationConfigurationSize is the largest notification configuration
+// accepted by PutBucketNotificationConfiguration.
+const maxNotificationConfigurationSize = 64 << 10
+
+// maxNotificationTopics is how many webhooks a bucket can notify.
+const maxNotificationTopics = 100
+
+// ErrNotificationConfiguration is returned for invalid notification
+// configurations.
+var ErrNotificationConfiguration = errs.Class("invalid notification configuration")
+
+// notificationEvents are the event types buckets can be notified of, and the
+// wildcards matching them.
+var notificationEvents = map[string]bool{
+	"s3:ObjectCreated:*":                         true,
+	gw.EventObjectCreatedPut:                     true,
+	gw.EventObjectCreatedCopy:                    true,
+	gw.EventObjectCreatedCompleteMultipartUpload: true,
+	"s3:ObjectRemoved:*":                         true,
+	gw.EventObjectRemovedDelete:                  true,
+}
+
+// notificationConfiguration is the S3 NotificationConfiguration document.
+// Webhooks are topics whose ARN is the URL they're delivered to. Queues and
+// Lambda functions aren't supported.
+type notificationConfiguration struct {
+	XMLName         xml.Name            `xml:"NotificationConfiguration" json:"-"`
+	XMLNS           string              `xml:"xmlns,attr,omitempty" json:"-"`
+	Topics          []notificationTopic `xml:"TopicConfiguration" json:"topics"`
+	Queues          []struct{}          `xml:"QueueConfiguration" json:"-"`
+	CloudFunctions  []struct{}          `xml:"CloudFunctionConfiguration" json:"-"`
+	LambdaFunctions []struct{}          `xml:"LambdaFunctionConfiguration" json:"-"`
+}
+
+type notificationTopic struct {
+	ID     string              `xml:"Id,omitempty" json:"id"`
+	Topic  string              `xml:"Topic" json:"topic"`
+	Events []string            `xml:"Event" json:"events"`
+	Filter *notificationFilter `xml:"Filter,omitempty" json:"filter,omitempty"`
+}
+
+type notificationFilter struct {
+	S3Key struct {
+		Rules []notificationFilterRule `xml:"FilterRule" json:"rules"`
+	} `xml:"S3Key" json:"s3Key"`
+}
+
+type notificationFilterRule struct {
+	Name  string `xml:"Name" json:"name"`
+	Value string `xml:"Value" json:"value"`
+}
+
+// parseNotificationConfiguration parses and validates an S3
+// NotificationConfiguration, giving IDs to the topics without one.
+func parseNotificationConfiguration(data []byte) (*notificationConfiguration, error) {
+	var config notificationConfiguration
+	if err := xml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	if len(config.Queues) > 0 || len(config.CloudFunctions) > 0 || len(config.LambdaFunctions) > 0 {
+		return nil, ErrNotificationConfiguration.New("only topic configurations with webhook URLs are supported")
+	}
+	if len(config.Topics) > maxNotificationTopics {
+		return nil, ErrNotificationConfiguration.New("at most %d topic configurations are allowed", maxNotificationTopics)
+	}
+
+	ids := make(map[string]bool)
+	for i := range config.Topics {
+		topic := &config.Topics[i]
+		if topic.ID == "" {
+			var id [16]byte
+			if _, err := rand.Read(id[:]); err != nil {
+				return nil, err
+			}
+			topic.ID = hex.EncodeToString(id[:])
+		}
+		if ids[topic.ID] {
+			return nil, ErrNotificationConfiguration.New("duplicate Id %q", topic.ID)
+		}
+		ids[topic.ID] = true
+
+		u, err := url.Parse(topic.Topic)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, ErrNotificationConfiguration.New("Topic %q is not a webhook URL", topic.Topic)
+		}
+		if len(topic.Events) == 0 {
+			return nil, ErrNotificationConfiguration.New("Event is required")
+		}
+		for _, event := range topic.Events {
+			if !notificationEvents[event] {
+				return nil, ErrNotificationConfiguration.New("unsupported Event %q", event)
+			}
+		}
+		if topic.Filter != nil {
+			seen := make(map[string]bool)
+			for j := range topic.Filter.S3Key.Rules {
+				rule := &topic.Filter.S3Key.Rules[j]
+				rule.Name = strings.ToLower(rule.Name)
+				if rule.Name != "prefix" && rule.Name != "suffix" {
+					return nil, ErrNotificationConfiguration.New("unsupported FilterRule Name %q", rule.Name)
+				}
+				if seen[rule.Name] {
+					return nil, ErrNotificationConfiguration.New("duplicate FilterRule Name %q", rule.Name)
+				}
+				seen[rule.Name] = true
+			}
+		}
+	}
+
+	config.XMLNS = notificationXMLNamespace
+	return &config, nil
+}
+
+// matches returns whether topic is for events of type event on key.
+func (topic *notificationTopic) matches(event, key string) bool {
+	if topic.Filter != nil {
+		for _, rule := range topic.Filter.S3Key.Rules {
+			switch rule.Name {
+			case "prefix":
+				if !strings.HasPrefix(key, rule.Value) {
+					return false
+				}
+			case "suffix":
+				if !strings.HasSuffix(key, rule.Value) {
+					return false
+				}
+			}
+		}
+	}
+	for _, pattern := range topic.Events {
+		if pattern == event || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(event, strings.TrimSuffix(pattern, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// bucketNotificationConfig is the notification configuration of a bucket as
+// it's stored. It keeps the secret deliveries are signed with, generated for
+// the bucket, and the DWS user the bucket belongs to.
+type bucketNotificationConfig struct {
+	Configuration *notificationConfiguration `json:"configuration"`
+	SigningSecret string                     `json:"signingSecret"`
+	Owner         string                     `json:"owner"`
+}
+
+// BucketNotification stores the notification configurations of buckets in
+// the DWS node's bucket registry.
+type BucketNotification struct {
+	log   *zap.Logger
+	node  nodeBucketConfig
+	cache *lrucache.ExpiringLRUOf[*bucketNotificationConfig]
+}
+
+// NewBucketNotification returns a BucketNotification that talks to the DWS
+// node configured by config.
+func NewBucketNotification(log *zap.Logger, config DwsConfig) *BucketNotification {
+	return &BucketNotification{
+		log:  log,
+		node: newNodeBucketConfig(config, "notification", "notification"),
+		cache: lrucache.NewOf[*bucketNotificationConfig](lrucache.Options{
+			Expiration: config.NotificationCacheExpiration,
+			Capacity:   config.NotificationCacheCapacity,
+		}),
+	}
+}
+
+// get returns the notification configuration of bucket, or nil if it has
+// none. Configurations are cached, like CORS configurations.
+func (c *BucketNotification) get(ctx context.Context, bucket string) (_ *bucketNotificationConfig, err error) {
+	defer mon.Task()(&ctx)(&err)
+	return c.cache.Get(ctx, bucket, func() (*bucketNotificationConfig, error) {
+		return c.fetch(ctx, bucket)
+	})
+}
+
+// fetch returns the notification configuration of bucket from the node.
+func (c *BucketNotification) fetch(ctx context.Context, bucket string) (_ *bucketNotificationConfig, err error) {
+	data, err := c.node.get(ctx, bucket)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var config bucketNotificationConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	if config.Configuration != nil {
+		config.Configuration.XMLNS = notificationXMLNamespace
+	}
+	return &config, nil
+}
+
+// signingSecret returns the secret deliveries of the notifications of bucket
+// are signed with. It's generated when notifications are turned on, and kept
+// as long as they are, so that updating the configuration doesn't change it.
+func (c *BucketNotification) signingSecret(ctx context.Context, bucket string) (_ string, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	config, err := c.fetch(ctx, bucket)
+	if err != nil {
+		return "", err
+	}
+	if config != nil && config.SigningSecret != "" {
+		return config.SigningSecret, nil
+	}
+
+	var secret [32]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret[:]), nil
+}
+
+// put sets the notification configuration of bucket.
+func (c *BucketNotification) put(ctx context.Context, bucket string, config *bucketNotificationConfig) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err := c.node.put(ctx, bucket, "application/json", data); err != nil {
+		return err
+	}
+
+	c.cache.Add(ctx, bucket, config)
+	return nil
+}
+
+// delete removes the notification configuration of bucket.
+func (c *BucketNotification) delete(ctx context.Context, bucket string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if err := c.node.delete(ctx, bucket); err != nil {
+		return err
+	}
+
+	c.cache.Add(ctx, bucket, nil)
+	return nil
+}
@@ -0,0 +1,145 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/common/testcontext"
+	"storj.io/gateway-mt/pkg/server/gw"
+)
+
+func TestParseNotificationConfiguration(t *testing.T) {
+	config, err := parseNotificationConfiguration([]byte(`<NotificationConfiguration>
+		<TopicConfiguration>
+			<Id>images</Id>
+			<Topic>https://hooks.example.com/images?token=secret</Topic>
+			<Event>s3:ObjectCreated:*</Event>
+			<Filter><S3Key>
+				<FilterRule><Name>Prefix</Name><Value>images/</Value></FilterRule>
+				<FilterRule><Name>suffix</Name><Value>.jpg</Value></FilterRule>
+			</S3Key></Filter>
+		</TopicConfiguration>
+		<TopicConfiguration>
+			<Topic>http://hooks.example.com/deletes</Topic>
+			<Event>s3:ObjectRemoved:Delete</Event>
+		</TopicConfiguration>
+	</NotificationConfiguration>`))
+	require.NoError(t, err)
+	require.Len(t, config.Topics, 2)
+	assert.Equal(t, "images", config.Topics[0].ID)
+	assert.Equal(t, "prefix", config.Topics[0].Filter.S3Key.Rules[0].Name)
+	assert.NotEmpty(t, config.Topics[1].ID)
+
+	for _, invalid := range []string{
+		`<NotificationConfiguration><QueueConfiguration><Queue>arn:aws:sqs:::queue</Queue></QueueConfiguration></NotificationConfiguration>`,
+		`<NotificationConfiguration><TopicConfiguration><Topic>arn:aws:sns:::topic</Topic><Event>s3:ObjectCreated:*</Event></TopicConfiguration></NotificationConfiguration>`,
+		`<NotificationConfiguration><TopicConfiguration><Topic>https://hooks.example.com</Topic></TopicConfiguration></NotificationConfiguration>`,
+		`<NotificationConfiguration><TopicConfiguration><Topic>https://hooks.example.com</Topic><Event>s3:ObjectRestore:*</Event></TopicConfiguration></NotificationConfiguration>`,
+		`<NotificationConfiguration><TopicConfiguration><Topic>https://hooks.example.com</Topic><Event>s3:ObjectCreated:*</Event>` +
+			`<Filter><S3Key><FilterRule><Name>infix</Name><Value>a</Value></FilterRule></S3Key></Filter></TopicConfiguration></NotificationConfiguration>`,
+		`<NotificationConfiguration>` +
+			`<TopicConfiguration><Id>a</Id><Topic>https://hooks.example.com</Topic><Event>s3:ObjectCreated:*</Event></TopicConfiguration>` +
+			`<TopicConfiguration><Id>a</Id><Topic>https://hooks.example.com</Topic><Event>s3:ObjectCreated:*</Event></TopicConfiguration>` +
+			`</NotificationConfiguration>`,
+	} {
+		_, err := parseNotificationConfiguration([]byte(invalid))
+		assert.True(t, ErrNotificationConfiguration.Has(err), invalid)
+	}
+
+	_, err = parseNotificationConfiguration([]byte(`<NotificationConfiguration>`))
+	require.Error(t, err)
+	assert.False(t, ErrNotificationConfiguration.Has(err))
+}
+
+func TestNotificationTopicMatches(t *testing.T) {
+	topic := notificationTopic{
+		Events: []string{"s3:ObjectCreated:*", gw.EventObjectRemovedDelete},
+		Filter: &notificationFilter{},
+	}
+	topic.Filter.S3Key.Rules = []notificationFilterRule{{Name: "prefix", Value: "images/"}, {Name: "suffix", Value: ".jpg"}}
+
+	assert.True(t, topic.matches(gw.EventObjectCreatedPut, "images/a.jpg"))
+	assert.True(t, topic.matches(gw.EventObjectCreatedCompleteMultipartUpload, "images/a.jpg"))
+	assert.True(t, topic.matches(gw.EventObjectRemovedDelete, "images/a.jpg"))
+	assert.False(t, topic.matches(gw.EventObjectCreatedPut, "images/a.png"))
+	assert.False(t, topic.matches(gw.EventObjectCreatedPut, "videos/a.jpg"))
+
+	topic = notificationTopic{Events: []string{gw.EventObjectCreatedCopy}}
+	assert.True(t, topic.matches(gw.EventObjectCreatedCopy, "a"))
+	assert.False(t, topic.matches(gw.EventObjectCreatedPut, "a"))
+}
+
+func TestNotificationConfigurationXML(t *testing.T) {
+	config, err := parseNotificationConfiguration([]byte(`<NotificationConfiguration><TopicConfiguration>` +
+		`<Id>a</Id><Topic>https://hooks.example.com</Topic><Event>s3:ObjectCreated:Put</Event>` +
+		`</TopicConfiguration></NotificationConfiguration>`))
+	require.NoError(t, err)
+
+	data, err := xml.Marshal(config)
+	require.NoError(t, err)
+	assert.Equal(t, `<NotificationConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><TopicConfiguration>`+
+		`<Id>a</Id><Topic>https://hooks.example.com</Topic><Event>s3:ObjectCreated:Put</Event>`+
+		`</TopicConfiguration></NotificationConfiguration>`, string(data))
+}
+
+func TestBucketNotification(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	node := &testBucketConfigNode{configs: map[string]string{}}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	bucketNotification := NewBucketNotification(zaptest.NewLogger(t), DwsConfig{
+		DwsBackendHost:              server.URL,
+		DwsNodeToken:                "token",
+		NotificationCacheExpiration: time.Hour,
+		NotificationCacheCapacity:   10,
+	})
+
+	config, err := bucketNotification.get(ctx, "photos")
+	require.NoError(t, err)
+	assert.Nil(t, config)
+
+	configuration, err := parseNotificationConfiguration([]byte(`<NotificationConfiguration><TopicConfiguration>` +
+		`<Id>a</Id><Topic>https://hooks.example.com</Topic><Event>s3:ObjectCreated:Put</Event>` +
+		`</TopicConfiguration></NotificationConfiguration>`))
+	require.NoError(t, err)
+	secret, err := bucketNotification.signingSecret(ctx, "photos")
+	require.NoError(t, err)
+	assert.Len(t, secret, 64)
+	expected := &bucketNotificationConfig{Configuration: configuration, SigningSecret: secret, Owner: "user"}
+	require.NoError(t, bucketNotification.put(ctx, "photos", expected))
+	assert.Contains(t, node.configs, "photos/notification")
+
+	// the configuration is read back from the node once the cache expires.
+	config, err = bucketNotification.fetch(ctx, "photos")
+	require.NoError(t, err)
+	require.NotNil(t, config.Configuration)
+	assert.Equal(t, expected.Configuration.Topics, config.Configuration.Topics)
+	assert.Equal(t, expected.SigningSecret, config.SigningSecret)
+	assert.Equal(t, expected.Owner, config.Owner)
+
+	// the signing secret is kept as long as notifications are on.
+	kept, err := bucketNotification.signingSecret(ctx, "photos")
+	require.NoError(t, err)
+	assert.Equal(t, secret, kept)
+
+	require.NoError(t, bucketNotification.delete(ctx, "photos"))
+	assert.NotContains(t, node.configs, "photos/notification")
+	config, err = bucketNotification.get(ctx, "photos")
+	require.NoError(t, err)
+	assert.Nil(t, config)
+
+	renewed, err := bucketNotification.signingSecret(ctx, "photos")
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, renewed)
+}
@@ -0,0 +1,648 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+	"github.com/minio/minio-go/v7/pkg/signer"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/common/lrucache"
+	"storj.io/gateway-mt/pkg/server/middleware"
+	"storj.io/gateway-mt/pkg/trustedip"
+	"storj.io/minio/cmd"
+	xhttp "storj.io/minio/cmd/http"
+	"storj.io/uplink"
+)
+
+// maxBucketPolicySize is the largest bucket policy accepted by
+// PutBucketPolicy, as in S3.
+const maxBucketPolicySize = 20 << 10
+
+// maxPostPolicyFormSize is the largest part of PostPolicyBucket requests read
+// for the key of the uploaded object, as in AccessKey.
+const maxPostPolicyFormSize = 5 << 20
+
+// maxDeleteObjectsRequestSize is the largest DeleteObjects request read to
+// check the bucket policy, as in minio.
+const maxDeleteObjectsRequestSize = 2 * 100000 * 1024
+
+// publicAccessRegion is the region anonymous requests allowed by bucket
+// policies are signed for, the one minio runs in.
+const publicAccessRegion = "us-east-1"
+
+// policyAction is what requests checked against bucket policies do.
+type policyAction int
+
+const (
+	policyGet policyAction = iota
+	policyPut
+	policyList
+	policyDelete
+)
+
+// allowedBy returns whether permission allows action.
+func (action policyAction) allowedBy(permission uplink.Permission) bool {
+	switch action {
+	case policyGet:
+		return permission.AllowDownload
+	case policyPut:
+		return permission.AllowUpload
+	case policyList:
+		return permission.AllowList
+	case policyDelete:
+		return permission.AllowDelete
+	default:
+		return false
+	}
+}
+
+// bucketPolicyStatement is a statement of a bucket policy, translated to the
+// permission it allows or denies on prefixes of the bucket. The empty prefix
+// is the whole bucket.
+type bucketPolicyStatement struct {
+	allow      bool
+	permission uplink.Permission
+	prefixes   []string
+}
+
+// parseBucketPolicy parses and validates the policy of bucket. Statements
+// apply to everyone, so their Principal has to be "*". Allow statements can
+// only allow public reads, with GetObject and ListBucket, as anonymous
+// requests are served with an access grant restricted to them. Deny
+// statements apply to requests with credentials too.
+func parseBucketPolicy(bucket string, data []byte) ([]bucketPolicyStatement, error) {
+	doc, err := parsePolicyDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	statements := make([]bucketPolicyStatement, 0, len(doc.Statement))
+	for _, s := range doc.Statement {
+		if !isPublicPrincipal(s.Principal) {
+			return nil, ErrPolicy.New("only the \"*\" Principal is supported")
+		}
+		permission, err := actionsPermission(s.Action)
+		if err != nil {
+			return nil, err
+		}
+		statement := bucketPolicyStatement{
+			allow:      s.Effect == "Allow",
+			permission: permission,
+		}
+		if statement.allow && (permission.AllowUpload || permission.AllowDelete) {
+			return nil, ErrPolicy.New("only s3:GetObject and s3:ListBucket can be allowed")
+		}
+		for _, resource := range s.Resource {
+			resourceBucket, prefix, err := parseResource(resource)
+			if err != nil {
+				return nil, err
+			}
+			if resourceBucket != bucket {
+				return nil, ErrPolicy.New("resource %q isn't in bucket %q", resource, bucket)
+			}
+			statement.prefixes = append(statement.prefixes, prefix)
+		}
+		statements = append(statements, statement)
+	}
+	return statements, nil
+}
+
+// isPublicPrincipal returns whether principal is everyone, as "*" or
+// {"AWS": "*"}.
+func isPublicPrincipal(principal json.RawMessage) bool {
+	var name string
+	if err := json.Unmarshal(principal, &name); err == nil {
+		return name == "*"
+	}
+	var names struct {
+		AWS stringList
+	}
+	if err := json.Unmarshal(principal, &names); err != nil {
+		return false
+	}
+	return len(names.AWS) == 1 && names.AWS[0] == "*"
+}
+
+// bucketPolicy is the policy of a bucket, as stored in the node. Anonymous
+// requests the policy allows are sent with the public credentials registered
+// for it, whose access grant is restricted to what its Allow statements
+// allow. That grant is shared from PublicParentGrant, with the same
+// restrictions, so that it can be revoked once the policy is replaced or
+// deleted. Both only allow reading what the policy makes public.
+type bucketPolicy struct {
+	Policy            json.RawMessage `json:"policy"`
+	Owner             string          `json:"owner"`
+	PublicAccessKey   string          `json:"public_access_key,omitempty"`
+	PublicAccessGrant string          `json:"public_access_grant,omitempty"`
+	PublicParentGrant string          `json:"public_parent_grant,omitempty"`
+
+	statements []bucketPolicyStatement
+}
+
+// evaluate returns whether statements of p allow and deny action on keys,
+// which are object keys or the prefix of listed objects.
+func (p *bucketPolicy) evaluate(action policyAction, keys []string) (allowed, denied bool) {
+	allowed = len(keys) > 0
+	for _, key := range keys {
+		var keyAllowed bool
+		for _, statement := range p.statements {
+			if !action.allowedBy(statement.permission) || !statementMatches(statement, action, key) {
+				continue
+			}
+			if !statement.allow {
+				return false, true
+			}
+			keyAllowed = true
+		}
+		allowed = allowed && keyAllowed
+	}
+	return allowed, false
+}
+
+// statementMatches returns whether key is in a prefix of statement. Listing
+// the prefix key also lists the objects of the prefixes that start with it,
+// so Deny statements match them too for list actions.
+func statementMatches(statement bucketPolicyStatement, action policyAction, key string) bool {
+	for _, prefix := range statement.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+		if action == policyList && !statement.allow && strings.HasPrefix(prefix, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// publicRestriction returns the permission and prefixes of the access grant
+// anonymous requests are served with. It allows what any Allow statement
+// allows on any of their prefixes: requests are checked against the policy
+// before they're served.
+func (p *bucketPolicy) publicRestriction(bucket string) (permission uplink.Permission, prefixes []uplink.SharePrefix) {
+	for _, statement := range p.statements {
+		if !statement.allow {
+			continue
+		}
+		permission.AllowDownload = permission.AllowDownload || statement.permission.AllowDownload
+		permission.AllowList = permission.AllowList || statement.permission.AllowList
+		for _, prefix := range statement.prefixes {
+			if shared := sharePrefix(p.Owner, bucket, prefix); !containsSharePrefix(prefixes, shared) {
+				prefixes = append(prefixes, shared)
+			}
+		}
+	}
+	return permission, prefixes
+}
+
+// containsSharePrefix returns whether prefixes contains prefix.
+func containsSharePrefix(prefixes []uplink.SharePrefix, prefix uplink.SharePrefix) bool {
+	for _, p := range prefixes {
+		if p == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// BucketPolicy stores the policies of buckets in the DWS node's bucket
+// registry.
+type BucketPolicy struct {
+	log   *zap.Logger
+	node  nodeBucketConfig
+	cache *lrucache.ExpiringLRUOf[*bucketPolicy]
+	// lookups bounds the lookups made for anonymous requests.
+	lookups chan struct{}
+}
+
+// errPolicyLookupsExhausted is returned by lookup when too many lookups are
+// in progress.
+var errPolicyLookupsExhausted = errs.New("too many bucket policy lookups in progress")
+
+// NewBucketPolicy returns a BucketPolicy that talks to the DWS node configured
+// by config.
+func NewBucketPolicy(log *zap.Logger, config DwsConfig) *BucketPolicy {
+	return &BucketPolicy{
+		log:  log,
+		node: newNodeBucketConfig(config, "policy", "policy"),
+		cache: lrucache.NewOf[*bucketPolicy](lrucache.Options{
+			Expiration: config.PolicyCacheExpiration,
+			Capacity:   config.PolicyCacheCapacity,
+		}),
+		lookups: make(chan struct{}, config.PolicyLookupLimit),
+	}
+}
+
+// get returns the policy of bucket, or nil if it has none. Policies are
+// cached, like CORS configurations, and so is the absence of one.
+func (c *BucketPolicy) get(ctx context.Context, bucket string) (_ *bucketPolicy, err error) {
+	defer mon.Task()(&ctx)(&err)
+	return c.cache.Get(ctx, bucket, func() (*bucketPolicy, error) {
+		return c.fetch(ctx, bucket)
+	})
+}
+
+// lookup is like get, but for anonymous requests. As anyone can make them,
+// for any bucket name, at most the lookup limit of them ask the node at once;
+// the others fail with errPolicyLookupsExhausted.
+func (c *BucketPolicy) lookup(ctx context.Context, bucket string) (_ *bucketPolicy, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if policy, cached := c.cache.GetCached(ctx, bucket); cached {
+		return policy, nil
+	}
+
+	select {
+	case c.lookups <- struct{}{}:
+		defer func() { <-c.lookups }()
+	default:
+		mon.Event("bucket_policy_lookups_exhausted")
+		return nil, errPolicyLookupsExhausted
+	}
+
+	return c.get(ctx, bucket)
+}
+
+// fetch returns the policy of bucket from the node.
+func (c *BucketPolicy) fetch(ctx context.Context, bucket string) (_ *bucketPolicy, err error) {
+	policy, err := c.stored(ctx, bucket)
+	if err != nil || policy == nil {
+		return nil, err
+	}
+	if policy.statements, err = parseBucketPolicy(bucket, policy.Policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// stored returns the policy of bucket as stored in the node, without its
+// statements, or nil if it has none. It's what replacing or deleting the
+// policy has to clean up after.
+func (c *BucketPolicy) stored(ctx context.Context, bucket string) (_ *bucketPolicy, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	data, err := c.node.get(ctx, bucket)
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	var policy bucketPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, errs.New("invalid stored policy: %v", err)
+	}
+	return &policy, nil
+}
+
+// put sets the policy of bucket.
+func (c *BucketPolicy) put(ctx context.Context, bucket string, policy *bucketPolicy) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	if err := c.node.put(ctx, bucket, "application/json", data); err != nil {
+		return err
+	}
+
+	c.cache.Add(ctx, bucket, policy)
+	return nil
+}
+
+// delete removes the policy of bucket.
+func (c *BucketPolicy) delete(ctx context.Context, bucket string) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if err := c.node.delete(ctx, bucket); err != nil {
+		return err
+	}
+
+	c.cache.Add(ctx, bucket, nil)
+	return nil
+}
+
+// errTemporaryPublicAccess is returned by registerPublicAccess for requests
+// with temporary credentials, whose access grant expires with them.
+var errTemporaryPublicAccess = errs.New("public access can't be granted with temporary credentials")
+
+// registerPublicAccess registers the credentials anonymous requests allowed
+// by policy are served with, derived from the access grant of the request
+// setting it, and sets them in policy.
+func (h objectAPIHandlersWrapper) registerPublicAccess(ctx context.Context, bucket string, policy *bucketPolicy) error {
+	credentials := middleware.GetAccess(ctx)
+	if credentials == nil || credentials.AccessGrant == "" {
+		return errs.New("missing access grant")
+	}
+	if credentials.SessionToken != "" {
+		return errTemporaryPublicAccess
+	}
+	access, err := uplink.ParseAccess(credentials.AccessGrant)
+	if err != nil {
+		return err
+	}
+
+	permission, prefixes := policy.publicRestriction(bucket)
+	parent, err := access.Share(permission, prefixes...)
+	if err != nil {
+		return err
+	}
+	public, err := parent.Share(permission, prefixes...)
+	if err != nil {
+		return err
+	}
+	if policy.PublicParentGrant, err = parent.Serialize(); err != nil {
+		return err
+	}
+	if policy.PublicAccessGrant, err = public.Serialize(); err != nil {
+		return err
+	}
+
+	registered, err := h.authClient.Register(ctx, policy.PublicAccessGrant, false)
+	if err != nil {
+		return err
+	}
+	policy.PublicAccessKey = registered.AccessKeyID
+	return nil
+}
+
+// accessRevoker is implemented by object layers that can revoke access
+// grants, like gw.MultiTenancyLayer.
+type accessRevoker interface {
+	RevokeAccess(ctx context.Context, parentGrant string, access *uplink.Access) error
+}
+
+// revokePublicAccess revokes the public access grant of policy, which was
+// replaced or deleted, if it has one. withBucketPolicy stops using it with
+// the policy, but it would stay valid otherwise. Failures are only logged, as
+// the change to the policy is done.
+func (h objectAPIHandlersWrapper) revokePublicAccess(ctx context.Context, bucket string, policy *bucketPolicy) {
+	if policy == nil || policy.PublicAccessKey == "" {
+		return
+	}
+	err := func() error {
+		revoker, ok := h.core.ObjectAPI().(accessRevoker)
+		if !ok {
+			return errs.New("the object layer can't revoke access grants")
+		}
+		if policy.PublicAccessGrant == "" || policy.PublicParentGrant == "" {
+			return errs.New("missing public access grants")
+		}
+		access, err := uplink.ParseAccess(policy.PublicAccessGrant)
+		if err != nil {
+			return err
+		}
+		return revoker.RevokeAccess(ctx, policy.PublicParentGrant, access)
+	}()
+	if err != nil {
+		mon.Event("bucket_policy_public_access_revocation_failed")
+		h.logger.With("bucket", bucket, "access_key", policy.PublicAccessKey).Errorf("failed to revoke public access of bucket policy: %s", err)
+	}
+}
+
+// publicOwnerCV is the context key of the publicOwner of anonymous requests
+// allowed by bucket policies.
+type publicOwnerCV struct{}
+
+// publicOwner is the user whose bucket the public credentials of a bucket
+// policy give access to. Like temporary credentials, they're unknown to DWS.
+type publicOwner struct {
+	accessKey string
+	userID    string
+}
+
+// objectKey returns the key of the object of r, for withBucketPolicy.
+func objectKey(r *http.Request) func() ([]string, error) {
+	key := mux.Vars(r)[VarKeyObject]
+	return func() ([]string, error) {
+		return []string{key}, nil
+	}
+}
+
+// listPrefix returns the prefix of the objects r lists, for withBucketPolicy.
+func listPrefix(r *http.Request) func() ([]string, error) {
+	return func() ([]string, error) {
+		return []string{r.URL.Query().Get("prefix")}, nil
+	}
+}
+
+// postPolicyKey returns the key of the object a PostPolicyBucket request
+// uploads, for withBucketPolicy. The form fields sent before the file are
+// read, like AccessKey reads them, and the body of r is replaced so they can
+// be read again.
+func postPolicyKey(r *http.Request) func() ([]string, error) {
+	return func() (_ []string, err error) {
+		_, params, err := mime.ParseMediaType(r.Header.Get(xhttp.ContentType))
+		if err != nil {
+			return nil, errs.Wrap(err)
+		}
+
+		bodyCache, err := middleware.NewBodyCache(r.Body, maxPostPolicyFormSize)
+		if err != nil {
+			return nil, err
+		}
+		r.Body = bodyCache
+		defer func() {
+			_, seekErr := bodyCache.Seek(0, io.SeekStart)
+			err = errs.Combine(err, seekErr)
+		}()
+
+		var key, filename string
+		reader := multipart.NewReader(io.LimitReader(bodyCache, maxPostPolicyFormSize), params["boundary"])
+	fields:
+		for {
+			part, err := reader.NextPart()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, errs.Wrap(err)
+			}
+			switch strings.ToLower(part.FormName()) {
+			case "key":
+				value, err := io.ReadAll(part)
+				if err != nil {
+					return nil, errs.Wrap(err)
+				}
+				key = string(value)
+			case "file":
+				// the file is the last field.
+				filename = part.FileName()
+				break fields
+			}
+		}
+		if key == "" {
+			return nil, nil // left for minio to report.
+		}
+		// like S3, ${filename} is replaced with the name of the uploaded file.
+		return []string{strings.ReplaceAll(key, "${filename}", filename)}, nil
+	}
+}
+
+// deleteObjectsKeys returns the keys of the objects a DeleteObjects request
+// deletes, for withBucketPolicy. The body of r is read and replaced.
+func deleteObjectsKeys(r *http.Request) func() ([]string, error) {
+	return func() ([]string, error) {
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxDeleteObjectsRequestSize))
+		if err != nil {
+			return nil, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(data))
+
+		var request cmd.DeleteObjectsRequest
+		if err := xml.Unmarshal(data, &request); err != nil {
+			return nil, errs.Wrap(err)
+		}
+		keys := make([]string, 0, len(request.Objects))
+		for _, object := range request.Objects {
+			keys = append(keys, object.ObjectName)
+		}
+		return keys, nil
+	}
+}
+
+// copySource returns the bucket and key of the source of the CopyObject or
+// UploadPartCopy request r, as sent in x-amz-copy-source.
+func copySource(r *http.Request) (bucket, key string, err error) {
+	source := r.Header.Get(xhttp.AmzCopySource)
+	if i := strings.IndexByte(source, '?'); i >= 0 {
+		source = source[:i]
+	}
+	source, err = url.PathUnescape(source)
+	if err != nil {
+		return "", "", err
+	}
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(source, Sep), Sep)
+	if !ok || bucket == "" || key == "" {
+		return "", "", errs.New("invalid copy source %q", source)
+	}
+	return bucket, key, nil
+}
+
+// checkCopySourcePolicy checks that the policy of the bucket of the source of
+// the CopyObject or UploadPartCopy request r, if any, doesn't deny getting
+// it. Its Allow statements don't matter: copies can't be anonymous, as
+// bucket policies can't allow puts. It has to be called before the bucket of
+// r is substituted.
+func (h objectAPIHandlersWrapper) checkCopySourcePolicy(w http.ResponseWriter, r *http.Request, fName string) error {
+	ctx := r.Context()
+	errCtx := cmd.NewContext(r, w, fName)
+
+	bucket, key, err := copySource(r)
+	if err != nil {
+		cmd.WriteErrorResponse(errCtx, w, cmd.GetAPIError(cmd.ErrInvalidCopySource), r.URL, false)
+		return err
+	}
+
+	if s3utils.CheckValidBucketName(bucket) != nil {
+		// the copy fails later; there's no policy to look up for it.
+		return nil
+	}
+	policy, err := h.bucketPolicy.get(ctx, bucket)
+	if err != nil {
+		// the copy is still limited by the access grant of its credentials.
+		mon.Event("bucket_policy_lookup_failed")
+		h.logger.With("bucket", bucket).Warnf("failed to get bucket policy; checking the copy source without it: %s", err)
+		return nil
+	}
+	if policy == nil {
+		return nil
+	}
+	if _, denied := policy.evaluate(policyGet, []string{key}); denied {
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrAccessDenied], r.URL, false)
+		return errs.New("copy source denied by bucket policy")
+	}
+	return nil
+}
+
+// withBucketPolicy returns r after checking that the policy of its bucket, if
+// any, lets it do action on keys. Anonymous requests the policy allows are
+// returned signed with the public credentials of the policy, so they're
+// served like requests with credentials. It has to be called before the
+// bucket of r is substituted.
+//
+// Policies are looked up before requests are authenticated, so invalid bucket
+// names aren't looked up, and lookups for anonymous requests are bounded.
+// Requests with credentials whose bucket policy can't be looked up are served
+// without it, limited by the access grant of their credentials.
+func (h objectAPIHandlersWrapper) withBucketPolicy(w http.ResponseWriter, r *http.Request, fName string, action policyAction, keys func() ([]string, error)) (*http.Request, error) {
+	ctx := r.Context()
+	errCtx := cmd.NewContext(r, w, fName)
+	bucket := mux.Vars(r)[VarKeyBucket]
+
+	if s3utils.CheckValidBucketName(bucket) != nil {
+		// the request fails later; there's no policy to look up for it.
+		return r, nil
+	}
+
+	anonymous := middleware.GetAccess(ctx) == nil
+	var policy *bucketPolicy
+	var err error
+	if anonymous {
+		policy, err = h.bucketPolicy.lookup(ctx, bucket)
+	} else {
+		policy, err = h.bucketPolicy.get(ctx, bucket)
+	}
+	switch {
+	case err != nil && !anonymous:
+		mon.Event("bucket_policy_lookup_failed")
+		h.logger.With("bucket", bucket).Warnf("failed to get bucket policy; serving the request without it: %s", err)
+		return r, nil
+	case errs.Is(err, errPolicyLookupsExhausted):
+		cmd.WriteErrorResponse(errCtx, w, cmd.GetAPIError(cmd.ErrSlowDown), r.URL, false)
+		return nil, err
+	case err != nil:
+		h.logger.With("bucket", bucket).Errorf("failed to get bucket policy: %s", err)
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+		return nil, err
+	case policy == nil:
+		return r, nil
+	}
+
+	requestKeys, err := keys()
+	if err != nil {
+		cmd.WriteErrorResponse(errCtx, w, cmd.GetAPIError(cmd.ErrMalformedXML), r.URL, false)
+		return nil, err
+	}
+	allowed, denied := policy.evaluate(action, requestKeys)
+	if denied || (anonymous && (!allowed || policy.PublicAccessKey == "")) {
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrAccessDenied], r.URL, false)
+		return nil, errs.New("denied by bucket policy")
+	}
+	if !anonymous {
+		return r, nil
+	}
+
+	response, err := h.authClient.ResolveWithCache(ctx, policy.PublicAccessKey, trustedip.GetClientIP(h.trustedIPs, r))
+	if err != nil {
+		h.logger.With("bucket", bucket).Errorf("failed to resolve public credentials of bucket policy: %s", err)
+		cmd.WriteErrorResponse(errCtx, w, apiErrors[ErrInternalError], r.URL, false)
+		return nil, err
+	}
+	ctx = middleware.WithCredentials(ctx, &middleware.Credentials{
+		AccessKey:           policy.PublicAccessKey,
+		AuthServiceResponse: response,
+	})
+	ctx = context.WithValue(ctx, publicOwnerCV{}, publicOwner{
+		accessKey: policy.PublicAccessKey,
+		userID:    policy.Owner,
+	})
+
+	r = r.WithContext(ctx)
+	r.Header.Set(xhttp.AmzContentSha256, "UNSIGNED-PAYLOAD")
+	return signer.SignV4(*r, policy.PublicAccessKey, response.SecretKey, "", publicAccessRegion), nil
+}
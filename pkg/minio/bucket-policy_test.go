@@ -0,0 +1,512 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minio/minio-go/v7/pkg/signer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/common/grant"
+	"storj.io/common/macaroon"
+	"storj.io/common/storj"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"storj.io/gateway-mt/pkg/authclient"
+	"storj.io/gateway-mt/pkg/server/middleware"
+	"storj.io/minio/cmd"
+	"storj.io/minio/cmd/config"
+	"storj.io/uplink"
+)
+
+const testBucketPolicy = `{
+	"Version": "2012-10-17",
+	"Statement": [
+		{"Sid": "public", "Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/public/*"},
+		{"Effect": "Allow", "Principal": {"AWS": "*"}, "Action": "s3:ListBucket", "Resource": "arn:aws:s3:::photos/public/"},
+		{"Effect": "Deny", "Principal": "*", "Action": ["s3:GetObject", "s3:DeleteObject"], "Resource": "arn:aws:s3:::photos/public/private/*"},
+		{"Effect": "Deny", "Principal": "*", "Action": "s3:PutObject", "Resource": "arn:aws:s3:::photos/*"}
+	]
+}`
+
+func TestParseBucketPolicy(t *testing.T) {
+	statements, err := parseBucketPolicy("photos", []byte(testBucketPolicy))
+	require.NoError(t, err)
+	assert.Equal(t, []bucketPolicyStatement{
+		{allow: true, permission: uplink.Permission{AllowDownload: true}, prefixes: []string{"public/"}},
+		{allow: true, permission: uplink.Permission{AllowList: true}, prefixes: []string{"public/"}},
+		{permission: uplink.Permission{AllowDownload: true, AllowDelete: true}, prefixes: []string{"public/private/"}},
+		{permission: uplink.Permission{AllowUpload: true}, prefixes: []string{""}},
+	}, statements)
+
+	for _, policy := range []string{
+		`{`,
+		`{"Statement": [{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*"}]}`,
+		`{"Statement": [{"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::1:root"}, "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*"}]}`,
+		`{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:PutObject", "Resource": "arn:aws:s3:::photos/*"}]}`,
+		`{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::docs/*"}]}`,
+		`{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "*"}]}`,
+		`{"Statement": [{"Effect": "Deny", "Principal": "*", "Action": "s3:PutBucketPolicy", "Resource": "arn:aws:s3:::photos"}]}`,
+	} {
+		_, err := parseBucketPolicy("photos", []byte(policy))
+		assert.True(t, ErrPolicy.Has(err), policy)
+	}
+}
+
+func TestBucketPolicyEvaluate(t *testing.T) {
+	statements, err := parseBucketPolicy("photos", []byte(testBucketPolicy))
+	require.NoError(t, err)
+	policy := &bucketPolicy{Owner: "user", statements: statements}
+
+	tests := []struct {
+		action  policyAction
+		keys    []string
+		allowed bool
+		denied  bool
+	}{
+		{action: policyGet, keys: []string{"public/a.jpg"}, allowed: true},
+		{action: policyGet, keys: []string{"a.jpg"}},
+		{action: policyGet, keys: []string{"public/private/a.jpg"}, denied: true},
+		{action: policyList, keys: []string{"public/2023/"}, allowed: true},
+		{action: policyList, keys: []string{""}},
+		{action: policyList, keys: []string{"public/private/"}, allowed: true},
+		{action: policyPut, keys: []string{"public/a.jpg"}, denied: true},
+		{action: policyDelete, keys: []string{"public/a.jpg"}},
+		{action: policyDelete, keys: []string{"public/a.jpg", "public/private/a.jpg"}, denied: true},
+		{action: policyGet},
+	}
+	for _, tc := range tests {
+		allowed, denied := policy.evaluate(tc.action, tc.keys)
+		assert.Equal(t, tc.allowed, allowed, "%d %v", tc.action, tc.keys)
+		assert.Equal(t, tc.denied, denied, "%d %v", tc.action, tc.keys)
+	}
+
+	// listing a prefix lists the objects of the prefixes starting with it, so
+	// it's denied if they're denied.
+	statements, err = parseBucketPolicy("photos", []byte(`{"Statement": [
+		{"Effect": "Allow", "Principal": "*", "Action": "s3:ListBucket", "Resource": "arn:aws:s3:::photos/*"},
+		{"Effect": "Deny", "Principal": "*", "Action": "s3:ListBucket", "Resource": "arn:aws:s3:::photos/secret/*"}
+	]}`))
+	require.NoError(t, err)
+	listPolicy := &bucketPolicy{Owner: "user", statements: statements}
+	for _, tc := range []struct {
+		keys    []string
+		allowed bool
+		denied  bool
+	}{
+		{keys: []string{""}, denied: true},
+		{keys: []string{"sec"}, denied: true},
+		{keys: []string{"secret/"}, denied: true},
+		{keys: []string{"secret/a/"}, denied: true},
+		{keys: []string{"public/"}, allowed: true},
+		{keys: []string{"secrets"}, allowed: true},
+	} {
+		allowed, denied := listPolicy.evaluate(policyList, tc.keys)
+		assert.Equal(t, tc.allowed, allowed, "%v", tc.keys)
+		assert.Equal(t, tc.denied, denied, "%v", tc.keys)
+	}
+	// Allow statements and other actions only match keys in their prefixes.
+	allowed, denied := policy.evaluate(policyList, []string{"public"})
+	assert.False(t, allowed)
+	assert.False(t, denied)
+	allowed, denied = policy.evaluate(policyGet, []string{"public/"})
+	assert.True(t, allowed)
+	assert.False(t, denied)
+
+	permission, prefixes := policy.publicRestriction("photos")
+	assert.Equal(t, uplink.Permission{AllowDownload: true, AllowList: true}, permission)
+	assert.Equal(t, []uplink.SharePrefix{{Bucket: "user", Prefix: "photos/public/"}}, prefixes)
+}
+
+func TestDeleteObjectsKeys(t *testing.T) {
+	body := `<Delete><Quiet>true</Quiet><Object><Key>a.jpg</Key></Object><Object><Key>b/c.jpg</Key></Object></Delete>`
+	r := httptest.NewRequest(http.MethodPost, "/photos?delete", strings.NewReader(body))
+
+	keys, err := deleteObjectsKeys(r)()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.jpg", "b/c.jpg"}, keys)
+
+	// the body is left for the handler.
+	data, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(data))
+}
+
+func TestPostPolicyKey(t *testing.T) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	require.NoError(t, form.WriteField("Key", "public/${filename}"))
+	require.NoError(t, form.WriteField("Policy", "policy"))
+	file, err := form.CreateFormFile("file", "a.jpg")
+	require.NoError(t, err)
+	_, err = file.Write([]byte("data"))
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+	sent := body.String()
+
+	r := httptest.NewRequest(http.MethodPost, "/photos", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+
+	keys, err := postPolicyKey(r)()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"public/a.jpg"}, keys)
+
+	// the body is left for the handler.
+	data, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, sent, string(data))
+}
+
+func TestCopySource(t *testing.T) {
+	for source, expected := range map[string][2]string{
+		"photos/a.jpg":                     {"photos", "a.jpg"},
+		"/photos/public/a%20b.jpg":         {"photos", "public/a b.jpg"},
+		"/photos/public/a.jpg?versionId=1": {"photos", "public/a.jpg"},
+	} {
+		r := httptest.NewRequest(http.MethodPut, "/docs/a.jpg", nil)
+		r.Header.Set("X-Amz-Copy-Source", source)
+		bucket, key, err := copySource(r)
+		require.NoError(t, err, source)
+		assert.Equal(t, expected, [2]string{bucket, key}, source)
+	}
+
+	for _, source := range []string{"", "photos", "/photos/", "/photos/%zz"} {
+		r := httptest.NewRequest(http.MethodPut, "/docs/a.jpg", nil)
+		r.Header.Set("X-Amz-Copy-Source", source)
+		_, _, err := copySource(r)
+		assert.Error(t, err, source)
+	}
+}
+
+func TestBucketPolicyHandlers(t *testing.T) {
+	ctx := testcontext.New(t)
+	const publicAccessKey = "jwaohtj3dhixxfpzhwj522x7z3pb"
+
+	node := httptest.NewServer(&testBucketConfigNode{configs: map[string]string{}})
+	defer node.Close()
+	policies := NewBucketPolicy(zaptest.NewLogger(t), DwsConfig{
+		DwsBackendHost:        node.URL,
+		DwsNodeToken:          "token",
+		PolicyCacheExpiration: time.Hour,
+		PolicyCacheCapacity:   10,
+	})
+	statements, err := parseBucketPolicy("photos", []byte(testBucketPolicy))
+	require.NoError(t, err)
+	require.NoError(t, policies.put(ctx, "photos", &bucketPolicy{
+		Policy:          json.RawMessage(testBucketPolicy),
+		Owner:           "user",
+		PublicAccessKey: publicAccessKey,
+		statements:      statements,
+	}))
+
+	const docsPolicy = `{"Statement": [{"Effect": "Deny", "Principal": "*", "Action": "s3:ListBucket", "Resource": "arn:aws:s3:::docs/secret/*"}]}`
+	statements, err = parseBucketPolicy("docs", []byte(docsPolicy))
+	require.NoError(t, err)
+	require.NoError(t, policies.put(ctx, "docs", &bucketPolicy{
+		Policy:     json.RawMessage(docsPolicy),
+		Owner:      "user",
+		statements: statements,
+	}))
+
+	// the Auth Service resolves the public credentials of the policy.
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/access/"+publicAccessKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"public":true,"access_grant":"publicgrant","secret_key":"publicsecretkey"}`))
+	}))
+	defer auth.Close()
+
+	h := objectAPIHandlersWrapper{
+		authClient:   authclient.New(authclient.Config{BaseURL: auth.URL, Token: "token", Timeout: 5 * time.Second}),
+		logger:       zaptest.NewLogger(t).Sugar(),
+		bucketPolicy: policies,
+	}
+
+	// anonymous requests the policy allows are served with its public
+	// credentials, as its owner.
+	var served *http.Request
+	router := mux.NewRouter()
+	router.Methods(http.MethodGet).Path("/{bucket}/{object:.+}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, err := h.withBucketPolicy(w, r, "GetObject", policyGet, objectKey(r))
+		if err != nil {
+			return
+		}
+		served = r
+		w.WriteHeader(http.StatusOK)
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/photos/public/a.jpg", nil).WithContext(ctx))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NotNil(t, served)
+	credentials := middleware.GetAccess(served.Context())
+	require.NotNil(t, credentials)
+	assert.Equal(t, publicAccessKey, credentials.AccessKey)
+	assert.Equal(t, "publicgrant", credentials.AccessGrant)
+	assert.Contains(t, served.Header.Get("Authorization"), "Credential="+publicAccessKey+"/")
+	owner, err := h.accessKeyOwner(served.Context(), credentials.AccessKey, "")
+	require.NoError(t, err)
+	assert.Equal(t, "user", owner)
+
+	for _, target := range []string{"/photos/a.jpg", "/photos/public/private/a.jpg"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx))
+		assert.Equal(t, http.StatusForbidden, rec.Code, target)
+	}
+
+	// requests with credentials are refused what the policy denies, by every
+	// handler.
+	withCredentials := middleware.WithCredentials(ctx, &middleware.Credentials{AccessKey: "accesskey"})
+	for _, tc := range []struct {
+		name    string
+		method  string
+		path    string
+		target  string
+		header  map[string]string
+		handler http.HandlerFunc
+	}{
+		{name: "ListObjectVersions", method: http.MethodGet, path: "/{bucket}", target: "/docs?versions&prefix=sec", handler: h.ListObjectVersionsHandler},
+		{name: "ListObjectsV2M", method: http.MethodGet, path: "/{bucket}", target: "/docs?list-type=2&metadata=true", handler: h.ListObjectsV2MHandler},
+		{name: "GetObject", method: http.MethodGet, target: "/photos/public/private/a.jpg", handler: h.GetObjectHandler},
+		{name: "HeadObject", method: http.MethodHead, target: "/photos/public/private/a.jpg", handler: h.HeadObjectHandler},
+		{name: "PutObject", method: http.MethodPut, target: "/photos/a.jpg", handler: h.PutObjectHandler},
+		{name: "CopyObject", method: http.MethodPut, target: "/docs/a.jpg", header: map[string]string{"X-Amz-Copy-Source": "/photos/public/private/a.jpg"}, handler: h.CopyObjectHandler},
+		{name: "CopyObjectPart", method: http.MethodPut, target: "/docs/a.jpg?partNumber=1&uploadId=upload", header: map[string]string{"X-Amz-Copy-Source": "photos/public/private/a.jpg"}, handler: h.CopyObjectPartHandler},
+		{name: "GetObjectTagging", method: http.MethodGet, target: "/photos/public/private/a.jpg?tagging", handler: h.GetObjectTaggingHandler},
+		{name: "PutObjectTagging", method: http.MethodPut, target: "/photos/a.jpg?tagging", handler: h.PutObjectTaggingHandler},
+		{name: "DeleteObjectTagging", method: http.MethodDelete, target: "/photos/a.jpg?tagging", handler: h.DeleteObjectTaggingHandler},
+		{name: "DeleteObject", method: http.MethodDelete, target: "/photos/public/private/a.jpg", handler: h.DeleteObjectHandler},
+		{name: "GetObjectACL", method: http.MethodGet, target: "/photos/public/private/a.jpg?acl", handler: h.GetObjectACLHandler},
+		{name: "PutObjectACL", method: http.MethodPut, target: "/photos/a.jpg?acl", handler: h.PutObjectACLHandler},
+		{name: "GetObjectRetention", method: http.MethodGet, target: "/photos/public/private/a.jpg?retention", handler: h.GetObjectRetentionHandler},
+		{name: "PutObjectRetention", method: http.MethodPut, target: "/photos/a.jpg?retention", handler: h.PutObjectRetentionHandler},
+		{name: "GetObjectLegalHold", method: http.MethodGet, target: "/photos/public/private/a.jpg?legal-hold", handler: h.GetObjectLegalHoldHandler},
+		{name: "PutObjectLegalHold", method: http.MethodPut, target: "/photos/a.jpg?legal-hold", handler: h.PutObjectLegalHoldHandler},
+		{name: "PostRestoreObject", method: http.MethodPost, target: "/photos/a.jpg?restore", handler: h.PostRestoreObjectHandler},
+	} {
+		path := tc.path
+		if path == "" {
+			path = "/{bucket}/{object:.+}"
+		}
+		router := mux.NewRouter()
+		router.Methods(tc.method).Path(path).HandlerFunc(tc.handler)
+
+		r := httptest.NewRequest(tc.method, tc.target, nil).WithContext(withCredentials)
+		for k, v := range tc.header {
+			r.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		assert.Equal(t, http.StatusForbidden, rec.Code, tc.name)
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	require.NoError(t, form.WriteField("key", "${filename}"))
+	file, err := form.CreateFormFile("file", "a.jpg")
+	require.NoError(t, err)
+	_, err = file.Write([]byte("data"))
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+
+	router = mux.NewRouter()
+	router.Methods(http.MethodPost).Path("/{bucket}").HandlerFunc(h.PostPolicyBucketHandler)
+	r := httptest.NewRequest(http.MethodPost, "/photos", &body).WithContext(withCredentials)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, r)
+	assert.Equal(t, http.StatusForbidden, rec.Code, "PostPolicyBucket")
+}
+
+func TestBucketPolicyLookup(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	node := &testBucketConfigNode{configs: map[string]string{}}
+	server := httptest.NewServer(node)
+	defer server.Close()
+	policies := NewBucketPolicy(zaptest.NewLogger(t), DwsConfig{
+		DwsBackendHost:        server.URL,
+		DwsNodeToken:          "token",
+		PolicyCacheExpiration: time.Hour,
+		PolicyCacheCapacity:   10,
+		PolicyLookupLimit:     1,
+	})
+	h := objectAPIHandlersWrapper{
+		logger:       zaptest.NewLogger(t).Sugar(),
+		bucketPolicy: policies,
+	}
+
+	router := mux.NewRouter()
+	router.Methods(http.MethodGet).Path("/{bucket}/{object:.+}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := h.withBucketPolicy(w, r, "GetObject", policyGet, objectKey(r)); err != nil {
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	get := func(ctx context.Context, target string) int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx))
+		return rec.Code
+	}
+	withCredentials := middleware.WithCredentials(ctx, &middleware.Credentials{AccessKey: "accesskey"})
+
+	// buckets without a policy are remembered not to have one.
+	assert.Equal(t, http.StatusOK, get(ctx, "/photos/a.jpg"))
+	node.fail = true
+	assert.Equal(t, http.StatusOK, get(ctx, "/photos/a.jpg"))
+
+	// policies that can't be looked up fail anonymous requests, and leave
+	// requests with credentials to their access grant.
+	assert.Equal(t, http.StatusInternalServerError, get(ctx, "/docs/a.jpg"))
+	assert.Equal(t, http.StatusOK, get(withCredentials, "/docs/a.jpg"))
+
+	// anonymous requests over the lookup limit slow down, and invalid bucket
+	// names aren't looked up.
+	node.fail = false
+	policies.lookups <- struct{}{}
+	assert.Equal(t, http.StatusServiceUnavailable, get(ctx, "/docs/a.jpg"))
+	assert.Equal(t, http.StatusOK, get(withCredentials, "/docs/a.jpg"))
+	assert.Equal(t, http.StatusOK, get(ctx, "/ab/a.jpg"))
+	assert.Equal(t, http.StatusOK, get(ctx, "/docs/a.jpg"))
+}
+
+func TestBucketPolicyPublicAccess(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	// requests are authenticated with the credentials in their context.
+	StartMinio(false)
+	t.Setenv(config.EnvRootPassword, "sessionsecret")
+
+	apiKey, err := macaroon.NewAPIKey(testrand.Bytes(32))
+	require.NoError(t, err)
+	key := testrand.Key()
+	ownerGrant, err := (&grant.Access{
+		SatelliteAddress: storj.NodeURL{ID: testrand.NodeID(), Address: "127.0.0.1:7777"}.String(),
+		APIKey:           apiKey,
+		EncAccess:        grant.NewEncryptionAccessWithDefaultKey(&key),
+	}).Serialize()
+	require.NoError(t, err)
+
+	// the Auth Service registers the public access grants of policies.
+	var registered []string
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			AccessGrant string `json:"access_grant"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		registered = append(registered, body.AccessGrant)
+		_, _ = fmt.Fprintf(w, `{"access_key_id":"publickey%d", "secret_key":"publicsecretkey"}`, len(registered))
+	}))
+	defer auth.Close()
+
+	node := httptest.NewServer(&testBucketConfigNode{configs: map[string]string{}})
+	defer node.Close()
+
+	layer := &testRevokerLayer{ObjectLayer: &testBucketMarkerLayer{markers: map[string]bool{"user/photos": true}}}
+	log := zaptest.NewLogger(t)
+	h := objectAPIHandlersWrapper{
+		core: cmd.ObjectAPIHandlers{
+			ObjectAPI: func() cmd.ObjectLayer { return layer },
+		},
+		authClient: authclient.New(authclient.Config{BaseURL: auth.URL, Token: "token", Timeout: 5 * time.Second}),
+		dwsClient:  &testDWSClient{buckets: map[string]string{"accesskey": "user"}},
+		logger:     log.Sugar(),
+		bucketPolicy: NewBucketPolicy(log, DwsConfig{
+			DwsBackendHost:        node.URL,
+			DwsNodeToken:          "token",
+			PolicyCacheExpiration: time.Hour,
+			PolicyCacheCapacity:   10,
+		}),
+	}
+
+	request := func(method, body string, credentials *middleware.Credentials, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "https://gateway.test/photos?policy", strings.NewReader(body))
+		r.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+		r = signer.SignV4(*r, credentials.AccessKey, credentials.SecretKey, credentials.SessionToken, "us-east-1")
+		r = r.WithContext(middleware.WithCredentials(ctx, credentials))
+		r = mux.SetURLVars(r, map[string]string{VarKeyBucket: "photos"})
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		return rec
+	}
+	owner := &middleware.Credentials{
+		AccessKey:           "accesskey",
+		AuthServiceResponse: authclient.AuthServiceResponse{AccessGrant: ownerGrant, SecretKey: "secretkey"},
+	}
+
+	// public access can't be granted with temporary credentials, as it would
+	// expire with them.
+	token, err := newSessionToken("tempaccesskey", "user", time.Now().Add(time.Hour), "sessionsecret")
+	require.NoError(t, err)
+	rec := request(http.MethodPut, testBucketPolicy, &middleware.Credentials{
+		AccessKey:           "tempaccesskey",
+		AuthServiceResponse: authclient.AuthServiceResponse{AccessGrant: ownerGrant, SecretKey: "tempsecretkey"},
+		SessionToken:        token,
+	}, h.PutBucketPolicyHandler)
+	assert.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), "temporary credentials")
+	assert.Empty(t, registered)
+
+	// the public access of replaced and deleted policies is revoked with the
+	// grant it was shared from.
+	rec = request(http.MethodPut, testBucketPolicy, owner, h.PutBucketPolicyHandler)
+	require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+	first, err := h.bucketPolicy.stored(ctx, "photos")
+	require.NoError(t, err)
+	require.Len(t, registered, 1)
+	assert.Equal(t, "publickey1", first.PublicAccessKey)
+	assert.Equal(t, registered[0], first.PublicAccessGrant)
+	assert.Empty(t, layer.revoked)
+
+	rec = request(http.MethodPut, testBucketPolicy, owner, h.PutBucketPolicyHandler)
+	require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+	second, err := h.bucketPolicy.stored(ctx, "photos")
+	require.NoError(t, err)
+	assert.Equal(t, "publickey2", second.PublicAccessKey)
+	assert.Equal(t, []testRevocation{{parent: first.PublicParentGrant, access: first.PublicAccessGrant}}, layer.revoked)
+
+	rec = request(http.MethodDelete, "", owner, h.DeleteBucketPolicyHandler)
+	require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+	assert.Equal(t, []testRevocation{
+		{parent: first.PublicParentGrant, access: first.PublicAccessGrant},
+		{parent: second.PublicParentGrant, access: second.PublicAccessGrant},
+	}, layer.revoked)
+}
+
+// testRevokerLayer is an object layer that records the access grants it
+// revokes.
+type testRevokerLayer struct {
+	cmd.ObjectLayer
+
+	revoked []testRevocation
+}
+
+type testRevocation struct {
+	parent, access string
+}
+
+func (l *testRevokerLayer) RevokeAccess(ctx context.Context, parent string, access *uplink.Access) error {
+	serialized, err := access.Serialize()
+	if err != nil {
+		return err
+	}
+	l.revoked = append(l.revoked, testRevocation{parent: parent, access: serialized})
+	return nil
+}
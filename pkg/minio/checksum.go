@@ -0,0 +1,959 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1" //nolint:gosec // SHA1 is one of the checksums S3 supports.
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/minio/minio-go/v7/pkg/signer"
+	"github.com/zeebo/errs"
+
+	"storj.io/common/lrucache"
+
+	"storj.io/gateway-mt/pkg/server/gw"
+	"storj.io/gateway-mt/pkg/server/middleware"
+	"storj.io/minio/cmd"
+	xhttp "storj.io/minio/cmd/http"
+	"storj.io/minio/pkg/bucket/policy"
+	miniohash "storj.io/minio/pkg/hash"
+)
+
+const (
+	amzChecksumPrefix       = "x-amz-checksum-"
+	amzChecksumAlgorithm    = "x-amz-checksum-algorithm"
+	amzChecksumMode         = "x-amz-checksum-mode"
+	amzSDKChecksumAlgorithm = "x-amz-sdk-checksum-algorithm"
+	amzTrailer              = "x-amz-trailer"
+
+	// streamingUnsignedPayloadTrailer is the x-amz-content-sha256 of
+	// aws-chunked bodies whose chunks aren't signed, followed by a trailing
+	// checksum.
+	streamingUnsignedPayloadTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	unsignedPayload                 = "UNSIGNED-PAYLOAD"
+	awsChunkedEncoding              = "aws-chunked"
+	signV4Algorithm                 = "AWS4-HMAC-SHA256"
+)
+
+// maxChunkLineSize is the longest chunk header or trailer line accepted in
+// aws-chunked bodies.
+const maxChunkLineSize = 4096
+
+// maxCompleteMultipartUploadSize is the largest CompleteMultipartUpload
+// request body read for part checksums. It fits the 10000 parts S3 allows.
+const maxCompleteMultipartUploadSize = 5 << 20
+
+// maxPartsList is the number of parts listed at once for the checksums of
+// aborted multipart uploads.
+const maxPartsList = 1000
+
+// ErrChecksum is returned for invalid or unsupported additional checksums.
+var ErrChecksum = errs.Class("invalid checksum")
+
+// errPartChecksum is returned when parts are listed with checksums other than
+// the ones they've been verified with.
+var errPartChecksum = errs.Class("part checksum")
+
+// errMalformedChunkedBody is returned while reading invalid aws-chunked
+// bodies.
+var errMalformedChunkedBody = cmd.InvalidArgument{Err: errs.New("malformed aws-chunked body")}
+
+// checksumAlgorithms are the additional checksum algorithms S3 supports, by
+// the name they're sent with in x-amz-sdk-checksum-algorithm.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"CRC32":  func() hash.Hash { return crc32.NewIEEE() },
+	"CRC32C": func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+}
+
+// checksumHeader returns the header checksums computed with algorithm are
+// sent in.
+func checksumHeader(algorithm string) string {
+	return amzChecksumPrefix + strings.ToLower(algorithm)
+}
+
+// checksumMetadataKey returns the custom metadata key checksums computed
+// with algorithm are stored under.
+func checksumMetadataKey(algorithm string) string {
+	return gw.ChecksumMetadataPrefix + strings.ToLower(algorithm)
+}
+
+// checksumAlgorithm returns the algorithm named name, in any case, and
+// whether it's supported.
+func checksumAlgorithm(name string) (string, bool) {
+	algorithm := strings.ToUpper(strings.TrimSpace(name))
+	_, ok := checksumAlgorithms[algorithm]
+	return algorithm, ok
+}
+
+// decodeChecksum returns the digest encoded in value, a checksum computed
+// with algorithm.
+func decodeChecksum(algorithm, value string) ([]byte, error) {
+	digest, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(digest) != checksumAlgorithms[algorithm]().Size() {
+		return nil, ErrChecksum.New("value for %s is invalid", checksumHeader(algorithm))
+	}
+	return digest, nil
+}
+
+// requestChecksum returns the algorithm of the additional checksum of the
+// data uploaded with header, and the checksum it's expected to have. trailer
+// is true when the checksum follows the data of an aws-chunked body, and
+// expected is empty when it's only asked to be computed.
+func requestChecksum(header http.Header) (algorithm, expected string, trailer bool, err error) {
+	names := make([]string, 0, len(checksumAlgorithms))
+	for name := range checksumAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := header.Get(checksumHeader(name))
+		if value == "" {
+			continue
+		}
+		if algorithm != "" {
+			return "", "", false, ErrChecksum.New("only one x-amz-checksum header is allowed")
+		}
+		algorithm, expected = name, value
+	}
+
+	if name := strings.ToLower(strings.TrimSpace(header.Get(amzTrailer))); name != "" {
+		trailerAlgorithm, ok := checksumAlgorithm(strings.TrimPrefix(name, amzChecksumPrefix))
+		switch {
+		case !ok || !strings.HasPrefix(name, amzChecksumPrefix):
+			return "", "", false, ErrChecksum.New("unsupported %s %q", amzTrailer, name)
+		case algorithm != "":
+			return "", "", false, ErrChecksum.New("only one x-amz-checksum header is allowed")
+		}
+		algorithm, trailer = trailerAlgorithm, true
+	}
+
+	if name := header.Get(amzSDKChecksumAlgorithm); name != "" {
+		sdkAlgorithm, ok := checksumAlgorithm(name)
+		switch {
+		case !ok:
+			return "", "", false, ErrChecksum.New("unsupported %s %q", amzSDKChecksumAlgorithm, name)
+		case algorithm != "" && algorithm != sdkAlgorithm:
+			return "", "", false, ErrChecksum.New("%s doesn't match the checksum sent", amzSDKChecksumAlgorithm)
+		}
+		algorithm = sdkAlgorithm
+	}
+
+	if expected != "" {
+		if _, err := decodeChecksum(algorithm, expected); err != nil {
+			return "", "", false, err
+		}
+	}
+	return algorithm, expected, trailer, nil
+}
+
+// completeMultipartUploadChecksums is the part of the CompleteMultipartUpload
+// document with the checksums of parts.
+type completeMultipartUploadChecksums struct {
+	Parts []struct {
+		PartNumber     int
+		ChecksumCRC32  string
+		ChecksumCRC32C string
+		ChecksumSHA1   string
+		ChecksumSHA256 string
+	} `xml:"Part"`
+}
+
+// partChecksum is the additional checksum of a part of a multipart upload.
+type partChecksum struct {
+	algorithm string
+	value     string
+}
+
+// completedPartChecksums returns the checksums of the parts listed in the
+// CompleteMultipartUpload document data, by part number. Parts listed
+// without one have an empty checksum. The document is left for minio to
+// validate, so one that can't be parsed lists no parts.
+func completedPartChecksums(data []byte) ([]int, []partChecksum, error) {
+	var complete completeMultipartUploadChecksums
+	if err := xml.Unmarshal(data, &complete); err != nil {
+		return nil, nil, nil
+	}
+
+	partNumbers := make([]int, 0, len(complete.Parts))
+	checksums := make([]partChecksum, 0, len(complete.Parts))
+	for _, part := range complete.Parts {
+		var checksum partChecksum
+		for name, value := range map[string]string{
+			"CRC32":  part.ChecksumCRC32,
+			"CRC32C": part.ChecksumCRC32C,
+			"SHA1":   part.ChecksumSHA1,
+			"SHA256": part.ChecksumSHA256,
+		} {
+			if value == "" {
+				continue
+			}
+			if checksum.algorithm != "" {
+				return nil, nil, ErrChecksum.New("part %d has more than one checksum", part.PartNumber)
+			}
+			checksum = partChecksum{algorithm: name, value: value}
+		}
+		if checksum.algorithm != "" {
+			if _, err := decodeChecksum(checksum.algorithm, checksum.value); err != nil {
+				return nil, nil, err
+			}
+		}
+		partNumbers = append(partNumbers, part.PartNumber)
+		checksums = append(checksums, checksum)
+	}
+	return partNumbers, checksums, nil
+}
+
+// compositeChecksum returns the checksum of the object completed from parts
+// with checksums, computed like S3 does: the checksum of their concatenated
+// digests, followed by the number of parts. algorithm is empty if parts have
+// no checksums.
+func compositeChecksum(checksums []partChecksum) (algorithm, value string, err error) {
+	var digests []byte
+	for i, checksum := range checksums {
+		switch {
+		case i == 0:
+			algorithm = checksum.algorithm
+		case checksum.algorithm != algorithm:
+			return "", "", ErrChecksum.New("parts have to have checksums with the same algorithm, or none")
+		}
+		if algorithm == "" {
+			continue
+		}
+
+		digest, err := decodeChecksum(algorithm, checksum.value)
+		if err != nil {
+			return "", "", err
+		}
+		digests = append(digests, digest...)
+	}
+	if algorithm == "" {
+		return "", "", nil
+	}
+
+	h := checksumAlgorithms[algorithm]()
+	_, _ = h.Write(digests)
+	return algorithm, base64.StdEncoding.EncodeToString(h.Sum(nil)) + "-" + strconv.Itoa(len(checksums)), nil
+}
+
+// multipartChecksums keeps the additional checksum algorithms multipart
+// uploads are started with, and the checksums their parts are verified with
+// when they're uploaded, so CompleteMultipartUpload doesn't have to trust
+// the ones it's sent. Algorithms are stored with uploads, and part checksums
+// in the DWS node's bucket registry, as parts can be uploaded through other
+// gateways; both are cached.
+type multipartChecksums struct {
+	algorithms *lrucache.ExpiringLRUOf[string]
+	parts      *lrucache.ExpiringLRUOf[partChecksum]
+	node       nodeBucketConfig
+}
+
+func newMultipartChecksums(config DwsConfig) *multipartChecksums {
+	return &multipartChecksums{
+		algorithms: lrucache.NewOf[string](lrucache.Options{
+			Expiration: 24 * time.Hour,
+			Capacity:   10000,
+		}),
+		parts: lrucache.NewOf[partChecksum](lrucache.Options{
+			Expiration: 24 * time.Hour,
+			Capacity:   100000,
+		}),
+		node: newNodeBucketConfig(config, "", "part checksum"),
+	}
+}
+
+// algorithm returns the additional checksum algorithm the multipart upload
+// uploadID of object in bucket has been started with, or an empty string if
+// it has none. Uploads that can't be found have none either, and are left for
+// minio to report.
+func (c *multipartChecksums) algorithm(ctx context.Context, layer cmd.ObjectLayer, bucket, object, uploadID string) (string, error) {
+	return c.algorithms.Get(ctx, uploadID, func() (string, error) {
+		info, err := layer.GetMultipartInfo(ctx, bucket, object, uploadID, cmd.ObjectOptions{})
+		if err != nil {
+			if errors.As(err, &cmd.ObjectNotFound{}) || errors.As(err, &cmd.InvalidUploadID{}) {
+				return "", nil
+			}
+			return "", err
+		}
+		return info.UserDefined[gw.ChecksumAlgorithmMetadataKey], nil
+	})
+}
+
+// partKey returns the key the checksum of part partNumber of the multipart
+// upload uploadID is kept under.
+func partKey(uploadID string, partNumber int) string {
+	return uploadID + "/" + strconv.Itoa(partNumber)
+}
+
+// partNode returns the node configuration the checksum of part partNumber of
+// the multipart upload uploadID is stored as, for its bucket.
+func (c *multipartChecksums) partNode(uploadID string, partNumber int) nodeBucketConfig {
+	node := c.node
+	node.subresource = "checksum-" + url.PathEscape(uploadID) + "-" + strconv.Itoa(partNumber)
+	return node
+}
+
+// putPart stores checksum as the one part partNumber of the multipart upload
+// uploadID to bucket has been verified with.
+func (c *multipartChecksums) putPart(ctx context.Context, bucket, uploadID string, partNumber int, checksum partChecksum) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	data, err := json.Marshal(storedPartChecksum{Algorithm: checksum.algorithm, Value: checksum.value})
+	if err != nil {
+		return err
+	}
+	if err := c.partNode(uploadID, partNumber).put(ctx, bucket, "application/json", data); err != nil {
+		return err
+	}
+	c.parts.Add(ctx, partKey(uploadID, partNumber), checksum)
+	return nil
+}
+
+// part returns the checksum part partNumber of the multipart upload uploadID
+// to bucket has been verified with, and whether it has one.
+func (c *multipartChecksums) part(ctx context.Context, bucket, uploadID string, partNumber int) (_ partChecksum, _ bool, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if checksum, ok := c.parts.GetCached(ctx, partKey(uploadID, partNumber)); ok {
+		return checksum, true, nil
+	}
+
+	data, err := c.partNode(uploadID, partNumber).get(ctx, bucket)
+	if err != nil || data == nil {
+		return partChecksum{}, false, err
+	}
+	var stored storedPartChecksum
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return partChecksum{}, false, errs.New("invalid stored part checksum: %v", err)
+	}
+	checksum := partChecksum{algorithm: stored.Algorithm, value: stored.Value}
+	c.parts.Add(ctx, partKey(uploadID, partNumber), checksum)
+	return checksum, true, nil
+}
+
+// deleteParts deletes the checksums of the parts partNumbers of the multipart
+// upload uploadID to bucket, once it's completed or aborted.
+func (c *multipartChecksums) deleteParts(ctx context.Context, bucket, uploadID string, partNumbers []int) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	for _, partNumber := range partNumbers {
+		c.parts.Delete(ctx, partKey(uploadID, partNumber))
+		err = errs.Combine(err, c.partNode(uploadID, partNumber).delete(ctx, bucket))
+	}
+	return err
+}
+
+// storedPartChecksum is a partChecksum, as it's stored in the node.
+type storedPartChecksum struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+// composite returns the composite checksum of the object the multipart upload
+// uploadID to bucket, started with the checksum algorithm started, is
+// completed into from the parts partNumbers, listed with the checksums
+// listed. It's computed from the checksums the parts have been verified with,
+// which the listed ones have to match, and is empty if parts are listed
+// without checksums, in uploads started without an algorithm. Parts listed
+// with checksums whose verified ones can't be found fail it, rather than
+// leave the object without a checksum.
+func (c *multipartChecksums) composite(ctx context.Context, bucket, uploadID, started string, partNumbers []int, listed []partChecksum) (algorithm, value string, err error) {
+	if _, _, err := compositeChecksum(listed); err != nil {
+		return "", "", err
+	}
+
+	verified := make([]partChecksum, 0, len(listed))
+	for i, checksum := range listed {
+		if started != "" && checksum.algorithm != started {
+			return "", "", ErrChecksum.New("part %d has to be listed with its %s checksum", partNumbers[i], checksumHeader(started))
+		}
+		if checksum.algorithm == "" {
+			// parts listed without checksums aren't looked up, but
+			// aren't accepted either if they're known to have one.
+			if _, ok := c.parts.GetCached(ctx, partKey(uploadID, partNumbers[i])); ok {
+				return "", "", errPartChecksum.New("part %d has to be listed with its checksum", partNumbers[i])
+			}
+			continue
+		}
+		stored, ok, err := c.part(ctx, bucket, uploadID, partNumbers[i])
+		if err != nil {
+			return "", "", err
+		}
+		if !ok {
+			return "", "", errPartChecksum.New("part %d hasn't been uploaded with a checksum", partNumbers[i])
+		}
+		if stored != checksum {
+			return "", "", errPartChecksum.New("part %d doesn't have the checksum it's listed with", partNumbers[i])
+		}
+		verified = append(verified, stored)
+	}
+	return compositeChecksum(verified)
+}
+
+// checksumBucket returns the bucket of the object of r, once it's been
+// substituted.
+func checksumBucket(r *http.Request) string {
+	bucket, _, _ := strings.Cut(mux.Vars(r)[VarKeyObject], Sep)
+	return bucket
+}
+
+// checksumReader computes the additional checksum of the data of a request
+// as it's read, and verifies it once it's read entirely.
+type checksumReader struct {
+	io.ReadCloser
+
+	algorithm string
+	expected  string
+	chunked   *chunkedReader
+	hash      hash.Hash
+	remaining int64
+	err       error
+
+	// value is the verified checksum, once the data has been read.
+	value string
+	// metadata is the metadata of the uploaded object, the checksum is added
+	// to once verified.
+	metadata map[string]string
+	// store, if set, stores the checksum once verified, before the data is
+	// accepted.
+	store func(value string) error
+}
+
+// newChecksumReader returns a checksumReader for the size bytes of body, whose
+// checksum computed with algorithm has to be expected, or the one sent in the
+// trailer of chunked, if any.
+func newChecksumReader(body io.ReadCloser, size int64, algorithm, expected string, chunked *chunkedReader) *checksumReader {
+	return &checksumReader{
+		ReadCloser: body,
+		algorithm:  algorithm,
+		expected:   expected,
+		chunked:    chunked,
+		hash:       checksumAlgorithms[algorithm](),
+		remaining:  size,
+	}
+}
+
+// bindMetadata is passed to gw.WithUploadMetadata.
+func (c *checksumReader) bindMetadata(metadata map[string]string) {
+	c.metadata = metadata
+}
+
+// Read verifies the checksum as soon as size bytes have been read, as the
+// readers minio wraps bodies in stop reading them then.
+func (c *checksumReader) Read(p []byte) (n int, err error) {
+	if c.remaining <= 0 {
+		return 0, c.verify()
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+
+	n, err = c.ReadCloser.Read(p)
+	_, _ = c.hash.Write(p[:n])
+	c.remaining -= int64(n)
+
+	switch {
+	case c.remaining == 0 && (err == nil || errors.Is(err, io.EOF)):
+		err = c.verify()
+	case errors.Is(err, io.EOF):
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (c *checksumReader) verify() error {
+	if c.err == nil {
+		c.err = c.check()
+	}
+	return c.err
+}
+
+func (c *checksumReader) check() error {
+	// reading past the data reads the trailer of aws-chunked bodies.
+	var b [1]byte
+	if n, err := io.ReadFull(c.ReadCloser, b[:]); n > 0 {
+		return cmd.InvalidArgument{Err: errs.New("body is longer than its declared length")}
+	} else if !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	expected := c.expected
+	if c.chunked != nil {
+		if expected = c.chunked.trailer.Get(checksumHeader(c.algorithm)); expected == "" {
+			return cmd.InvalidArgument{Err: errs.New("missing trailing %s", checksumHeader(c.algorithm))}
+		}
+	}
+
+	value := base64.StdEncoding.EncodeToString(c.hash.Sum(nil))
+	if expected != "" && expected != value {
+		return miniohash.BadDigest{ExpectedMD5: expected, CalculatedMD5: value}
+	}
+
+	if c.store != nil {
+		if err := c.store(value); err != nil {
+			return err
+		}
+	}
+
+	c.value = value
+	if c.metadata != nil {
+		c.metadata[checksumMetadataKey(c.algorithm)] = value
+	}
+	return io.EOF
+}
+
+// chunkedReader decodes aws-chunked bodies sent with
+// STREAMING-UNSIGNED-PAYLOAD-TRAILER, whose chunks aren't signed, keeping the
+// trailer that follows them.
+type chunkedReader struct {
+	io.Closer
+
+	r         *bufio.Reader
+	remaining int64
+	started   bool
+	done      bool
+	trailer   http.Header
+}
+
+func newChunkedReader(body io.ReadCloser) *chunkedReader {
+	return &chunkedReader{
+		Closer:  body,
+		r:       bufio.NewReaderSize(body, maxChunkLineSize),
+		trailer: make(http.Header),
+	}
+}
+
+func (c *chunkedReader) Read(p []byte) (n int, err error) {
+	for c.remaining == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+
+	n, err = c.r.Read(p)
+	c.remaining -= int64(n)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextChunk reads the header of the next chunk, and the trailer after the
+// last one.
+func (c *chunkedReader) nextChunk() error {
+	if c.started {
+		// the data of chunks is followed by CRLF.
+		if line, err := c.readLine(); err != nil {
+			return err
+		} else if line != "" {
+			return errMalformedChunkedBody
+		}
+	}
+	c.started = true
+
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		line = line[:i] // chunk extensions, like chunk-signature.
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+	if err != nil || size < 0 {
+		return errMalformedChunkedBody
+	}
+	if size > 0 {
+		c.remaining = size
+		return nil
+	}
+
+	c.done = true
+	for {
+		line, err := c.readLine()
+		switch {
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return nil // some clients don't end the trailer with an empty line.
+		case err != nil:
+			return err
+		case line == "":
+			return nil
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return errMalformedChunkedBody
+		}
+		c.trailer.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+}
+
+// readLine reads a line of the body, without its CRLF.
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	switch {
+	case errors.Is(err, bufio.ErrBufferFull):
+		return "", errMalformedChunkedBody
+	case errors.Is(err, io.EOF):
+		if len(line) > 0 {
+			return "", errMalformedChunkedBody
+		}
+		return "", io.ErrUnexpectedEOF
+	case err != nil:
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// decodeChunkedRequest returns r with its aws-chunked body decoded by the
+// returned chunkedReader, so minio, which doesn't support them, can handle
+// it. The seed signature of r is verified, and r is signed again with the
+// same credentials once its headers describe the decoded body.
+func decodeChunkedRequest(r *http.Request) (*http.Request, *chunkedReader, cmd.APIErrorCode) {
+	ctx := r.Context()
+
+	if !strings.HasPrefix(r.Header.Get(xhttp.Authorization), signV4Algorithm) {
+		return nil, nil, cmd.ErrSignatureVersionNotSupported
+	}
+	credentials := middleware.GetAccess(ctx)
+	if credentials == nil || credentials.Error != nil || credentials.SecretKey == "" {
+		return nil, nil, cmd.ErrAccessDenied
+	}
+	v4, err := middleware.ParseV4FromHeader(r)
+	if err != nil {
+		return nil, nil, cmd.ErrCredMalformed
+	}
+
+	// the payload hash isn't a hex SHA256, so minio reports it doesn't match
+	// once it has verified the signature.
+	if _, _, code := cmd.CheckRequestAuthTypeCredential(ctx, r, policy.PutObjectAction, "", ""); code != cmd.ErrContentSHA256Mismatch {
+		if code == cmd.ErrNone {
+			code = cmd.ErrContentSHA256Mismatch
+		}
+		return nil, nil, code
+	}
+
+	size, err := strconv.ParseInt(r.Header.Get(xhttp.AmzDecodedContentLength), 10, 64)
+	if err != nil || size < 0 {
+		return nil, nil, cmd.ErrMissingContentLength
+	}
+
+	chunked := newChunkedReader(r.Body)
+	r.Body = chunked
+	r.ContentLength = size
+	r.Header.Set(xhttp.ContentLength, strconv.FormatInt(size, 10))
+	r.Header.Set(xhttp.AmzContentSha256, unsignedPayload)
+	r.Header.Del(xhttp.AmzDecodedContentLength)
+	r.Header.Del(amzTrailer)
+	r.Header.Del(xhttp.Authorization)
+
+	var encodings []string
+	for _, encoding := range strings.Split(r.Header.Get(xhttp.ContentEncoding), ",") {
+		if encoding = strings.TrimSpace(encoding); encoding != "" && !strings.EqualFold(encoding, awsChunkedEncoding) {
+			encodings = append(encodings, encoding)
+		}
+	}
+	if len(encodings) > 0 {
+		r.Header.Set(xhttp.ContentEncoding, strings.Join(encodings, ","))
+	} else {
+		r.Header.Del(xhttp.ContentEncoding)
+	}
+
+	return signer.SignV4(*r, credentials.AccessKey, credentials.SecretKey, "", v4.Credential.Region), chunked, cmd.ErrNone
+}
+
+// withRequestChecksum returns w and r so the additional checksum of the data
+// uploaded by r, if any, is verified as it's read and returned once it's
+// uploaded. For PutObject, the checksum is stored with the object.
+func (h objectAPIHandlersWrapper) withRequestChecksum(w http.ResponseWriter, r *http.Request, fName string) (http.ResponseWriter, *http.Request, error) {
+	errCtx := cmd.NewContext(r, w, fName)
+
+	algorithm, expected, trailer, err := requestChecksum(r.Header)
+	if err != nil {
+		apiErr := apiErrors[ErrInvalidChecksum]
+		apiErr.Description = err.Error()
+		cmd.WriteErrorResponse(errCtx, w, apiErr, r.URL, false)
+		return nil, nil, err
+	}
+
+	var chunked *chunkedReader
+	switch {
+	case r.Header.Get(xhttp.AmzContentSha256) == streamingUnsignedPayloadTrailer:
+		if !trailer {
+			err := ErrChecksum.New("%s is required with %s", amzTrailer, streamingUnsignedPayloadTrailer)
+			apiErr := apiErrors[ErrInvalidChecksum]
+			apiErr.Description = err.Error()
+			cmd.WriteErrorResponse(errCtx, w, apiErr, r.URL, false)
+			return nil, nil, err
+		}
+		decoded, decodedBody, code := decodeChunkedRequest(r)
+		if code != cmd.ErrNone {
+			cmd.WriteErrorResponse(errCtx, w, cmd.GetAPIError(code), r.URL, false)
+			return nil, nil, errs.New("failed to decode aws-chunked request")
+		}
+		r, chunked = decoded, decodedBody
+	case trailer:
+		err := ErrChecksum.New("trailing checksums are only supported with %s", streamingUnsignedPayloadTrailer)
+		apiErr := apiErrors[ErrInvalidChecksum]
+		apiErr.Description = err.Error()
+		cmd.WriteErrorResponse(errCtx, w, apiErr, r.URL, false)
+		return nil, nil, err
+	}
+
+	if algorithm == "" {
+		return w, r, nil
+	}
+	if r.ContentLength < 0 {
+		cmd.WriteErrorResponse(errCtx, w, cmd.GetAPIError(cmd.ErrMissingContentLength), r.URL, false)
+		return nil, nil, errs.New("missing content length")
+	}
+
+	reader := newChecksumReader(r.Body, r.ContentLength, algorithm, expected, chunked)
+	r.Body = reader
+	r = r.WithContext(gw.WithUploadMetadata(r.Context(), reader.bindMetadata))
+
+	return &successHeaderWriter{ResponseWriter: w, set: func(header http.Header) {
+		if reader.value != "" {
+			header.Set(checksumHeader(algorithm), reader.value)
+		}
+	}}, r, nil
+}
+
+// withChecksumAlgorithm returns w and r so the additional checksum algorithm
+// r starts a multipart upload with is stored with it, for its parts to be
+// held to, and returned once it's started.
+func (h objectAPIHandlersWrapper) withChecksumAlgorithm(w http.ResponseWriter, r *http.Request, fName string) (http.ResponseWriter, *http.Request, error) {
+	name := r.Header.Get(amzChecksumAlgorithm)
+	if name == "" {
+		return w, r, nil
+	}
+
+	algorithm, ok := checksumAlgorithm(name)
+	if !ok {
+		err := ErrChecksum.New("unsupported %s %q", amzChecksumAlgorithm, name)
+		apiErr := apiErrors[ErrInvalidChecksum]
+		apiErr.Description = err.Error()
+		cmd.WriteErrorResponse(cmd.NewContext(r, w, fName), w, apiErr, r.URL, false)
+		return nil, nil, err
+	}
+
+	r = r.WithContext(gw.WithUploadMetadata(r.Context(), func(metadata map[string]string) {
+		metadata[gw.ChecksumAlgorithmMetadataKey] = algorithm
+	}))
+
+	return &successHeaderWriter{ResponseWriter: w, set: func(header http.Header) {
+		header.Set(amzChecksumAlgorithm, algorithm)
+	}}, r, nil
+}
+
+// withPartChecksum makes sure the checksum the part r uploads is verified
+// with is stored before the part is, for CompleteMultipartUpload. Parts of
+// uploads started with a checksum algorithm have to be uploaded with a
+// checksum computed with it. r has to have been returned by
+// withRequestChecksum, and its bucket and object substituted.
+func (h objectAPIHandlersWrapper) withPartChecksum(w http.ResponseWriter, r *http.Request, fName string) error {
+	errCtx := cmd.NewContext(r, w, fName)
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+
+	uploadID := query.Get("uploadId")
+	partNumber, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil {
+		return nil // left for minio to report.
+	}
+
+	algorithm, err := h.multipartChecksums.algorithm(errCtx, h.core.ObjectAPI(), vars[VarKeyBucket], vars[VarKeyObject], uploadID)
+	if err != nil {
+		cmd.WriteErrorResponse(errCtx, w, cmd.ToAPIError(errCtx, err), r.URL, false)
+		return err
+	}
+
+	// withRequestChecksum replaces the body of requests with checksums.
+	reader, _ := r.Body.(*checksumReader)
+	if algorithm != "" && (reader == nil || reader.algorithm != algorithm) {
+		err := ErrChecksum.New("parts of this upload have to be uploaded with a %s checksum", checksumHeader(algorithm))
+		apiErr := apiErrors[ErrInvalidChecksum]
+		apiErr.Description = err.Error()
+		cmd.WriteErrorResponse(errCtx, w, apiErr, r.URL, false)
+		return err
+	}
+	if reader == nil {
+		return nil
+	}
+
+	bucket := checksumBucket(r)
+	reader.store = func(value string) error {
+		return h.multipartChecksums.putPart(errCtx, bucket, uploadID, partNumber, partChecksum{
+			algorithm: reader.algorithm,
+			value:     value,
+		})
+	}
+	return nil
+}
+
+// deletePartChecksums deletes the stored checksums of the parts partNumbers
+// of the multipart upload of r, once it's been completed or aborted. r has to
+// have its bucket and object substituted.
+func (h objectAPIHandlersWrapper) deletePartChecksums(ctx context.Context, r *http.Request, partNumbers []int) {
+	if len(partNumbers) == 0 {
+		return
+	}
+	vars := mux.Vars(r)
+	uploadID := r.URL.Query().Get("uploadId")
+
+	// the upload is left as it is if it's failed to be completed or aborted.
+	_, err := h.core.ObjectAPI().GetMultipartInfo(ctx, vars[VarKeyBucket], vars[VarKeyObject], uploadID, cmd.ObjectOptions{})
+	if !errors.As(err, &cmd.ObjectNotFound{}) && !errors.As(err, &cmd.InvalidUploadID{}) {
+		return
+	}
+
+	if err := h.multipartChecksums.deleteParts(ctx, checksumBucket(r), uploadID, partNumbers); err != nil {
+		h.logger.With("upload", uploadID).Warnf("failed to delete part checksums: %v", err)
+		mon.Event("part_checksums_delete_failed")
+	}
+}
+
+// uploadedParts returns the numbers of the parts of the multipart upload of
+// r, whose checksums are deleted once it's aborted. r has to have its bucket
+// and object substituted.
+func (h objectAPIHandlersWrapper) uploadedParts(ctx context.Context, r *http.Request) ([]int, error) {
+	vars := mux.Vars(r)
+	uploadID := r.URL.Query().Get("uploadId")
+
+	var partNumbers []int
+	marker := 0
+	for {
+		info, err := h.core.ObjectAPI().ListObjectParts(ctx, vars[VarKeyBucket], vars[VarKeyObject], uploadID, marker, maxPartsList, cmd.ObjectOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range info.Parts {
+			partNumbers = append(partNumbers, part.PartNumber)
+		}
+		if !info.IsTruncated || info.NextPartNumberMarker <= marker {
+			return partNumbers, nil
+		}
+		marker = info.NextPartNumberMarker
+	}
+}
+
+// withCompositeChecksum returns r so the object it completes is stored with
+// the composite checksum of its parts, computed from the checksums they've
+// been verified with. The ones listed in the request have to match them, and
+// the algorithm the upload has been started with. The numbers of the parts
+// with checksums are returned too, for deletePartChecksums. r has to have its
+// bucket and object substituted.
+func (h objectAPIHandlersWrapper) withCompositeChecksum(w http.ResponseWriter, r *http.Request, fName string) (*http.Request, []int, error) {
+	errCtx := cmd.NewContext(r, w, fName)
+	vars := mux.Vars(r)
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxCompleteMultipartUploadSize+1))
+	if err != nil {
+		cmd.WriteErrorResponse(errCtx, w, cmd.ToAPIError(errCtx, err), r.URL, false)
+		return nil, nil, err
+	}
+	if len(data) > maxCompleteMultipartUploadSize {
+		cmd.WriteErrorResponse(errCtx, w, cmd.GetAPIError(cmd.ErrEntityTooLarge), r.URL, false)
+		return nil, nil, errs.New("request body too large")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
+
+	partNumbers, listed, err := completedPartChecksums(data)
+	if err != nil {
+		apiErr := apiErrors[ErrInvalidChecksum]
+		apiErr.Description = err.Error()
+		cmd.WriteErrorResponse(errCtx, w, apiErr, r.URL, false)
+		return nil, nil, err
+	}
+
+	uploadID := r.URL.Query().Get("uploadId")
+	started, err := h.multipartChecksums.algorithm(errCtx, h.core.ObjectAPI(), vars[VarKeyBucket], vars[VarKeyObject], uploadID)
+	if err != nil {
+		cmd.WriteErrorResponse(errCtx, w, cmd.ToAPIError(errCtx, err), r.URL, false)
+		return nil, nil, err
+	}
+
+	algorithm, value, err := h.multipartChecksums.composite(errCtx, checksumBucket(r), uploadID, started, partNumbers, listed)
+	switch {
+	case errPartChecksum.Has(err):
+		cmd.WriteErrorResponse(errCtx, w, cmd.GetAPIError(cmd.ErrInvalidPart), r.URL, false)
+		return nil, nil, err
+	case err != nil:
+		apiErr := apiErrors[ErrInvalidChecksum]
+		apiErr.Description = err.Error()
+		cmd.WriteErrorResponse(errCtx, w, apiErr, r.URL, false)
+		return nil, nil, err
+	}
+	if started == "" && value == "" {
+		return r, nil, nil
+	}
+
+	var checksummed []int
+	for i, checksum := range listed {
+		if checksum.algorithm != "" {
+			checksummed = append(checksummed, partNumbers[i])
+		}
+	}
+
+	return r.WithContext(gw.WithUploadMetadata(r.Context(), func(metadata map[string]string) {
+		delete(metadata, gw.ChecksumAlgorithmMetadataKey)
+		if value != "" {
+			metadata[checksumMetadataKey(algorithm)] = value
+		}
+	})), checksummed, nil
+}
+
+// withChecksumMode returns r so the checksums of the object it gets are
+// returned, if it asks for them with x-amz-checksum-mode. Like S3, they're
+// only returned for entire objects.
+func withChecksumMode(r *http.Request) *http.Request {
+	if !strings.EqualFold(r.Header.Get(amzChecksumMode), "ENABLED") ||
+		r.Header.Get(xhttp.Range) != "" || r.URL.Query().Get("partNumber") != "" {
+		return r
+	}
+	return r.WithContext(gw.WithChecksumMode(r.Context()))
+}
+
+// successHeaderWriter sets headers of successful responses once they're
+// known, right before they're written.
+type successHeaderWriter struct {
+	http.ResponseWriter
+	set func(http.Header)
+}
+
+func (w *successHeaderWriter) WriteHeader(statusCode int) {
+	if statusCode == http.StatusOK {
+		w.set(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *successHeaderWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
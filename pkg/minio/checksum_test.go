@@ -0,0 +1,299 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/testcontext"
+	"storj.io/gateway-mt/pkg/server/gw"
+	"storj.io/minio/cmd"
+	miniohash "storj.io/minio/pkg/hash"
+)
+
+func TestRequestChecksum(t *testing.T) {
+	tests := []struct {
+		header    map[string]string
+		algorithm string
+		expected  string
+		trailer   bool
+		invalid   bool
+	}{
+		{header: map[string]string{}},
+		{header: map[string]string{"x-amz-checksum-crc32": "DUoRhQ=="}, algorithm: "CRC32", expected: "DUoRhQ=="},
+		{header: map[string]string{"x-amz-sdk-checksum-algorithm": "sha256"}, algorithm: "SHA256"},
+		{header: map[string]string{"x-amz-trailer": "x-amz-checksum-crc32c", "x-amz-sdk-checksum-algorithm": "CRC32C"}, algorithm: "CRC32C", trailer: true},
+		{header: map[string]string{"x-amz-checksum-crc32": "DUoRhQ==", "x-amz-checksum-sha1": "Kq5sNclPz7QV2+lfQIuc6R7oRu0="}, invalid: true},
+		{header: map[string]string{"x-amz-checksum-crc32": "DUoRhQ==", "x-amz-trailer": "x-amz-checksum-crc32"}, invalid: true},
+		{header: map[string]string{"x-amz-checksum-crc32": "DUoRhQ==", "x-amz-sdk-checksum-algorithm": "SHA1"}, invalid: true},
+		{header: map[string]string{"x-amz-checksum-crc32": "uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek="}, invalid: true},
+		{header: map[string]string{"x-amz-trailer": "x-amz-meta-a"}, invalid: true},
+		{header: map[string]string{"x-amz-sdk-checksum-algorithm": "MD5"}, invalid: true},
+	}
+	for i, tc := range tests {
+		header := make(http.Header)
+		for k, v := range tc.header {
+			header.Set(k, v)
+		}
+		algorithm, expected, trailer, err := requestChecksum(header)
+		if tc.invalid {
+			assert.True(t, ErrChecksum.Has(err), i)
+			continue
+		}
+		require.NoError(t, err, i)
+		assert.Equal(t, tc.algorithm, algorithm, i)
+		assert.Equal(t, tc.expected, expected, i)
+		assert.Equal(t, tc.trailer, trailer, i)
+	}
+}
+
+func TestCompletedPartChecksums(t *testing.T) {
+	partNumbers, checksums, err := completedPartChecksums([]byte(`<CompleteMultipartUpload>
+		<Part><PartNumber>1</PartNumber><ETag>a</ETag><ChecksumCRC32>7YH59g==</ChecksumCRC32></Part>
+		<Part><PartNumber>2</PartNumber><ETag>b</ETag></Part>
+	</CompleteMultipartUpload>`))
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, partNumbers)
+	assert.Equal(t, []partChecksum{{algorithm: "CRC32", value: "7YH59g=="}, {}}, checksums)
+
+	_, _, err = completedPartChecksums([]byte(`<CompleteMultipartUpload>
+		<Part><PartNumber>1</PartNumber><ETag>a</ETag><ChecksumCRC32>7YH59g==</ChecksumCRC32><ChecksumSHA1>Kq5sNclPz7QV2+lfQIuc6R7oRu0=</ChecksumSHA1></Part>
+	</CompleteMultipartUpload>`))
+	assert.True(t, ErrChecksum.Has(err))
+
+	_, _, err = completedPartChecksums([]byte(`<CompleteMultipartUpload>
+		<Part><PartNumber>1</PartNumber><ETag>a</ETag><ChecksumCRC32>invalid</ChecksumCRC32></Part>
+	</CompleteMultipartUpload>`))
+	assert.True(t, ErrChecksum.Has(err))
+}
+
+func TestCompositeChecksum(t *testing.T) {
+	algorithm, value, err := compositeChecksum([]partChecksum{
+		{algorithm: "CRC32", value: "7YH59g=="},
+		{algorithm: "CRC32", value: "OncRQw=="},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "CRC32", algorithm)
+	assert.Equal(t, "1Fu2mQ==-2", value)
+
+	algorithm, _, err = compositeChecksum([]partChecksum{{}})
+	require.NoError(t, err)
+	assert.Empty(t, algorithm)
+
+	_, _, err = compositeChecksum([]partChecksum{{algorithm: "CRC32", value: "7YH59g=="}, {}})
+	assert.True(t, ErrChecksum.Has(err))
+}
+
+func TestMultipartChecksums(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	node := &testBucketConfigNode{configs: map[string]string{}}
+	server := httptest.NewServer(node)
+	defer server.Close()
+	config := DwsConfig{DwsBackendHost: server.URL, DwsNodeToken: "token"}
+
+	layer := &testMultipartLayer{algorithms: map[string]string{"crc32": "CRC32"}}
+	checksums := newMultipartChecksums(config)
+
+	algorithm, err := checksums.algorithm(ctx, layer, "bucket", "object", "crc32")
+	require.NoError(t, err)
+	assert.Equal(t, "CRC32", algorithm)
+	for _, uploadID := range []string{"none", "missing"} {
+		algorithm, err = checksums.algorithm(ctx, layer, "bucket", "object", uploadID)
+		require.NoError(t, err)
+		assert.Empty(t, algorithm)
+	}
+
+	first := partChecksum{algorithm: "CRC32", value: "7YH59g=="}
+	second := partChecksum{algorithm: "CRC32", value: "OncRQw=="}
+	require.NoError(t, checksums.putPart(ctx, "photos", "crc32", 1, first))
+	require.NoError(t, checksums.putPart(ctx, "photos", "crc32", 2, second))
+	assert.Len(t, node.configs, 2)
+
+	algorithm, value, err := checksums.composite(ctx, "photos", "crc32", "CRC32", []int{1, 2}, []partChecksum{first, second})
+	require.NoError(t, err)
+	assert.Equal(t, "CRC32", algorithm)
+	assert.Equal(t, "1Fu2mQ==-2", value)
+
+	// the listed checksums have to be the ones parts have been verified with.
+	_, _, err = checksums.composite(ctx, "photos", "crc32", "CRC32", []int{1, 2}, []partChecksum{first, first})
+	assert.True(t, errPartChecksum.Has(err))
+	_, _, err = checksums.composite(ctx, "photos", "crc32", "", []int{1, 2}, []partChecksum{first, {}})
+	assert.True(t, ErrChecksum.Has(err))
+	_, _, err = checksums.composite(ctx, "photos", "crc32", "", []int{1, 2}, []partChecksum{{}, {}})
+	assert.True(t, errPartChecksum.Has(err))
+
+	// and have the algorithm the upload has been started with.
+	_, _, err = checksums.composite(ctx, "photos", "crc32", "SHA1", []int{1, 2}, []partChecksum{first, second})
+	assert.True(t, ErrChecksum.Has(err))
+
+	// parts verified by other gateways are looked up in the node.
+	other := newMultipartChecksums(config)
+	algorithm, value, err = other.composite(ctx, "photos", "crc32", "CRC32", []int{1, 2}, []partChecksum{first, second})
+	require.NoError(t, err)
+	assert.Equal(t, "CRC32", algorithm)
+	assert.Equal(t, "1Fu2mQ==-2", value)
+
+	// parts whose checksums can't be found fail the composite checksum.
+	_, _, err = checksums.composite(ctx, "photos", "crc32", "CRC32", []int{1, 3}, []partChecksum{first, second})
+	assert.True(t, errPartChecksum.Has(err))
+
+	node.fail = true
+	_, _, err = newMultipartChecksums(config).composite(ctx, "photos", "crc32", "CRC32", []int{1, 2}, []partChecksum{first, second})
+	require.Error(t, err)
+	assert.False(t, errPartChecksum.Has(err))
+	assert.Error(t, checksums.putPart(ctx, "photos", "crc32", 3, first))
+	node.fail = false
+
+	require.NoError(t, checksums.deleteParts(ctx, "photos", "crc32", []int{1, 2}))
+	assert.Empty(t, node.configs)
+	_, ok, err := checksums.part(ctx, "photos", "crc32", 1)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestWithPartChecksum(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	node := &testBucketConfigNode{configs: map[string]string{}}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	h := objectAPIHandlersWrapper{
+		core: cmd.ObjectAPIHandlers{
+			ObjectAPI: func() cmd.ObjectLayer {
+				return &testMultipartLayer{algorithms: map[string]string{"crc32": "CRC32"}}
+			},
+		},
+		multipartChecksums: newMultipartChecksums(DwsConfig{DwsBackendHost: server.URL, DwsNodeToken: "token"}),
+	}
+
+	newRequest := func(uploadID, algorithm string) *http.Request {
+		r := httptest.NewRequest(http.MethodPut, "/photos/object?partNumber=1&uploadId="+uploadID, strings.NewReader("hello world"))
+		r = mux.SetURLVars(r, map[string]string{VarKeyBucket: "user", VarKeyObject: "photos/object"})
+		if algorithm != "" {
+			r.Body = newChecksumReader(r.Body, 11, algorithm, "", nil)
+		}
+		return r
+	}
+
+	for _, tc := range []struct {
+		uploadID, algorithm string
+		value               string
+	}{
+		{uploadID: "crc32", algorithm: "CRC32", value: "DUoRhQ=="},
+		{uploadID: "none", algorithm: "SHA1", value: "Kq5sNclPz7QV2+lfQIuc6R7oRu0="},
+		{uploadID: "other"},
+	} {
+		r := newRequest(tc.uploadID, tc.algorithm)
+		require.NoError(t, h.withPartChecksum(httptest.NewRecorder(), r, "PutObjectPart"))
+		_, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		stored, ok, err := newMultipartChecksums(DwsConfig{DwsBackendHost: server.URL, DwsNodeToken: "token"}).part(ctx, "photos", tc.uploadID, 1)
+		require.NoError(t, err)
+		assert.Equal(t, tc.value != "", ok, tc.uploadID)
+		assert.Equal(t, tc.value, stored.value, tc.uploadID)
+	}
+
+	// parts have to be uploaded with the algorithm of their upload.
+	for _, algorithm := range []string{"", "SHA1"} {
+		rec := httptest.NewRecorder()
+		err := h.withPartChecksum(rec, newRequest("crc32", algorithm), "PutObjectPart")
+		assert.True(t, ErrChecksum.Has(err), algorithm)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, algorithm)
+	}
+
+	// and aren't accepted if their checksums can't be stored.
+	node.fail = true
+	r := newRequest("crc32", "CRC32")
+	require.NoError(t, h.withPartChecksum(httptest.NewRecorder(), r, "PutObjectPart"))
+	_, err := io.ReadAll(r.Body)
+	require.Error(t, err)
+}
+
+// testMultipartLayer is an object layer with multipart uploads started with
+// the checksum algorithms of their IDs.
+type testMultipartLayer struct {
+	cmd.ObjectLayer
+
+	algorithms map[string]string
+}
+
+func (l *testMultipartLayer) GetMultipartInfo(ctx context.Context, bucket, object, uploadID string, opts cmd.ObjectOptions) (cmd.MultipartInfo, error) {
+	if uploadID == "missing" {
+		return cmd.MultipartInfo{}, cmd.InvalidUploadID{UploadID: uploadID}
+	}
+	info := cmd.MultipartInfo{Bucket: bucket, Object: object, UploadID: uploadID, UserDefined: map[string]string{}}
+	if algorithm, ok := l.algorithms[uploadID]; ok {
+		info.UserDefined[gw.ChecksumAlgorithmMetadataKey] = algorithm
+	}
+	return info, nil
+}
+
+func TestChecksumReader(t *testing.T) {
+	metadata := map[string]string{}
+	reader := newChecksumReader(io.NopCloser(strings.NewReader("hello world")), 11, "CRC32", "DUoRhQ==", nil)
+	reader.bindMetadata(metadata)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+	assert.Equal(t, "DUoRhQ==", reader.value)
+	assert.Equal(t, map[string]string{"s3:checksum-crc32": "DUoRhQ=="}, metadata)
+
+	// the checksum is verified as soon as all the data has been read.
+	reader = newChecksumReader(io.NopCloser(strings.NewReader("hello world")), 11, "SHA256", "", nil)
+	n, err := reader.Read(make([]byte, 11))
+	assert.Equal(t, 11, n)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, "uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=", reader.value)
+
+	reader = newChecksumReader(io.NopCloser(strings.NewReader("hello world")), 11, "CRC32", "7YH59g==", nil)
+	_, err = io.ReadAll(reader)
+	assert.ErrorAs(t, err, &miniohash.BadDigest{})
+	assert.Empty(t, reader.value)
+
+	reader = newChecksumReader(io.NopCloser(strings.NewReader("hello")), 11, "CRC32", "", nil)
+	_, err = io.ReadAll(reader)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestChunkedChecksumReader(t *testing.T) {
+	body := "6\r\nhello \r\n5\r\nworld\r\n0\r\nx-amz-checksum-crc32:DUoRhQ==\r\n\r\n"
+	chunked := newChunkedReader(io.NopCloser(strings.NewReader(body)))
+	reader := newChecksumReader(chunked, 11, "CRC32", "", chunked)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+	assert.Equal(t, "DUoRhQ==", reader.value)
+
+	// with chunk extensions, and without the final empty line.
+	body = "b;chunk-signature=abc\r\nhello world\r\n0\r\nx-amz-checksum-crc32:7YH59g==\r\n"
+	chunked = newChunkedReader(io.NopCloser(strings.NewReader(body)))
+	reader = newChecksumReader(chunked, 11, "CRC32", "", chunked)
+	_, err = io.ReadAll(reader)
+	assert.ErrorAs(t, err, &miniohash.BadDigest{})
+
+	for _, body := range []string{
+		"b\r\nhello world\r\n0\r\n\r\n",
+		"b\r\nhello worldX\r\n0\r\nx-amz-checksum-crc32:DUoRhQ==\r\n\r\n",
+		"z\r\nhello world\r\n0\r\nx-amz-checksum-crc32:DUoRhQ==\r\n\r\n",
+		"b\r\nhello world\r\n1\r\n!\r\n0\r\nx-amz-checksum-crc32:DUoRhQ==\r\n\r\n",
+	} {
+		chunked := newChunkedReader(io.NopCloser(strings.NewReader(body)))
+		reader := newChecksumReader(chunked, 11, "CRC32", "", chunked)
+		_, err := io.ReadAll(reader)
+		assert.Error(t, err, body)
+		assert.Empty(t, reader.value, body)
+	}
+}
@@ -20,19 +20,32 @@
 package minio
 
 import (
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/minio/minio-go/v7/pkg/s3utils"
 	"github.com/rs/cors"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
 
+	"storj.io/gateway-mt/pkg/server/middleware"
 	"storj.io/minio/cmd"
 	xhttp "storj.io/minio/cmd/http"
 	"storj.io/minio/cmd/logger"
 	"storj.io/minio/pkg/wildcard"
 )
 
-// CorsHandler handler for CORS (Cross Origin Resource Sharing).
-func CorsHandler(allowedOrigins []string) mux.MiddlewareFunc {
+// CorsHandler handler for CORS (Cross Origin Resource Sharing). Requests to a
+// bucket with a CORS configuration are evaluated against its rules, while the
+// others are allowed from allowedOrigins. domainNames are used to find the
+// bucket of virtual-hosted-style requests.
+//
+// Bucket configurations are looked up before requests are authenticated, so
+// the client IP limits of rateLimiter, if any, are applied first.
+func CorsHandler(allowedOrigins, domainNames []string, bucketCORS *BucketCORS, rateLimiter *middleware.RateLimiter) mux.MiddlewareFunc {
 	return func(handler http.Handler) http.Handler {
 		commonS3Headers := []string{
 			xhttp.Date,
@@ -56,7 +69,7 @@ func CorsHandler(allowedOrigins []string) mux.MiddlewareFunc {
 			"*",
 		}
 
-		return cors.New(cors.Options{
+		global := cors.New(cors.Options{
 			AllowOriginFunc: func(origin string) bool {
 				for _, allowedOrigin := range allowedOrigins {
 					if wildcard.MatchSimple(allowedOrigin, origin) {
@@ -78,7 +91,117 @@ func CorsHandler(allowedOrigins []string) mux.MiddlewareFunc {
 			ExposedHeaders:   commonS3Headers,
 			AllowCredentials: true,
 		}).Handler(handler)
+
+		if bucketCORS == nil {
+			return global
+		}
+
+		var lookup http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bucket := requestBucket(r, domainNames)
+			config, err := bucketCORS.lookup(r.Context(), bucket)
+			if err != nil && !errs.Is(err, errCORSLookupsExhausted) {
+				mon.Event("bucket_cors_lookup_failed")
+				bucketCORS.log.Warn("unable to get bucket CORS configuration; using the global one", zap.String("bucket", bucket), zap.Error(err))
+			}
+			if config == nil {
+				global.ServeHTTP(w, r)
+				return
+			}
+
+			serveBucketCORS(w, r, r.Header.Get("Origin"), config, handler)
+		})
+		if rateLimiter != nil && rateLimiter.Enabled() {
+			lookup = rateLimiter.Limit(lookup)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Origin") == "" || s3utils.CheckValidBucketName(requestBucket(r, domainNames)) != nil {
+				global.ServeHTTP(w, r)
+				return
+			}
+			lookup.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestBucket returns the bucket r is for, whether it's named in the host or
+// the path.
+func requestBucket(r *http.Request, domainNames []string) string {
+	bucket, _ := requestBucketObject(r, domainNames)
+	return bucket
+}
+
+// requestBucketObject returns the bucket r is for, and the key of the object
+// if it's for one.
+func requestBucketObject(r *http.Request, domainNames []string) (bucket, object string) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, domainName := range domainNames {
+		if strings.HasSuffix(host, "."+domainName) {
+			return strings.TrimSuffix(host, "."+domainName), strings.TrimPrefix(r.URL.Path, "/")
+		}
+	}
+
+	bucket, object, _ = strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	return bucket, object
+}
+
+// serveBucketCORS answers preflight requests and adds CORS headers to actual
+// requests from origin according to the rules of config, the way S3 does.
+func serveBucketCORS(w http.ResponseWriter, r *http.Request, origin string, config *corsConfiguration, handler http.Handler) {
+	header := w.Header()
+	header.Add("Vary", "Origin")
+
+	if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+		if rule := config.match(origin, r.Method, nil); rule != nil {
+			setAllowOrigin(header, rule, origin)
+			if len(rule.ExposeHeaders) > 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(rule.ExposeHeaders, ", "))
+			}
+		}
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	var requestHeaders []string
+	for _, name := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			requestHeaders = append(requestHeaders, name)
+		}
+	}
+
+	rule := config.match(origin, r.Header.Get("Access-Control-Request-Method"), requestHeaders)
+	if rule == nil {
+		mon.Event("bucket_cors_preflight_forbidden")
+		cmd.WriteErrorResponse(r.Context(), w, apiErrors[ErrCORSForbidden], r.URL, false)
+		return
+	}
+
+	setAllowOrigin(header, rule, origin)
+	header.Set("Access-Control-Allow-Methods", strings.Join(rule.AllowedMethods, ", "))
+	if len(requestHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(requestHeaders, ", "))
+	}
+	if rule.MaxAgeSeconds != nil {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(*rule.MaxAgeSeconds))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// setAllowOrigin allows origin, or any origin without credentials if rule
+// does.
+func setAllowOrigin(header http.Header, rule *corsRule, origin string) {
+	if rule.allowsAnyOrigin() {
+		header.Set("Access-Control-Allow-Origin", "*")
+		return
 	}
+	header.Set("Access-Control-Allow-Origin", origin)
+	header.Set("Access-Control-Allow-Credentials", "true")
 }
 
 // CriticalErrorHandler handles critical server failures caused by
@@ -3,15 +3,18 @@ package minio
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	dwsProto "storj.io/gateway-mt/pkg/minio/dws/proto"
+	"storj.io/gateway-mt/pkg/server/middleware"
 	"storj.io/minio/cmd"
 	"storj.io/minio/pkg/bucket/policy"
 )
@@ -31,6 +34,16 @@ const (
 	ErrInternalError       = "ErrInternalError"
 	ErrBucketAlreadyExists = "ErrBucketAlreadyExists"
 	ErrNoSuchBucket        = "ErrNoSuchBucket"
+	ErrCORSForbidden       = "ErrCORSForbidden"
+	ErrInvalidCORSRequest  = "ErrInvalidCORSRequest"
+	ErrInvalidLogging      = "ErrInvalidLogging"
+	ErrInvalidLogTarget    = "ErrInvalidLogTarget"
+	ErrInvalidNotification = "ErrInvalidNotification"
+	ErrInvalidLifecycle    = "ErrInvalidLifecycle"
+	ErrNoSuchLifecycle     = "ErrNoSuchLifecycle"
+	ErrInvalidChecksum     = "ErrInvalidChecksum"
+	ErrInvalidPolicy       = "ErrInvalidPolicy"
+	ErrNoSuchBucketPolicy  = "ErrNoSuchBucketPolicy"
 )
 
 var apiErrors = map[string]cmd.APIError{
@@ -55,12 +68,81 @@ var apiErrors = map[string]cmd.APIError{
 		Description:    "The specified bucket does not exist",
 		HTTPStatusCode: http.StatusNotFound,
 	},
+	ErrCORSForbidden: {
+		Code: "AccessForbidden",
+		Description: "CORSResponse: This CORS request is not allowed. This is usually because the evaluation of Origin, " +
+			"request method / Access-Control-Request-Method or Access-Control-Request-Headers are not whitelisted by the resource's CORS spec.",
+		HTTPStatusCode: http.StatusForbidden,
+	},
+	ErrInvalidCORSRequest: {
+		Code:           "InvalidRequest",
+		Description:    "The CORS configuration is invalid.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidLogging: {
+		Code:           "InvalidArgument",
+		Description:    "The logging status is invalid.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidLogTarget: {
+		Code:           "InvalidTargetBucketForLogging",
+		Description:    "The target bucket for logging does not exist or is not owned by you.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidNotification: {
+		Code:           "InvalidArgument",
+		Description:    "The notification configuration is invalid.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidLifecycle: {
+		Code:           "InvalidRequest",
+		Description:    "The lifecycle configuration is invalid.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrNoSuchLifecycle: {
+		Code:           "NoSuchLifecycleConfiguration",
+		Description:    "The lifecycle configuration does not exist",
+		HTTPStatusCode: http.StatusNotFound,
+	},
+	ErrInvalidChecksum: {
+		Code:           "InvalidRequest",
+		Description:    "The checksum is invalid.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidPolicy: {
+		Code:           "MalformedPolicy",
+		Description:    "The bucket policy is invalid.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrNoSuchBucketPolicy: {
+		Code:           "NoSuchBucketPolicy",
+		Description:    "The bucket policy does not exist",
+		HTTPStatusCode: http.StatusNotFound,
+	},
 }
 
 type DwsConfig struct {
 	UuidResolverAddr string `help:"full path to dws node service for resolving uuids" default:"localhost:6005"`
 	DwsBackendHost   string `help:"dws node host" default:"localhost:6005"`
 	DwsNodeToken     string `help:"dws node token" releaseDefault:"" default:"secret"`
+
+	CorsCacheExpiration time.Duration `help:"how long bucket CORS configurations are cached for" default:"1m"`
+	CorsCacheCapacity   int           `help:"number of bucket CORS configurations to cache" default:"10000"`
+	CorsLookupLimit     int           `help:"number of bucket CORS configurations looked up at once for requests that aren't authenticated yet" default:"32"`
+
+	LoggingCacheExpiration time.Duration `help:"how long bucket logging configurations are cached for" default:"1m"`
+	LoggingCacheCapacity   int           `help:"number of bucket logging configurations to cache" default:"10000"`
+	LoggingLookupLimit     int           `help:"number of bucket logging configurations looked up at once for access logs" default:"32"`
+
+	NotificationCacheExpiration time.Duration `help:"how long bucket notification configurations are cached for" default:"1m"`
+	NotificationCacheCapacity   int           `help:"number of bucket notification configurations to cache" default:"10000"`
+
+	LifecycleCacheExpiration time.Duration `help:"how long bucket lifecycle configurations are cached for" default:"1m"`
+	LifecycleCacheCapacity   int           `help:"number of bucket lifecycle configurations to cache" default:"10000"`
+
+	PolicyCacheExpiration time.Duration `help:"how long bucket policies are cached for" default:"1m"`
+	PolicyCacheCapacity   int           `help:"number of bucket policies to cache" default:"10000"`
+	PolicyLookupLimit     int           `help:"number of bucket policies looked up at once for anonymous requests" default:"32"`
 }
 
 func (h objectAPIHandlersWrapper) getUserID(r *http.Request, w http.ResponseWriter) (string, error) {
@@ -69,7 +151,22 @@ func (h objectAPIHandlersWrapper) getUserID(r *http.Request, w http.ResponseWrit
 	if cred.AccessKey == "" {
 		return "", errors.New("failed to get access key from auth header")
 	}
-	res, err := h.dwsClient.GetBucketByAccessKey(ctx, &dwsProto.GetBucketByAccessKeyRequest{AccessKey: cred.AccessKey})
+	return h.accessKeyOwner(ctx, cred.AccessKey, middleware.GetSessionToken(r))
+}
+
+// accessKeyOwner returns the user whose bucket accessKey gives access to.
+// Temporary credentials are unknown to DWS, so their owner is the one their
+// session token was issued for (see AssumeRoleHandler), and the owner of the
+// public credentials of bucket policies is in the context of the anonymous
+// requests they're used for (see withBucketPolicy).
+func (h objectAPIHandlersWrapper) accessKeyOwner(ctx context.Context, accessKey, sessionToken string) (string, error) {
+	if owner, ok := ctx.Value(publicOwnerCV{}).(publicOwner); ok && owner.accessKey == accessKey {
+		return owner.userID, nil
+	}
+	if sessionToken != "" {
+		return sessionTokenOwner(sessionToken, accessKey, sessionTokenSecret())
+	}
+	res, err := h.dwsClient.GetBucketByAccessKey(ctx, &dwsProto.GetBucketByAccessKeyRequest{AccessKey: accessKey})
 	if err != nil {
 		h.logger.Error("failed to get bucket by accessKey: %w", err)
 		return "", fmt.Errorf("failed to get bucket by access key: %w", err)
@@ -147,6 +244,35 @@ func (h objectAPIHandlersWrapper) bucketPrefixSubstitution(w http.ResponseWriter
 	return nil
 }
 
+// bucketOwnerCheck returns the bucket of the request after checking that it
+// belongs to the user whose credentials are used.
+func (h objectAPIHandlersWrapper) bucketOwnerCheck(w http.ResponseWriter, r *http.Request, fName string) (string, error) {
+	bucket, _, err := h.bucketOwner(w, r, fName)
+	return bucket, err
+}
+
+// bucketOwner is like bucketOwnerCheck, but it also returns the user the
+// bucket belongs to.
+func (h objectAPIHandlersWrapper) bucketOwner(w http.ResponseWriter, r *http.Request, fName string) (bucket, userID string, err error) {
+	ctx := cmd.NewContext(r, w, fName)
+	bucket = mux.Vars(r)[VarKeyBucket]
+	userID, err = h.getUserID(r, w)
+	if err != nil {
+		cmd.WriteErrorResponse(ctx, w, apiErrors[ErrAccessDenied], r.URL, false)
+		return "", "", fmt.Errorf("user not found")
+	}
+	// buckets are marker objects in the user's bucket (see PutBucketHandler).
+	if _, err := h.core.ObjectAPI().GetObjectInfo(ctx, userID, bucket, cmd.ObjectOptions{}); err != nil {
+		if errors.As(err, &cmd.ObjectNotFound{}) {
+			cmd.WriteErrorResponse(ctx, w, apiErrors[ErrNoSuchBucket], r.URL, false)
+		} else {
+			cmd.WriteErrorResponse(ctx, w, cmd.ToAPIError(ctx, err), r.URL, false)
+		}
+		return "", "", err
+	}
+	return bucket, userID, nil
+}
+
 func (h objectAPIHandlersWrapper) objectPrefixSubstitution(w http.ResponseWriter, r *http.Request, fName string) error {
 	ctx := cmd.NewContext(r, w, fName)
 	vars := mux.Vars(r)
@@ -91,7 +91,11 @@ func (iamOS *IAMAuthStore) GetObjectNInfo(ctx context.Context, bucket, object st
 		Credentials: auth.Credentials{
 			AccessKey: user,
 			SecretKey: credentials.SecretKey,
-			Status:    "on",
+			// MinIO checks requests are sent with the session token of
+			// their credentials, if they have one. The ones of temporary
+			// credentials are checked when their owner is looked up.
+			SessionToken: credentials.SessionToken,
+			Status:       "on",
 		},
 	})
 }
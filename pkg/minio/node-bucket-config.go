@@ -0,0 +1,120 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+// maxNodeBucketConfigSize is the largest bucket configuration read from the
+// DWS node.
+const maxNodeBucketConfigSize = 1 << 20
+
+// nodeBucketConfig stores a kind of bucket configuration, like CORS
+// configurations, as a subresource of buckets in the DWS node's bucket
+// registry.
+type nodeBucketConfig struct {
+	httpClient  *http.Client
+	nodeHost    string
+	nodeToken   string
+	subresource string
+	// name describes the configuration in errors.
+	name string
+}
+
+func newNodeBucketConfig(config DwsConfig, subresource, name string) nodeBucketConfig {
+	return nodeBucketConfig{
+		httpClient: &http.Client{
+			Timeout: time.Second * 15,
+		},
+		nodeHost:    config.DwsBackendHost,
+		nodeToken:   config.DwsNodeToken,
+		subresource: subresource,
+		name:        name,
+	}
+}
+
+// get returns the configuration of bucket, or nil if it has none.
+func (c nodeBucketConfig) get(ctx context.Context, bucket string) (_ []byte, err error) {
+	resp, err := c.request(ctx, http.MethodGet, bucket, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = errs.Combine(err, resp.Body.Close()) }()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, errs.New("unexpected status code while getting bucket %s configuration: %d", c.name, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxNodeBucketConfigSize))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// put sets the configuration of bucket.
+func (c nodeBucketConfig) put(ctx context.Context, bucket, contentType string, data []byte) (err error) {
+	resp, err := c.request(ctx, http.MethodPut, bucket, contentType, data)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errs.Combine(err, resp.Body.Close()) }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errs.New("unexpected status code while putting bucket %s configuration: %d", c.name, resp.StatusCode)
+	}
+	return nil
+}
+
+// delete removes the configuration of bucket.
+func (c nodeBucketConfig) delete(ctx context.Context, bucket string) (err error) {
+	resp, err := c.request(ctx, http.MethodDelete, bucket, "", nil)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errs.Combine(err, resp.Body.Close()) }()
+
+	if resp.StatusCode != http.StatusNotFound && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+		return errs.New("unexpected status code while deleting bucket %s configuration: %d", c.name, resp.StatusCode)
+	}
+	return nil
+}
+
+func (c nodeBucketConfig) request(ctx context.Context, method, bucket, contentType string, body []byte) (*http.Response, error) {
+	u := c.nodeHost + nodeBucketPath + "/" + url.PathEscape(bucket) + "/" + c.subresource
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not create a request: %w", err)
+	}
+	req.Header.Set("Authorization", c.nodeToken)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not do request: %w", err)
+	}
+	return resp, nil
+}
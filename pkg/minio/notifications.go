@@ -0,0 +1,574 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/gateway-mt/pkg/server/gw"
+	"storj.io/gateway-mt/pkg/server/middleware"
+	"storj.io/minio/cmd/logger"
+)
+
+const (
+	// maxNotificationRetryInterval caps the interval between attempts to
+	// deliver an event.
+	maxNotificationRetryInterval = time.Hour
+	// notificationPollInterval is how often deliveries due for a retry are
+	// looked for.
+	notificationPollInterval = time.Second
+)
+
+// The headers of event deliveries. Deliveries are signed with the signing
+// secret of their bucket, returned by the notification configuration API in
+// NotificationSigningSecretHeader, so that webhooks can check they come from
+// the gateway: the signature is the hex-encoded HMAC-SHA256 of the timestamp,
+// a dot and the body, sent as "t=<timestamp>,v1=<signature>".
+const (
+	NotificationDeliveryIDHeader    = "X-Dws-Delivery-Id"
+	NotificationSignatureHeader     = "X-Dws-Signature"
+	NotificationSigningSecretHeader = "X-Dws-Signing-Secret"
+)
+
+// NotificationsConfig configures how bucket events are delivered to webhooks.
+type NotificationsConfig struct {
+	QueueDir             string        `help:"directory event deliveries are kept in until they succeed; empty keeps them in memory only" default:"$CONFDIR/notifications"`
+	MaxQueued            int           `help:"number of queued event deliveries over which events are dropped" default:"10000"`
+	MaxAttempts          int           `help:"number of times an event delivery is attempted before it's dropped" default:"10"`
+	RetryInterval        time.Duration `help:"how long to wait before retrying a failed event delivery, doubled after each attempt up to an hour" default:"10s"`
+	DeliveryTimeout      time.Duration `help:"how long an event delivery can take" default:"10s"`
+	Concurrency          int           `help:"number of event deliveries made at once" default:"10"`
+	AllowPrivateWebhooks bool          `help:"allow delivering events to webhooks on loopback and private addresses" default:"false"`
+}
+
+// notificationDelivery is an event to deliver to a webhook, as it's queued.
+type notificationDelivery struct {
+	ID          string          `json:"id"`
+	URL         string          `json:"url"`
+	Secret      string          `json:"secret"`
+	Payload     json.RawMessage `json:"payload"`
+	Created     time.Time       `json:"created"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+
+	// busy is set while the delivery is attempted or saved.
+	busy bool
+}
+
+// Notifications delivers the events of buckets with notification
+// configurations to their webhooks. Deliveries are queued, on disk if a queue
+// directory is configured, and retried with exponential backoff until they
+// succeed or run out of attempts.
+type Notifications struct {
+	log                *zap.Logger
+	config             NotificationsConfig
+	bucketNotification *BucketNotification
+	client             *http.Client
+	now                func() time.Time
+
+	mu     sync.Mutex
+	queue  map[string]*notificationDelivery
+	busy   int
+	closed bool
+
+	wake       chan struct{}
+	done       chan struct{}
+	closeOnce  sync.Once
+	deliveries sync.WaitGroup
+}
+
+// NewNotifications returns Notifications that look up the notification
+// configuration of buckets, and the secrets deliveries are signed with, with
+// bucketNotification. Deliveries left in the queue directory are queued
+// again.
+func NewNotifications(log *zap.Logger, config NotificationsConfig, bucketNotification *BucketNotification) (*Notifications, error) {
+	if config.Concurrency <= 0 {
+		config.Concurrency = 1
+	}
+
+	n := &Notifications{
+		log:                log,
+		config:             config,
+		bucketNotification: bucketNotification,
+		client:             newWebhookClient(config.AllowPrivateWebhooks),
+		now:                time.Now,
+		queue:              make(map[string]*notificationDelivery),
+		wake:               make(chan struct{}, 1),
+		done:               make(chan struct{}),
+	}
+	if err := n.load(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// newWebhookClient returns the client deliveries are made with. Unless
+// allowPrivate is set, it refuses to connect to addresses that aren't public,
+// so that webhooks can't reach services behind the gateway, and it doesn't
+// follow redirects.
+func newWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+				ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+				return errs.New("webhook address %s is not public", host)
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     time.Minute,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// ObjectEvent queues deliveries of event to the webhooks of its bucket.
+// Objects are named after the virtual bucket they're in, and events for
+// bucket markers are ignored.
+func (n *Notifications) ObjectEvent(ctx context.Context, event gw.ObjectEvent) {
+	bucket, key, ok := strings.Cut(event.Object.Name, Sep)
+	if !ok || key == "" {
+		return
+	}
+
+	config, err := n.bucketNotification.get(ctx, bucket)
+	if err != nil {
+		mon.Event("notification_config_failed")
+		n.log.Debug("unable to get bucket notification configuration", zap.String("bucket", bucket), zap.Error(err))
+		return
+	}
+	// the bucket name is global, so events of other users' objects that
+	// happen to be named after it are ignored.
+	if config == nil || config.Configuration == nil || config.Owner != event.Object.Bucket {
+		return
+	}
+	// deliveries aren't sent unsigned.
+	if config.SigningSecret == "" {
+		mon.Event("notification_secret_missing")
+		return
+	}
+
+	for _, topic := range config.Configuration.Topics {
+		if !topic.matches(event.Name, key) {
+			continue
+		}
+		payload, err := json.Marshal(newS3Event(ctx, event, config, topic.ID, bucket, key))
+		if err != nil {
+			n.log.Error("unable to encode event", zap.Error(err))
+			continue
+		}
+		n.enqueue(&notificationDelivery{
+			URL:     topic.Topic,
+			Secret:  config.SigningSecret,
+			Payload: payload,
+		})
+	}
+}
+
+// enqueue queues delivery, dropping it if the queue is full.
+func (n *Notifications) enqueue(delivery *notificationDelivery) {
+	id, err := newNotificationID()
+	if err != nil {
+		dropNotifications("id_failed")
+		return
+	}
+	delivery.ID = id
+	delivery.Created = n.now()
+	delivery.NextAttempt = delivery.Created
+	delivery.busy = true
+
+	n.mu.Lock()
+	switch {
+	case n.closed:
+		n.mu.Unlock()
+		dropNotifications("closed")
+		return
+	case len(n.queue) >= n.config.MaxQueued:
+		n.mu.Unlock()
+		dropNotifications("queue_full")
+		return
+	}
+	n.queue[delivery.ID] = delivery
+	n.mu.Unlock()
+
+	if err := n.save(delivery); err != nil {
+		// the delivery is still attempted, but it won't survive a restart.
+		mon.Event("notification_save_failed")
+		n.log.Warn("unable to save event delivery", zap.Error(err))
+	}
+
+	n.mu.Lock()
+	delivery.busy = false
+	n.mu.Unlock()
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers queued events until ctx is canceled or Notifications are
+// closed.
+func (n *Notifications) Run(ctx context.Context) error {
+	ticker := time.NewTicker(notificationPollInterval)
+	defer ticker.Stop()
+
+	for {
+		n.dispatch(ctx)
+
+		select {
+		case <-ctx.Done():
+			n.deliveries.Wait()
+			return nil
+		case <-n.done:
+			n.deliveries.Wait()
+			return nil
+		case <-ticker.C:
+		case <-n.wake:
+		}
+	}
+}
+
+// Close stops queueing events and waits for the deliveries being made. The
+// remaining deliveries are left in the queue directory for the next start.
+func (n *Notifications) Close() error {
+	n.mu.Lock()
+	n.closed = true
+	n.mu.Unlock()
+	n.closeOnce.Do(func() { close(n.done) })
+
+	n.deliveries.Wait()
+	return nil
+}
+
+// dispatch starts the deliveries that are due, as long as there are free
+// slots.
+func (n *Notifications) dispatch(ctx context.Context) {
+	now := n.now()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		return
+	}
+	for _, delivery := range n.queue {
+		if n.busy >= n.config.Concurrency {
+			return
+		}
+		if delivery.busy || delivery.NextAttempt.After(now) {
+			continue
+		}
+		delivery.busy = true
+		n.busy++
+
+		n.deliveries.Add(1)
+		go func(delivery *notificationDelivery) {
+			defer n.deliveries.Done()
+			n.finish(delivery, n.deliver(ctx, delivery))
+		}(delivery)
+	}
+}
+
+// finish dequeues delivery if it succeeded or ran out of attempts, and
+// schedules its next attempt otherwise.
+func (n *Notifications) finish(delivery *notificationDelivery, err error) {
+	n.mu.Lock()
+	n.busy--
+	if err == nil || delivery.Attempts+1 >= n.config.MaxAttempts {
+		delete(n.queue, delivery.ID)
+		n.mu.Unlock()
+
+		if err == nil {
+			mon.Counter("notification_delivered").Inc(1)
+		} else {
+			dropNotifications("attempts")
+			n.log.Warn("dropping event delivery", zap.String("webhook", redactURL(delivery.URL)), zap.Error(err))
+		}
+		if err := n.remove(delivery); err != nil {
+			n.log.Warn("unable to remove event delivery", zap.Error(err))
+		}
+		return
+	}
+
+	delivery.Attempts++
+	delivery.NextAttempt = n.now().Add(notificationRetryInterval(n.config.RetryInterval, delivery.Attempts))
+	n.mu.Unlock()
+
+	mon.Event("notification_delivery_failed")
+	n.log.Debug("event delivery failed", zap.String("webhook", redactURL(delivery.URL)), zap.Int("attempts", delivery.Attempts), zap.Error(err))
+
+	if err := n.save(delivery); err != nil {
+		mon.Event("notification_save_failed")
+		n.log.Warn("unable to save event delivery", zap.Error(err))
+	}
+
+	n.mu.Lock()
+	delivery.busy = false
+	n.mu.Unlock()
+}
+
+// deliver posts the payload of delivery to its webhook.
+func (n *Notifications) deliver(ctx context.Context, delivery *notificationDelivery) (err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, n.config.DeliveryTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(n.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(NotificationDeliveryIDHeader, delivery.ID)
+	req.Header.Set(NotificationSignatureHeader, "t="+timestamp+",v1="+signNotification(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errs.Combine(err, resp.Body.Close()) }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errs.New("unexpected status code from webhook: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// signNotification returns the hex-encoded signature of a delivery of payload
+// at timestamp.
+func signNotification(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(timestamp + "."))
+	_, _ = mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// notificationRetryInterval returns how long to wait after a delivery failed
+// attempts times.
+func notificationRetryInterval(interval time.Duration, attempts int) time.Duration {
+	for i := 1; i < attempts && interval < maxNotificationRetryInterval; i++ {
+		interval *= 2
+	}
+	if interval > maxNotificationRetryInterval {
+		interval = maxNotificationRetryInterval
+	}
+	return interval
+}
+
+// load queues the deliveries in the queue directory.
+func (n *Notifications) load() error {
+	if n.config.QueueDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(n.config.QueueDir, 0o700); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(n.config.QueueDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(n.config.QueueDir, entry.Name())
+		if entry.IsDir() {
+			continue
+		}
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			// leftovers of deliveries that were being saved.
+			_ = os.Remove(path)
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var delivery notificationDelivery
+		if err := json.Unmarshal(data, &delivery); err != nil || delivery.ID == "" {
+			n.log.Warn("removing invalid event delivery", zap.String("path", path), zap.Error(err))
+			_ = os.Remove(path)
+			continue
+		}
+		n.queue[delivery.ID] = &delivery
+	}
+	return nil
+}
+
+// save writes delivery to the queue directory, replacing the previous version
+// of it atomically.
+func (n *Notifications) save(delivery *notificationDelivery) (err error) {
+	if n.config.QueueDir == "" {
+		return nil
+	}
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(n.config.QueueDir, delivery.ID+".json")
+	file, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		return errs.Combine(err, file.Close())
+	}
+	if err := file.Sync(); err != nil {
+		return errs.Combine(err, file.Close())
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// remove removes delivery from the queue directory.
+func (n *Notifications) remove(delivery *notificationDelivery) error {
+	if n.config.QueueDir == "" {
+		return nil
+	}
+	err := os.Remove(filepath.Join(n.config.QueueDir, delivery.ID+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func dropNotifications(reason string) {
+	mon.Counter("notification_dropped", monkit.NewSeriesTag("reason", reason)).Inc(1)
+}
+
+func newNotificationID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id[:]), nil
+}
+
+// redactURL returns rawurl without its query and credentials, which may be
+// secrets of the webhook.
+func redactURL(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host + u.Path
+}
+
+// s3Event is the S3 event message format.
+//
+// See https://docs.aws.amazon.com/AmazonS3/latest/userguide/notification-content-structure.html.
+type s3Event struct {
+	Records []s3EventRecord `json:"Records"`
+}
+
+type s3EventRecord struct {
+	EventVersion      string            `json:"eventVersion"`
+	EventSource       string            `json:"eventSource"`
+	AWSRegion         string            `json:"awsRegion"`
+	EventTime         string            `json:"eventTime"`
+	EventName         string            `json:"eventName"`
+	UserIdentity      s3EventIdentity   `json:"userIdentity"`
+	RequestParameters map[string]string `json:"requestParameters"`
+	ResponseElements  map[string]string `json:"responseElements"`
+	S3                s3EventEntity     `json:"s3"`
+}
+
+type s3EventIdentity struct {
+	PrincipalID string `json:"principalId"`
+}
+
+type s3EventEntity struct {
+	SchemaVersion   string        `json:"s3SchemaVersion"`
+	ConfigurationID string        `json:"configurationId"`
+	Bucket          s3EventBucket `json:"bucket"`
+	Object          s3EventObject `json:"object"`
+}
+
+type s3EventBucket struct {
+	Name          string          `json:"name"`
+	OwnerIdentity s3EventIdentity `json:"ownerIdentity"`
+	ARN           string          `json:"arn"`
+}
+
+type s3EventObject struct {
+	Key       string `json:"key"`
+	Size      int64  `json:"size,omitempty"`
+	ETag      string `json:"eTag,omitempty"`
+	VersionID string `json:"versionId,omitempty"`
+	Sequencer string `json:"sequencer"`
+}
+
+// newS3Event returns the message of event on key in bucket for the topic
+// configurationID of config.
+func newS3Event(ctx context.Context, event gw.ObjectEvent, config *bucketNotificationConfig, configurationID, bucket, key string) s3Event {
+	record := s3EventRecord{
+		EventVersion:      "2.1",
+		EventSource:       "aws:s3",
+		EventTime:         event.Time.UTC().Format("2006-01-02T15:04:05.000Z"),
+		EventName:         strings.TrimPrefix(event.Name, "s3:"),
+		UserIdentity:      s3EventIdentity{PrincipalID: middleware.GetAccess(ctx).MacaroonHead()},
+		RequestParameters: map[string]string{"sourceIPAddress": ""},
+		ResponseElements:  map[string]string{"x-amz-request-id": ""},
+		S3: s3EventEntity{
+			SchemaVersion:   "1.0",
+			ConfigurationID: configurationID,
+			Bucket: s3EventBucket{
+				Name:          bucket,
+				OwnerIdentity: s3EventIdentity{PrincipalID: config.Owner},
+				ARN:           "arn:aws:s3:::" + bucket,
+			},
+			Object: s3EventObject{
+				Key:       url.QueryEscape(key),
+				Size:      event.Object.Size,
+				ETag:      event.Object.ETag,
+				VersionID: event.Object.VersionID,
+				Sequencer: strings.ToUpper(strconv.FormatInt(event.Time.UnixNano(), 16)),
+			},
+		},
+	}
+	if reqInfo := logger.GetReqInfo(ctx); reqInfo != nil {
+		record.RequestParameters["sourceIPAddress"] = reqInfo.RemoteHost
+		record.ResponseElements["x-amz-request-id"] = reqInfo.RequestID
+	}
+	return s3Event{Records: []s3EventRecord{record}}
+}
@@ -0,0 +1,226 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/common/testcontext"
+	"storj.io/gateway-mt/pkg/server/gw"
+	minio "storj.io/minio/cmd"
+)
+
+// testWebhook records the event deliveries it receives, failing them while
+// fail is set.
+type testWebhook struct {
+	mu         sync.Mutex
+	deliveries []*http.Request
+	bodies     [][]byte
+	fail       bool
+}
+
+func (webhook *testWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	webhook.mu.Lock()
+	defer webhook.mu.Unlock()
+	webhook.deliveries = append(webhook.deliveries, r)
+	webhook.bodies = append(webhook.bodies, body)
+	if webhook.fail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+func (webhook *testWebhook) received() int {
+	webhook.mu.Lock()
+	defer webhook.mu.Unlock()
+	return len(webhook.deliveries)
+}
+
+func newTestNotifications(t *testing.T, config NotificationsConfig, webhookURL string) *Notifications {
+	ctx := testcontext.New(t)
+
+	node := &testBucketConfigNode{configs: map[string]string{}}
+	server := httptest.NewServer(node)
+	t.Cleanup(server.Close)
+
+	bucketNotification := NewBucketNotification(zaptest.NewLogger(t), DwsConfig{
+		DwsBackendHost:              server.URL,
+		DwsNodeToken:                "token",
+		NotificationCacheExpiration: time.Hour,
+		NotificationCacheCapacity:   10,
+	})
+	configuration, err := parseNotificationConfiguration([]byte(`<NotificationConfiguration><TopicConfiguration>` +
+		`<Id>images</Id><Topic>` + webhookURL + `</Topic><Event>s3:ObjectCreated:*</Event>` +
+		`<Filter><S3Key><FilterRule><Name>prefix</Name><Value>images/</Value></FilterRule></S3Key></Filter>` +
+		`</TopicConfiguration></NotificationConfiguration>`))
+	require.NoError(t, err)
+	require.NoError(t, bucketNotification.put(ctx, "photos", &bucketNotificationConfig{
+		Configuration: configuration,
+		SigningSecret: "signing secret",
+		Owner:         "user",
+	}))
+
+	config.AllowPrivateWebhooks = true
+	notifications, err := NewNotifications(zaptest.NewLogger(t), config, bucketNotification)
+	require.NoError(t, err)
+	return notifications
+}
+
+func TestNotifications(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	webhook := &testWebhook{}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+
+	notifications := newTestNotifications(t, NotificationsConfig{
+		MaxQueued:       10,
+		MaxAttempts:     3,
+		RetryInterval:   time.Hour,
+		DeliveryTimeout: 5 * time.Second,
+		Concurrency:     2,
+	}, server.URL+"/hook")
+
+	eventTime := time.Date(2023, 10, 5, 14, 3, 2, 0, time.UTC)
+	event := func(name, bucket, object string) gw.ObjectEvent {
+		return gw.ObjectEvent{
+			Name:   name,
+			Object: minio.ObjectInfo{Bucket: bucket, Name: object, Size: 5, ETag: "etag"},
+			Time:   eventTime,
+		}
+	}
+	notifications.ObjectEvent(ctx, event(gw.EventObjectCreatedPut, "user", "photos/images/a b.jpg"))
+	// events that don't match the filter, of another user's bucket or of the
+	// bucket marker aren't delivered.
+	notifications.ObjectEvent(ctx, event(gw.EventObjectCreatedPut, "user", "photos/videos/a.mp4"))
+	notifications.ObjectEvent(ctx, event(gw.EventObjectCreatedPut, "other", "photos/images/a.jpg"))
+	notifications.ObjectEvent(ctx, event(gw.EventObjectCreatedPut, "user", "photos/"))
+	notifications.ObjectEvent(ctx, event(gw.EventObjectRemovedDelete, "user", "photos/images/a.jpg"))
+
+	runCtx, cancel := context.WithCancel(ctx)
+	ctx.Go(func() error { return notifications.Run(runCtx) })
+	require.Eventually(t, func() bool { return webhook.received() == 1 }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, notifications.Close())
+	assert.Equal(t, 1, webhook.received())
+
+	r, body := webhook.deliveries[0], webhook.bodies[0]
+	assert.Equal(t, "/hook", r.URL.Path)
+	assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+	assert.NotEmpty(t, r.Header.Get(NotificationDeliveryIDHeader))
+
+	timestamp, signature, ok := strings.Cut(r.Header.Get(NotificationSignatureHeader), ",")
+	require.True(t, ok)
+	assert.Equal(t, "v1="+signNotification("signing secret", strings.TrimPrefix(timestamp, "t="), body), signature)
+
+	var message s3Event
+	require.NoError(t, json.Unmarshal(body, &message))
+	require.Len(t, message.Records, 1)
+	record := message.Records[0]
+	assert.Equal(t, "ObjectCreated:Put", record.EventName)
+	assert.Equal(t, "2023-10-05T14:03:02.000Z", record.EventTime)
+	assert.Equal(t, "images", record.S3.ConfigurationID)
+	assert.Equal(t, "photos", record.S3.Bucket.Name)
+	assert.Equal(t, "user", record.S3.Bucket.OwnerIdentity.PrincipalID)
+	assert.Equal(t, "images%2Fa+b.jpg", record.S3.Object.Key)
+	assert.EqualValues(t, 5, record.S3.Object.Size)
+	assert.Equal(t, "etag", record.S3.Object.ETag)
+
+	notifications.mu.Lock()
+	assert.Empty(t, notifications.queue)
+	notifications.mu.Unlock()
+}
+
+func TestNotificationsRetry(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	webhook := &testWebhook{fail: true}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+
+	config := NotificationsConfig{
+		QueueDir:        ctx.Dir("notifications"),
+		MaxQueued:       1,
+		MaxAttempts:     2,
+		RetryInterval:   time.Minute,
+		DeliveryTimeout: 5 * time.Second,
+		Concurrency:     1,
+	}
+	notifications := newTestNotifications(t, config, server.URL)
+	now := time.Now()
+	notifications.now = func() time.Time { return now }
+
+	event := gw.ObjectEvent{Name: gw.EventObjectCreatedCopy, Object: minio.ObjectInfo{Bucket: "user", Name: "photos/images/a.jpg"}, Time: now}
+	notifications.ObjectEvent(ctx, event)
+	// events over the queue bound are dropped.
+	notifications.ObjectEvent(ctx, event)
+
+	entries, err := os.ReadDir(config.QueueDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	// failed deliveries stay queued until they're retried.
+	notifications.dispatch(ctx)
+	notifications.deliveries.Wait()
+	assert.Equal(t, 1, webhook.received())
+	notifications.dispatch(ctx)
+	notifications.deliveries.Wait()
+	assert.Equal(t, 1, webhook.received())
+	require.NoError(t, notifications.Close())
+
+	// queued deliveries survive restarts.
+	notifications = newTestNotifications(t, config, server.URL)
+	notifications.now = func() time.Time { return now.Add(time.Minute) }
+	notifications.mu.Lock()
+	require.Len(t, notifications.queue, 1)
+	for _, delivery := range notifications.queue {
+		assert.Equal(t, 1, delivery.Attempts)
+	}
+	notifications.mu.Unlock()
+
+	// deliveries are dropped once they run out of attempts.
+	notifications.dispatch(ctx)
+	notifications.deliveries.Wait()
+	assert.Equal(t, 2, webhook.received())
+	notifications.mu.Lock()
+	assert.Empty(t, notifications.queue)
+	notifications.mu.Unlock()
+	entries, err = os.ReadDir(config.QueueDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	require.NoError(t, notifications.Close())
+}
+
+func TestNotificationRetryInterval(t *testing.T) {
+	assert.Equal(t, 10*time.Second, notificationRetryInterval(10*time.Second, 1))
+	assert.Equal(t, 40*time.Second, notificationRetryInterval(10*time.Second, 3))
+	assert.Equal(t, time.Hour, notificationRetryInterval(10*time.Second, 100))
+}
+
+func TestWebhookClientPrivateAddresses(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	server := httptest.NewServer(&testWebhook{})
+	defer server.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, nil)
+	require.NoError(t, err)
+	_, err = newWebhookClient(false).Do(req) //nolint:bodyclose // the request fails.
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not public")
+}
@@ -0,0 +1,150 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/uplink"
+)
+
+// resourceARNPrefix prefixes the ARNs of the buckets and objects policies
+// refer to.
+const resourceARNPrefix = "arn:aws:s3:::"
+
+// ErrPolicy is returned for invalid IAM policies, and for the ones the gateway
+// can't translate into access grant restrictions.
+var ErrPolicy = errs.Class("invalid policy")
+
+// policyDocument is the subset of IAM policy documents the gateway parses.
+type policyDocument struct {
+	Version   string            `json:"Version"`
+	ID        string            `json:"Id,omitempty"`
+	Statement []policyStatement `json:"Statement"`
+}
+
+// policyStatement is a statement of a policyDocument. The elements that
+// aren't supported are only parsed to refuse them.
+type policyStatement struct {
+	Sid         string          `json:"Sid,omitempty"`
+	Effect      string          `json:"Effect"`
+	Principal   json.RawMessage `json:"Principal,omitempty"`
+	Action      stringList      `json:"Action"`
+	NotAction   stringList      `json:"NotAction,omitempty"`
+	Resource    stringList      `json:"Resource"`
+	NotResource stringList      `json:"NotResource,omitempty"`
+	Condition   json.RawMessage `json:"Condition,omitempty"`
+}
+
+// stringList is a policy element that's either a string or a list of them.
+type stringList []string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = stringList{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// parsePolicyDocument parses an IAM policy document, refusing the statements
+// with elements the gateway doesn't support.
+func parsePolicyDocument(data []byte) (policyDocument, error) {
+	var doc policyDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return policyDocument{}, ErrPolicy.Wrap(err)
+	}
+	if len(doc.Statement) == 0 {
+		return policyDocument{}, ErrPolicy.New("no statements")
+	}
+	for _, statement := range doc.Statement {
+		switch {
+		case statement.Effect != "Allow" && statement.Effect != "Deny":
+			return policyDocument{}, ErrPolicy.New("invalid effect %q", statement.Effect)
+		case len(statement.NotAction) > 0 || len(statement.NotResource) > 0:
+			return policyDocument{}, ErrPolicy.New("NotAction and NotResource are not supported")
+		case len(statement.Condition) > 0:
+			return policyDocument{}, ErrPolicy.New("conditions are not supported")
+		case len(statement.Action) == 0 || len(statement.Resource) == 0:
+			return policyDocument{}, ErrPolicy.New("statements need actions and resources")
+		}
+	}
+	return doc, nil
+}
+
+// actionsPermission returns the permission the policy actions amount to.
+// Actions are mapped to the permissions of access grants, so the ones that
+// would need finer-grained permissions are refused.
+func actionsPermission(actions []string) (uplink.Permission, error) {
+	var permission uplink.Permission
+	for _, action := range actions {
+		switch action {
+		case "s3:*":
+			permission.AllowDownload = true
+			permission.AllowUpload = true
+			permission.AllowList = true
+			permission.AllowDelete = true
+		case "s3:GetObject":
+			permission.AllowDownload = true
+		case "s3:PutObject", "s3:AbortMultipartUpload":
+			permission.AllowUpload = true
+		case "s3:ListBucket", "s3:ListBucketMultipartUploads", "s3:ListMultipartUploadParts":
+			permission.AllowList = true
+		case "s3:DeleteObject":
+			permission.AllowDelete = true
+		default:
+			return uplink.Permission{}, ErrPolicy.New("action %q is not supported", action)
+		}
+	}
+	return permission, nil
+}
+
+// parseResource returns the bucket and the object key prefix a resource ARN
+// refers to. Bucket is empty for "*", which refers to every bucket. Wildcards
+// are only supported at the end of prefixes ending with a slash, as access
+// grants restrict access to whole path components.
+func parseResource(resource string) (bucket, prefix string, err error) {
+	if resource == "*" {
+		return "", "", nil
+	}
+	if !strings.HasPrefix(resource, resourceARNPrefix) {
+		return "", "", ErrPolicy.New("invalid resource %q", resource)
+	}
+	path := strings.TrimPrefix(resource, resourceARNPrefix)
+	if path == "*" {
+		return "", "", nil
+	}
+
+	bucket, prefix, _ = strings.Cut(path, "/")
+	prefix = strings.TrimSuffix(prefix, "*")
+	switch {
+	case bucket == "" || strings.ContainsAny(bucket, "*?"):
+		return "", "", ErrPolicy.New("invalid resource %q", resource)
+	case strings.ContainsAny(prefix, "*?"):
+		return "", "", ErrPolicy.New("resource %q has wildcards before its end", resource)
+	case prefix != "" && !strings.HasSuffix(prefix, "/"):
+		return "", "", ErrPolicy.New("resource %q is not a bucket nor a prefix ending with /*", resource)
+	}
+	return bucket, prefix, nil
+}
+
+// sharePrefix returns the prefix of the user's bucket a virtual bucket and a
+// prefix in it are stored under (see objectPrefixSubstitution). An empty
+// bucket shares the whole user's bucket.
+func sharePrefix(userID, bucket, prefix string) uplink.SharePrefix {
+	if bucket == "" {
+		return uplink.SharePrefix{Bucket: userID}
+	}
+	return uplink.SharePrefix{Bucket: userID, Prefix: bucket + Sep + prefix}
+}
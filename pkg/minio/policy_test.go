@@ -0,0 +1,83 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/uplink"
+)
+
+func TestParsePolicyDocument(t *testing.T) {
+	doc, err := parsePolicyDocument([]byte(`{
+		"Version": "2012-10-17",
+		"Statement": [{"Sid": "a", "Effect": "Allow", "Action": "s3:GetObject", "Resource": ["arn:aws:s3:::b/*"]}]
+	}`))
+	require.NoError(t, err)
+	require.Len(t, doc.Statement, 1)
+	assert.Equal(t, stringList{"s3:GetObject"}, doc.Statement[0].Action)
+	assert.Equal(t, stringList{"arn:aws:s3:::b/*"}, doc.Statement[0].Resource)
+
+	for _, policy := range []string{
+		`[]`,
+		`{"Statement": [{"Effect": "Maybe", "Action": "s3:GetObject", "Resource": "*"}]}`,
+		`{"Statement": [{"Effect": "Allow", "NotAction": "s3:GetObject", "Resource": "*"}]}`,
+		`{"Statement": [{"Effect": "Allow", "Action": "s3:GetObject", "NotResource": "*"}]}`,
+		`{"Statement": [{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "*", "Condition": {}}]}`,
+		`{"Statement": [{"Effect": "Allow", "Resource": "*"}]}`,
+	} {
+		_, err := parsePolicyDocument([]byte(policy))
+		assert.True(t, ErrPolicy.Has(err), policy)
+	}
+}
+
+func TestActionsPermission(t *testing.T) {
+	permission, err := actionsPermission([]string{"s3:GetObject", "s3:DeleteObject"})
+	require.NoError(t, err)
+	assert.Equal(t, uplink.Permission{AllowDownload: true, AllowDelete: true}, permission)
+
+	permission, err = actionsPermission([]string{"s3:*"})
+	require.NoError(t, err)
+	assert.Equal(t, uplink.FullPermission(), permission)
+
+	_, err = actionsPermission([]string{"s3:GetObject", "s3:GetBucketPolicy"})
+	assert.True(t, ErrPolicy.Has(err))
+}
+
+func TestParseResource(t *testing.T) {
+	tests := []struct {
+		resource string
+		bucket   string
+		prefix   string
+		invalid  bool
+	}{
+		{resource: "*"},
+		{resource: "arn:aws:s3:::*"},
+		{resource: "arn:aws:s3:::photos", bucket: "photos"},
+		{resource: "arn:aws:s3:::photos/*", bucket: "photos"},
+		{resource: "arn:aws:s3:::photos/2023/*", bucket: "photos", prefix: "2023/"},
+		{resource: "arn:aws:s3:::photos/2023/", bucket: "photos", prefix: "2023/"},
+		{resource: "photos/*", invalid: true},
+		{resource: "arn:aws:s3:::photos/2023*", invalid: true},
+		{resource: "arn:aws:s3:::photos/*/raw/*", invalid: true},
+		{resource: "arn:aws:s3:::photos/a.jpg", invalid: true},
+		{resource: "arn:aws:s3:::pho*", invalid: true},
+	}
+	for _, tc := range tests {
+		bucket, prefix, err := parseResource(tc.resource)
+		if tc.invalid {
+			assert.True(t, ErrPolicy.Has(err), tc.resource)
+			continue
+		}
+		require.NoError(t, err, tc.resource)
+		assert.Equal(t, tc.bucket, bucket, tc.resource)
+		assert.Equal(t, tc.prefix, prefix, tc.resource)
+	}
+
+	assert.Equal(t, uplink.SharePrefix{Bucket: "user"}, sharePrefix("user", "", ""))
+	assert.Equal(t, uplink.SharePrefix{Bucket: "user", Prefix: "photos/2023/"}, sharePrefix("user", "photos", "2023/"))
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/common/lrucache"
+	dwsProto "storj.io/gateway-mt/pkg/minio/dws/proto"
+	"storj.io/gateway-mt/pkg/server/middleware"
+	"storj.io/minio/cmd"
+	xhttp "storj.io/minio/cmd/http"
+)
+
+// RateLimited responds to requests over the rate limits with SlowDown, asking
+// to retry once the limits allow it.
+func RateLimited(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	err := cmd.APIError{
+		Code:           "SlowDown",
+		HTTPStatusCode: http.StatusTooManyRequests, // Minio's ErrSlowDown yields a 503, but 429 seems clearer
+		Description:    "Please reduce your request rate.",
+	}
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	cmd.WriteErrorResponse(r.Context(), &retryAfterWriter{ResponseWriter: w, retryAfter: strconv.Itoa(seconds)}, err, r.URL, false)
+}
+
+// retryAfterWriter replaces the Retry-After header that WriteErrorResponse
+// always sets to two minutes for SlowDown.
+type retryAfterWriter struct {
+	http.ResponseWriter
+	retryAfter string
+}
+
+func (w *retryAfterWriter) WriteHeader(statusCode int) {
+	w.Header().Set(xhttp.RetryAfter, w.retryAfter)
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *retryAfterWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// NewProjectResolver returns a function that resolves the project of a request
+// to the user its access key belongs to, which is the bucket DWS keeps their
+// buckets in. Projects are cached, as they don't change for an access key.
+func NewProjectResolver(dwsClient dwsProto.StorageCachingServiceClient) func(*http.Request) (string, error) {
+	cache := lrucache.NewOf[string](lrucache.Options{
+		Expiration: 10 * time.Minute,
+		Capacity:   10000,
+	})
+	return func(r *http.Request) (string, error) {
+		ctx := r.Context()
+		credentials := middleware.GetAccess(ctx)
+		if credentials == nil || credentials.AccessKey == "" {
+			return "", errs.New("missing access key")
+		}
+		return cache.Get(ctx, credentials.AccessKey, func() (string, error) {
+			res, err := dwsClient.GetBucketByAccessKey(ctx, &dwsProto.GetBucketByAccessKeyRequest{AccessKey: credentials.AccessKey})
+			if err != nil {
+				return "", err
+			}
+			return res.Bucket, nil
+		})
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimited(t *testing.T) {
+	for _, tc := range []struct {
+		retryAfter time.Duration
+		header     string
+	}{
+		{retryAfter: 100 * time.Millisecond, header: "1"},
+		{retryAfter: 2 * time.Second, header: "2"},
+		{retryAfter: 2*time.Second + time.Millisecond, header: "3"},
+	} {
+		w := httptest.NewRecorder()
+		RateLimited(w, httptest.NewRequest(http.MethodGet, "/bucket/key", nil), tc.retryAfter)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, tc.header, w.Header().Get("Retry-After"))
+		assert.Contains(t, w.Body.String(), "<Code>SlowDown</Code>")
+	}
+}
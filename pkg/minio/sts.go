@@ -0,0 +1,424 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/gateway-mt/pkg/server/middleware"
+	"storj.io/minio/cmd"
+	"storj.io/minio/cmd/config"
+	xhttp "storj.io/minio/cmd/http"
+	"storj.io/minio/pkg/auth"
+	"storj.io/minio/pkg/env"
+	"storj.io/uplink"
+)
+
+const (
+	stsActionAssumeRole      = "AssumeRole"
+	stsActionGetSessionToken = "GetSessionToken"
+
+	// maxSTSRequestSize is the largest STS request body accepted. It fits
+	// the largest session policies STS accepts.
+	maxSTSRequestSize = 64 << 10
+
+	// the durations temporary credentials can be requested for, the same as
+	// AssumeRole's.
+	stsDefaultDuration = time.Hour
+	stsMinDuration     = 15 * time.Minute
+	stsMaxDuration     = 12 * time.Hour
+
+	// maxSTSSignatureSkew is how far the time STS requests are signed at can
+	// be from the gateway's.
+	maxSTSSignatureSkew = 15 * time.Minute
+
+	// sessionTokenOwnerClaim is the session token claim holding the user the
+	// temporary credentials were issued for.
+	sessionTokenOwnerClaim = "owner"
+)
+
+// ErrSTS is returned for invalid STS requests.
+var ErrSTS = errs.Class("sts")
+
+// stsCredentials are the temporary credentials returned by STS actions.
+type stsCredentials struct {
+	AccessKeyID     string `xml:"AccessKeyId"`
+	SecretAccessKey string `xml:"SecretAccessKey"`
+	SessionToken    string `xml:"SessionToken"`
+	Expiration      string `xml:"Expiration"`
+}
+
+type stsResponseMetadata struct {
+	RequestID string `xml:"RequestId"`
+}
+
+type assumeRoleResponse struct {
+	XMLName xml.Name `xml:"https://sts.amazonaws.com/doc/2011-06-15/ AssumeRoleResponse"`
+	Result  struct {
+		Credentials stsCredentials `xml:"Credentials"`
+	} `xml:"AssumeRoleResult"`
+	ResponseMetadata stsResponseMetadata `xml:"ResponseMetadata"`
+}
+
+type getSessionTokenResponse struct {
+	XMLName xml.Name `xml:"https://sts.amazonaws.com/doc/2011-06-15/ GetSessionTokenResponse"`
+	Result  struct {
+		Credentials stsCredentials `xml:"Credentials"`
+	} `xml:"GetSessionTokenResult"`
+	ResponseMetadata stsResponseMetadata `xml:"ResponseMetadata"`
+}
+
+// AssumeRoleHandler implements the AssumeRole and GetSessionToken STS actions.
+// They issue temporary credentials for an access grant derived from the one
+// of the credentials the request is signed with, restricted to the user's
+// bucket, to the session policy of AssumeRole requests, and to the requested
+// duration. The credentials are registered with the Auth Service, which
+// expires them with the access grant.
+func (h objectAPIHandlersWrapper) AssumeRoleHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	defer mon.Task()(&ctx)(nil)
+	errCtx := cmd.NewContext(r, w, "AssumeRole")
+
+	secret := sessionTokenSecret()
+	if secret == "" {
+		writeSTSError(w, http.StatusNotImplemented, "NotImplemented", "Temporary credentials are not enabled.")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSTSRequestSize+1))
+	if err != nil {
+		writeSTSError(w, http.StatusBadRequest, "InvalidRequest", err.Error())
+		return
+	}
+	if len(body) > maxSTSRequestSize {
+		writeSTSError(w, http.StatusRequestEntityTooLarge, "InvalidRequest", "The request is too large.")
+		return
+	}
+
+	credentials := middleware.GetAccess(ctx)
+	if credentials == nil || credentials.Error != nil {
+		writeSTSError(w, http.StatusForbidden, "InvalidClientTokenId", "The security token included in the request is invalid.")
+		return
+	}
+	if err := verifySTSSignature(r, body, credentials.SecretKey, time.Now()); err != nil {
+		writeSTSError(w, http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided.")
+		return
+	}
+
+	params, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeSTSError(w, http.StatusBadRequest, "InvalidRequest", "The request body is malformed.")
+		return
+	}
+	for k, v := range r.URL.Query() {
+		if _, ok := params[k]; !ok {
+			params[k] = v
+		}
+	}
+
+	action := params.Get("Action")
+	if action != stsActionAssumeRole && action != stsActionGetSessionToken {
+		writeSTSError(w, http.StatusBadRequest, "InvalidAction", "Could not find operation "+action+".")
+		return
+	}
+
+	duration, err := stsDuration(params.Get("DurationSeconds"))
+	if err != nil {
+		writeSTSError(w, http.StatusBadRequest, "ValidationError", err.Error())
+		return
+	}
+
+	userID, err := h.accessKeyOwner(errCtx, credentials.AccessKey, credentials.SessionToken)
+	if err != nil {
+		writeSTSError(w, http.StatusForbidden, "AccessDenied", "Access Denied.")
+		return
+	}
+
+	var policy string
+	if action == stsActionAssumeRole {
+		policy = params.Get("Policy")
+	}
+	permission, prefixes, err := sessionPolicyRestriction(userID, policy)
+	if err != nil {
+		writeSTSError(w, http.StatusBadRequest, "MalformedPolicyDocument", err.Error())
+		return
+	}
+	expiration := time.Now().Add(duration).UTC().Truncate(time.Second)
+	permission.NotAfter = expiration
+
+	access, err := uplink.ParseAccess(credentials.AccessGrant)
+	if err != nil {
+		writeSTSError(w, http.StatusForbidden, "AccessDenied", "Access Denied.")
+		return
+	}
+	restricted, err := access.Share(permission, prefixes...)
+	if err != nil {
+		h.logger.Errorf("failed to restrict access grant: %s", err)
+		writeSTSError(w, http.StatusInternalServerError, "InternalFailure", "We encountered an internal error, please try again.")
+		return
+	}
+	grant, err := restricted.Serialize()
+	if err != nil {
+		h.logger.Errorf("failed to serialize access grant: %s", err)
+		writeSTSError(w, http.StatusInternalServerError, "InternalFailure", "We encountered an internal error, please try again.")
+		return
+	}
+
+	registered, err := h.authClient.Register(ctx, grant, false)
+	if err != nil {
+		h.logger.Errorf("failed to register temporary credentials: %s", err)
+		writeSTSError(w, http.StatusInternalServerError, "InternalFailure", "We encountered an internal error, please try again.")
+		return
+	}
+
+	token, err := newSessionToken(registered.AccessKeyID, userID, expiration, secret)
+	if err != nil {
+		h.logger.Errorf("failed to sign session token: %s", err)
+		writeSTSError(w, http.StatusInternalServerError, "InternalFailure", "We encountered an internal error, please try again.")
+		return
+	}
+
+	issued := stsCredentials{
+		AccessKeyID:     registered.AccessKeyID,
+		SecretAccessKey: registered.SecretKey,
+		SessionToken:    token,
+		Expiration:      expiration.Format(iso8601TimeFormat),
+	}
+	metadata := stsResponseMetadata{RequestID: w.Header().Get(xhttp.AmzRequestID)}
+
+	var response interface{}
+	if action == stsActionAssumeRole {
+		resp := assumeRoleResponse{ResponseMetadata: metadata}
+		resp.Result.Credentials = issued
+		response = resp
+	} else {
+		resp := getSessionTokenResponse{ResponseMetadata: metadata}
+		resp.Result.Credentials = issued
+		response = resp
+	}
+	cmd.WriteSuccessResponseXML(w, cmd.EncodeResponse(response))
+}
+
+// writeSTSError writes an STS error response.
+func writeSTSError(w http.ResponseWriter, status int, code, message string) {
+	var response cmd.STSErrorResponse
+	response.Error.Type = "Sender"
+	if status >= http.StatusInternalServerError {
+		response.Error.Type = "Receiver"
+	}
+	response.Error.Code = code
+	response.Error.Message = message
+	response.RequestID = w.Header().Get(xhttp.AmzRequestID)
+
+	w.Header().Set(xhttp.ContentType, "text/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write(cmd.EncodeResponse(response))
+}
+
+// stsDuration parses the DurationSeconds parameter of STS requests.
+func stsDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return stsDefaultDuration, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, ErrSTS.New("DurationSeconds must be a number of seconds")
+	}
+	duration := time.Duration(seconds) * time.Second
+	if duration < stsMinDuration || duration > stsMaxDuration {
+		return 0, ErrSTS.New("DurationSeconds must be between %d and %d", int(stsMinDuration.Seconds()), int(stsMaxDuration.Seconds()))
+	}
+	return duration, nil
+}
+
+// sessionPolicyRestriction returns the permission and the prefixes of the
+// user's bucket temporary credentials are restricted to. Without a session
+// policy, they get full access to the user's bucket. Session policies can
+// only allow actions, and as access grants can't give different permissions
+// to different prefixes, all their statements have to allow the same ones.
+func sessionPolicyRestriction(userID, policy string) (uplink.Permission, []uplink.SharePrefix, error) {
+	if policy == "" {
+		return uplink.FullPermission(), []uplink.SharePrefix{{Bucket: userID}}, nil
+	}
+
+	doc, err := parsePolicyDocument([]byte(policy))
+	if err != nil {
+		return uplink.Permission{}, nil, err
+	}
+
+	var permission uplink.Permission
+	var prefixes []uplink.SharePrefix
+	for i, statement := range doc.Statement {
+		if statement.Effect != "Allow" {
+			return uplink.Permission{}, nil, ErrPolicy.New("session policies can only allow actions")
+		}
+		if len(statement.Principal) > 0 {
+			return uplink.Permission{}, nil, ErrPolicy.New("session policies can't have principals")
+		}
+		statementPermission, err := actionsPermission(statement.Action)
+		if err != nil {
+			return uplink.Permission{}, nil, err
+		}
+		if i > 0 && statementPermission != permission {
+			return uplink.Permission{}, nil, ErrPolicy.New("all the statements have to allow the same actions")
+		}
+		permission = statementPermission
+
+		for _, resource := range statement.Resource {
+			bucket, prefix, err := parseResource(resource)
+			if err != nil {
+				return uplink.Permission{}, nil, err
+			}
+			prefixes = append(prefixes, sharePrefix(userID, bucket, prefix))
+		}
+	}
+	return permission, prefixes, nil
+}
+
+// sessionTokenSecret returns the secret session tokens are signed with. MinIO
+// checks the session tokens requests are sent with are signed with its root
+// credentials' secret key, so it's read from the same environment variables.
+func sessionTokenSecret() string {
+	return env.Get(config.EnvRootPassword, env.Get(config.EnvSecretKey, ""))
+}
+
+// newSessionToken returns the session token of the temporary credentials
+// with accessKey, issued for userID until expiration.
+func newSessionToken(accessKey, userID string, expiration time.Time, secret string) (string, error) {
+	return auth.JWTSignWithAccessKey(accessKey, map[string]interface{}{
+		"exp":                  expiration.Unix(),
+		sessionTokenOwnerClaim: userID,
+	}, secret)
+}
+
+// sessionTokenOwner returns the user the temporary credentials with accessKey
+// and token were issued for.
+func sessionTokenOwner(token, accessKey, secret string) (string, error) {
+	if secret == "" {
+		return "", ErrSTS.New("temporary credentials are not enabled")
+	}
+	claims, err := auth.ExtractClaims(token, secret)
+	if err != nil {
+		return "", ErrSTS.New("invalid session token: %w", err)
+	}
+	if claims.AccessKey != accessKey {
+		return "", ErrSTS.New("session token issued for other credentials")
+	}
+	owner, _ := claims.Lookup(sessionTokenOwnerClaim)
+	if owner == "" {
+		return "", ErrSTS.New("session token without owner")
+	}
+	return owner, nil
+}
+
+// verifySTSSignature checks that r is signed with AWS Signature Version 4
+// using secretKey, for STS. MinIO's signature checks can't be used, as they
+// require requests signed for S3.
+func verifySTSSignature(r *http.Request, body []byte, secretKey string, now time.Time) error {
+	v4, err := middleware.ParseV4FromHeader(r)
+	if err != nil {
+		return ErrSTS.Wrap(err)
+	}
+	if v4.Date.Before(now.Add(-maxSTSSignatureSkew)) || v4.Date.After(now.Add(maxSTSSignatureSkew)) {
+		return ErrSTS.New("signature time too skewed")
+	}
+
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	if v4.ContentSHA256 != "" && v4.ContentSHA256 != payloadHash {
+		return ErrSTS.New("payload hash mismatch")
+	}
+
+	signedHeaders := append([]string(nil), v4.SignedHeaders...)
+	sort.Strings(signedHeaders)
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		var values []string
+		switch name {
+		case "host":
+			values = []string{r.Host}
+		case "content-length":
+			values = []string{strconv.FormatInt(r.ContentLength, 10)}
+		default:
+			values = r.Header.Values(name)
+		}
+		if len(values) == 0 {
+			return ErrSTS.New("signed header %q is missing", name)
+		}
+		for i := range values {
+			values[i] = strings.Join(strings.Fields(values[i]), " ")
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+
+	canonicalURI := r.URL.EscapedPath()
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		canonicalURI,
+		canonicalQuery(r.URL.Query()),
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	date := v4.Credential.Date.Format("20060102")
+	scope := strings.Join([]string{date, v4.Credential.Region, v4.Credential.Service, "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		v4.Date.Format("20060102T150405Z"),
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, v4.Credential.Region, v4.Credential.Service, "aws4_request"} {
+		key = sumHMAC(key, []byte(part))
+	}
+	signature := hex.EncodeToString(sumHMAC(key, []byte(stringToSign)))
+
+	if subtle.ConstantTimeCompare([]byte(signature), []byte(v4.Signature)) != 1 {
+		return ErrSTS.New("signature mismatch")
+	}
+	return nil
+}
+
+// canonicalQuery returns the canonical query string of query, as signed with
+// AWS Signature Version 4.
+func canonicalQuery(query url.Values) string {
+	escape := func(s string) string {
+		return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+	}
+	var params []string
+	for k, values := range query {
+		for _, v := range values {
+			params = append(params, escape(k)+"="+escape(v))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func sumHMAC(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,278 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package minio
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/signer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/grpc"
+
+	"storj.io/common/grant"
+	"storj.io/common/macaroon"
+	"storj.io/common/storj"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"storj.io/gateway-mt/pkg/authclient"
+	dwsProto "storj.io/gateway-mt/pkg/minio/dws/proto"
+	"storj.io/gateway-mt/pkg/server/middleware"
+	"storj.io/minio/cmd/config"
+	"storj.io/uplink"
+)
+
+func TestVerifySTSSignature(t *testing.T) {
+	body := []byte("Action=AssumeRole&Version=2011-06-15&DurationSeconds=900")
+	sum := sha256.Sum256(body)
+
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "https://gateway.test/", bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+		return signer.SignV4STS(*r, "accesskey", "secretkey", "us-east-1")
+	}
+
+	r := newRequest()
+	require.NoError(t, verifySTSSignature(r, body, "secretkey", time.Now()))
+	assert.True(t, ErrSTS.Has(verifySTSSignature(r, body, "othersecretkey", time.Now())))
+	assert.True(t, ErrSTS.Has(verifySTSSignature(r, []byte("Action=GetSessionToken"), "secretkey", time.Now())))
+	assert.True(t, ErrSTS.Has(verifySTSSignature(r, body, "secretkey", time.Now().Add(time.Hour))))
+
+	r = newRequest()
+	r.Header.Set("Content-Type", "text/plain")
+	assert.True(t, ErrSTS.Has(verifySTSSignature(r, body, "secretkey", time.Now())))
+
+	r = newRequest()
+	r.Header.Del("Authorization")
+	assert.True(t, ErrSTS.Has(verifySTSSignature(r, body, "secretkey", time.Now())))
+}
+
+func TestSessionToken(t *testing.T) {
+	token, err := newSessionToken("accesskey", "user", time.Now().Add(time.Hour), "secret")
+	require.NoError(t, err)
+
+	owner, err := sessionTokenOwner(token, "accesskey", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, "user", owner)
+
+	_, err = sessionTokenOwner(token, "otheraccesskey", "secret")
+	assert.True(t, ErrSTS.Has(err))
+	_, err = sessionTokenOwner(token, "accesskey", "othersecret")
+	assert.True(t, ErrSTS.Has(err))
+	_, err = sessionTokenOwner(token, "accesskey", "")
+	assert.True(t, ErrSTS.Has(err))
+
+	token, err = newSessionToken("accesskey", "user", time.Now().Add(-time.Minute), "secret")
+	require.NoError(t, err)
+	_, err = sessionTokenOwner(token, "accesskey", "secret")
+	assert.True(t, ErrSTS.Has(err))
+}
+
+func TestSTSDuration(t *testing.T) {
+	duration, err := stsDuration("")
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, duration)
+
+	duration, err = stsDuration("900")
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, duration)
+
+	for _, value := range []string{"899", "43201", "1h", "-1"} {
+		_, err := stsDuration(value)
+		assert.True(t, ErrSTS.Has(err), value)
+	}
+}
+
+func TestSessionPolicyRestriction(t *testing.T) {
+	permission, prefixes, err := sessionPolicyRestriction("user", "")
+	require.NoError(t, err)
+	assert.Equal(t, uplink.FullPermission(), permission)
+	assert.Equal(t, []uplink.SharePrefix{{Bucket: "user"}}, prefixes)
+
+	permission, prefixes, err = sessionPolicyRestriction("user", `{
+		"Version": "2012-10-17",
+		"Statement": [
+			{"Effect": "Allow", "Action": ["s3:GetObject", "s3:ListBucket"], "Resource": "arn:aws:s3:::photos/2023/*"},
+			{"Effect": "Allow", "Action": ["s3:ListBucket", "s3:GetObject"], "Resource": ["arn:aws:s3:::docs"]}
+		]
+	}`)
+	require.NoError(t, err)
+	assert.Equal(t, uplink.Permission{AllowDownload: true, AllowList: true}, permission)
+	assert.Equal(t, []uplink.SharePrefix{
+		{Bucket: "user", Prefix: "photos/2023/"},
+		{Bucket: "user", Prefix: "docs/"},
+	}, prefixes)
+
+	for _, policy := range []string{
+		`{`,
+		`{"Statement": []}`,
+		`{"Statement": [{"Effect": "Deny", "Action": "s3:GetObject", "Resource": "*"}]}`,
+		`{"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "*"}]}`,
+		`{"Statement": [{"Effect": "Allow", "Action": "s3:PutBucketPolicy", "Resource": "*"}]}`,
+		`{"Statement": [
+			{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::a/*"},
+			{"Effect": "Allow", "Action": "s3:PutObject", "Resource": "arn:aws:s3:::b/*"}
+		]}`,
+	} {
+		_, _, err := sessionPolicyRestriction("user", policy)
+		assert.True(t, ErrPolicy.Has(err), policy)
+	}
+}
+
+func TestAssumeRoleHandler(t *testing.T) {
+	ctx := testcontext.New(t)
+	t.Setenv(config.EnvRootPassword, "sessionsecret")
+
+	apiKeySecret := testrand.Bytes(32)
+	apiKey, err := macaroon.NewAPIKey(apiKeySecret)
+	require.NoError(t, err)
+	key := testrand.Key()
+	ownerGrant, err := (&grant.Access{
+		SatelliteAddress: storj.NodeURL{ID: testrand.NodeID(), Address: "127.0.0.1:7777"}.String(),
+		APIKey:           apiKey,
+		EncAccess:        grant.NewEncryptionAccessWithDefaultKey(&key),
+	}).Serialize()
+	require.NoError(t, err)
+
+	// the Auth Service registers grants like in authclient's TestRegister.
+	var registered []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/v1/access", r.URL.Path)
+
+		var body struct {
+			AccessGrant string `json:"access_grant"`
+			Public      bool   `json:"public"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		require.False(t, body.Public)
+		registered = append(registered, body.AccessGrant)
+
+		_, err := w.Write([]byte(`{"access_key_id":"tempaccesskey", "secret_key":"tempsecretkey", "endpoint":"https://gateway.test"}`))
+		require.NoError(t, err)
+	}))
+	defer ts.Close()
+
+	h := objectAPIHandlersWrapper{
+		authClient: authclient.New(authclient.Config{BaseURL: ts.URL, Token: "token", Timeout: 5 * time.Second}),
+		dwsClient:  &testDWSClient{buckets: map[string]string{"accesskey": "user"}},
+		logger:     zaptest.NewLogger(t).Sugar(),
+	}
+
+	assumeRole := func(credentials *middleware.Credentials, params url.Values) *httptest.ResponseRecorder {
+		body := []byte(params.Encode())
+		sum := sha256.Sum256(body)
+		r := httptest.NewRequest(http.MethodPost, "https://gateway.test/", bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+		r = signer.SignV4STS(*r, credentials.AccessKey, credentials.SecretKey, "us-east-1")
+		r = r.WithContext(middleware.WithCredentials(ctx, credentials))
+
+		rec := httptest.NewRecorder()
+		h.AssumeRoleHandler(rec, r)
+		return rec
+	}
+
+	params := url.Values{
+		"Action":          {"AssumeRole"},
+		"Version":         {"2011-06-15"},
+		"DurationSeconds": {"900"},
+		"Policy":          {`{"Statement": [{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::photos/*"}]}`},
+	}
+	rec := assumeRole(&middleware.Credentials{
+		AccessKey:           "accesskey",
+		AuthServiceResponse: authclient.AuthServiceResponse{AccessGrant: ownerGrant, SecretKey: "secretkey"},
+	}, params)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var resp assumeRoleResponse
+	require.NoError(t, xml.Unmarshal(rec.Body.Bytes(), &resp))
+	issued := resp.Result.Credentials
+	assert.Equal(t, "tempaccesskey", issued.AccessKeyID)
+	assert.Equal(t, "tempsecretkey", issued.SecretAccessKey)
+	expiration, err := time.Parse(iso8601TimeFormat, issued.Expiration)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), expiration, time.Minute)
+
+	// the registered grant is restricted to downloads from the user's bucket,
+	// until the credentials expire.
+	require.Len(t, registered, 1)
+	access, err := grant.ParseAccess(registered[0])
+	require.NoError(t, err)
+	now := time.Now()
+	allowed, err := access.APIKey.GetAllowedBuckets(ctx, macaroon.Action{Op: macaroon.ActionRead, Time: now})
+	require.NoError(t, err)
+	assert.Equal(t, macaroon.AllowedBuckets{Buckets: map[string]struct{}{"user": {}}}, allowed)
+	assert.Error(t, access.APIKey.Check(ctx, apiKeySecret, macaroon.Action{Op: macaroon.ActionWrite, Time: now}, nil))
+	_, err = access.APIKey.GetAllowedBuckets(ctx, macaroon.Action{Op: macaroon.ActionRead, Time: expiration.Add(time.Second)})
+	assert.Error(t, err)
+
+	// requests with the temporary credentials are resolved to the user with
+	// their session token.
+	r := httptest.NewRequest(http.MethodGet, "https://gateway.test/photos/a.jpg", nil)
+	r = signer.SignV4(*r, issued.AccessKeyID, issued.SecretAccessKey, issued.SessionToken, "us-east-1")
+	userID, err := h.accessKeyOwner(ctx, issued.AccessKeyID, middleware.GetSessionToken(r))
+	require.NoError(t, err)
+	assert.Equal(t, "user", userID)
+
+	// so they can assume roles of their own, but not with forged tokens,
+	// or tokens issued for other credentials.
+	forged, err := newSessionToken(issued.AccessKeyID, "other", expiration, "othersecret")
+	require.NoError(t, err)
+	other, err := newSessionToken("otheraccesskey", "other", expiration, "sessionsecret")
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		token string
+		valid bool
+	}{
+		{token: issued.SessionToken, valid: true},
+		{token: forged},
+		{token: other},
+		{token: issued.SessionToken + "x"},
+	} {
+		_, err := h.accessKeyOwner(ctx, issued.AccessKeyID, tc.token)
+		assert.Equal(t, !tc.valid, ErrSTS.Has(err))
+
+		rec := assumeRole(&middleware.Credentials{
+			AccessKey:           issued.AccessKeyID,
+			AuthServiceResponse: authclient.AuthServiceResponse{AccessGrant: registered[0], SecretKey: issued.SecretAccessKey},
+			SessionToken:        tc.token,
+		}, params)
+		if tc.valid {
+			assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		} else {
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+			assert.Contains(t, rec.Body.String(), "<Code>AccessDenied</Code>")
+		}
+	}
+	assert.Len(t, registered, 2)
+}
+
+// testDWSClient resolves access keys to the buckets of their users.
+type testDWSClient struct {
+	dwsProto.StorageCachingServiceClient
+
+	buckets map[string]string
+}
+
+func (c *testDWSClient) GetBucketByAccessKey(ctx context.Context, in *dwsProto.GetBucketByAccessKeyRequest, opts ...grpc.CallOption) (*dwsProto.GetBucketByAccessKeyResponse, error) {
+	bucket, ok := c.buckets[in.AccessKey]
+	if !ok {
+		return nil, errs.New("unknown access key")
+	}
+	return &dwsProto.GetBucketByAccessKeyResponse{Bucket: bucket}, nil
+}
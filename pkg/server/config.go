@@ -9,6 +9,7 @@ import (
 	"storj.io/common/memory"
 	"storj.io/gateway-mt/pkg/authclient"
 	"storj.io/gateway-mt/pkg/minio"
+	"storj.io/gateway-mt/pkg/server/middleware"
 	"storj.io/gateway/miniogw"
 )
 
@@ -33,6 +34,9 @@ type Config struct {
 	ShutdownDelay        time.Duration `help:"time to delay server shutdown while returning 503s on the health endpoint" devDefault:"1s" releaseDefault:"45s"`
 
 	DwsCfg                  minio.DwsConfig
+	RateLimit               middleware.RateLimitConfig
+	AccessLog               minio.ServerAccessLogConfig
+	Notifications           minio.NotificationsConfig
 	Auth                    authclient.Config
 	S3Compatibility         miniogw.S3CompatibilityConfig
 	Client                  ClientConfig
@@ -84,7 +88,13 @@ type ClientConfig struct {
 	DialTimeout       time.Duration `help:"timeout for dials" default:"10s"`
 	MaximumBufferSize memory.Size   `help:"maximum buffer size for DRPC streams" default:"304kB"`
 
-	Upload uploadConfig
+	Upload      uploadConfig
+	ProjectPool projectPoolConfig
+}
+
+type projectPoolConfig struct {
+	Capacity       int           `help:"number of projects kept open to be reused by requests using the same access grant; zero disables reuse" default:"1000"`
+	IdleExpiration time.Duration `help:"how long projects that aren't used are kept open" default:"2m0s"`
 }
 
 type uploadConfig struct {
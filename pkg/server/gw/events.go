@@ -0,0 +1,43 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package gw
+
+import (
+	"context"
+	"time"
+
+	minio "storj.io/minio/cmd"
+)
+
+// The S3 event types of the changes MultiTenancyLayer emits events for.
+const (
+	EventObjectCreatedPut                     = "s3:ObjectCreated:Put"
+	EventObjectCreatedCopy                    = "s3:ObjectCreated:Copy"
+	EventObjectCreatedCompleteMultipartUpload = "s3:ObjectCreated:CompleteMultipartUpload"
+	EventObjectRemovedDelete                  = "s3:ObjectRemoved:Delete"
+)
+
+// ObjectEvent is a change made to an object through MultiTenancyLayer.
+type ObjectEvent struct {
+	// Name is the S3 event type, like s3:ObjectCreated:Put.
+	Name string
+	// Object is the object as the underlying layer returned it. Removed
+	// objects may only have their bucket and name.
+	Object minio.ObjectInfo
+	Time   time.Time
+}
+
+// ObjectEventListener is notified of the changes made to objects. It's called
+// within the calls that made them, once they succeeded, so it shouldn't block.
+type ObjectEventListener interface {
+	ObjectEvent(ctx context.Context, event ObjectEvent)
+}
+
+// emit notifies the listener, if any, of a change to object.
+func (l *MultiTenancyLayer) emit(ctx context.Context, name string, object minio.ObjectInfo) {
+	if l.events == nil {
+		return
+	}
+	l.events.ObjectEvent(ctx, ObjectEvent{Name: name, Object: object, Time: time.Now()})
+}
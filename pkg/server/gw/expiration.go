@@ -0,0 +1,108 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package gw
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	minio "storj.io/minio/cmd"
+	"storj.io/minio/pkg/hash"
+)
+
+// objectTTLKeys are the custom metadata keys storj.io/gateway reads the
+// expiration of uploaded objects from, in the order it looks for them.
+var objectTTLKeys = []string{
+	"X-Amz-Meta-Object-Expires",
+	"X-Minio-Meta-Object-Expires",
+	"X-Amz-Meta-Storj-Expires",
+	"X-Minio-Meta-Storj-Expires",
+}
+
+type expirationCV struct{}
+
+// WithObjectExpiration returns a context in which the objects MultiTenancyLayer
+// uploads or copies expire at expires, unless their metadata asks for them to
+// expire sooner. It's how bucket lifecycle rules are applied.
+func WithObjectExpiration(ctx context.Context, expires time.Time) context.Context {
+	return context.WithValue(ctx, expirationCV{}, expires)
+}
+
+// objectExpiration returns the expiration set by WithObjectExpiration, or the
+// zero time if there's none.
+func objectExpiration(ctx context.Context) time.Time {
+	expires, _ := ctx.Value(expirationCV{}).(time.Time)
+	return expires
+}
+
+// requestedExpiration returns the expiration metadata asks for, the same way
+// storj.io/gateway parses it. ok is false if it can't be parsed, in which case
+// the gateway refuses the upload.
+func requestedExpiration(metadata map[string]string) (expires time.Time, ok bool) {
+	for _, key := range objectTTLKeys {
+		value, found := metadata[key]
+		if !found {
+			continue
+		}
+		switch {
+		case value == "" || value == "none":
+			return time.Time{}, true
+		case strings.HasPrefix(value, "+"):
+			d, err := time.ParseDuration(value)
+			return time.Now().Add(d), err == nil
+		default:
+			t, err := time.Parse(time.RFC3339, value)
+			return t, err == nil
+		}
+	}
+	return time.Time{}, true
+}
+
+// withObjectExpiration returns a copy of metadata making the object expire at
+// the expiration set on ctx, and true, if it's sooner than the one metadata
+// already asks for. Otherwise, metadata is returned as is.
+func withObjectExpiration(ctx context.Context, metadata map[string]string) (map[string]string, bool) {
+	expires := objectExpiration(ctx)
+	if expires.IsZero() {
+		return metadata, false
+	}
+	requested, ok := requestedExpiration(metadata)
+	if !ok || (!requested.IsZero() && !requested.After(expires)) {
+		return metadata, false
+	}
+
+	updated := make(map[string]string, len(metadata)+1)
+	for k, v := range metadata {
+		updated[k] = v
+	}
+	// the first key is the one the gateway looks for first.
+	updated[objectTTLKeys[0]] = expires.UTC().Format(time.RFC3339)
+	return updated, true
+}
+
+// copyObjectExpiring copies an object by downloading and uploading it again,
+// for copies that need to expire sooner than their source: server-side copies
+// keep the expiration of their source.
+func (l *MultiTenancyLayer) copyObjectExpiring(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, metadata map[string]string, srcOpts, destOpts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var noLock minio.LockType
+	reader, err := l.layer.GetObjectNInfo(ctx, srcBucket, srcObject, nil, nil, noLock, srcOpts)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer func() { err = errs.Combine(err, reader.Close()) }()
+
+	size := reader.ObjInfo.Size
+	hashReader, err := hash.NewReader(reader, size, "", "", size)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	destOpts.UserDefined = metadata
+	return l.layer.PutObject(ctx, destBucket, destObject, minio.NewPutObjReader(hashReader), destOpts)
+}
@@ -0,0 +1,47 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package gw
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithObjectExpiration(t *testing.T) {
+	expires := time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)
+	ctx := WithObjectExpiration(context.Background(), expires)
+
+	metadata, ok := withObjectExpiration(context.Background(), map[string]string{"a": "b"})
+	require.False(t, ok)
+	require.Equal(t, map[string]string{"a": "b"}, metadata)
+
+	tests := []struct {
+		metadata map[string]string
+		applied  bool
+	}{
+		{nil, true},
+		{map[string]string{"X-Amz-Meta-Object-Expires": "none"}, true},
+		{map[string]string{"X-Amz-Meta-Storj-Expires": "2031-01-01T00:00:00Z"}, true},
+		{map[string]string{"X-Amz-Meta-Object-Expires": "+1h"}, false},
+		{map[string]string{"X-Minio-Meta-Object-Expires": "2029-01-01T00:00:00Z"}, false},
+		{map[string]string{"X-Amz-Meta-Object-Expires": "invalid"}, false},
+	}
+	for i, tc := range tests {
+		metadata, ok := withObjectExpiration(ctx, tc.metadata)
+		require.Equal(t, tc.applied, ok, i)
+		if !tc.applied {
+			require.Equal(t, tc.metadata, metadata, i)
+			continue
+		}
+		require.Equal(t, "2030-01-02T00:00:00Z", metadata["X-Amz-Meta-Object-Expires"], i)
+		for k, v := range tc.metadata {
+			if k != "X-Amz-Meta-Object-Expires" {
+				require.Equal(t, v, metadata[k], i)
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package gw
+
+import (
+	"context"
+	"strings"
+
+	"storj.io/gateway/miniogw"
+	minio "storj.io/minio/cmd"
+	"storj.io/uplink"
+)
+
+// ChecksumMetadataPrefix prefixes the custom metadata keys the additional
+// checksums of objects are stored under, followed by their lowercase
+// algorithm, e.g. s3:checksum-crc32.
+const ChecksumMetadataPrefix = "s3:checksum-"
+
+// ChecksumAlgorithmMetadataKey is the custom metadata key of multipart uploads
+// the additional checksum algorithm they're started with is stored under, so
+// their parts can be held to it.
+const ChecksumAlgorithmMetadataKey = "s3:multipart-checksum-algorithm"
+
+// checksumHeaderPrefix prefixes the headers checksums are returned in.
+const checksumHeaderPrefix = "X-Amz-Checksum-"
+
+type uploadMetadataCV struct{}
+
+type checksumModeCV struct{}
+
+// WithUploadMetadata returns a context in which MultiTenancyLayer calls bind
+// with the custom metadata of the objects it uploads, so it can be added to.
+// It's called before the data of objects is read, so metadata that depends on
+// it can be added as it's read. Multipart uploads are started with the metadata
+// it adds, and for completed ones, it's called once they're committed, and the
+// metadata it adds or removes is stored afterwards.
+func WithUploadMetadata(ctx context.Context, bind func(metadata map[string]string)) context.Context {
+	return context.WithValue(ctx, uploadMetadataCV{}, bind)
+}
+
+// uploadMetadata returns the function set by WithUploadMetadata, or nil if
+// there's none.
+func uploadMetadata(ctx context.Context) func(map[string]string) {
+	bind, _ := ctx.Value(uploadMetadataCV{}).(func(map[string]string))
+	return bind
+}
+
+// WithChecksumMode returns a context in which the objects MultiTenancyLayer
+// returns have their stored checksums in their metadata, as the x-amz-checksum-*
+// headers GetObject and HeadObject send with x-amz-checksum-mode: ENABLED.
+func WithChecksumMode(ctx context.Context) context.Context {
+	return context.WithValue(ctx, checksumModeCV{}, true)
+}
+
+// withChecksumHeaders returns a copy of metadata with its checksums as
+// x-amz-checksum-* headers, if ctx has checksum mode enabled and there are
+// any. Otherwise, metadata is returned as is.
+func withChecksumHeaders(ctx context.Context, metadata map[string]string) map[string]string {
+	if enabled, _ := ctx.Value(checksumModeCV{}).(bool); !enabled {
+		return metadata
+	}
+
+	var updated map[string]string
+	for k, v := range metadata {
+		if !strings.HasPrefix(k, ChecksumMetadataPrefix) {
+			continue
+		}
+		if updated == nil {
+			updated = make(map[string]string, len(metadata)+1)
+			for k, v := range metadata {
+				updated[k] = v
+			}
+		}
+		updated[checksumHeaderPrefix+strings.TrimPrefix(k, ChecksumMetadataPrefix)] = v
+	}
+	if updated == nil {
+		return metadata
+	}
+	return updated
+}
+
+// completeUploadMetadata adds the metadata bind adds to the object a multipart
+// upload has been completed into. CompleteMultipartUpload stores the metadata
+// the upload was started with, so it's updated once the object is committed.
+func completeUploadMetadata(ctx context.Context, project *uplink.Project, objInfo minio.ObjectInfo, bind func(map[string]string)) (_ minio.ObjectInfo, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	metadata := make(map[string]string, len(objInfo.UserDefined)+1)
+	for k, v := range objInfo.UserDefined {
+		metadata[k] = v
+	}
+	bind(metadata)
+
+	if err := project.UpdateObjectMetadata(ctx, objInfo.Bucket, objInfo.Name, metadata, nil); err != nil {
+		return objInfo, miniogw.ConvertError(err, objInfo.Bucket, objInfo.Name)
+	}
+	objInfo.UserDefined = metadata
+	return objInfo, nil
+}
@@ -0,0 +1,27 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package gw
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithChecksumHeaders(t *testing.T) {
+	metadata := map[string]string{"s3:etag": "a", "s3:checksum-crc32": "DUoRhQ=="}
+
+	require.Equal(t, metadata, withChecksumHeaders(context.Background(), metadata))
+
+	ctx := WithChecksumMode(context.Background())
+	require.Equal(t, map[string]string{
+		"s3:etag":              "a",
+		"s3:checksum-crc32":    "DUoRhQ==",
+		"X-Amz-Checksum-crc32": "DUoRhQ==",
+	}, withChecksumHeaders(ctx, metadata))
+	require.Len(t, metadata, 2)
+
+	require.Equal(t, map[string]string{"s3:etag": "a"}, withChecksumHeaders(ctx, map[string]string{"s3:etag": "a"}))
+}
@@ -58,19 +58,22 @@ type UploadConfig struct {
 // UplinkConfig holds a configuration for libuplink that controls how to talk to
 // the rest of the network and adjacent settings.
 type UplinkConfig struct {
-	Base    uplink.Config
-	Uploads UploadConfig
+	Base        uplink.Config
+	Uploads     UploadConfig
+	ProjectPool ProjectPoolOptions
 }
 
 // NewMultiTenantLayer initializes and returns new MultiTenancyLayer. A properly
-// closed object layer will also close connectionPool.
-func NewMultiTenantLayer(gateway minio.Gateway, satelliteConnectionPool *rpcpool.Pool, connectionPool *rpcpool.Pool, config UplinkConfig, insecureLogAll bool) (*MultiTenancyLayer, error) {
+// closed object layer will also close connectionPool and the projects it pools.
+func NewMultiTenantLayer(gateway minio.Gateway, satelliteConnectionPool *rpcpool.Pool, connectionPool *rpcpool.Pool, events ObjectEventListener, config UplinkConfig, insecureLogAll bool) (*MultiTenancyLayer, error) {
 	layer, err := gateway.NewGatewayLayer(auth.Credentials{})
 
 	return &MultiTenancyLayer{
 		layer:                   layer,
 		satelliteConnectionPool: satelliteConnectionPool,
 		connectionPool:          connectionPool,
+		projects:                newProjectPool(config.ProjectPool),
+		events:                  events,
 		config:                  config,
 		insecureLogAll:          insecureLogAll,
 	}, err
@@ -84,6 +87,8 @@ type MultiTenancyLayer struct {
 	layer                   minio.ObjectLayer
 	satelliteConnectionPool *rpcpool.Pool
 	connectionPool          *rpcpool.Pool
+	projects                *projectPool
+	events                  ObjectEventListener
 
 	config         UplinkConfig
 	insecureLogAll bool
@@ -147,19 +152,19 @@ func copyReqInfo(dst *gwlog.Log, src *logger.ReqInfo) {
 
 // Shutdown is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).Shutdown.
 func (l *MultiTenancyLayer) Shutdown(ctx context.Context) error {
-	return l.log(ctx, errs.Combine(l.connectionPool.Close(), l.satelliteConnectionPool.Close()))
+	return l.log(ctx, errs.Combine(l.projects.Close(), l.connectionPool.Close(), l.satelliteConnectionPool.Close()))
 }
 
 // StorageInfo is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).StorageInfo.
 func (l *MultiTenancyLayer) StorageInfo(ctx context.Context) (minio.StorageInfo, []error) {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return minio.StorageInfo{}, []error{err}
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	info, errors := l.layer.StorageInfo(miniogw.WithUplinkProject(ctx, project))
+	info, errors := l.layer.StorageInfo(ctx)
 
 	for _, err := range errors {
 		_ = l.log(ctx, err)
@@ -170,14 +175,14 @@ func (l *MultiTenancyLayer) StorageInfo(ctx context.Context) (minio.StorageInfo,
 
 // MakeBucketWithLocation is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).MakeBucketWithLocation.
 func (l *MultiTenancyLayer) MakeBucketWithLocation(ctx context.Context, bucket string, opts minio.BucketOptions) error {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	return l.log(ctx, l.layer.MakeBucketWithLocation(miniogw.WithUplinkProject(ctx, project), bucket, opts))
+	return l.log(ctx, l.layer.MakeBucketWithLocation(ctx, bucket, opts))
 }
 
 // GetBucketInfo is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).GetBucketInfo.
@@ -192,27 +197,27 @@ func (l *MultiTenancyLayer) GetBucketInfo(ctx context.Context, bucket string) (b
 		return minio.BucketInfo{}, minio.NotImplemented{Message: "GetBucketInfo (anonymous)"}
 	}
 
-	project, err := l.openProject(ctx, accessGrant)
+	ctx, project, err := l.openProject(ctx, accessGrant)
 	if err != nil {
 		return minio.BucketInfo{}, err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	bucketInfo, err = l.layer.GetBucketInfo(miniogw.WithUplinkProject(ctx, project), bucket)
+	bucketInfo, err = l.layer.GetBucketInfo(ctx, bucket)
 	return bucketInfo, l.log(ctx, err)
 }
 
 // ListBuckets is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).ListBuckets.
 func (l *MultiTenancyLayer) ListBuckets(ctx context.Context) (buckets []minio.BucketInfo, err error) {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return nil, err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	buckets, err = l.layer.ListBuckets(miniogw.WithUplinkProject(ctx, project))
+	buckets, err = l.layer.ListBuckets(ctx)
 	return buckets, l.log(ctx, err)
 }
 
@@ -228,14 +233,14 @@ type BucketWithAttributionInfo struct {
 func (l *MultiTenancyLayer) ListBucketsWithAttribution(ctx context.Context) (buckets []BucketWithAttributionInfo, err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return nil, err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	it := bucket.ListBucketsWithAttribution(ctx, project, nil)
+	it := bucket.ListBucketsWithAttribution(ctx, project.Project, nil)
 
 	for it.Next() {
 		buckets = append(buckets, BucketWithAttributionInfo{
@@ -250,120 +255,157 @@ func (l *MultiTenancyLayer) ListBucketsWithAttribution(ctx context.Context) (buc
 
 // DeleteBucket is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).DeleteBucket.
 func (l *MultiTenancyLayer) DeleteBucket(ctx context.Context, bucket string, forceDelete bool) error {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	return l.log(ctx, l.layer.DeleteBucket(miniogw.WithUplinkProject(ctx, project), bucket, forceDelete))
+	return l.log(ctx, l.layer.DeleteBucket(ctx, bucket, forceDelete))
 }
 
 // ListObjects is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).ListObjects.
 func (l *MultiTenancyLayer) ListObjects(ctx context.Context, bucket, prefix, marker, delimiter string, maxKeys int) (result minio.ListObjectsInfo, err error) {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return minio.ListObjectsInfo{}, err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	result, err = l.layer.ListObjects(miniogw.WithUplinkProject(ctx, project), bucket, prefix, marker, delimiter, maxKeys)
+	result, err = l.layer.ListObjects(ctx, bucket, prefix, marker, delimiter, maxKeys)
 	return result, l.log(ctx, err)
 }
 
 // ListObjectsV2 is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).ListObjectsV2.
 func (l *MultiTenancyLayer) ListObjectsV2(ctx context.Context, bucket, prefix, continuationToken, delimiter string, maxKeys int, fetchOwner bool, startAfter string) (result minio.ListObjectsV2Info, err error) {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return minio.ListObjectsV2Info{}, err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	result, err = l.layer.ListObjectsV2(miniogw.WithUplinkProject(ctx, project), bucket, prefix, continuationToken, delimiter, maxKeys, fetchOwner, startAfter)
+	result, err = l.layer.ListObjectsV2(ctx, bucket, prefix, continuationToken, delimiter, maxKeys, fetchOwner, startAfter)
 	return result, l.log(ctx, err)
 }
 
 // GetObjectNInfo is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).GetObjectNInfo.
 func (l *MultiTenancyLayer) GetObjectNInfo(ctx context.Context, bucket, object string, rs *minio.HTTPRangeSpec, h http.Header, lockType minio.LockType, opts minio.ObjectOptions) (reader *minio.GetObjectReader, err error) {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return nil, err
 	}
 
-	defer func() { err = errs.Combine(err, project.Close()) }()
+	reader, err = l.layer.GetObjectNInfo(ctx, bucket, object, rs, h, lockType, opts)
+	if err != nil {
+		err = errs.Combine(err, project.Close())
+		return nil, l.log(ctx, err)
+	}
 
-	reader, err = l.layer.GetObjectNInfo(miniogw.WithUplinkProject(ctx, project), bucket, object, rs, h, lockType, opts)
-	return reader, l.log(ctx, err)
+	reader.ObjInfo.UserDefined = withChecksumHeaders(ctx, reader.ObjInfo.UserDefined)
+
+	// the object is downloaded as the reader is read, so the project is only
+	// released once it's closed.
+	return minio.NewGetObjectReaderFromReader(reader, reader.ObjInfo, minio.ObjectOptions{}, func() {
+		_ = reader.Close()
+		_ = project.Close()
+	})
 }
 
 // GetObjectInfo is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).GetObjectInfo.
 func (l *MultiTenancyLayer) GetObjectInfo(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return minio.ObjectInfo{}, err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	objInfo, err = l.layer.GetObjectInfo(miniogw.WithUplinkProject(ctx, project), bucket, object, opts)
+	objInfo, err = l.layer.GetObjectInfo(ctx, bucket, object, opts)
+	objInfo.UserDefined = withChecksumHeaders(ctx, objInfo.UserDefined)
 	return objInfo, l.log(ctx, err)
 }
 
 // PutObject is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).PutObject.
 func (l *MultiTenancyLayer) PutObject(ctx context.Context, bucket, object string, data *minio.PutObjReader, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return minio.ObjectInfo{}, err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	objInfo, err = l.layer.PutObject(miniogw.WithUplinkProject(ctx, project), bucket, object, data, opts)
+	opts.UserDefined, _ = withObjectExpiration(ctx, opts.UserDefined)
+	if bind := uploadMetadata(ctx); bind != nil {
+		if opts.UserDefined == nil {
+			opts.UserDefined = make(map[string]string)
+		}
+		bind(opts.UserDefined)
+	}
+
+	objInfo, err = l.layer.PutObject(ctx, bucket, object, data, opts)
+	if err == nil {
+		l.emit(ctx, EventObjectCreatedPut, objInfo)
+	}
 
 	return objInfo, l.log(ctx, err)
 }
 
 // CopyObject is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).CopyObject.
 func (l *MultiTenancyLayer) CopyObject(ctx context.Context, srcBucket, srcObject, destBucket, destObject string, srcInfo minio.ObjectInfo, srcOpts, destOpts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return minio.ObjectInfo{}, err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	objInfo, err = l.layer.CopyObject(miniogw.WithUplinkProject(ctx, project), srcBucket, srcObject, destBucket, destObject, srcInfo, srcOpts, destOpts)
+	// copies keep the expiration of their source, so the ones that need to
+	// expire sooner are uploaded again.
+	if metadata, ok := withObjectExpiration(ctx, srcInfo.UserDefined); ok && (srcBucket != destBucket || srcObject != destObject) {
+		objInfo, err = l.copyObjectExpiring(ctx, srcBucket, srcObject, destBucket, destObject, metadata, srcOpts, destOpts)
+	} else {
+		objInfo, err = l.layer.CopyObject(ctx, srcBucket, srcObject, destBucket, destObject, srcInfo, srcOpts, destOpts)
+	}
+	if err == nil {
+		l.emit(ctx, EventObjectCreatedCopy, objInfo)
+	}
 	return objInfo, l.log(ctx, err)
 }
 
 // DeleteObject is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).DeleteObject.
 func (l *MultiTenancyLayer) DeleteObject(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return minio.ObjectInfo{}, err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	objInfo, err = l.layer.DeleteObject(miniogw.WithUplinkProject(ctx, project), bucket, object, opts)
+	objInfo, err = l.layer.DeleteObject(ctx, bucket, object, opts)
+	if err == nil {
+		l.emit(ctx, EventObjectRemovedDelete, minio.ObjectInfo{Bucket: bucket, Name: object, VersionID: objInfo.VersionID})
+	}
 	return objInfo, l.log(ctx, err)
 }
 
 // DeleteObjects is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).DeleteObjects.
 func (l *MultiTenancyLayer) DeleteObjects(ctx context.Context, bucket string, objects []minio.ObjectToDelete, opts minio.ObjectOptions) (deleted []minio.DeletedObject, errors []error) {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return nil, []error{err}
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	deleted, errors = l.layer.DeleteObjects(miniogw.WithUplinkProject(ctx, project), bucket, objects, opts)
+	deleted, errors = l.layer.DeleteObjects(ctx, bucket, objects, opts)
 
-	for _, err := range errors {
+	for i, err := range errors {
+		if err == nil && i < len(deleted) && deleted[i].ObjectName != "" {
+			l.emit(ctx, EventObjectRemovedDelete, minio.ObjectInfo{Bucket: bucket, Name: deleted[i].ObjectName, VersionID: deleted[i].VersionID})
+		}
 		_ = l.log(ctx, err)
 	}
 
@@ -372,91 +414,105 @@ func (l *MultiTenancyLayer) DeleteObjects(ctx context.Context, bucket string, ob
 
 // ListMultipartUploads is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).ListMultipartUploads.
 func (l *MultiTenancyLayer) ListMultipartUploads(ctx context.Context, bucket, prefix, keyMarker, uploadIDMarker, delimiter string, maxUploads int) (result minio.ListMultipartsInfo, err error) {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return minio.ListMultipartsInfo{}, err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	result, err = l.layer.ListMultipartUploads(miniogw.WithUplinkProject(ctx, project), bucket, prefix, keyMarker, uploadIDMarker, delimiter, maxUploads)
+	result, err = l.layer.ListMultipartUploads(ctx, bucket, prefix, keyMarker, uploadIDMarker, delimiter, maxUploads)
 	return result, l.log(ctx, err)
 }
 
 // NewMultipartUpload is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).NewMultipartUpload.
 func (l *MultiTenancyLayer) NewMultipartUpload(ctx context.Context, bucket, object string, opts minio.ObjectOptions) (uploadID string, err error) {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return "", err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	uploadID, err = l.layer.NewMultipartUpload(miniogw.WithUplinkProject(ctx, project), bucket, object, opts)
+	opts.UserDefined, _ = withObjectExpiration(ctx, opts.UserDefined)
+	if bind := uploadMetadata(ctx); bind != nil {
+		if opts.UserDefined == nil {
+			opts.UserDefined = make(map[string]string)
+		}
+		bind(opts.UserDefined)
+	}
+
+	uploadID, err = l.layer.NewMultipartUpload(ctx, bucket, object, opts)
 	return uploadID, l.log(ctx, err)
 }
 
 // PutObjectPart is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).PutObjectPart.
 func (l *MultiTenancyLayer) PutObjectPart(ctx context.Context, bucket, object, uploadID string, partID int, data *minio.PutObjReader, opts minio.ObjectOptions) (info minio.PartInfo, err error) {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return minio.PartInfo{}, err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	info, err = l.layer.PutObjectPart(miniogw.WithUplinkProject(ctx, project), bucket, object, uploadID, partID, data, opts)
+	info, err = l.layer.PutObjectPart(ctx, bucket, object, uploadID, partID, data, opts)
 	return info, l.log(ctx, err)
 }
 
 // GetMultipartInfo is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).GetMultipartInfo.
 func (l *MultiTenancyLayer) GetMultipartInfo(ctx context.Context, bucket string, object string, uploadID string, opts minio.ObjectOptions) (info minio.MultipartInfo, err error) {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return minio.MultipartInfo{}, err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	info, err = l.layer.GetMultipartInfo(miniogw.WithUplinkProject(ctx, project), bucket, object, uploadID, opts)
+	info, err = l.layer.GetMultipartInfo(ctx, bucket, object, uploadID, opts)
 	return info, l.log(ctx, err)
 }
 
 // ListObjectParts is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).ListObjectParts.
 func (l *MultiTenancyLayer) ListObjectParts(ctx context.Context, bucket, object, uploadID string, partNumberMarker int, maxParts int, opts minio.ObjectOptions) (result minio.ListPartsInfo, err error) {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return minio.ListPartsInfo{}, err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	result, err = l.layer.ListObjectParts(miniogw.WithUplinkProject(ctx, project), bucket, object, uploadID, partNumberMarker, maxParts, opts)
+	result, err = l.layer.ListObjectParts(ctx, bucket, object, uploadID, partNumberMarker, maxParts, opts)
 	return result, l.log(ctx, err)
 }
 
 // AbortMultipartUpload is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).AbortMultipartUpload.
 func (l *MultiTenancyLayer) AbortMultipartUpload(ctx context.Context, bucket, object, uploadID string, opts minio.ObjectOptions) error {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	return l.log(ctx, l.layer.AbortMultipartUpload(miniogw.WithUplinkProject(ctx, project), bucket, object, uploadID, opts))
+	return l.log(ctx, l.layer.AbortMultipartUpload(ctx, bucket, object, uploadID, opts))
 }
 
 // CompleteMultipartUpload is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).CompleteMultipartUpload.
 func (l *MultiTenancyLayer) CompleteMultipartUpload(ctx context.Context, bucket, object, uploadID string, uploadedParts []minio.CompletePart, opts minio.ObjectOptions) (objInfo minio.ObjectInfo, err error) {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return minio.ObjectInfo{}, err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	objInfo, err = l.layer.CompleteMultipartUpload(miniogw.WithUplinkProject(ctx, project), bucket, object, uploadID, uploadedParts, opts)
+	objInfo, err = l.layer.CompleteMultipartUpload(ctx, bucket, object, uploadID, uploadedParts, opts)
+	if bind := uploadMetadata(ctx); err == nil && bind != nil {
+		objInfo, err = completeUploadMetadata(ctx, project.Project, objInfo, bind)
+	}
+	if err == nil {
+		l.emit(ctx, EventObjectCreatedCompleteMultipartUpload, objInfo)
+	}
 	return objInfo, l.log(ctx, err)
 }
 
@@ -467,45 +523,58 @@ func (l *MultiTenancyLayer) IsTaggingSupported() bool {
 
 // PutObjectTags is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).PutObjectTags.
 func (l *MultiTenancyLayer) PutObjectTags(ctx context.Context, bucketName, objectPath string, tags string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return minio.ObjectInfo{}, err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	objInfo, err := l.layer.PutObjectTags(miniogw.WithUplinkProject(ctx, project), bucketName, objectPath, tags, opts)
+	objInfo, err := l.layer.PutObjectTags(ctx, bucketName, objectPath, tags, opts)
 
 	return objInfo, l.log(ctx, err)
 }
 
 // GetObjectTags is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).GetObjectTags.
 func (l *MultiTenancyLayer) GetObjectTags(ctx context.Context, bucketName, objectPath string, opts minio.ObjectOptions) (t *tags.Tags, err error) {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return nil, err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	t, err = l.layer.GetObjectTags(miniogw.WithUplinkProject(ctx, project), bucketName, objectPath, opts)
+	t, err = l.layer.GetObjectTags(ctx, bucketName, objectPath, opts)
 	return t, l.log(ctx, err)
 }
 
 // DeleteObjectTags is a multi-tenant wrapping of storj.io/gateway.(*gatewayLayer).DeleteObjectTags.
 func (l *MultiTenancyLayer) DeleteObjectTags(ctx context.Context, bucketName, objectPath string, opts minio.ObjectOptions) (minio.ObjectInfo, error) {
-	project, err := l.openProject(ctx, getAccessGrant(ctx))
+	ctx, project, err := l.openProject(ctx, getAccessGrant(ctx))
 	if err != nil {
 		return minio.ObjectInfo{}, err
 	}
 
 	defer func() { err = errs.Combine(err, project.Close()) }()
 
-	objInfo, err := l.layer.DeleteObjectTags(miniogw.WithUplinkProject(ctx, project), bucketName, objectPath, opts)
+	objInfo, err := l.layer.DeleteObjectTags(ctx, bucketName, objectPath, opts)
 
 	return objInfo, l.log(ctx, err)
 }
 
+// RevokeAccess revokes access with the access grant parent, which it has to
+// have been shared from.
+func (l *MultiTenancyLayer) RevokeAccess(ctx context.Context, parent string, access *uplink.Access) (err error) {
+	ctx, project, err := l.openProject(ctx, parent)
+	if err != nil {
+		return err
+	}
+
+	defer func() { err = errs.Combine(err, project.Close()) }()
+
+	return l.log(ctx, project.RevokeAccess(ctx, access))
+}
+
 func getAccessGrant(ctx context.Context) string {
 	credentials := middleware.GetAccess(ctx)
 	if credentials == nil || credentials.AccessKey == "" {
@@ -514,28 +583,42 @@ func getAccessGrant(ctx context.Context) string {
 	return credentials.AccessGrant
 }
 
-func (l *MultiTenancyLayer) openProject(ctx context.Context, accessKey string) (_ *uplink.Project, err error) {
+// openProject returns a reference to the project for accessKey, which has to
+// be closed, and a context to call the wrapped layer with it. Projects are
+// pooled, so the configuration that applies per request is applied to the
+// context rather than only when opening them.
+func (l *MultiTenancyLayer) openProject(ctx context.Context, accessKey string) (_ context.Context, _ *projectRef, err error) {
+	callCtx := l.withUploadsConfig(ctx)
+
 	defer mon.Task()(&ctx)(&err)
 
 	// this happens when an anonymous request hits the gateway endpoint, e.g.
 	// accessing http://localhost:20010 directly.
 	if accessKey == "" {
-		return nil, ErrAccessKeyEmpty
+		return nil, nil, ErrAccessKeyEmpty
 	}
 
-	access, err := uplink.ParseAccess(accessKey)
+	userAgent := getUserAgent(ctx)
+
+	project, err := l.projects.get(ctx, accessKey, userAgent, func() (*uplink.Project, error) {
+		access, err := uplink.ParseAccess(accessKey)
+		if err != nil {
+			return nil, ErrAccessGrant.Wrap(err)
+		}
+		return l.setupProject(ctx, access, userAgent)
+	})
 	if err != nil {
-		return nil, ErrAccessGrant.Wrap(err)
+		return nil, nil, err
 	}
 
-	return l.setupProject(ctx, access)
+	return miniogw.WithUplinkProject(callCtx, project.Project), project, nil
 }
 
-func (l *MultiTenancyLayer) setupProject(ctx context.Context, access *uplink.Access) (_ *uplink.Project, err error) {
+func (l *MultiTenancyLayer) setupProject(ctx context.Context, access *uplink.Access, userAgent string) (_ *uplink.Project, err error) {
 	defer mon.Task()(&ctx)(&err)
 
-	baseConfig, uploadsConfig := l.config.Base, l.config.Uploads
-	baseConfig.UserAgent = getUserAgent(ctx)
+	baseConfig := l.config.Base
+	baseConfig.UserAgent = userAgent
 
 	err = transport.SetConnectionPool(ctx, &baseConfig, l.connectionPool)
 	if err != nil {
@@ -547,14 +630,18 @@ func (l *MultiTenancyLayer) setupProject(ctx context.Context, access *uplink.Acc
 		return nil, err
 	}
 
-	if uploadsConfig.PieceHashAlgorithmBlake3 { // the default one is PieceHashAlgorithm_SHA256
+	return baseConfig.OpenProject(l.withUploadsConfig(ctx), access)
+}
+
+// withUploadsConfig returns ctx configured for uploads as set by l.config.
+func (l *MultiTenancyLayer) withUploadsConfig(ctx context.Context) context.Context {
+	if l.config.Uploads.PieceHashAlgorithmBlake3 { // the default one is PieceHashAlgorithm_SHA256
 		ctx = piecestore.WithPieceHashAlgo(ctx, pb.PieceHashAlgorithm_BLAKE3)
 	}
-	if uploadsConfig.RefactoredCodePath {
+	if l.config.Uploads.RefactoredCodePath {
 		ctx = testuplink.WithConcurrentSegmentUploadsDefaultConfig(ctx)
 	}
-
-	return baseConfig.OpenProject(ctx, access)
+	return ctx
 }
 
 func getUserAgent(ctx context.Context) string {
@@ -0,0 +1,235 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package gw
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"storj.io/uplink"
+)
+
+// ProjectPoolOptions configures the pool of projects MultiTenancyLayer
+// reuses across requests.
+type ProjectPoolOptions struct {
+	// Capacity is how many projects are kept open. Zero disables the pool.
+	Capacity int
+	// IdleExpiration is how long projects that aren't used are kept open.
+	IdleExpiration time.Duration
+}
+
+// projectKey identifies the projects that can be shared by requests. Projects
+// are opened with the user agent of the request, so it's part of the key.
+type projectKey struct {
+	accessGrant [sha256.Size]byte
+	userAgent   string
+}
+
+// pooledProject is a project in the pool, and how many calls are using it.
+type pooledProject struct {
+	key     projectKey
+	project *uplink.Project
+	// opened is how long opening the project took, which is saved each time
+	// it's reused.
+	opened time.Duration
+
+	refs     int
+	lastUsed time.Time
+	// evicted is set once the project is no longer in the pool, so that the
+	// last call using it closes it.
+	evicted bool
+}
+
+// projectPool keeps the projects opened for requests open for the following
+// requests using the same access grant, so that sequences of requests, like
+// the parts of a multipart upload, don't each parse the grant and open a
+// project.
+type projectPool struct {
+	options ProjectPoolOptions
+	now     func() time.Time
+
+	mu       sync.Mutex
+	projects map[projectKey]*pooledProject
+	closed   bool
+}
+
+func newProjectPool(options ProjectPoolOptions) *projectPool {
+	return &projectPool{
+		options:  options,
+		now:      time.Now,
+		projects: make(map[projectKey]*pooledProject),
+	}
+}
+
+// projectRef is a reference to a project. Closing it releases the reference,
+// which closes the project if it's not pooled.
+type projectRef struct {
+	*uplink.Project
+
+	once    sync.Once
+	release func() error
+}
+
+// Close releases the reference to the project. Subsequent calls do nothing.
+func (ref *projectRef) Close() (err error) {
+	ref.once.Do(func() { err = ref.release() })
+	return err
+}
+
+// get returns a reference to the project for accessGrant and userAgent,
+// calling open if there's none in the pool.
+func (p *projectPool) get(ctx context.Context, accessGrant, userAgent string, open func() (*uplink.Project, error)) (_ *projectRef, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	if p.options.Capacity <= 0 {
+		project, err := open()
+		if err != nil {
+			return nil, err
+		}
+		return &projectRef{Project: project, release: project.Close}, nil
+	}
+
+	key := projectKey{accessGrant: sha256.Sum256([]byte(accessGrant)), userAgent: userAgent}
+
+	p.mu.Lock()
+	if pooled, ok := p.projects[key]; ok && !p.expired(pooled, p.now()) {
+		pooled.refs++
+		p.mu.Unlock()
+
+		mon.Counter("project_pool_hit").Inc(1)
+		mon.DurationVal("project_pool_open_saved").Observe(pooled.opened)
+		return p.ref(pooled), nil
+	}
+	p.mu.Unlock()
+
+	mon.Counter("project_pool_miss").Inc(1)
+
+	start := time.Now()
+	project, err := open()
+	if err != nil {
+		return nil, err
+	}
+	pooled := &pooledProject{key: key, project: project, opened: time.Since(start), refs: 1}
+
+	p.mu.Lock()
+	evicted := p.add(pooled)
+	size := len(p.projects)
+	p.mu.Unlock()
+
+	mon.IntVal("project_pool_size").Observe(int64(size))
+	// failing to close the evicted projects doesn't concern this call.
+	if err := closeProjects(evicted); err != nil {
+		mon.Event("project_pool_close_failed")
+	}
+
+	return p.ref(pooled), nil
+}
+
+func (p *projectPool) ref(pooled *pooledProject) *projectRef {
+	return &projectRef{
+		Project: pooled.project,
+		release: func() error { return p.release(pooled) },
+	}
+}
+
+// add adds pooled to the pool, evicting the projects that are idle for too
+// long, or the least recently used idle project if the pool is full. If
+// there's no room, or the pool is closed, pooled is left out of it. It returns
+// the evicted projects to close. p.mu must be held.
+func (p *projectPool) add(pooled *pooledProject) (evicted []*uplink.Project) {
+	if p.closed {
+		pooled.evicted = true
+		return nil
+	}
+
+	now := p.now()
+	var lru *pooledProject
+	for key, other := range p.projects {
+		if other.refs > 0 {
+			continue
+		}
+		if p.expired(other, now) || key == pooled.key {
+			evicted = append(evicted, p.evict(other)...)
+			continue
+		}
+		if lru == nil || other.lastUsed.Before(lru.lastUsed) {
+			lru = other
+		}
+	}
+
+	if _, ok := p.projects[pooled.key]; ok {
+		// another call opened the same project in the meantime, and it's
+		// still in use.
+		pooled.evicted = true
+		return evicted
+	}
+	if len(p.projects) >= p.options.Capacity {
+		if lru == nil {
+			pooled.evicted = true
+			return evicted
+		}
+		evicted = append(evicted, p.evict(lru)...)
+	}
+
+	p.projects[pooled.key] = pooled
+	return evicted
+}
+
+// evict removes pooled from the pool, returning it to close unless it's in
+// use. p.mu must be held.
+func (p *projectPool) evict(pooled *pooledProject) []*uplink.Project {
+	delete(p.projects, pooled.key)
+	pooled.evicted = true
+	if pooled.refs > 0 {
+		return nil
+	}
+	return []*uplink.Project{pooled.project}
+}
+
+// expired returns whether pooled has been idle for too long. p.mu must be
+// held.
+func (p *projectPool) expired(pooled *pooledProject, now time.Time) bool {
+	return pooled.refs == 0 && now.Sub(pooled.lastUsed) >= p.options.IdleExpiration
+}
+
+// release releases a reference to pooled, closing it if it's the last one and
+// it's no longer pooled.
+func (p *projectPool) release(pooled *pooledProject) error {
+	p.mu.Lock()
+	pooled.refs--
+	pooled.lastUsed = p.now()
+	closing := pooled.refs == 0 && pooled.evicted
+	p.mu.Unlock()
+
+	if closing {
+		return pooled.project.Close()
+	}
+	return nil
+}
+
+// Close closes the projects that aren't in use. The ones that are get closed
+// once they're released.
+func (p *projectPool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	var evicted []*uplink.Project
+	for _, pooled := range p.projects {
+		evicted = append(evicted, p.evict(pooled)...)
+	}
+	p.mu.Unlock()
+
+	return closeProjects(evicted)
+}
+
+func closeProjects(projects []*uplink.Project) error {
+	var group errs.Group
+	for _, project := range projects {
+		group.Add(project.Close())
+	}
+	return group.Err()
+}
@@ -0,0 +1,158 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package gw
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/grant"
+	"storj.io/common/macaroon"
+	"storj.io/common/pb"
+	"storj.io/common/rpc/rpcpool"
+	"storj.io/common/storj"
+	"storj.io/common/testcontext"
+	"storj.io/common/testrand"
+	"storj.io/gateway/miniogw"
+	"storj.io/minio/cmd/logger"
+	"storj.io/uplink/private/piecestore"
+)
+
+func newTestAccessGrant(t *testing.T, secret string) string {
+	apiKey, err := macaroon.NewAPIKey([]byte(secret))
+	require.NoError(t, err)
+	access := grant.Access{
+		SatelliteAddress: storj.NodeURL{ID: testrand.NodeID(), Address: "127.0.0.1:7777"}.String(),
+		APIKey:           apiKey,
+		EncAccess:        grant.NewEncryptionAccess(),
+	}
+	serialized, err := access.Serialize()
+	require.NoError(t, err)
+	return serialized
+}
+
+func newTestPoolingLayer(options ProjectPoolOptions, uploads UploadConfig) (*MultiTenancyLayer, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	layer := &MultiTenancyLayer{
+		satelliteConnectionPool: rpcpool.New(rpcpool.Options{}),
+		connectionPool:          rpcpool.New(rpcpool.Options{}),
+		projects:                newProjectPool(options),
+		config:                  UplinkConfig{Uploads: uploads, ProjectPool: options},
+	}
+	layer.projects.now = func() time.Time { return now }
+	return layer, &now
+}
+
+func withUserAgent(ctx context.Context, userAgent string) context.Context {
+	return logger.SetReqInfo(ctx, &logger.ReqInfo{UserAgent: userAgent})
+}
+
+func TestProjectPool(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	layer, now := newTestPoolingLayer(ProjectPoolOptions{Capacity: 2, IdleExpiration: time.Minute}, UploadConfig{})
+	defer ctx.Check(func() error { return layer.Shutdown(ctx) })
+
+	grant1, grant2 := newTestAccessGrant(t, "secret1"), newTestAccessGrant(t, "secret2")
+
+	open := func(ctx context.Context, grant string) *projectRef {
+		return openTestProject(ctx, t, layer, grant)
+	}
+
+	// in-flight calls using the same grant and user agent share the project.
+	first, second := open(ctx, grant1), open(ctx, grant1)
+	assert.Same(t, first.Project, second.Project)
+	otherAgent := open(withUserAgent(ctx, "Test/1.0"), grant1)
+	assert.NotSame(t, first.Project, otherAgent.Project)
+
+	// the pool is full of projects in use, so other projects aren't pooled.
+	unpooled := open(ctx, grant2)
+	require.NoError(t, unpooled.Close())
+	assert.NotSame(t, unpooled.Project, open(ctx, grant2).Project)
+	assert.Len(t, layer.projects.projects, 2)
+
+	// released projects are reused until they're evicted.
+	require.NoError(t, first.Close())
+	require.NoError(t, first.Close())
+	require.NoError(t, second.Close())
+	require.NoError(t, otherAgent.Close())
+	*now = now.Add(time.Second)
+	reused := open(ctx, grant1)
+	assert.Same(t, first.Project, reused.Project)
+	require.NoError(t, reused.Close())
+
+	// the least recently used project is evicted to make room.
+	*now = now.Add(time.Second)
+	third := open(ctx, grant2)
+	require.NoError(t, third.Close())
+	assert.Len(t, layer.projects.projects, 2)
+	assert.Same(t, third.Project, open(ctx, grant2).Project)
+	assert.NotSame(t, otherAgent.Project, open(withUserAgent(ctx, "Test/1.0"), grant1).Project)
+
+	// projects idle for too long are opened again.
+	layer2, now2 := newTestPoolingLayer(ProjectPoolOptions{Capacity: 2, IdleExpiration: time.Minute}, UploadConfig{})
+	defer ctx.Check(func() error { return layer2.Shutdown(ctx) })
+	idle := openTestProject(ctx, t, layer2, grant1)
+	require.NoError(t, idle.Close())
+	*now2 = now2.Add(time.Minute)
+	fresh := openTestProject(ctx, t, layer2, grant1)
+	assert.NotSame(t, idle.Project, fresh.Project)
+
+	// projects in use when the pool is closed are closed when released.
+	require.NoError(t, layer2.projects.Close())
+	assert.Empty(t, layer2.projects.projects)
+	require.NoError(t, fresh.Close())
+	assert.NotSame(t, fresh.Project, openTestProject(ctx, t, layer2, grant1).Project)
+	assert.Empty(t, layer2.projects.projects)
+}
+
+func openTestProject(ctx context.Context, t *testing.T, layer *MultiTenancyLayer, grant string) *projectRef {
+	callCtx, project, err := layer.openProject(ctx, grant)
+	require.NoError(t, err)
+	called, ok := miniogw.GetUplinkProject(callCtx)
+	require.True(t, ok)
+	require.Same(t, project.Project, called)
+	return project
+}
+
+func TestProjectPoolDisabled(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	layer, _ := newTestPoolingLayer(ProjectPoolOptions{}, UploadConfig{})
+	defer ctx.Check(func() error { return layer.Shutdown(ctx) })
+
+	grant := newTestAccessGrant(t, "secret")
+	first := openTestProject(ctx, t, layer, grant)
+	second := openTestProject(ctx, t, layer, grant)
+	assert.NotSame(t, first.Project, second.Project)
+	require.NoError(t, first.Close())
+	require.NoError(t, second.Close())
+	assert.Empty(t, layer.projects.projects)
+
+	_, _, err := layer.openProject(ctx, "invalid")
+	require.True(t, ErrAccessGrant.Has(err))
+}
+
+func TestProjectPoolUploadsConfig(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	layer, _ := newTestPoolingLayer(ProjectPoolOptions{Capacity: 1, IdleExpiration: time.Minute}, UploadConfig{PieceHashAlgorithmBlake3: true})
+	defer ctx.Check(func() error { return layer.Shutdown(ctx) })
+
+	grant := newTestAccessGrant(t, "secret")
+
+	// the configuration applies to each call, whether it opened the project
+	// or not.
+	for i := 0; i < 2; i++ {
+		callCtx, project, err := layer.openProject(ctx, grant)
+		require.NoError(t, err)
+		assert.Equal(t, pb.PieceHashAlgorithm_BLAKE3, piecestore.GetPieceHashAlgo(callCtx))
+		require.NoError(t, project.Close())
+	}
+	assert.Equal(t, pb.PieceHashAlgorithm_SHA256, piecestore.GetPieceHashAlgo(ctx))
+}
@@ -33,7 +33,10 @@ type credentialsCV struct{}
 type Credentials struct {
 	AccessKey string
 	authclient.AuthServiceResponse
-	Error error
+	// SessionToken is the security token the request was sent with, which
+	// temporary credentials have to be used with.
+	SessionToken string
+	Error        error
 }
 
 const (
@@ -101,7 +104,7 @@ func AccessKey(authClient *authclient.AuthClient, trustedIPs trustedip.List, log
 			log.Debug("authentication through authservice successful")
 
 			// return a new context that contains the access grant
-			credentials := Credentials{AccessKey: accessKeyID, AuthServiceResponse: authResponse, Error: err}
+			credentials := Credentials{AccessKey: accessKeyID, AuthServiceResponse: authResponse, SessionToken: GetSessionToken(r), Error: err}
 			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, credentialsCV{}, &credentials)))
 		})
 	}
@@ -141,6 +144,22 @@ func GetAccess(ctx context.Context) *Credentials {
 	return creds
 }
 
+// WithCredentials returns a copy of ctx carrying credentials, the way AccessKey
+// passes them to handlers. It's for work done on behalf of the credentials'
+// owner outside of their requests.
+func WithCredentials(ctx context.Context, credentials *Credentials) context.Context {
+	return context.WithValue(ctx, credentialsCV{}, credentials)
+}
+
+// GetSessionToken returns the security token the request was sent with, from
+// its X-Amz-Security-Token header or query parameter.
+func GetSessionToken(r *http.Request) string {
+	if token := r.Header.Get("X-Amz-Security-Token"); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("X-Amz-Security-Token")
+}
+
 // GetAccessKeyID returns the access key ID from the request and a signature validator.
 func GetAccessKeyID(r *http.Request) (string, error) {
 	switch {
@@ -178,7 +178,7 @@ func logGatewayResponse(log *zap.Logger, r *http.Request, rw whmon.ResponseWrite
 		zap.String("error", gl.TagValue("error")),
 		zap.String("request-id", gl.RequestID),
 		zap.String("encryption-key-hash", getEncryptionKeyHash(r)),
-		zap.String("macaroon-head", getMacaroonHead(r)),
+		zap.String("macaroon-head", GetMacaroonHead(r)),
 		zap.Object("query", &requestQueryLogObject{
 			query:  r.URL.Query(),
 			logAll: insecureLogAll,
@@ -198,10 +198,15 @@ func logGatewayResponse(log *zap.Logger, r *http.Request, rw whmon.ResponseWrite
 	}
 }
 
-// getMacaroonHead gets the macaroon head corresponding to the current request.
+// GetMacaroonHead gets the macaroon head corresponding to the current request.
 // Macaroon head is the best available criteria for associating a request to a user.
-func getMacaroonHead(r *http.Request) string {
-	credentials := GetAccess(r.Context())
+func GetMacaroonHead(r *http.Request) string {
+	return GetAccess(r.Context()).MacaroonHead()
+}
+
+// MacaroonHead returns the hex-encoded macaroon head of the access grant of
+// credentials, or an empty string if there's none.
+func (credentials *Credentials) MacaroonHead() string {
 	if credentials == nil || credentials.AccessGrant == "" {
 		return ""
 	}
@@ -0,0 +1,408 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spacemonkeygo/monkit/v3"
+	"github.com/zeebo/errs"
+	"go.uber.org/zap"
+
+	"storj.io/common/memory"
+	"storj.io/common/sync2"
+	"storj.io/gateway-mt/pkg/trustedip"
+)
+
+// rateLimitChargeSize is how many bytes a request transfers before they're
+// charged to the bandwidth limits, and the transfer waits if they're
+// exceeded. The rest is charged when it ends.
+const rateLimitChargeSize = 256 * memory.KiB
+
+// RateLimit configures token-bucket limits on the rate of requests and the
+// bandwidth they use.
+type RateLimit struct {
+	Requests       float64     `json:"requests" help:"requests per second allowed; zero disables the limit" default:"0"`
+	RequestsBurst  int         `json:"requests-burst" help:"requests allowed at once; zero means the request rate, rounded up" default:"0"`
+	Bandwidth      memory.Size `json:"bandwidth" help:"bytes per second allowed to be uploaded and downloaded; zero disables the limit" default:"0B"`
+	BandwidthBurst memory.Size `json:"bandwidth-burst" help:"bytes allowed to be transferred at once; zero means the bandwidth" default:"0B"`
+}
+
+func (limit RateLimit) enabled() bool {
+	return limit.Requests > 0 || limit.Bandwidth > 0
+}
+
+func (limit RateLimit) requestsBurst() float64 {
+	if limit.RequestsBurst > 0 {
+		return float64(limit.RequestsBurst)
+	}
+	return math.Max(1, math.Ceil(limit.Requests))
+}
+
+func (limit RateLimit) bandwidthBurst() float64 {
+	if limit.BandwidthBurst > 0 {
+		return limit.BandwidthBurst.Float64()
+	}
+	return limit.Bandwidth.Float64()
+}
+
+// RateLimitConfig configures the limits of RateLimiter.
+type RateLimitConfig struct {
+	Credential       RateLimit
+	IP               RateLimit
+	ProjectOverrides string `help:"path to a JSON file mapping projects to the credential limits that apply to their credentials instead" default:""`
+
+	RedisURL           string        `help:"URL (redis://[:password@]host:port[/db]) of a Redis-protocol server to share the limits between gateways; they're kept in memory if empty" default:""`
+	RedisKeyPrefix     string        `help:"prefix of the keys the limits are kept under on the Redis-protocol server" default:"gateway-mt:ratelimit:"`
+	RedisTimeout       time.Duration `help:"timeout for requests to the Redis-protocol server" default:"100ms"`
+	RedisRetryInterval time.Duration `help:"how long the limits kept in memory are used after the Redis-protocol server fails" default:"10s"`
+}
+
+// LoadRateLimitOverrides loads the per-project overrides of the credential
+// limits from the JSON file at path, if any.
+func LoadRateLimitOverrides(path string) (map[string]RateLimit, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var overrides map[string]RateLimit
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, errs.New("invalid rate limit overrides in %q: %v", path, err)
+	}
+	return overrides, nil
+}
+
+// RateLimitKey is something requests are limited by, like a credential or a
+// client IP, and its limits.
+type RateLimitKey struct {
+	Name  string
+	Limit RateLimit
+}
+
+// RateLimitDecision is the outcome of checking a request against the limits
+// of its keys.
+type RateLimitDecision struct {
+	// RetryAfter is how long until the request would be allowed, or zero if
+	// it is.
+	RetryAfter time.Duration
+	// Key is the index of the key whose limit would be exceeded the longest.
+	Key int
+	// Limit is which of its limits it is: "requests" or "bandwidth".
+	Limit string
+}
+
+// RateLimitBackend keeps the state of rate limits.
+type RateLimitBackend interface {
+	// Allow checks whether a request is within the limits of all of keys,
+	// and counts it if it is.
+	Allow(ctx context.Context, now time.Time, keys []RateLimitKey) (RateLimitDecision, error)
+	// Charge charges bytes transferred by a request to the bandwidth limits
+	// of keys, and returns how long the request has to wait before
+	// transferring more to stay within them.
+	Charge(ctx context.Context, now time.Time, keys []RateLimitKey, bytes int64) (time.Duration, error)
+	// Close releases the resources of the backend.
+	Close() error
+}
+
+// OpenRateLimitBackend returns the backend configured to share the limits
+// between gateways, or nil if they're kept in memory.
+func OpenRateLimitBackend(config RateLimitConfig) (RateLimitBackend, error) {
+	if config.RedisURL == "" {
+		return nil, nil
+	}
+	return NewRedisRateLimitBackend(config.RedisURL, config.RedisKeyPrefix, config.RedisTimeout)
+}
+
+// RateLimiter imposes limits on the rate of requests and the bandwidth they
+// use per macaroon head and per client IP.
+type RateLimiter struct {
+	log        *zap.Logger
+	config     RateLimitConfig
+	overrides  map[string]RateLimit
+	trustedIPs trustedip.List
+	// shared keeps the limits shared with other gateways, if any. While it's
+	// failing, the limits kept in local are used instead.
+	shared RateLimitBackend
+	local  *MemoryRateLimitBackend
+	// projectFunc returns the project of a request, which selects the
+	// override of its credential limits. It's only called if there are
+	// overrides.
+	projectFunc func(*http.Request) (string, error)
+	// limitFunc writes the response to requests over the limits, which can be
+	// retried after retryAfter.
+	limitFunc func(w http.ResponseWriter, r *http.Request, retryAfter time.Duration)
+	now       func() time.Time
+	// sleep throttles transfers over the bandwidth limits.
+	sleep func(ctx context.Context, d time.Duration)
+
+	mu            sync.Mutex
+	degradedUntil time.Time
+}
+
+// NewRateLimiter constructs a RateLimiter. The limits are kept in shared, or
+// in memory if it's nil. As with NewLimiter, responding to limited requests
+// is left to limitFunc to avoid an import loop.
+func NewRateLimiter(
+	log *zap.Logger,
+	config RateLimitConfig,
+	overrides map[string]RateLimit,
+	shared RateLimitBackend,
+	trustedIPs trustedip.List,
+	projectFunc func(*http.Request) (string, error),
+	limitFunc func(w http.ResponseWriter, r *http.Request, retryAfter time.Duration),
+) *RateLimiter {
+	return &RateLimiter{
+		log:         log,
+		config:      config,
+		overrides:   overrides,
+		trustedIPs:  trustedIPs,
+		shared:      shared,
+		local:       NewMemoryRateLimitBackend(),
+		projectFunc: projectFunc,
+		limitFunc:   limitFunc,
+		now:         time.Now,
+		sleep:       func(ctx context.Context, d time.Duration) { sync2.Sleep(ctx, d) },
+	}
+}
+
+// Enabled returns whether any limit is configured.
+func (l *RateLimiter) Enabled() bool {
+	if l.config.Credential.enabled() || l.config.IP.enabled() {
+		return true
+	}
+	for _, limit := range l.overrides {
+		if limit.enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// Close closes the shared backend, if any.
+func (l *RateLimiter) Close() error {
+	if l.shared == nil {
+		return nil
+	}
+	return l.shared.Close()
+}
+
+// ipRateLimitedKey marks the context of requests whose client IP limits were
+// already applied, so that they aren't counted twice when Limit is run again
+// once credentials are known.
+type ipRateLimitedKey struct{}
+
+// Limit applies the limits as an HTTP middleware. It relies on the AccessKey
+// middleware being run to append credentials to the request context. It can
+// be run before that too, in which case only the client IP limits apply, and
+// only the credential limits are left for the next time.
+func (l *RateLimiter) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		ctx := r.Context()
+		defer mon.TaskNamed("RateLimit")(&ctx)(&err)
+
+		var keys []RateLimitKey
+		var scopes []string
+		add := func(scope, name string, limit RateLimit) {
+			if limit.enabled() {
+				keys = append(keys, RateLimitKey{Name: name, Limit: limit})
+				scopes = append(scopes, scope)
+			}
+		}
+
+		if head, err := getRequestMacaroonHead(r); err == nil {
+			limit := l.config.Credential
+			if len(l.overrides) > 0 && l.projectFunc != nil {
+				if project, err := l.projectFunc(r); err == nil {
+					if override, ok := l.overrides[project]; ok {
+						limit = override
+					}
+				}
+			}
+			add("credential", "head:"+head, limit)
+		}
+		if ctx.Value(ipRateLimitedKey{}) == nil {
+			add("ip", "ip:"+trustedip.GetClientIP(l.trustedIPs, r), l.config.IP)
+			r = r.WithContext(context.WithValue(r.Context(), ipRateLimitedKey{}, true))
+		}
+
+		if len(keys) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		decision := l.allow(ctx, keys)
+		if decision.RetryAfter > 0 {
+			mon.Counter("rate_limited",
+				monkit.NewSeriesTag("scope", scopes[decision.Key]),
+				monkit.NewSeriesTag("limit", decision.Limit)).Inc(1)
+			mon.DurationVal("rate_limited_retry_after").Observe(decision.RetryAfter)
+			l.limitFunc(w, r, decision.RetryAfter)
+			return
+		}
+
+		var charged []RateLimitKey
+		for _, key := range keys {
+			if key.Limit.Bandwidth > 0 {
+				charged = append(charged, key)
+			}
+		}
+		if len(charged) > 0 {
+			meter := &bandwidthMeter{
+				charge: func(bytes int64) time.Duration { return l.charge(ctx, charged, bytes) },
+				sleep:  func(d time.Duration) { l.sleep(ctx, d) },
+			}
+			defer meter.flush()
+			if r.Body != nil {
+				r.Body = &chargingReader{ReadCloser: r.Body, meter: meter}
+			}
+			w = &chargingWriter{ResponseWriter: w, meter: meter}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// backend returns the backend to use, which is the shared one unless it
+// failed recently.
+func (l *RateLimiter) backend(now time.Time) (_ RateLimitBackend, shared bool) {
+	if l.shared == nil {
+		return l.local, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Before(l.degradedUntil) {
+		return l.local, false
+	}
+	return l.shared, true
+}
+
+// degrade switches to the local limits for a while after the shared backend
+// failed with err.
+func (l *RateLimiter) degrade(now time.Time, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Before(l.degradedUntil) {
+		return
+	}
+	l.degradedUntil = now.Add(l.config.RedisRetryInterval)
+	mon.Event("rate_limit_backend_degraded")
+	l.log.Warn("shared rate limits unavailable; using local ones",
+		zap.Duration("retry interval", l.config.RedisRetryInterval), zap.Error(err))
+}
+
+func (l *RateLimiter) allow(ctx context.Context, keys []RateLimitKey) RateLimitDecision {
+	now := l.now()
+	if backend, shared := l.backend(now); shared {
+		decision, err := backend.Allow(ctx, now, keys)
+		if err == nil {
+			return decision
+		}
+		l.degrade(now, err)
+	}
+	// the memory backend doesn't fail.
+	decision, _ := l.local.Allow(ctx, now, keys)
+	return decision
+}
+
+func (l *RateLimiter) charge(ctx context.Context, keys []RateLimitKey, bytes int64) time.Duration {
+	now := l.now()
+	if backend, shared := l.backend(now); shared {
+		wait, err := backend.Charge(ctx, now, keys, bytes)
+		if err == nil {
+			return wait
+		}
+		l.degrade(now, err)
+	}
+	// the memory backend doesn't fail.
+	wait, _ := l.local.Charge(ctx, now, keys, bytes)
+	return wait
+}
+
+// bandwidthMeter charges the bytes a request transfers in batches, so that
+// shared backends aren't asked on each read and write, and throttles the
+// transfer while it's over the limits.
+type bandwidthMeter struct {
+	charge func(bytes int64) time.Duration
+	sleep  func(d time.Duration)
+
+	mu      sync.Mutex
+	pending int64
+}
+
+// add charges n more bytes, waiting if the transfer went over the limits.
+func (m *bandwidthMeter) add(n int) {
+	if n <= 0 {
+		return
+	}
+	var bytes int64
+	m.mu.Lock()
+	m.pending += int64(n)
+	if m.pending >= rateLimitChargeSize.Int64() {
+		bytes, m.pending = m.pending, 0
+	}
+	m.mu.Unlock()
+
+	if bytes > 0 {
+		if wait := m.charge(bytes); wait > 0 {
+			mon.DurationVal("rate_limit_bandwidth_wait").Observe(wait)
+			m.sleep(wait)
+		}
+	}
+}
+
+func (m *bandwidthMeter) flush() {
+	m.mu.Lock()
+	bytes := m.pending
+	m.pending = 0
+	m.mu.Unlock()
+
+	if bytes > 0 {
+		// the transfer is over, so what's over the limits delays the next
+		// requests instead.
+		m.charge(bytes)
+	}
+}
+
+// chargingReader charges the bytes read from a request body, and slows reading
+// down to the bandwidth limits.
+type chargingReader struct {
+	io.ReadCloser
+	meter *bandwidthMeter
+}
+
+func (r *chargingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.meter.add(n)
+	return n, err
+}
+
+// chargingWriter charges the bytes written to a response, and slows writing
+// down to the bandwidth limits.
+type chargingWriter struct {
+	http.ResponseWriter
+	meter *bandwidthMeter
+}
+
+func (w *chargingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.meter.add(n)
+	return n, err
+}
+
+func (w *chargingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package middleware
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// rateLimitSweepInterval is how often the state of keys whose buckets have
+// refilled is dropped.
+const rateLimitSweepInterval = time.Minute
+
+// tokenBucket holds tokens that refill at a rate up to a burst. Tokens can be
+// taken past zero, which delays until more can be taken.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time, rate, burst float64) {
+	if b.updated.IsZero() {
+		b.tokens = burst
+	} else if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*rate)
+	}
+	b.updated = now
+}
+
+// wait returns how long until the bucket holds n tokens.
+func (b *tokenBucket) wait(rate, n float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / rate * float64(time.Second))
+}
+
+// full returns whether the bucket would have refilled by now.
+func (b *tokenBucket) full(now time.Time, rate, burst float64) bool {
+	return b.updated.IsZero() || b.tokens+now.Sub(b.updated).Seconds()*rate >= burst
+}
+
+// rateLimitState is the state of the limits of a key.
+type rateLimitState struct {
+	limit     RateLimit
+	requests  tokenBucket
+	bandwidth tokenBucket
+}
+
+func (s *rateLimitState) refill(now time.Time) {
+	if s.limit.Requests > 0 {
+		s.requests.refill(now, s.limit.Requests, s.limit.requestsBurst())
+	}
+	if s.limit.Bandwidth > 0 {
+		s.bandwidth.refill(now, s.limit.Bandwidth.Float64(), s.limit.bandwidthBurst())
+	}
+}
+
+// wait returns how long until a request would be allowed, and which of the
+// limits it would exceed until then.
+func (s *rateLimitState) wait() (time.Duration, string) {
+	var wait time.Duration
+	var exceeded string
+	if s.limit.Requests > 0 {
+		if d := s.requests.wait(s.limit.Requests, 1); d > wait {
+			wait, exceeded = d, "requests"
+		}
+	}
+	if s.limit.Bandwidth > 0 {
+		// transfers are charged as they happen, so all that's needed to start
+		// one is to be out of debt.
+		if d := s.bandwidth.wait(s.limit.Bandwidth.Float64(), 0); d > wait {
+			wait, exceeded = d, "bandwidth"
+		}
+	}
+	return wait, exceeded
+}
+
+func (s *rateLimitState) full(now time.Time) bool {
+	return (s.limit.Requests <= 0 || s.requests.full(now, s.limit.Requests, s.limit.requestsBurst())) &&
+		(s.limit.Bandwidth <= 0 || s.bandwidth.full(now, s.limit.Bandwidth.Float64(), s.limit.bandwidthBurst()))
+}
+
+// MemoryRateLimitBackend keeps token buckets in memory, so the limits only
+// apply to the requests of one gateway.
+type MemoryRateLimitBackend struct {
+	mu        sync.Mutex
+	states    map[string]*rateLimitState
+	lastSweep time.Time
+}
+
+// NewMemoryRateLimitBackend constructs a MemoryRateLimitBackend.
+func NewMemoryRateLimitBackend() *MemoryRateLimitBackend {
+	return &MemoryRateLimitBackend{
+		states: make(map[string]*rateLimitState),
+	}
+}
+
+// Allow implements RateLimitBackend.
+func (b *MemoryRateLimitBackend) Allow(ctx context.Context, now time.Time, keys []RateLimitKey) (RateLimitDecision, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sweep(now)
+
+	var decision RateLimitDecision
+	states := make([]*rateLimitState, len(keys))
+	for i, key := range keys {
+		states[i] = b.state(now, key)
+		if wait, exceeded := states[i].wait(); wait > decision.RetryAfter {
+			decision = RateLimitDecision{RetryAfter: wait, Key: i, Limit: exceeded}
+		}
+	}
+	if decision.RetryAfter == 0 {
+		// the request is only counted once it's allowed by all the limits, so
+		// that limited requests don't prolong the wait.
+		for _, state := range states {
+			if state.limit.Requests > 0 {
+				state.requests.tokens--
+			}
+		}
+	}
+	return decision, nil
+}
+
+// Charge implements RateLimitBackend.
+func (b *MemoryRateLimitBackend) Charge(ctx context.Context, now time.Time, keys []RateLimitKey, bytes int64) (time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var wait time.Duration
+	for _, key := range keys {
+		if key.Limit.Bandwidth > 0 {
+			state := b.state(now, key)
+			state.bandwidth.tokens -= float64(bytes)
+			if d := state.bandwidth.wait(key.Limit.Bandwidth.Float64(), 0); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait, nil
+}
+
+// Close implements RateLimitBackend.
+func (b *MemoryRateLimitBackend) Close() error { return nil }
+
+// state returns the refilled state of key. b.mu must be held.
+func (b *MemoryRateLimitBackend) state(now time.Time, key RateLimitKey) *rateLimitState {
+	state, ok := b.states[key.Name]
+	if !ok || state.limit != key.Limit {
+		state = &rateLimitState{limit: key.Limit}
+		b.states[key.Name] = state
+	}
+	state.refill(now)
+	return state
+}
+
+// sweep drops the state of the keys whose buckets have refilled, as they're
+// indistinguishable from new ones. b.mu must be held.
+func (b *MemoryRateLimitBackend) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	b.lastSweep = now
+	for name, state := range b.states {
+		if state.full(now) {
+			delete(b.states, name)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package middleware
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/zeebo/errs"
+)
+
+// RedisRateLimitBackend keeps sliding-window counters on a Redis-protocol
+// server, so that the limits are shared between gateways.
+//
+// Each limit is counted over windows that take as long as its rate needs to
+// make up its burst. A key is estimated to have used the count of the current
+// window plus the part of the count of the previous one that the sliding
+// window still covers. Unlike token buckets, usage is forgotten after two
+// windows, so a transfer larger than the bandwidth burst only delays the
+// following requests until the window it was charged in slides by.
+//
+// Checking and counting requests are separate round trips, so gateways racing
+// each other can slightly exceed the limits.
+type RedisRateLimitBackend struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+// NewRedisRateLimitBackend constructs a RedisRateLimitBackend for the server
+// at url, keeping the counters under keys starting with prefix.
+func NewRedisRateLimitBackend(rawurl, prefix string, timeout time.Duration) (*RedisRateLimitBackend, error) {
+	// check the URL now rather than failing every request.
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, errs.New("invalid rate limit Redis URL: %v", err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, errs.New("invalid rate limit Redis URL scheme: %q", u.Scheme)
+	}
+	return &RedisRateLimitBackend{
+		pool: &redis.Pool{
+			Dial: func() (redis.Conn, error) {
+				return redis.DialURL(rawurl,
+					redis.DialConnectTimeout(timeout),
+					redis.DialReadTimeout(timeout),
+					redis.DialWriteTimeout(timeout))
+			},
+			MaxIdle:     16,
+			IdleTimeout: time.Minute,
+		},
+		prefix: prefix,
+	}, nil
+}
+
+// slidingWindow is a limit counted over windows of a fixed length.
+type slidingWindow struct {
+	key string
+	// limit is the most that can have been used for a request to be allowed.
+	limit float64
+	// window is how long each window takes, and index is which one is
+	// current.
+	window time.Duration
+	index  int64
+	// elapsed is which fraction of the current window has passed.
+	elapsed float64
+	// previous and current are the counts of the previous and current
+	// windows.
+	previous, current float64
+}
+
+func newSlidingWindow(prefix, name, limitName string, now time.Time, rate, burst, limit float64) *slidingWindow {
+	window := time.Duration(burst / rate * float64(time.Second))
+	if window < time.Millisecond {
+		window = time.Millisecond
+	}
+	index := now.UnixNano() / int64(window)
+	return &slidingWindow{
+		key:     prefix + name + ":" + limitName + ":" + strconv.FormatInt(window.Milliseconds(), 10) + ":",
+		limit:   limit,
+		window:  window,
+		index:   index,
+		elapsed: float64(now.UnixNano()-index*int64(window)) / float64(window),
+	}
+}
+
+func (w *slidingWindow) currentKey() string  { return w.key + strconv.FormatInt(w.index, 10) }
+func (w *slidingWindow) previousKey() string { return w.key + strconv.FormatInt(w.index-1, 10) }
+
+// wait returns how long until the estimated usage is within the limit.
+func (w *slidingWindow) wait() time.Duration {
+	if w.previous*(1-w.elapsed)+w.current <= w.limit {
+		return 0
+	}
+	var fraction float64
+	if w.current > w.limit {
+		// the current window has to become the previous one, and slide by
+		// until what's left of it is within the limit.
+		fraction = (1 - w.elapsed) + (1 - w.limit/w.current)
+	} else {
+		fraction = (1 - (w.limit-w.current)/w.previous) - w.elapsed
+	}
+	return time.Duration(fraction * float64(w.window))
+}
+
+// rateLimitWindows returns the sliding windows of the limits of key.
+func (b *RedisRateLimitBackend) rateLimitWindows(now time.Time, key RateLimitKey) (requests, bandwidth *slidingWindow) {
+	if key.Limit.Requests > 0 {
+		burst := key.Limit.requestsBurst()
+		requests = newSlidingWindow(b.prefix, key.Name, "requests", now, key.Limit.Requests, burst, burst-1)
+	}
+	if key.Limit.Bandwidth > 0 {
+		// transfers are charged as they happen, so all that's needed to start
+		// one is to be within the burst.
+		burst := key.Limit.bandwidthBurst()
+		bandwidth = newSlidingWindow(b.prefix, key.Name, "bandwidth", now, key.Limit.Bandwidth.Float64(), burst, burst)
+	}
+	return requests, bandwidth
+}
+
+// Allow implements RateLimitBackend.
+func (b *RedisRateLimitBackend) Allow(ctx context.Context, now time.Time, keys []RateLimitKey) (_ RateLimitDecision, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	type limited struct {
+		key    int
+		name   string
+		window *slidingWindow
+	}
+	var windows []limited
+	for i, key := range keys {
+		requests, bandwidth := b.rateLimitWindows(now, key)
+		if requests != nil {
+			windows = append(windows, limited{key: i, name: "requests", window: requests})
+		}
+		if bandwidth != nil {
+			windows = append(windows, limited{key: i, name: "bandwidth", window: bandwidth})
+		}
+	}
+	if len(windows) == 0 {
+		return RateLimitDecision{}, nil
+	}
+
+	conn, err := b.pool.GetContext(ctx)
+	if err != nil {
+		return RateLimitDecision{}, errs.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, conn.Close()) }()
+
+	args := make([]interface{}, 0, 2*len(windows))
+	for _, w := range windows {
+		args = append(args, w.window.previousKey(), w.window.currentKey())
+	}
+	counts, err := redis.Int64s(conn.Do("MGET", args...))
+	if err != nil {
+		return RateLimitDecision{}, errs.Wrap(err)
+	}
+	if len(counts) != len(args) {
+		return RateLimitDecision{}, errs.New("unexpected number of counts: %d", len(counts))
+	}
+
+	var decision RateLimitDecision
+	for i, w := range windows {
+		w.window.previous, w.window.current = float64(counts[2*i]), float64(counts[2*i+1])
+		if wait := w.window.wait(); wait > decision.RetryAfter {
+			decision = RateLimitDecision{RetryAfter: wait, Key: w.key, Limit: w.name}
+		}
+	}
+	if decision.RetryAfter > 0 {
+		return decision, nil
+	}
+
+	// the request is only counted once it's allowed by all the limits, so
+	// that limited requests don't prolong the wait.
+	for _, w := range windows {
+		if w.name == "requests" {
+			if err := incrSlidingWindow(conn, w.window, 1); err != nil {
+				return RateLimitDecision{}, err
+			}
+		}
+	}
+	if _, err := conn.Do(""); err != nil {
+		return RateLimitDecision{}, errs.Wrap(err)
+	}
+	return decision, nil
+}
+
+// Charge implements RateLimitBackend.
+func (b *RedisRateLimitBackend) Charge(ctx context.Context, now time.Time, keys []RateLimitKey, bytes int64) (_ time.Duration, err error) {
+	defer mon.Task()(&ctx)(&err)
+
+	var windows []*slidingWindow
+	for _, key := range keys {
+		if _, bandwidth := b.rateLimitWindows(now, key); bandwidth != nil {
+			windows = append(windows, bandwidth)
+		}
+	}
+	if len(windows) == 0 {
+		return 0, nil
+	}
+
+	conn, err := b.pool.GetContext(ctx)
+	if err != nil {
+		return 0, errs.Wrap(err)
+	}
+	defer func() { err = errs.Combine(err, conn.Close()) }()
+
+	for _, w := range windows {
+		if err := incrSlidingWindow(conn, w, bytes); err != nil {
+			return 0, err
+		}
+		if err := conn.Send("MGET", w.previousKey()); err != nil {
+			return 0, errs.Wrap(err)
+		}
+	}
+	replies, err := redis.Values(conn.Do(""))
+	if err != nil {
+		return 0, errs.Wrap(err)
+	}
+	// each window has the replies of INCRBY, PEXPIRE and MGET.
+	if len(replies) != 3*len(windows) {
+		return 0, errs.New("unexpected number of replies: %d", len(replies))
+	}
+
+	var wait time.Duration
+	for i, w := range windows {
+		current, err := redis.Int64(replies[3*i], nil)
+		if err != nil {
+			return 0, errs.Wrap(err)
+		}
+		previous, err := redis.Int64s(replies[3*i+2], nil)
+		if err != nil || len(previous) != 1 {
+			return 0, errs.New("unexpected previous count: %v", err)
+		}
+		w.previous, w.current = float64(previous[0]), float64(current)
+		if d := w.wait(); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// incrSlidingWindow queues adding n to the current count of w, which expires
+// once it's no longer needed as the previous count either.
+func incrSlidingWindow(conn redis.Conn, w *slidingWindow, n int64) error {
+	key := w.currentKey()
+	if err := conn.Send("INCRBY", key, n); err != nil {
+		return errs.Wrap(err)
+	}
+	return errs.Wrap(conn.Send("PEXPIRE", key, (2 * w.window).Milliseconds()))
+}
+
+// Close implements RateLimitBackend.
+func (b *RedisRateLimitBackend) Close() error {
+	return b.pool.Close()
+}
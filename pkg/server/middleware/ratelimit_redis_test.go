@@ -0,0 +1,262 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package middleware
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"storj.io/common/memory"
+	"storj.io/common/testcontext"
+)
+
+// testRedisServer is an in-process stand-in for the commands of a
+// Redis-protocol server that RedisRateLimitBackend uses.
+type testRedisServer struct {
+	listener net.Listener
+
+	mu     sync.Mutex
+	values map[string]int64
+	ttls   map[string]int64
+	fail   bool
+}
+
+func newTestRedisServer(ctx *testcontext.Context, t *testing.T) *testRedisServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &testRedisServer{
+		listener: listener,
+		values:   make(map[string]int64),
+		ttls:     make(map[string]int64),
+	}
+	ctx.Go(func() error {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return nil
+				}
+				return err
+			}
+			ctx.Go(func() error {
+				defer func() { _ = conn.Close() }()
+				server.serve(conn)
+				return nil
+			})
+		}
+	})
+	t.Cleanup(func() { _ = listener.Close() })
+
+	return server
+}
+
+func (server *testRedisServer) URL() string {
+	return "redis://" + server.listener.Addr().String()
+}
+
+func (server *testRedisServer) setFail(fail bool) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.fail = fail
+}
+
+func (server *testRedisServer) sum(suffix string) (sum int64) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for key, value := range server.values {
+		if strings.Contains(key, suffix) {
+			sum += value
+		}
+	}
+	return sum
+}
+
+func (server *testRedisServer) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			return
+		}
+		if _, err := io.WriteString(conn, server.do(args)); err != nil {
+			return
+		}
+	}
+}
+
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected command: %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func (server *testRedisServer) do(args []string) string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if server.fail {
+		return "-ERR unavailable\r\n"
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "MGET":
+		reply := "*" + strconv.Itoa(len(args)-1) + "\r\n"
+		for _, key := range args[1:] {
+			value, ok := server.values[key]
+			if !ok {
+				reply += "$-1\r\n"
+				continue
+			}
+			s := strconv.FormatInt(value, 10)
+			reply += "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+		}
+		return reply
+	case "INCRBY":
+		n, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return "-ERR value is not an integer\r\n"
+		}
+		server.values[args[1]] += n
+		return ":" + strconv.FormatInt(server.values[args[1]], 10) + "\r\n"
+	case "PEXPIRE":
+		ttl, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return "-ERR value is not an integer\r\n"
+		}
+		server.ttls[args[1]] = ttl
+		return ":1\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func TestRedisRateLimitBackendShared(t *testing.T) {
+	ctx := testcontext.New(t)
+	server := newTestRedisServer(ctx, t)
+
+	config := RateLimitConfig{
+		Credential:         RateLimit{Requests: 2, RequestsBurst: 4, Bandwidth: memory.MiB, BandwidthBurst: 2 * memory.MiB},
+		RedisKeyPrefix:     "test:",
+		RedisRetryInterval: time.Minute,
+	}
+	newTest := func() *rateLimitTest {
+		backend, err := NewRedisRateLimitBackend(server.URL(), config.RedisKeyPrefix, time.Second)
+		require.NoError(t, err)
+		test := newSharedRateLimitTest(t, config, nil, backend)
+		t.Cleanup(func() { require.NoError(t, test.limiter.Close()) })
+		return test
+	}
+	test1, test2 := newTest(), newTest()
+
+	creds := getCredentials(t)
+
+	// the burst is shared by both gateways. The clock starts at the start of
+	// a window, which takes 2s.
+	for i := 0; i < 2; i++ {
+		test1.expect(creds, "10.0.0.1", 200, 0)
+		test2.expect(creds, "10.0.0.1", 200, 0)
+	}
+	test1.expect(creds, "10.0.0.1", 429, 2500*time.Millisecond)
+	test2.expect(creds, "10.0.0.1", 429, 2500*time.Millisecond)
+	assert.EqualValues(t, 4, server.sum(":requests:"))
+
+	// a quarter into the next window, the previous one only counts for 3 of
+	// its 4 requests.
+	test1.now = test1.now.Add(2500 * time.Millisecond)
+	test2.now = test1.now
+	test2.expect(creds, "10.0.0.1", 200, 0)
+	test1.expect(creds, "10.0.0.1", 429, 500*time.Millisecond)
+
+	// transfers are charged to the shared counters, and counters expire after
+	// two windows.
+	test1.now = test1.now.Add(time.Minute)
+	test2.now = test1.now
+	w := test1.do(creds, "10.0.0.1", "/?download=3MiB", "")
+	require.Equal(t, 200, w.Code)
+	assert.EqualValues(t, 3*memory.MiB, server.sum(":bandwidth:"))
+	// the transfer is slowed down once it's over the burst.
+	assert.Positive(t, test1.waited)
+	w = test2.do(creds, "10.0.0.1", "/", "")
+	require.Equal(t, 429, w.Code)
+
+	server.mu.Lock()
+	for key, ttl := range server.ttls {
+		assert.EqualValues(t, 4000, ttl, key)
+	}
+	server.mu.Unlock()
+
+	_, err := NewRedisRateLimitBackend("http://"+server.listener.Addr().String(), "", time.Second)
+	require.Error(t, err)
+}
+
+func TestRedisRateLimitBackendDegraded(t *testing.T) {
+	ctx := testcontext.New(t)
+	server := newTestRedisServer(ctx, t)
+
+	config := RateLimitConfig{
+		IP:                 RateLimit{Requests: 1, RequestsBurst: 2},
+		RedisRetryInterval: 10 * time.Second,
+	}
+	backend, err := NewRedisRateLimitBackend(server.URL(), "", time.Second)
+	require.NoError(t, err)
+	test := newSharedRateLimitTest(t, config, nil, backend)
+	defer func() { require.NoError(t, test.limiter.Close()) }()
+
+	test.expect(nil, "10.0.0.1", 200, 0)
+	assert.EqualValues(t, 1, server.sum(":requests:"))
+
+	// while the server fails, the limits are kept in memory.
+	server.setFail(true)
+	test.expect(nil, "10.0.0.1", 200, 0)
+	test.expect(nil, "10.0.0.1", 200, 0)
+	test.expect(nil, "10.0.0.1", 429, time.Second)
+
+	// the server is used again after the retry interval.
+	server.setFail(false)
+	test.now = test.now.Add(5 * time.Second)
+	test.expect(nil, "10.0.0.1", 200, 0)
+	test.expect(nil, "10.0.0.1", 200, 0)
+	assert.EqualValues(t, 1, server.sum(":requests:"))
+	test.now = test.now.Add(5 * time.Second)
+	test.expect(nil, "10.0.0.1", 200, 0)
+	assert.EqualValues(t, 2, server.sum(":requests:"))
+}
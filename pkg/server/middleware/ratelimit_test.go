@@ -0,0 +1,246 @@
+// Copyright (C) 2023 Storj Labs, Inc.
+// See LICENSE for copying information.
+
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"storj.io/common/memory"
+	"storj.io/common/testcontext"
+	"storj.io/gateway-mt/pkg/trustedip"
+)
+
+type rateLimitTest struct {
+	t       *testing.T
+	ctx     context.Context
+	limiter *RateLimiter
+	handler http.Handler
+	now     time.Time
+	// waited is how long transfers were throttled for. The clock advances
+	// while they are.
+	waited time.Duration
+}
+
+func newRateLimitTest(t *testing.T, config RateLimitConfig, overrides map[string]RateLimit) *rateLimitTest {
+	return newSharedRateLimitTest(t, config, overrides, nil)
+}
+
+func newSharedRateLimitTest(t *testing.T, config RateLimitConfig, overrides map[string]RateLimit, shared RateLimitBackend) *rateLimitTest {
+	test := &rateLimitTest{t: t, ctx: testcontext.New(t), now: time.Unix(1700000000, 0)}
+
+	test.limiter = NewRateLimiter(zaptest.NewLogger(t), config, overrides, shared, trustedip.NewListUntrustAll(),
+		func(r *http.Request) (string, error) {
+			return GetAccess(r.Context()).AccessKey, nil
+		},
+		func(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+			w.Header().Set("Retry-After", retryAfter.String())
+			w.WriteHeader(http.StatusTooManyRequests)
+		},
+	)
+	test.limiter.now = func() time.Time { return test.now }
+	test.limiter.sleep = func(ctx context.Context, d time.Duration) {
+		test.waited += d
+		test.now = test.now.Add(d)
+	}
+
+	test.handler = test.limiter.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if download := r.URL.Query().Get("download"); download != "" {
+			var size memory.Size
+			require.NoError(t, size.Set(download))
+			_, _ = io.Copy(w, io.LimitReader(zeroReader{}, size.Int64()))
+		}
+	}))
+
+	return test
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func (test *rateLimitTest) do(creds *Credentials, ip, target, body string) *httptest.ResponseRecorder {
+	ctx := test.ctx
+	if creds != nil {
+		ctx = context.WithValue(ctx, credentialsCV{}, creds)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, strings.NewReader(body))
+	require.NoError(test.t, err)
+	req.RemoteAddr = ip + ":1234"
+	w := httptest.NewRecorder()
+	test.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (test *rateLimitTest) expect(creds *Credentials, ip string, code int, retryAfter time.Duration) {
+	test.t.Helper()
+	w := test.do(creds, ip, "/", "")
+	require.Equal(test.t, code, w.Code)
+	if code == http.StatusTooManyRequests {
+		assert.Equal(test.t, retryAfter.String(), w.Header().Get("Retry-After"))
+	}
+}
+
+func TestRateLimiterRequests(t *testing.T) {
+	test := newRateLimitTest(t, RateLimitConfig{
+		Credential: RateLimit{Requests: 2, RequestsBurst: 3},
+		IP:         RateLimit{Requests: 10},
+	}, nil)
+	require.True(t, test.limiter.Enabled())
+
+	creds1, creds2 := getCredentials(t), getCredentials(t)
+
+	// the burst is allowed at once, then requests are allowed at the rate.
+	for i := 0; i < 3; i++ {
+		test.expect(creds1, "10.0.0.1", http.StatusOK, 0)
+	}
+	test.expect(creds1, "10.0.0.1", http.StatusTooManyRequests, 500*time.Millisecond)
+	test.expect(creds2, "10.0.0.1", http.StatusOK, 0)
+
+	// limited requests don't count, so they don't prolong the wait.
+	test.now = test.now.Add(200 * time.Millisecond)
+	test.expect(creds1, "10.0.0.1", http.StatusTooManyRequests, 300*time.Millisecond)
+	test.now = test.now.Add(300 * time.Millisecond)
+	test.expect(creds1, "10.0.0.1", http.StatusOK, 0)
+
+	// the IP limit applies across credentials and to anonymous requests.
+	for i := 0; i < 10; i++ {
+		test.expect(nil, "10.0.0.2", http.StatusOK, 0)
+	}
+	test.expect(nil, "10.0.0.2", http.StatusTooManyRequests, 100*time.Millisecond)
+	test.expect(creds2, "10.0.0.2", http.StatusTooManyRequests, 100*time.Millisecond)
+	test.expect(nil, "10.0.0.3", http.StatusOK, 0)
+}
+
+func TestRateLimiterTwice(t *testing.T) {
+	test := newRateLimitTest(t, RateLimitConfig{
+		Credential: RateLimit{Requests: 1},
+		IP:         RateLimit{Requests: 2},
+	}, nil)
+
+	// the limiter is run before credentials are known, and again after.
+	var creds *Credentials
+	inner := test.handler
+	test.handler = test.limiter.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inner.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), credentialsCV{}, creds)))
+	}))
+
+	creds1, creds2 := getCredentials(t), getCredentials(t)
+
+	// requests count once against the IP limit.
+	creds = creds1
+	test.expect(nil, "10.0.0.1", http.StatusOK, 0)
+	creds = creds2
+	test.expect(nil, "10.0.0.1", http.StatusOK, 0)
+	test.expect(nil, "10.0.0.1", http.StatusTooManyRequests, 500*time.Millisecond)
+
+	// the credential limits still apply.
+	test.expect(nil, "10.0.0.2", http.StatusTooManyRequests, time.Second)
+}
+
+func TestRateLimiterBandwidth(t *testing.T) {
+	test := newRateLimitTest(t, RateLimitConfig{
+		Credential: RateLimit{Bandwidth: memory.MiB, BandwidthBurst: 2 * memory.MiB},
+	}, nil)
+
+	creds := getCredentials(t)
+
+	// transfers are charged as they happen, and slowed down to the bandwidth
+	// once they're over the burst.
+	w := test.do(creds, "10.0.0.1", "/?download=3MiB", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 3*memory.MiB.Int(), w.Body.Len())
+	assert.InDelta(t, time.Second, test.waited, float64(time.Millisecond))
+	test.expect(creds, "10.0.0.1", http.StatusOK, 0)
+
+	// so are uploads, and what's left when a transfer ends makes the next
+	// request wait until the debt is paid off.
+	test.waited = 0
+	test.now = test.now.Add(time.Second)
+	w = test.do(creds, "10.0.0.1", "/", strings.Repeat("x", (memory.MiB+memory.MiB/2+128*memory.KiB).Int()))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.InDelta(t, 500*time.Millisecond, test.waited, float64(time.Millisecond))
+	test.expect(creds, "10.0.0.1", http.StatusTooManyRequests, 125*time.Millisecond)
+
+	// the IP isn't limited.
+	test.expect(nil, "10.0.0.1", http.StatusOK, 0)
+}
+
+func TestRateLimiterOverrides(t *testing.T) {
+	creds := getCredentials(t)
+	test := newRateLimitTest(t, RateLimitConfig{
+		Credential: RateLimit{Requests: 1},
+	}, map[string]RateLimit{
+		creds.AccessKey: {Requests: 5},
+	})
+
+	for i := 0; i < 5; i++ {
+		test.expect(creds, "10.0.0.1", http.StatusOK, 0)
+	}
+	test.expect(creds, "10.0.0.1", http.StatusTooManyRequests, 200*time.Millisecond)
+
+	other := getCredentials(t)
+	other.AccessKey = "other"
+	test.expect(other, "10.0.0.1", http.StatusOK, 0)
+	test.expect(other, "10.0.0.1", http.StatusTooManyRequests, time.Second)
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	test := newRateLimitTest(t, RateLimitConfig{
+		IP: RateLimit{Requests: 1, RequestsBurst: 10},
+	}, nil)
+
+	test.expect(nil, "10.0.0.1", http.StatusOK, 0)
+	test.expect(nil, "10.0.0.2", http.StatusOK, 0)
+	test.now = test.now.Add(rateLimitSweepInterval - 5*time.Second)
+	for i := 0; i < 10; i++ {
+		test.expect(nil, "10.0.0.2", http.StatusOK, 0)
+	}
+	assert.Len(t, test.limiter.local.states, 2)
+
+	// keys whose buckets have refilled are dropped.
+	test.now = test.now.Add(5 * time.Second)
+	test.expect(nil, "10.0.0.3", http.StatusOK, 0)
+	assert.Len(t, test.limiter.local.states, 2)
+	assert.NotContains(t, test.limiter.local.states, "ip:10.0.0.1")
+}
+
+func TestLoadRateLimitOverrides(t *testing.T) {
+	ctx := testcontext.New(t)
+
+	overrides, err := LoadRateLimitOverrides("")
+	require.NoError(t, err)
+	assert.Nil(t, overrides)
+
+	path := filepath.Join(ctx.Dir(), "overrides.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"project": {"requests": 100, "requests-burst": 200, "bandwidth": "10 MB", "bandwidth-burst": "20 MB"}
+	}`), 0o644))
+	overrides, err = LoadRateLimitOverrides(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]RateLimit{
+		"project": {Requests: 100, RequestsBurst: 200, Bandwidth: 10 * memory.MB, BandwidthBurst: 20 * memory.MB},
+	}, overrides)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"project": {"requests": "many"}}`), 0o644))
+	_, err = LoadRateLimitOverrides(path)
+	require.Error(t, err)
+}
@@ -18,6 +18,7 @@ import (
 	mhttp "github.com/spacemonkeygo/monkit/v3/http"
 	"github.com/zeebo/errs"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	"storj.io/common/rpc/rpcpool"
@@ -50,11 +51,14 @@ var (
 // Once Peer.Run() has been called, new instances of a Peer will not update any configuration used
 // by Minio.
 type Peer struct {
-	server     *httpserver.Server
-	log        *zap.Logger
-	config     Config
-	closeLayer func(context.Context) error
-	inShutdown int32
+	server        *httpserver.Server
+	log           *zap.Logger
+	config        Config
+	closeLayer    func(context.Context) error
+	rateLimiter   *middleware.RateLimiter
+	accessLogs    *minio.AccessLogs
+	notifications *minio.Notifications
+	inShutdown    int32
 }
 
 // New returns new instance of an S3 compatible http server.
@@ -89,7 +93,13 @@ func New(config Config, log *zap.Logger, trustedIPs trustedip.List, corsAllowedO
 
 	uplinkConfig := configureUplinkConfig(config.Client)
 
-	layer, err := gw.NewMultiTenantLayer(miniogw.NewStorjGateway(config.S3Compatibility), satelliteConnectionPool, connectionPool, uplinkConfig, config.InsecureLogAll)
+	bucketNotification := minio.NewBucketNotification(log, dwsConfig)
+	notifications, err := minio.NewNotifications(log, config.Notifications, bucketNotification)
+	if err != nil {
+		return nil, err
+	}
+
+	layer, err := gw.NewMultiTenantLayer(miniogw.NewStorjGateway(config.S3Compatibility), satelliteConnectionPool, connectionPool, notifications, uplinkConfig, config.InsecureLogAll)
 	if err != nil {
 		return nil, err
 	}
@@ -121,8 +131,25 @@ func New(config Config, log *zap.Logger, trustedIPs trustedip.List, corsAllowedO
 	}
 	dwsClient := dwsProto.NewStorageCachingServiceClient(conn)
 
+	bucketCORS := minio.NewBucketCORS(log, dwsConfig)
+	bucketLogging := minio.NewBucketLogging(log, dwsConfig)
+	bucketLifecycle := minio.NewBucketLifecycle(log, dwsConfig)
+	bucketPolicy := minio.NewBucketPolicy(log, dwsConfig)
+	accessLogs := minio.NewAccessLogs(log, config.AccessLog, bucketLogging, layer, authClient, trustedIPs, dedupedDomains)
+
+	rateLimitOverrides, err := middleware.LoadRateLimitOverrides(config.RateLimit.ProjectOverrides)
+	if err != nil {
+		return nil, err
+	}
+	rateLimitBackend, err := middleware.OpenRateLimitBackend(config.RateLimit)
+	if err != nil {
+		return nil, err
+	}
+	rateLimiter := middleware.NewRateLimiter(log, config.RateLimit, rateLimitOverrides, rateLimitBackend, trustedIPs,
+		minio.NewProjectResolver(dwsClient), minio.RateLimited)
+
 	minio.RegisterAPIRouter(r, layer, dedupedDomains, concurrentAllowed, corsAllowedOrigins, authClient, dwsClient, trustedIPs,
-		log, dwsConfig.UuidResolverAddr, dwsConfig.DwsBackendHost, dwsConfig.DwsNodeToken)
+		log, dwsConfig.UuidResolverAddr, dwsConfig.DwsBackendHost, dwsConfig.DwsNodeToken, bucketCORS, bucketLogging, bucketNotification, bucketLifecycle, bucketPolicy, accessLogs, rateLimiter)
 
 	r.Use(func(handler http.Handler) http.Handler {
 		return mhttp.TraceHandler(handler, mon)
@@ -140,7 +167,7 @@ func New(config Config, log *zap.Logger, trustedIPs trustedip.List, corsAllowedO
 	r.Use(middleware.NewLogRequests(log, config.InsecureLogAll))
 	r.Use(middleware.NewLogResponses(log, config.InsecureLogAll))
 
-	var handler http.Handler = minio.CriticalErrorHandler{Handler: minio.CorsHandler(corsAllowedOrigins)(r)}
+	var handler http.Handler = minio.CriticalErrorHandler{Handler: minio.CorsHandler(corsAllowedOrigins, dedupedDomains, bucketCORS, rateLimiter)(r)}
 
 	var tlsConfig *httpserver.TLSConfig
 	if !config.InsecureDisableTLS {
@@ -170,10 +197,13 @@ func New(config Config, log *zap.Logger, trustedIPs trustedip.List, corsAllowedO
 	}
 
 	peer := Peer{
-		log:        log,
-		server:     server,
-		config:     config,
-		closeLayer: layer.Shutdown,
+		log:           log,
+		server:        server,
+		config:        config,
+		closeLayer:    layer.Shutdown,
+		rateLimiter:   rateLimiter,
+		accessLogs:    accessLogs,
+		notifications: notifications,
 	}
 	publicServices.HandleFunc("/health", peer.healthCheck)
 	return &peer, nil
@@ -203,6 +233,10 @@ func configureUplinkConfig(clientConfig ClientConfig) gw.UplinkConfig {
 			PieceHashAlgorithmBlake3: clientConfig.Upload.PieceHashAlgorithmBlake3,
 			RefactoredCodePath:       clientConfig.Upload.RefactoredCodePath,
 		},
+		ProjectPool: gw.ProjectPoolOptions{
+			Capacity:       clientConfig.ProjectPool.Capacity,
+			IdleExpiration: clientConfig.ProjectPool.IdleExpiration,
+		},
 	}
 
 	transport.SetMaximumBufferSize(&ret.Base, clientConfig.MaximumBufferSize.Int())
@@ -235,7 +269,21 @@ func (s *Peer) Run(ctx context.Context) (err error) {
 		minio.StartMinio(!s.config.InsecureDisableTLS)
 	})
 
-	return s.server.Run(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var group errgroup.Group
+	group.Go(func() error {
+		return s.accessLogs.Run(ctx)
+	})
+	group.Go(func() error {
+		return s.notifications.Run(ctx)
+	})
+	group.Go(func() error {
+		defer cancel()
+		return s.server.Run(ctx)
+	})
+	return group.Wait()
 }
 
 // Close shuts down the server and all underlying resources.
@@ -250,7 +298,11 @@ func (s *Peer) Close() error {
 	defer cancel()
 
 	// note: httpserver.Shutdown has its own configured timeout
-	return Error.Wrap(errs.Combine(s.closeLayer(ctx), s.server.Shutdown()))
+	// access logs are written through the layer, so they're flushed before
+	// it's shut down.
+	serverErr := s.server.Shutdown()
+	accessLogsErr := s.accessLogs.Close()
+	return Error.Wrap(errs.Combine(serverErr, accessLogsErr, s.closeLayer(ctx), s.notifications.Close(), s.rateLimiter.Close()))
 }
 
 // Address returns the web address the peer is listening on.